@@ -0,0 +1,174 @@
+// Package admission rejects new work when the queueing delay before the
+// handlers is too long. The delay is measured from the arrival of a request,
+// such as the time it was accepted or the X-Request-Start header set by a
+// proxy, to the start of its handler. It reacts to overload as soon as a queue
+// builds up, unlike the CPU usage which lags badly in bursty overloads.
+//
+// Once the min queueing delay of an interval exceeds the target, new requests
+// are rejected during the next interval. Rejected requests are cheap, so the
+// queue drains and the requests are admitted again.
+package admission
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrOverloaded is returned when the request is rejected for the queueing delay.
+var ErrOverloaded = errors.New("admission: queueing delay exceeds target")
+
+// Option is admission controller option function.
+type Option func(*options)
+
+// options of admission controller.
+type options struct {
+	target   time.Duration
+	interval time.Duration
+}
+
+// WithTarget with the acceptable queueing delay, default 20ms.
+func WithTarget(d time.Duration) Option {
+	return func(o *options) {
+		o.target = d
+	}
+}
+
+// WithInterval with the interval of checking the min queueing delay, default 100ms.
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// Stat contains the metrics snapshot of admission controller.
+type Stat struct {
+	// Delay is the min queueing delay of the last interval.
+	Delay      time.Duration
+	Overloaded bool
+	Admitted   uint64
+	Rejected   uint64
+}
+
+// Controller admits requests by their queueing delay.
+type Controller struct {
+	mu sync.Mutex
+	// min queueing delay of the current interval, -1 without requests
+	minDelay   time.Duration
+	lastDelay  time.Duration
+	intervalAt time.Time
+	overloaded bool
+	admitted   uint64
+	rejected   uint64
+	opts       options
+}
+
+// New returns an admission controller.
+func New(opts ...Option) *Controller {
+	opt := options{
+		target:   20 * time.Millisecond,
+		interval: 100 * time.Millisecond,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Controller{minDelay: -1, intervalAt: time.Now(), opts: opt}
+}
+
+// Admit records the queueing delay of a request arrived at arrival, and
+// returns ErrOverloaded if the request is rejected.
+func (c *Controller) Admit(arrival time.Time) error {
+	return c.admit(arrival, time.Now())
+}
+
+func (c *Controller) admit(arrival, now time.Time) error {
+	delay := now.Sub(arrival)
+	if delay < 0 {
+		delay = 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.intervalAt) >= c.opts.interval {
+		// an interval without requests has no queue
+		c.lastDelay = 0
+		if c.minDelay >= 0 {
+			c.lastDelay = c.minDelay
+		}
+		c.overloaded = c.lastDelay > c.opts.target
+		c.minDelay = -1
+		c.intervalAt = now
+	}
+	if c.minDelay < 0 || delay < c.minDelay {
+		c.minDelay = delay
+	}
+	if c.overloaded {
+		c.rejected++
+		return ErrOverloaded
+	}
+	c.admitted++
+	return nil
+}
+
+// Delay returns the min queueing delay of the last interval, it can be used
+// as the delay of loadshed.QueueDelaySignal.
+func (c *Controller) Delay() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastDelay
+}
+
+// Stat returns the metrics snapshot of the controller.
+func (c *Controller) Stat() Stat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stat{
+		Delay:      c.lastDelay,
+		Overloaded: c.overloaded,
+		Admitted:   c.admitted,
+		Rejected:   c.rejected,
+	}
+}
+
+// Middleware returns a net/http middleware admitting requests by the
+// X-Request-Start header, requests without the header are admitted.
+// Rejected requests are answered with 503.
+func (c *Controller) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if arrival, ok := RequestStart(r.Header); ok {
+			if err := c.Admit(arrival); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestStart parses the X-Request-Start header set by proxies, in seconds
+// with a fraction such as nginx "t=${msec}", or in milliseconds or
+// microseconds since the epoch, optionally prefixed with "t=".
+func RequestStart(header http.Header) (time.Time, bool) {
+	v := strings.TrimPrefix(header.Get("X-Request-Start"), "t=")
+	if v == "" {
+		return time.Time{}, false
+	}
+	if strings.Contains(v, ".") {
+		sec, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(sec*1e9)), true
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// milliseconds have 13 digits until year 2286
+	if n < 1e14 {
+		return time.UnixMilli(n), true
+	}
+	return time.UnixMicro(n), true
+}
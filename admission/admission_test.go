@@ -0,0 +1,68 @@
+package admission
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControllerAdmit(t *testing.T) {
+	c := New(WithTarget(10*time.Millisecond), WithInterval(100*time.Millisecond))
+	now := time.Now()
+	// a burst with a long delay doesn't make the min delay exceed the target
+	assert.NoError(t, c.admit(now.Add(-50*time.Millisecond), now))
+	assert.NoError(t, c.admit(now.Add(9*time.Millisecond), now.Add(10*time.Millisecond)))
+	now = now.Add(100 * time.Millisecond)
+	assert.NoError(t, c.admit(now.Add(-20*time.Millisecond), now))
+	assert.Equal(t, time.Millisecond, c.Delay())
+
+	// a standing queue
+	assert.NoError(t, c.admit(now.Add(-30*time.Millisecond), now.Add(50*time.Millisecond)))
+	now = now.Add(100 * time.Millisecond)
+	assert.Equal(t, ErrOverloaded, c.admit(now.Add(-20*time.Millisecond), now))
+	assert.Equal(t, ErrOverloaded, c.admit(now, now))
+	assert.Equal(t, Stat{Delay: 20 * time.Millisecond, Overloaded: true, Admitted: 4, Rejected: 2}, c.Stat())
+
+	// the queue drained
+	now = now.Add(100 * time.Millisecond)
+	assert.NoError(t, c.admit(now, now))
+	assert.False(t, c.Stat().Overloaded)
+}
+
+func TestRequestStart(t *testing.T) {
+	now := time.Now()
+	for _, v := range []string{
+		"t=" + strconv.FormatFloat(float64(now.UnixMicro())/1e6, 'f', 3, 64),
+		strconv.FormatInt(now.UnixMilli(), 10),
+		"t=" + strconv.FormatInt(now.UnixMicro(), 10),
+	} {
+		header := http.Header{"X-Request-Start": []string{v}}
+		start, ok := RequestStart(header)
+		assert.True(t, ok, v)
+		assert.WithinDuration(t, now, start, time.Millisecond, v)
+	}
+	_, ok := RequestStart(http.Header{"X-Request-Start": []string{"t=abc"}})
+	assert.False(t, ok)
+	_, ok = RequestStart(http.Header{})
+	assert.False(t, ok)
+}
+
+func TestControllerMiddleware(t *testing.T) {
+	c := New(WithTarget(time.Millisecond), WithInterval(time.Millisecond))
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	start := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	serve := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Request-Start", start)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, serve())
+	time.Sleep(2 * time.Millisecond)
+	assert.Equal(t, http.StatusServiceUnavailable, serve())
+}
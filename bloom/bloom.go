@@ -0,0 +1,197 @@
+package bloom
+
+// Native Go implement of Bloom filter, with the double hashing of paper
+// Less Hashing, Same Performance: Building a Better Bloom Filter (https://www.eecs.harvard.edu/~michaelm/postscripts/rsa2008.pdf)
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"unsafe"
+
+	"github.com/twmb/murmur3"
+)
+
+var (
+	// ErrShapeMismatch is returned when merging Bloom filters of different bits or hashes.
+	ErrShapeMismatch = errors.New("bloom: shape mismatch")
+	// ErrInvalidData is returned when unmarshaling malformed data.
+	ErrInvalidData = errors.New("bloom: invalid data")
+)
+
+// Filter tests whether a key is in a set, with false positives but no false
+// negatives.
+type Filter struct {
+	m      uint64
+	k      uint32
+	bitset []uint64
+}
+
+// New return a Bloom filter sized for n keys with a false positive rate of fpr.
+func New(n uint64, fpr float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if fpr <= 0 || fpr >= 1 {
+		fpr = 0.01
+	}
+	m := math.Ceil(-float64(n) * math.Log(fpr) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	return NewWithSize(uint64(m), uint32(k))
+}
+
+// NewWithSize return a Bloom filter of m bits and k hashes.
+func NewWithSize(m uint64, k uint32) *Filter {
+	if m < 1 {
+		m = 1
+	}
+	if k < 1 {
+		k = 1
+	}
+	return &Filter{m: m, k: k, bitset: make([]uint64, (m+63)/64)}
+}
+
+// Add add key into the filter.
+func (f *Filter) Add(key []byte) {
+	h1, h2 := murmur3.Sum128(key)
+	for i := uint32(0); i < f.k; i++ {
+		idx := f.location(h1, h2, i)
+		f.bitset[idx/64] |= 1 << (idx % 64)
+	}
+}
+
+// AddString add key into the filter without copying it.
+func (f *Filter) AddString(key string) {
+	f.Add(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// Test return false if key is definitely not in the filter.
+func (f *Filter) Test(key []byte) bool {
+	h1, h2 := murmur3.Sum128(key)
+	for i := uint32(0); i < f.k; i++ {
+		idx := f.location(h1, h2, i)
+		if f.bitset[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestString return false if key is definitely not in the filter.
+func (f *Filter) TestString(key string) bool {
+	return f.Test(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// TestAndAdd add key into the filter and return if it was in the filter.
+func (f *Filter) TestAndAdd(key []byte) bool {
+	h1, h2 := murmur3.Sum128(key)
+	present := true
+	for i := uint32(0); i < f.k; i++ {
+		idx := f.location(h1, h2, i)
+		if f.bitset[idx/64]&(1<<(idx%64)) == 0 {
+			present = false
+			f.bitset[idx/64] |= 1 << (idx % 64)
+		}
+	}
+	return present
+}
+
+// TestAndAddString add key into the filter and return if it was in the filter.
+func (f *Filter) TestAndAddString(key string) bool {
+	return f.TestAndAdd(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+func (f *Filter) location(h1, h2 uint64, i uint32) uint64 {
+	return (h1 + uint64(i)*h2) % f.m
+}
+
+// Count return the estimated number of keys added into the filter.
+func (f *Filter) Count() uint64 {
+	x := float64(f.ones())
+	if x >= float64(f.m) {
+		x = float64(f.m) - 1
+	}
+	return uint64(-float64(f.m)/float64(f.k)*math.Log(1-x/float64(f.m)) + 0.5)
+}
+
+// FPR return the estimated false positive rate of the filter.
+func (f *Filter) FPR() float64 {
+	return math.Pow(float64(f.ones())/float64(f.m), float64(f.k))
+}
+
+func (f *Filter) ones() uint64 {
+	var ones int
+	for _, w := range f.bitset {
+		ones += bits.OnesCount64(w)
+	}
+	return uint64(ones)
+}
+
+// Merge merge other into f, both must have the same bits and hashes.
+func (f *Filter) Merge(other *Filter) error {
+	if f.m != other.m || f.k != other.k {
+		return ErrShapeMismatch
+	}
+	for i, w := range other.bitset {
+		f.bitset[i] |= w
+	}
+	return nil
+}
+
+// Reset clear all bits in place.
+func (f *Filter) Reset() {
+	for i := range f.bitset {
+		f.bitset[i] = 0
+	}
+}
+
+// Bits return the number of bits of the filter.
+func (f *Filter) Bits() uint64 {
+	return f.m
+}
+
+// Hashes return the number of hashes of the filter.
+func (f *Filter) Hashes() uint32 {
+	return f.k
+}
+
+// SizeBytes return the memory consumed by the filter in bytes.
+func (f *Filter) SizeBytes() uint64 {
+	return uint64(unsafe.Sizeof(*f)) + uint64(cap(f.bitset))*8
+}
+
+// MarshalBinary encode the filter as the bits, the hashes and the bitset in
+// little endian.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 12+len(f.bitset)*8)
+	binary.LittleEndian.PutUint64(data, f.m)
+	binary.LittleEndian.PutUint32(data[8:], f.k)
+	for i, w := range f.bitset {
+		binary.LittleEndian.PutUint64(data[12+i*8:], w)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary decode the filter encoded by MarshalBinary.
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < 12 {
+		return ErrInvalidData
+	}
+	m := binary.LittleEndian.Uint64(data)
+	k := binary.LittleEndian.Uint32(data[8:])
+	// the words of m bits, without overflowing near 2^64
+	words := m / 64
+	if m%64 != 0 {
+		words++
+	}
+	if m == 0 || k == 0 || (len(data)-12)%8 != 0 || uint64((len(data)-12)/8) != words {
+		return ErrInvalidData
+	}
+	f.m, f.k = m, k
+	f.bitset = make([]uint64, words)
+	for i := range f.bitset {
+		f.bitset[i] = binary.LittleEndian.Uint64(data[12+i*8:])
+	}
+	return nil
+}
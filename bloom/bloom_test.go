@@ -0,0 +1,83 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFPR(t *testing.T) {
+	f := New(10000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.AddString(strconv.Itoa(i))
+	}
+	for i := 0; i < 10000; i++ {
+		assert.True(t, f.TestString(strconv.Itoa(i)))
+	}
+	var fp int
+	for i := 10000; i < 110000; i++ {
+		if f.TestString(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	assert.InDelta(t, 0.01, float64(fp)/100000, 0.005)
+	assert.InDelta(t, 0.01, f.FPR(), 0.005)
+	assert.InEpsilon(t, 10000, float64(f.Count()), 0.05)
+}
+
+func TestBloomTestAndAdd(t *testing.T) {
+	f := New(100, 0.01)
+	assert.False(t, f.TestAndAddString("a"))
+	assert.True(t, f.TestAndAddString("a"))
+	f.Reset()
+	assert.False(t, f.TestString("a"))
+}
+
+func TestBloomMerge(t *testing.T) {
+	a, b := New(1000, 0.01), New(1000, 0.01)
+	a.AddString("a")
+	b.AddString("b")
+	assert.Nil(t, a.Merge(b))
+	assert.True(t, a.TestString("a"))
+	assert.True(t, a.TestString("b"))
+	assert.Equal(t, ErrShapeMismatch, a.Merge(New(100, 0.01)))
+}
+
+func TestBloomMarshal(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.AddString(strconv.Itoa(i))
+	}
+	data, err := f.MarshalBinary()
+	assert.Nil(t, err)
+	var g Filter
+	assert.Nil(t, g.UnmarshalBinary(data))
+	assert.Equal(t, f.Bits(), g.Bits())
+	assert.Equal(t, f.Hashes(), g.Hashes())
+	for i := 0; i < 1000; i++ {
+		assert.True(t, g.TestString(strconv.Itoa(i)))
+	}
+	assert.Equal(t, ErrInvalidData, g.UnmarshalBinary(data[:len(data)-1]))
+
+	// the word count of m near 2^64 must not overflow to an empty bitset
+	data = make([]byte, 12)
+	binary.LittleEndian.PutUint64(data, math.MaxUint64)
+	binary.LittleEndian.PutUint32(data[8:], 3)
+	assert.Equal(t, ErrInvalidData, g.UnmarshalBinary(data))
+}
+
+func BenchmarkBloomAdd(b *testing.B) {
+	f := New(1000, 0.01)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.AddString(keys[i%len(keys)])
+	}
+}
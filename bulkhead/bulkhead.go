@@ -0,0 +1,150 @@
+// Package bulkhead partitions concurrency and queue capacity into compartments
+// per dependency or per tenant, so one slow downstream cannot consume all
+// worker capacity.
+package bulkhead
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+)
+
+// Compartment is the capacity of a compartment.
+type Compartment struct {
+	Name string
+	// MaxConcurrent is the max in-flight requests.
+	MaxConcurrent int
+	// MaxQueue is the max requests waiting for a slot.
+	MaxQueue int
+}
+
+// Option is bulkhead option function.
+type Option func(*options)
+
+// options of bulkhead.
+type options struct {
+	compartments []Compartment
+	def          Compartment
+	timeout      time.Duration
+	order        concurrency.Order
+}
+
+// WithCompartments with the compartments of dependencies or tenants.
+func WithCompartments(compartments ...Compartment) Option {
+	return func(o *options) {
+		o.compartments = append(o.compartments, compartments...)
+	}
+}
+
+// WithDefault with the capacity of compartments created on demand, default 100 in-flight and no queue.
+func WithDefault(maxConcurrent, maxQueue int) Option {
+	return func(o *options) {
+		o.def.MaxConcurrent = maxConcurrent
+		o.def.MaxQueue = maxQueue
+	}
+}
+
+// WithTimeout with the max duration a request waits in the queue, default 1s.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithOrder with the order of waking up waiters, default FIFO.
+func WithOrder(order concurrency.Order) Option {
+	return func(o *options) {
+		o.order = order
+	}
+}
+
+// Stat contains the metrics snapshot of a compartment.
+type Stat struct {
+	concurrency.Stat
+	Name string
+	// Saturation is the ratio of in-flight requests to the capacity.
+	Saturation float64
+}
+
+// Bulkhead isolates the capacity of compartments.
+type Bulkhead struct {
+	mu           sync.RWMutex
+	compartments map[string]*concurrency.Limiter
+	opts         options
+}
+
+// New returns a bulkhead.
+func New(opts ...Option) *Bulkhead {
+	opt := options{
+		def:     Compartment{MaxConcurrent: 100},
+		timeout: time.Second,
+		order:   concurrency.FIFO,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	b := &Bulkhead{
+		compartments: make(map[string]*concurrency.Limiter, len(opt.compartments)),
+		opts:         opt,
+	}
+	for _, c := range opt.compartments {
+		b.compartments[c.Name] = b.newLimiter(c)
+	}
+	return b
+}
+
+func (b *Bulkhead) newLimiter(c Compartment) *concurrency.Limiter {
+	return concurrency.NewLimiter(c.MaxConcurrent,
+		concurrency.WithQueue(c.MaxQueue),
+		concurrency.WithTimeout(b.opts.timeout),
+		concurrency.WithOrder(b.opts.order),
+	)
+}
+
+// compartment returns the limiter of name, it is created with the default capacity if absent.
+func (b *Bulkhead) compartment(name string) *concurrency.Limiter {
+	b.mu.RLock()
+	l, ok := b.compartments[name]
+	b.mu.RUnlock()
+	if ok {
+		return l
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if l, ok = b.compartments[name]; !ok {
+		def := b.opts.def
+		def.Name = name
+		l = b.newLimiter(def)
+		b.compartments[name] = l
+	}
+	return l
+}
+
+// Acquire acquires a slot of the compartment, the returned DoneFunc must be
+// called to release it. It returns ratelimit.ErrLimitExceed if the queue is
+// full, concurrency.ErrWaitTimeout or ctx.Err() if the wait times out.
+func (b *Bulkhead) Acquire(ctx context.Context, name string) (ratelimit.DoneFunc, error) {
+	return b.compartment(name).Acquire(ctx)
+}
+
+// Stats returns the metrics of all compartments sorted by name.
+func (b *Bulkhead) Stats() []Stat {
+	b.mu.RLock()
+	stats := make([]Stat, 0, len(b.compartments))
+	for name, l := range b.compartments {
+		stat := Stat{Stat: l.Stat(), Name: name}
+		if stat.Limit > 0 {
+			stat.Saturation = float64(stat.InFlight) / float64(stat.Limit)
+		}
+		stats = append(stats, stat)
+	}
+	b.mu.RUnlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
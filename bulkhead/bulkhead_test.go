@@ -0,0 +1,43 @@
+package bulkhead
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+)
+
+func TestBulkhead(t *testing.T) {
+	b := New(
+		WithCompartments(Compartment{Name: "db", MaxConcurrent: 2, MaxQueue: 1}),
+		WithDefault(1, 0),
+		WithTimeout(10*time.Millisecond),
+	)
+	ctx := context.Background()
+	done1, err := b.Acquire(ctx, "db")
+	assert.NoError(t, err)
+	_, err = b.Acquire(ctx, "db")
+	assert.NoError(t, err)
+	_, err = b.Acquire(ctx, "db")
+	assert.Equal(t, concurrency.ErrWaitTimeout, err)
+
+	// a saturated compartment doesn't affect others
+	_, err = b.Acquire(ctx, "tenant")
+	assert.NoError(t, err)
+	_, err = b.Acquire(ctx, "tenant")
+	assert.Equal(t, ratelimit.ErrLimitExceed, err)
+
+	done1(ratelimit.DoneInfo{})
+	stats := b.Stats()
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "db", stats[0].Name)
+	assert.Equal(t, 1, stats[0].InFlight)
+	assert.Equal(t, uint64(1), stats[0].Timeouts)
+	assert.Equal(t, 0.5, stats[0].Saturation)
+	assert.Equal(t, "tenant", stats[1].Name)
+	assert.Equal(t, uint64(1), stats[1].Rejected)
+	assert.Equal(t, 1.0, stats[1].Saturation)
+}
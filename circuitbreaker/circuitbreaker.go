@@ -2,6 +2,7 @@ package circuitbreaker
 
 import (
 	"errors"
+	"time"
 )
 
 // ErrNotAllowed error not allowed.
@@ -13,3 +14,14 @@ type CircuitBreaker interface {
 	MarkSuccess()
 	MarkFailed()
 }
+
+// Permit is a request allowed by a breaker which stamps its permits with its
+// state, so the result of a request allowed before the breaker changed its
+// state is not counted in the new state.
+type Permit interface {
+	// Mark marks the result and the elapsed time of the request.
+	Mark(success bool, elapsed time.Duration)
+	// Release returns the permit of a request which is not run, without
+	// marking a result.
+	Release()
+}
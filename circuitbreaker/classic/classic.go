@@ -0,0 +1,417 @@
+// Package classic implements the classic closed/open/half-open circuit breaker.
+//
+// The breaker stays closed while the failure rate and slow call rate of the
+// rolling window are below the thresholds, then opens and rejects all requests.
+// After the open timeout it turns half-open and permits a few probe requests,
+// whose results decide whether it closes again or goes back to open.
+package classic
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/window"
+)
+
+// State is the state of circuit breaker.
+type State int32
+
+const (
+	// StateClosed allows all requests and collects their results.
+	StateClosed State = iota
+	// StateOpen rejects all requests until the open timeout elapses.
+	StateOpen
+	// StateHalfOpen permits a limited number of probe requests.
+	StateHalfOpen
+	// StateForcedOpen rejects all requests until Reset is called.
+	StateForcedOpen
+	// StateForcedClosed allows all requests until Reset is called.
+	StateForcedClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	case StateForcedOpen:
+		return "forced-open"
+	case StateForcedClosed:
+		return "forced-closed"
+	}
+	return "unknown"
+}
+
+// Option is classic breaker option function.
+type Option func(*options)
+
+// StateListener is called after the breaker changes its state.
+type StateListener func(from, to State)
+
+var (
+	_ circuitbreaker.CircuitBreaker = (*Breaker)(nil)
+	_ circuitbreaker.Permit         = permit{}
+)
+
+// options is a breaker options.
+type options struct {
+	failureRate  float64
+	slowRate     float64
+	slowDuration time.Duration
+	request      int64
+	bucket       int
+	window       time.Duration
+	openTimeout  time.Duration
+	probes       int64
+	listeners    []StateListener
+}
+
+// WithFailureRate with the failure rate threshold to open the breaker, default 0.5.
+func WithFailureRate(r float64) Option {
+	return func(o *options) {
+		o.failureRate = r
+	}
+}
+
+// WithSlowCallRate with the slow call rate threshold to open the breaker,
+// default 1 which only opens when all calls are slow.
+func WithSlowCallRate(r float64) Option {
+	return func(o *options) {
+		o.slowRate = r
+	}
+}
+
+// WithSlowCallDuration with the duration above which a call is slow, default 1s.
+func WithSlowCallDuration(d time.Duration) Option {
+	return func(o *options) {
+		o.slowDuration = d
+	}
+}
+
+// WithRequest with the minimum number of requests in the window before the rates are evaluated.
+func WithRequest(r int64) Option {
+	return func(o *options) {
+		o.request = r
+	}
+}
+
+// WithWindow with the duration size of the statistical window.
+func WithWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.window = d
+	}
+}
+
+// WithBucket set the bucket number in a window duration.
+func WithBucket(b int) Option {
+	return func(o *options) {
+		o.bucket = b
+	}
+}
+
+// WithOpenTimeout with the duration the breaker stays open before turning half-open.
+func WithOpenTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.openTimeout = d
+	}
+}
+
+// WithProbes with the number of requests permitted in half-open state.
+func WithProbes(n int64) Option {
+	return func(o *options) {
+		o.probes = n
+	}
+}
+
+// WithStateListener add a listener called after every state change.
+func WithStateListener(l StateListener) Option {
+	return func(o *options) {
+		o.listeners = append(o.listeners, l)
+	}
+}
+
+// Breaker is a classic CircuitBreaker pattern.
+type Breaker struct {
+	mu    sync.Mutex
+	state State
+	// generation is incremented by every state change, the results of the
+	// permits of earlier generations are ignored.
+	generation uint64
+	// openedAt is the time the breaker turned open.
+	openedAt time.Time
+
+	// stat counts successes as 1 and failures as 0, slowStat counts slow calls as 1.
+	stat     window.RollingCounter
+	slowStat window.RollingCounter
+
+	// probe results of half-open state.
+	probing       int64
+	probeFailures int64
+	probeSlows    int64
+	probeDone     int64
+
+	opts options
+}
+
+// NewBreaker return a classic breaker with options.
+func NewBreaker(opts ...Option) *Breaker {
+	opt := options{
+		failureRate:  0.5,
+		slowRate:     1,
+		slowDuration: time.Second,
+		request:      100,
+		bucket:       10,
+		window:       3 * time.Second,
+		openTimeout:  5 * time.Second,
+		probes:       10,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	b := &Breaker{opts: opt, state: StateClosed}
+	b.resetStat()
+	return b
+}
+
+func (b *Breaker) resetStat() {
+	counterOpts := window.RollingCounterOpts{
+		Size:           b.opts.bucket,
+		BucketDuration: time.Duration(int64(b.opts.window) / int64(b.opts.bucket)),
+	}
+	b.stat = window.NewRollingCounter(counterOpts)
+	b.slowStat = window.NewRollingCounter(counterOpts)
+	b.probing, b.probeFailures, b.probeSlows, b.probeDone = 0, 0, 0, 0
+}
+
+// State return the current state of breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow request if error returns nil. The result marked by Mark, MarkSuccess or
+// MarkFailed is counted in the current state, use Acquire to ignore the results
+// of requests allowed before the breaker changed its state.
+func (b *Breaker) Allow() error {
+	_, err := b.allow()
+	return err
+}
+
+// Acquire allows the request if error returns nil, and returns its permit
+// stamped with the generation of the breaker. The result marked by the permit
+// is ignored if the breaker changed its state since, such as a late result of a
+// request allowed in closed state arriving in half-open state.
+func (b *Breaker) Acquire() (circuitbreaker.Permit, error) {
+	gen, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+	return permit{b: b, generation: gen}, nil
+}
+
+// permit is a request allowed by Acquire.
+type permit struct {
+	b          *Breaker
+	generation uint64
+}
+
+func (p permit) Mark(success bool, elapsed time.Duration) {
+	p.b.mark(p.generation, success, elapsed)
+}
+
+func (p permit) Release() {
+	p.b.release(p.generation)
+}
+
+// allow returns the generation of the permit if the request is allowed.
+func (b *Breaker) allow() (uint64, error) {
+	b.mu.Lock()
+	from := b.state
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.opts.openTimeout {
+			b.mu.Unlock()
+			return 0, circuitbreaker.ErrNotAllowed
+		}
+		b.transition(StateHalfOpen)
+		fallthrough
+	case StateHalfOpen:
+		if b.probing >= b.opts.probes {
+			b.mu.Unlock()
+			b.notify(from, StateHalfOpen)
+			return 0, circuitbreaker.ErrNotAllowed
+		}
+		b.probing++
+	case StateForcedOpen:
+		b.mu.Unlock()
+		return 0, circuitbreaker.ErrNotAllowed
+	}
+	to, gen := b.state, b.generation
+	b.mu.Unlock()
+	b.notify(from, to)
+	return gen, nil
+}
+
+// Release return the permit of a request which is allowed but not run, such
+// as one rejected by another breaker, without marking a result. It frees the
+// probe of half-open state.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	gen := b.generation
+	b.mu.Unlock()
+	b.release(gen)
+}
+
+func (b *Breaker) release(gen uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if gen == b.generation && b.state == StateHalfOpen && b.probing > b.probeDone {
+		b.probing--
+	}
+}
+
+// MarkSuccess mark request is success.
+func (b *Breaker) MarkSuccess() {
+	b.Mark(true, 0)
+}
+
+// MarkFailed mark request is failed.
+func (b *Breaker) MarkFailed() {
+	b.Mark(false, 0)
+}
+
+// Mark mark the result and the elapsed time of a request, requests taking longer
+// than the slow call duration count towards the slow call rate. In half-open
+// state the results beyond the permitted probes are ignored.
+func (b *Breaker) Mark(success bool, elapsed time.Duration) {
+	b.mu.Lock()
+	gen := b.generation
+	b.mu.Unlock()
+	b.mark(gen, success, elapsed)
+}
+
+func (b *Breaker) mark(gen uint64, success bool, elapsed time.Duration) {
+	slow := elapsed > b.opts.slowDuration
+	b.mu.Lock()
+	if gen != b.generation || (b.state == StateHalfOpen && b.probeDone >= b.probing) {
+		b.mu.Unlock()
+		return
+	}
+	from := b.state
+	switch b.state {
+	case StateClosed:
+		b.markClosed(success, slow)
+	case StateHalfOpen:
+		b.markHalfOpen(success, slow)
+	}
+	to := b.state
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+func (b *Breaker) markClosed(success, slow bool) {
+	b.stat.Add(boolToInt(success))
+	b.slowStat.Add(boolToInt(slow))
+	var total, successes, slows int64
+	b.stat.Reduce(func(iterator window.Iterator) float64 {
+		for iterator.Next() {
+			bucket := iterator.Bucket()
+			total += bucket.Count
+			for _, p := range bucket.Points {
+				successes += int64(p)
+			}
+		}
+		return 0
+	})
+	if total < b.opts.request {
+		return
+	}
+	slows = int64(b.slowStat.Sum())
+	if b.exceeded(total-successes, slows, total) {
+		b.transition(StateOpen)
+	}
+}
+
+func (b *Breaker) markHalfOpen(success, slow bool) {
+	b.probeDone++
+	if !success {
+		b.probeFailures++
+	}
+	if slow {
+		b.probeSlows++
+	}
+	if b.probeDone < b.opts.probes {
+		return
+	}
+	if b.exceeded(b.probeFailures, b.probeSlows, b.probeDone) {
+		b.transition(StateOpen)
+		return
+	}
+	b.transition(StateClosed)
+}
+
+func (b *Breaker) exceeded(failures, slows, total int64) bool {
+	return float64(failures) >= b.opts.failureRate*float64(total) ||
+		float64(slows) >= b.opts.slowRate*float64(total)
+}
+
+// transition must be called with mu held.
+func (b *Breaker) transition(to State) {
+	if b.state == to {
+		return
+	}
+	b.state = to
+	b.generation++
+	switch to {
+	case StateOpen:
+		b.openedAt = time.Now()
+	case StateClosed, StateHalfOpen:
+		b.resetStat()
+	}
+}
+
+func (b *Breaker) notify(from, to State) {
+	if from == to {
+		return
+	}
+	for _, l := range b.opts.listeners {
+		l(from, to)
+	}
+}
+
+// ForceOpen keep the breaker open until Reset is called.
+func (b *Breaker) ForceOpen() {
+	b.force(StateForcedOpen)
+}
+
+// ForceClosed keep the breaker closed until Reset is called.
+func (b *Breaker) ForceClosed() {
+	b.force(StateForcedClosed)
+}
+
+// Reset reset the breaker to closed state and clear its statistics.
+func (b *Breaker) Reset() {
+	b.force(StateClosed)
+}
+
+func (b *Breaker) force(to State) {
+	b.mu.Lock()
+	from := b.state
+	b.state = to
+	b.generation++
+	b.resetStat()
+	b.mu.Unlock()
+	b.notify(from, to)
+}
+
+func boolToInt(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,188 @@
+package classic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+)
+
+func getClassicBreaker(opts ...Option) *Breaker {
+	opts = append([]Option{
+		WithRequest(10),
+		WithWindow(time.Second),
+		WithBucket(10),
+		WithOpenTimeout(100 * time.Millisecond),
+		WithProbes(2),
+	}, opts...)
+	return NewBreaker(opts...)
+}
+
+func markSuccess(b *Breaker, count int) {
+	for i := 0; i < count; i++ {
+		b.MarkSuccess()
+	}
+}
+
+func markFailed(b *Breaker, count int) {
+	for i := 0; i < count; i++ {
+		b.MarkFailed()
+	}
+}
+
+func TestClassicOpen(t *testing.T) {
+	b := getClassicBreaker()
+	markFailed(b, 9)
+	assert.Equal(t, StateClosed, b.State())
+	assert.Nil(t, b.Allow())
+
+	markSuccess(b, 1)
+	markFailed(b, 1)
+	assert.Equal(t, StateOpen, b.State())
+	assert.Equal(t, circuitbreaker.ErrNotAllowed, b.Allow())
+}
+
+func TestClassicHalfOpen(t *testing.T) {
+	var changes []State
+	b := getClassicBreaker(WithStateListener(func(from, to State) {
+		changes = append(changes, to)
+	}))
+	markFailed(b, 10)
+	time.Sleep(120 * time.Millisecond)
+
+	t.Run("probe failed", func(t *testing.T) {
+		assert.Nil(t, b.Allow())
+		assert.Equal(t, StateHalfOpen, b.State())
+		assert.Nil(t, b.Allow())
+		assert.Equal(t, circuitbreaker.ErrNotAllowed, b.Allow())
+		b.MarkSuccess()
+		b.MarkFailed()
+		assert.Equal(t, StateOpen, b.State())
+	})
+
+	time.Sleep(120 * time.Millisecond)
+	t.Run("probe succeed", func(t *testing.T) {
+		assert.Nil(t, b.Allow())
+		assert.Nil(t, b.Allow())
+		markSuccess(b, 2)
+		assert.Equal(t, StateClosed, b.State())
+		assert.Nil(t, b.Allow())
+	})
+	assert.Equal(t, []State{StateOpen, StateHalfOpen, StateOpen, StateHalfOpen, StateClosed}, changes)
+}
+
+func TestClassicRelease(t *testing.T) {
+	b := getClassicBreaker()
+	markFailed(b, 10)
+	time.Sleep(120 * time.Millisecond)
+	assert.Nil(t, b.Allow())
+	assert.Nil(t, b.Allow())
+	assert.Equal(t, circuitbreaker.ErrNotAllowed, b.Allow())
+	// a released probe is permitted again and its result is not counted
+	b.Release()
+	assert.Nil(t, b.Allow())
+	b.Release()
+	b.Release()
+	b.Release()
+	assert.Equal(t, StateHalfOpen, b.State())
+	assert.Nil(t, b.Allow())
+	assert.Nil(t, b.Allow())
+	markSuccess(b, 2)
+	assert.Equal(t, StateClosed, b.State())
+	// releasing in closed state is a no-op
+	b.Release()
+	assert.Equal(t, StateClosed, b.State())
+}
+
+func TestClassicAcquire(t *testing.T) {
+	b := getClassicBreaker()
+	// requests allowed in closed state are still running when it opens
+	var late []circuitbreaker.Permit
+	for i := 0; i < 2; i++ {
+		p, err := b.Acquire()
+		assert.Nil(t, err)
+		late = append(late, p)
+	}
+	markFailed(b, 10)
+	assert.Equal(t, StateOpen, b.State())
+	time.Sleep(120 * time.Millisecond)
+
+	// their late results neither close the breaker nor free the probes
+	p, err := b.Acquire()
+	assert.Nil(t, err)
+	assert.Equal(t, StateHalfOpen, b.State())
+	for _, l := range late {
+		l.Mark(true, 0)
+		l.Release()
+	}
+	assert.Equal(t, StateHalfOpen, b.State())
+	_, err = b.Acquire()
+	assert.Nil(t, err)
+	_, err = b.Acquire()
+	assert.Equal(t, circuitbreaker.ErrNotAllowed, err)
+
+	// a released probe is permitted again
+	p.Release()
+	p, err = b.Acquire()
+	assert.Nil(t, err)
+	p.Mark(true, 0)
+	assert.Equal(t, StateHalfOpen, b.State())
+	b.MarkSuccess()
+	assert.Equal(t, StateClosed, b.State())
+
+	// the results marked beyond the permitted probes are ignored
+	markFailed(b, 10)
+	time.Sleep(120 * time.Millisecond)
+	assert.Nil(t, b.Allow())
+	markSuccess(b, 2)
+	assert.Equal(t, StateHalfOpen, b.State())
+}
+
+func TestClassicSlowCall(t *testing.T) {
+	b := getClassicBreaker(WithSlowCallRate(0.5), WithSlowCallDuration(10*time.Millisecond))
+	for i := 0; i < 5; i++ {
+		b.Mark(true, time.Millisecond)
+	}
+	for i := 0; i < 4; i++ {
+		b.Mark(true, 20*time.Millisecond)
+	}
+	assert.Equal(t, StateClosed, b.State())
+	b.Mark(true, 20*time.Millisecond)
+	assert.Equal(t, StateOpen, b.State())
+}
+
+func TestClassicForce(t *testing.T) {
+	b := getClassicBreaker()
+	b.ForceOpen()
+	assert.Equal(t, StateForcedOpen, b.State())
+	assert.Equal(t, circuitbreaker.ErrNotAllowed, b.Allow())
+
+	b.ForceClosed()
+	markFailed(b, 100)
+	assert.Equal(t, StateForcedClosed, b.State())
+	assert.Nil(t, b.Allow())
+
+	b.Reset()
+	assert.Equal(t, StateClosed, b.State())
+	markFailed(b, 10)
+	assert.Equal(t, StateOpen, b.State())
+}
+
+func TestStateString(t *testing.T) {
+	assert.Equal(t, "half-open", StateHalfOpen.String())
+	assert.Equal(t, "unknown", State(100).String())
+}
+
+func BenchmarkClassicBreakerAllow(b *testing.B) {
+	breaker := NewBreaker()
+	b.ResetTimer()
+	for i := 0; i <= b.N; i++ {
+		_ = breaker.Allow()
+		if i%2 == 0 {
+			breaker.MarkSuccess()
+		} else {
+			breaker.MarkFailed()
+		}
+	}
+}
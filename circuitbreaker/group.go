@@ -0,0 +1,129 @@
+package circuitbreaker
+
+import (
+	"container/list"
+	"sync"
+)
+
+// GroupOption is breaker group option function.
+type GroupOption func(*groupOptions)
+
+// groupOptions of breaker group.
+type groupOptions struct {
+	maxBreakers int
+}
+
+// WithMaxBreakers with the max live breakers, the least recently used breaker
+// is evicted beyond it and a new breaker is created when its key comes back,
+// default 1024, 0 means unbounded.
+func WithMaxBreakers(n int) GroupOption {
+	return func(o *groupOptions) {
+		o.maxBreakers = n
+	}
+}
+
+type groupEntry[B CircuitBreaker] struct {
+	key     string
+	breaker B
+}
+
+// BreakerGroup lazily manages one breaker per key, such as an endpoint, a
+// tenant or a shard, so one failing key doesn't break the others.
+type BreakerGroup[B CircuitBreaker] struct {
+	mu       sync.Mutex
+	factory  func(key string) B
+	breakers map[string]*list.Element
+	lru      *list.List
+	opts     groupOptions
+}
+
+// NewBreakerGroup returns a breaker group creating the breaker of a key by factory.
+func NewBreakerGroup[B CircuitBreaker](factory func(key string) B, opts ...GroupOption) *BreakerGroup[B] {
+	opt := groupOptions{maxBreakers: 1024}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &BreakerGroup[B]{
+		factory:  factory,
+		breakers: make(map[string]*list.Element),
+		lru:      list.New(),
+		opts:     opt,
+	}
+}
+
+// Get returns the breaker of key, creating it if it doesn't exist.
+func (g *BreakerGroup[B]) Get(key string) B {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if e, ok := g.breakers[key]; ok {
+		g.lru.MoveToFront(e)
+		return e.Value.(*groupEntry[B]).breaker
+	}
+	entry := &groupEntry[B]{key: key, breaker: g.factory(key)}
+	g.breakers[key] = g.lru.PushFront(entry)
+	if g.opts.maxBreakers > 0 && g.lru.Len() > g.opts.maxBreakers {
+		oldest := g.lru.Back()
+		g.lru.Remove(oldest)
+		delete(g.breakers, oldest.Value.(*groupEntry[B]).key)
+	}
+	return entry.breaker
+}
+
+// Do calls fn if the breaker of key allows it and marks the result, it returns
+// ErrNotAllowed without calling fn if the breaker rejects.
+func (g *BreakerGroup[B]) Do(key string, fn func() error) error {
+	b := g.Get(key)
+	if err := b.Allow(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		b.MarkFailed()
+		return err
+	}
+	b.MarkSuccess()
+	return nil
+}
+
+// Remove removes the breaker of key.
+func (g *BreakerGroup[B]) Remove(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if e, ok := g.breakers[key]; ok {
+		g.lru.Remove(e)
+		delete(g.breakers, key)
+	}
+}
+
+// Len returns the number of live breakers.
+func (g *BreakerGroup[B]) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lru.Len()
+}
+
+// Breakers returns the live breakers by key, for inspecting their states in bulk.
+func (g *BreakerGroup[B]) Breakers() map[string]B {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	breakers := make(map[string]B, len(g.breakers))
+	for key, e := range g.breakers {
+		breakers[key] = e.Value.(*groupEntry[B]).breaker
+	}
+	return breakers
+}
+
+// Range calls fn for the live breakers from the most to the least recently
+// used, until fn returns false. fn is called without holding the lock.
+func (g *BreakerGroup[B]) Range(fn func(key string, breaker B) bool) {
+	g.mu.Lock()
+	entries := make([]*groupEntry[B], 0, g.lru.Len())
+	for e := g.lru.Front(); e != nil; e = e.Next() {
+		entries = append(entries, e.Value.(*groupEntry[B]))
+	}
+	g.mu.Unlock()
+	for _, entry := range entries {
+		if !fn(entry.key, entry.breaker) {
+			return
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package circuitbreaker_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/circuitbreaker/classic"
+)
+
+func TestBreakerGroup(t *testing.T) {
+	var created []string
+	g := circuitbreaker.NewBreakerGroup(func(key string) *classic.Breaker {
+		created = append(created, key)
+		return classic.NewBreaker(classic.WithRequest(1))
+	}, circuitbreaker.WithMaxBreakers(2))
+
+	failed := errors.New("failed")
+	assert.Equal(t, failed, g.Do("a", func() error { return failed }))
+	assert.Equal(t, circuitbreaker.ErrNotAllowed, g.Do("a", func() error { return nil }))
+	assert.NoError(t, g.Do("b", func() error { return nil }))
+	assert.Same(t, g.Get("a"), g.Get("a"))
+
+	states := make(map[string]classic.State)
+	for key, b := range g.Breakers() {
+		states[key] = b.State()
+	}
+	assert.Equal(t, map[string]classic.State{"a": classic.StateOpen, "b": classic.StateClosed}, states)
+
+	// b is the least recently used and evicted
+	g.Get("c")
+	assert.Equal(t, 2, g.Len())
+	var keys []string
+	g.Range(func(key string, _ *classic.Breaker) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []string{"c", "a"}, keys)
+	g.Get("b")
+	assert.Equal(t, []string{"a", "b", "c", "b"}, created)
+
+	g.Remove("b")
+	assert.Equal(t, 1, g.Len())
+}
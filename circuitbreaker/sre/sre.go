@@ -1,3 +1,7 @@
+// Package sre implements the client-side adaptive throttling circuit breaker
+// from the Google SRE book (https://sre.google/sre-book/handling-overload/).
+// Requests are rejected locally with probability
+// max(0, (requests - K * accepts) / (requests + 1)).
 package sre
 
 import (
@@ -7,7 +11,7 @@ import (
 	"time"
 
 	"github.com/zychimne/aegis/circuitbreaker"
-	"github.com/zychimne/aegis/internal/window"
+	"github.com/zychimne/aegis/window"
 	"golang.org/x/exp/rand"
 )
 
@@ -37,7 +41,7 @@ type options struct {
 	window  time.Duration
 }
 
-// WithSuccess with the K = 1 / Success value of sre breaker, default success is 0.5
+// WithSuccess with the K = 1 / Success value of sre breaker, default success is 0.6
 // Reducing the K will make adaptive throttling behave more aggressively,
 // Increasing the K will make adaptive throttling behave less aggressively.
 func WithSuccess(s float64) Option {
@@ -6,7 +6,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
-	"github.com/zychimne/aegis/internal/window"
+	"github.com/zychimne/aegis/window"
 	"golang.org/x/exp/rand"
 )
 
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/contrib/aegispb"
+	"github.com/zychimne/aegis/topk"
+)
+
+// agent merges the hot keys reported by the processes of the host.
+type agent struct {
+	source string
+
+	mu   sync.Mutex
+	topk *topk.HeavyKeeper
+	// pending counts the reports since the last forward, the forwarded hot
+	// list is its counts as the reports of the processes are.
+	pending *topk.HeavyKeeper
+	newTopk func(minCount int) *topk.HeavyKeeper
+}
+
+func newAgent(source string, hotKeys, minCount int) *agent {
+	factor := uint32(math.Log(float64(hotKeys)))
+	if factor < 1 {
+		factor = 1
+	}
+	newTopk := func(minCount int) *topk.HeavyKeeper {
+		hk := topk.NewHeavyKeeper(uint32(hotKeys), 1024*factor, 4, 0.925, uint32(minCount), topk.WithTimestamps())
+		return hk.(*topk.HeavyKeeper)
+	}
+	return &agent{source: source, topk: newTopk(minCount), pending: newTopk(0), newTopk: newTopk}
+}
+
+// report count the items of a hot list, they are the counts since the last
+// report of the process, or single events with a count of one.
+func (a *agent) report(l aegispb.HotList) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, item := range l.Items {
+		if item.Key != "" && item.Count > 0 {
+			a.topk.Add(item.Key, item.Count)
+			a.pending.Add(item.Key, item.Count)
+		}
+	}
+}
+
+// takePending return the hot list of the counts since the last call, and
+// starts counting them over.
+func (a *agent) takePending() aegispb.HotList {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l := aegispb.HotList{Source: a.source}
+	l.Time = time.Now()
+	l.Total = a.pending.Total()
+	l.Items = a.pending.List()
+	a.pending = a.newTopk(0)
+	return l
+}
+
+// restorePending count the items of a hot list taken by takePending again, so
+// they are forwarded with the next counts.
+func (a *agent) restorePending(l aegispb.HotList) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, item := range l.Items {
+		a.pending.Add(item.Key, item.Count)
+	}
+}
+
+// hotList return the first n merged hot keys, all if n is zero.
+func (a *agent) hotList(n int) aegispb.HotList {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l := aegispb.HotList{Source: a.source}
+	l.Time = time.Now()
+	l.Total = a.topk.Total()
+	if n > 0 {
+		l.Items = a.topk.ListN(n)
+	} else {
+		l.Items = a.topk.List()
+	}
+	return l
+}
+
+func (a *agent) fading() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.topk.Fading()
+}
+
+// serve accept the connections of the processes until the listener is closed.
+func (a *agent) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+// serveConn read the delimited hot lists of a process until it disconnects.
+func (a *agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := aegispb.ReadDelimited(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("aegis-agent: read report: %v", err)
+			}
+			return
+		}
+		l, err := aegispb.UnmarshalHotList(msg)
+		if err != nil {
+			log.Printf("aegis-agent: decode report: %v", err)
+			return
+		}
+		a.report(l)
+	}
+}
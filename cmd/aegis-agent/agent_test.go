@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/contrib/aegispb"
+	"github.com/zychimne/aegis/topk"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func report(t *testing.T, socket, source string, items ...topk.Item) {
+	conn, err := net.Dial("unix", socket)
+	assert.NoError(t, err)
+	defer conn.Close()
+	l := aegispb.HotList{Source: source, Snapshot: topk.Snapshot{Items: items}}
+	assert.NoError(t, aegispb.WriteDelimited(conn, aegispb.MarshalHotList(l)))
+}
+
+func TestAgent(t *testing.T) {
+	a := newAgent("host", 10, 0)
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	assert.NoError(t, err)
+	go a.serve(ln)
+	defer ln.Close()
+
+	// the counts of the processes are merged
+	report(t, socket, "api", topk.Item{Key: "a", Count: 5}, topk.Item{Key: "b", Count: 3})
+	report(t, socket, "worker", topk.Item{Key: "b", Count: 4})
+	assert.Eventually(t, func() bool { return a.hotList(0).Total == 12 }, time.Second, 10*time.Millisecond)
+	l := a.hotList(0)
+	assert.Equal(t, "host", l.Source)
+	assert.Equal(t, []string{"b", "a"}, keys(l.Items))
+	assert.Equal(t, uint32(7), l.Items[0].Count)
+	assert.Len(t, a.hotList(1).Items, 1)
+
+	srv := httptest.NewServer(a.handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/hotkeys?limit=1")
+	assert.NoError(t, err)
+	var res jsonHotList
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	resp.Body.Close()
+	assert.Equal(t, "b", res.Items[0].Key)
+	assert.Len(t, res.Items, 1)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/hotkeys", nil)
+	req.Header.Set("Accept", contentTypeProtobuf)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	pb, err := aegispb.UnmarshalHotList(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, keys(pb.Items))
+
+	resp, err = http.Get(srv.URL + "/hotkeys?limit=-1")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestAgentGRPC(t *testing.T) {
+	a := newAgent("host", 10, 0)
+	a.report(aegispb.HotList{Snapshot: topk.Snapshot{Items: []topk.Item{{Key: "a", Count: 2}, {Key: "b", Count: 1}}}})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	srv := a.grpcServer()
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	assert.NoError(t, err)
+	defer conn.Close()
+	var reply []byte
+	err = conn.Invoke(context.Background(), "/aegis.v1.Agent/HotList", aegispb.MarshalHotListRequest(1), &reply)
+	assert.NoError(t, err)
+	l, err := aegispb.UnmarshalHotList(reply)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, keys(l.Items))
+}
+
+func TestAgentForward(t *testing.T) {
+	a := newAgent("host", 10, 0)
+	a.report(aegispb.HotList{Snapshot: topk.Snapshot{Items: []topk.Item{{Key: "a", Count: 2}}}})
+	received := make(chan aegispb.HotList, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, contentTypeProtobuf, r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		l, err := aegispb.UnmarshalHotList(body)
+		assert.NoError(t, err)
+		select {
+		case received <- l:
+		default:
+		}
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		a.forward(ctx, srv.Client(), srv.URL, 10*time.Millisecond)
+	}()
+	select {
+	case l := <-received:
+		assert.Equal(t, "host", l.Source)
+		assert.Equal(t, []string{"a"}, keys(l.Items))
+	case <-time.After(time.Second):
+		t.Fatal("hot list not forwarded")
+	}
+	cancel()
+	<-stopped
+	a.takePending()
+
+	// the counts since the last forward are forwarded, and again with the next
+	// counts if the aggregator fails
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer fail.Close()
+	a.report(aegispb.HotList{Snapshot: topk.Snapshot{Items: []topk.Item{{Key: "b", Count: 3}}}})
+	assert.Error(t, a.post(context.Background(), fail.Client(), fail.URL))
+	a.report(aegispb.HotList{Snapshot: topk.Snapshot{Items: []topk.Item{{Key: "b", Count: 1}}}})
+	l := a.takePending()
+	assert.Equal(t, []string{"b"}, keys(l.Items))
+	assert.Equal(t, uint32(4), l.Items[0].Count)
+	assert.Equal(t, uint64(4), l.Total)
+	assert.Empty(t, a.takePending().Items)
+	assert.Equal(t, []string{"b", "a"}, keys(a.hotList(0).Items))
+}
+
+func keys(items []topk.Item) []string {
+	res := make([]string, 0, len(items))
+	for _, item := range items {
+		res = append(res, item.Key)
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/zychimne/aegis/contrib/aegispb"
+)
+
+// forward post the counts reported since the last forward in protobuf to the
+// cluster aggregator every interval until ctx is done, so the aggregator adds
+// them up as the agent does with the reports of the processes.
+func (a *agent) forward(ctx context.Context, client *http.Client, url string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.post(ctx, client, url); err != nil {
+				log.Printf("aegis-agent: forward: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// post the counts since the last forward, they are forwarded again with the
+// next counts if the aggregator doesn't accept them.
+func (a *agent) post(ctx context.Context, client *http.Client, url string) error {
+	l := a.takePending()
+	if err := a.send(ctx, client, url, aegispb.MarshalHotList(l)); err != nil {
+		a.restorePending(l)
+		return err
+	}
+	return nil
+}
+
+func (a *agent) send(ctx context.Context, client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeProtobuf)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("aggregator replied %s", resp.Status)
+	}
+	return nil
+}
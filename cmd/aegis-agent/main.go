@@ -0,0 +1,111 @@
+// Command aegis-agent is a per-host sidecar merging the hot keys of the local
+// processes. Processes report hot lists, the counts since their last report,
+// to a Unix domain socket as delimited aegis.v1.HotList messages. The merged
+// hot list is served over HTTP and gRPC, and the counts since the last forward
+// are forwarded to the cluster aggregator.
+//
+//	aegis-agent -socket /run/aegis-agent.sock -http :9701 -grpc :9702
+//	aegis-agent -forward http://aggregator:9700/report -forward-interval 10s
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var (
+		socket          string
+		httpAddr        string
+		grpcAddr        string
+		forwardURL      string
+		forwardInterval time.Duration
+		hotKeys         int
+		minCount        int
+		fading          time.Duration
+		source          string
+	)
+	hostname, _ := os.Hostname()
+	flag.StringVar(&socket, "socket", "/run/aegis-agent.sock", "unix domain socket the processes report to")
+	flag.StringVar(&httpAddr, "http", ":9701", "address of the HTTP server, empty disables")
+	flag.StringVar(&grpcAddr, "grpc", "", "address of the gRPC server, empty disables")
+	flag.StringVar(&forwardURL, "forward", "", "URL of the cluster aggregator, empty disables")
+	flag.DurationVar(&forwardInterval, "forward-interval", 10*time.Second, "interval of forwarding to the aggregator")
+	flag.IntVar(&hotKeys, "hotkeys", 100, "number of hot keys tracked")
+	flag.IntVar(&minCount, "min-count", 0, "minimum count of a hot key")
+	flag.DurationVar(&fading, "fading", 10*time.Second, "interval of halving the counts, 0 disables")
+	flag.StringVar(&source, "source", hostname, "source of the hot list")
+	flag.Parse()
+
+	if hotKeys <= 0 || minCount < 0 || forwardInterval <= 0 {
+		fatal(fmt.Errorf("hotkeys and forward-interval must be positive, min-count must not be negative"))
+	}
+	a := newAgent(source, hotKeys, minCount)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// a stale socket of a previous run is replaced
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fatal(err)
+	}
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if err := a.serve(ln); err != nil {
+			fatal(err)
+		}
+	}()
+
+	if httpAddr != "" {
+		srv := &http.Server{Addr: httpAddr, Handler: a.handler()}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal(err)
+			}
+		}()
+		defer srv.Close()
+	}
+	if grpcAddr != "" {
+		l, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			fatal(err)
+		}
+		srv := a.grpcServer()
+		go srv.Serve(l)
+		defer srv.Stop()
+	}
+	if forwardURL != "" {
+		go a.forward(ctx, &http.Client{Timeout: forwardInterval}, forwardURL, forwardInterval)
+	}
+	if fading > 0 {
+		go func() {
+			ticker := time.NewTicker(fading)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					a.fading()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	<-ctx.Done()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "aegis-agent:", err)
+	os.Exit(2)
+}
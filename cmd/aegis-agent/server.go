@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zychimne/aegis/contrib/aegispb"
+	"google.golang.org/grpc"
+)
+
+// contentTypeProtobuf is the content type of the protobuf hot lists.
+const contentTypeProtobuf = "application/x-protobuf"
+
+type jsonItem struct {
+	Key       string     `json:"key"`
+	Count     uint32     `json:"count"`
+	Rank      int        `json:"rank"`
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+}
+
+type jsonHotList struct {
+	Source string     `json:"source"`
+	Time   time.Time  `json:"time"`
+	Total  uint64     `json:"total"`
+	Items  []jsonItem `json:"items"`
+}
+
+// handler serves the merged hot list at /hotkeys, in JSON or in protobuf if
+// accepted, the limit parameter limits the number of hot keys.
+func (a *agent) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hotkeys", func(w http.ResponseWriter, r *http.Request) {
+		var limit int
+		if s := r.URL.Query().Get("limit"); s != "" {
+			var err error
+			if limit, err = strconv.Atoi(s); err != nil || limit < 0 {
+				http.Error(w, fmt.Sprintf("invalid limit %q", s), http.StatusBadRequest)
+				return
+			}
+		}
+		l := a.hotList(limit)
+		if strings.Contains(r.Header.Get("Accept"), contentTypeProtobuf) {
+			w.Header().Set("Content-Type", contentTypeProtobuf)
+			w.Write(aegispb.MarshalHotList(l))
+			return
+		}
+		res := jsonHotList{Source: l.Source, Time: l.Time, Total: l.Total, Items: make([]jsonItem, 0, len(l.Items))}
+		for _, item := range l.Items {
+			res.Items = append(res.Items, jsonItem{
+				Key:       item.Key,
+				Count:     item.Count,
+				Rank:      item.Rank,
+				FirstSeen: timeOrNil(item.FirstSeen),
+				LastSeen:  timeOrNil(item.LastSeen),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
+	return mux
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// rawCodec passes the messages through as bytes, they are encoded by aegispb.
+// It is named proto so clients generated from aegis.proto interoperate.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("aegis-agent: unexpected message %T", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("aegis-agent: unexpected message %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// agentServiceDesc is the aegis.v1.Agent service of aegis.proto.
+var agentServiceDesc = grpc.ServiceDesc{
+	ServiceName: "aegis.v1.Agent",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "HotList",
+		Handler:    hotListHandler,
+	}},
+	Metadata: "aegis.proto",
+}
+
+func hotListHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	var req []byte
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		limit, err := aegispb.UnmarshalHotListRequest(req.([]byte))
+		if err != nil {
+			return nil, err
+		}
+		return aegispb.MarshalHotList(srv.(*agent).hotList(int(limit))), nil
+	}
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/aegis.v1.Agent/HotList"}
+	return interceptor(ctx, req, info, handler)
+}
+
+// grpcServer returns a gRPC server of the aegis.v1.Agent service.
+func (a *agent) grpcServer() *grpc.Server {
+	s := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}))
+	s.RegisterService(&agentServiceDesc, a)
+	return s
+}
@@ -0,0 +1,184 @@
+// Command hotkeybench benchmarks the hotkey cache under a synthetic workload
+// and prints the hit ratio, throughput and allocations.
+//
+// Reads get the key from the local cache and, on a miss, add the loaded value
+// like a cache-aside loader. Writes invalidate the key.
+//
+//	hotkeybench -dist zipf -zipf-s 1.1 -keys 100000 -write 0.05 -duration 10s
+//	hotkeybench -shift 2s -shift-step 1000 -burst 5s -burst-len 500ms
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zychimne/aegis/hotkey"
+	"golang.org/x/exp/rand"
+)
+
+type stats struct {
+	reads  int64
+	writes int64
+	hits   int64
+}
+
+func main() {
+	var (
+		w        workload
+		option   hotkey.Option
+		capacity uint64
+		write    float64
+		duration time.Duration
+		workers  int
+		fading   time.Duration
+		seed     uint64
+	)
+	flag.StringVar(&w.dist, "dist", "zipf", "key distribution, zipf or uniform")
+	flag.Uint64Var(&w.keys, "keys", 10000, "key cardinality")
+	flag.Float64Var(&w.zipfS, "zipf-s", 1.2, "zipf s parameter, > 1")
+	flag.Float64Var(&w.zipfV, "zipf-v", 1, "zipf v parameter, >= 1")
+	flag.DurationVar(&w.shift, "shift", 0, "interval of the hot spot shift, 0 disables")
+	flag.Uint64Var(&w.shiftStep, "shift-step", 100, "keys the hot spot moves by at each shift")
+	flag.DurationVar(&w.burst, "burst", 0, "period of the bursts of a single key, 0 disables")
+	flag.DurationVar(&w.burstLen, "burst-len", 100*time.Millisecond, "length of a burst")
+	flag.Float64Var(&w.burstRatio, "burst-ratio", 0.5, "ratio of the requests to the burst key")
+	flag.Float64Var(&write, "write", 0.1, "ratio of writes in [0, 1]")
+	flag.DurationVar(&duration, "duration", 10*time.Second, "duration of the benchmark")
+	flag.IntVar(&workers, "workers", runtime.GOMAXPROCS(0), "concurrent workers")
+	flag.Uint64Var(&seed, "seed", uint64(time.Now().UnixNano()), "random seed")
+	flag.IntVar(&option.HotKeyCnt, "hotkeys", 100, "number of hot keys tracked")
+	flag.Uint64Var(&capacity, "cap", 100, "capacity of the local cache")
+	flag.BoolVar(&option.AutoCache, "auto-cache", true, "cache the hot keys automatically")
+	flag.DurationVar(&option.TTL, "ttl", 100*time.Millisecond, "ttl of the cached values")
+	flag.IntVar(&option.MinCount, "min-count", 0, "minimum count of a hot key")
+	flag.DurationVar(&fading, "fading", 0, "interval of the topk fading, 0 disables")
+	flag.Parse()
+	option.LocalCacheCap = capacity
+
+	if err := w.validate(); err != nil {
+		fatal(err)
+	}
+	if write < 0 || write > 1 || duration <= 0 || workers <= 0 {
+		fatal(fmt.Errorf("write must be in [0, 1], duration and workers must be positive"))
+	}
+	h, err := hotkey.NewHotkey(&option)
+	if err != nil {
+		fatal(err)
+	}
+
+	var (
+		st     stats
+		wg     sync.WaitGroup
+		stop   = make(chan struct{})
+		before runtime.MemStats
+		after  runtime.MemStats
+	)
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	if fading > 0 {
+		go func() {
+			ticker := time.NewTicker(fading)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					h.Fading()
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			run(h, newGenerator(&w, seed+uint64(i), start), rand.New(rand.NewSource(seed^uint64(i+1))), write, &st, stop)
+		}(i)
+	}
+	time.Sleep(duration)
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	report(os.Stdout, &st, h, elapsed, &before, &after)
+}
+
+func run(h *hotkey.HotKeyWithCache, g *generator, r *rand.Rand, write float64, st *stats, stop <-chan struct{}) {
+	var (
+		reads, writes, hits int64
+		now                 time.Time
+	)
+	defer func() {
+		atomic.AddInt64(&st.reads, reads)
+		atomic.AddInt64(&st.writes, writes)
+		atomic.AddInt64(&st.hits, hits)
+	}()
+	for n := 0; ; n++ {
+		// checking the clock and the stop channel on every request dominates
+		// the cost of a cache hit, so they are checked every 64 requests.
+		if n%64 == 0 {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			now = time.Now()
+		}
+		key := strconv.FormatUint(g.next(now), 10)
+		if r.Float64() < write {
+			writes++
+			h.Del(key)
+			continue
+		}
+		reads++
+		if h.Get(key) != nil {
+			hits++
+			h.Add(key, 1)
+			continue
+		}
+		h.AddWithValue(key, key, 1)
+	}
+}
+
+func report(out io.Writer, st *stats, h *hotkey.HotKeyWithCache, elapsed time.Duration, before, after *runtime.MemStats) {
+	ops := st.reads + st.writes
+	var hitRatio float64
+	if st.reads > 0 {
+		hitRatio = float64(st.hits) / float64(st.reads)
+	}
+	perOp := func(v uint64) float64 {
+		if ops == 0 {
+			return 0
+		}
+		return float64(v) / float64(ops)
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "duration\t%v\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(tw, "ops\t%d\n", ops)
+	fmt.Fprintf(tw, "throughput\t%.0f ops/s\n", float64(ops)/elapsed.Seconds())
+	fmt.Fprintf(tw, "reads\t%d\n", st.reads)
+	fmt.Fprintf(tw, "writes\t%d\n", st.writes)
+	fmt.Fprintf(tw, "hits\t%d\n", st.hits)
+	fmt.Fprintf(tw, "hit ratio\t%.4f\n", hitRatio)
+	fmt.Fprintf(tw, "hot keys\t%d\n", len(h.List()))
+	fmt.Fprintf(tw, "allocs/op\t%.2f\n", perOp(after.Mallocs-before.Mallocs))
+	fmt.Fprintf(tw, "bytes/op\t%.2f\n", perOp(after.TotalAlloc-before.TotalAlloc))
+	fmt.Fprintf(tw, "gc\t%d\n", after.NumGC-before.NumGC)
+	tw.Flush()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "hotkeybench:", err)
+	os.Exit(2)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+// workload is the key distribution of a benchmark.
+type workload struct {
+	dist  string
+	keys  uint64
+	zipfS float64
+	zipfV float64
+	// shift move the hot spot by shiftStep keys every shift.
+	shift     time.Duration
+	shiftStep uint64
+	// burst send burstRatio of the requests to a single key during burstLen of
+	// every burst.
+	burst      time.Duration
+	burstLen   time.Duration
+	burstRatio float64
+}
+
+func (w *workload) validate() error {
+	switch {
+	case w.dist != "zipf" && w.dist != "uniform":
+		return fmt.Errorf("invalid distribution %q, must be zipf or uniform", w.dist)
+	case w.keys == 0:
+		return fmt.Errorf("keys must be positive")
+	case w.dist == "zipf" && (w.zipfS <= 1 || w.zipfV < 1):
+		return fmt.Errorf("zipf requires s > 1 and v >= 1")
+	case w.burst > 0 && (w.burstLen <= 0 || w.burstLen > w.burst):
+		return fmt.Errorf("burst length must be in (0, %v]", w.burst)
+	case w.burstRatio < 0 || w.burstRatio > 1:
+		return fmt.Errorf("burst ratio must be in [0, 1]")
+	}
+	return nil
+}
+
+// generator generate the keys of a worker, it's not safe for concurrent use.
+type generator struct {
+	w     *workload
+	r     *rand.Rand
+	zipf  *rand.Zipf
+	start time.Time
+}
+
+func newGenerator(w *workload, seed uint64, start time.Time) *generator {
+	g := &generator{w: w, r: rand.New(rand.NewSource(seed)), start: start}
+	if w.dist == "zipf" {
+		g.zipf = rand.NewZipf(g.r, w.zipfS, w.zipfV, w.keys-1)
+	}
+	return g
+}
+
+// next return the key at now, keys are in [0, keys).
+func (g *generator) next(now time.Time) uint64 {
+	elapsed := now.Sub(g.start)
+	if g.w.burst > 0 && elapsed%g.w.burst < g.w.burstLen && g.r.Float64() < g.w.burstRatio {
+		return g.w.keys - 1
+	}
+	var key uint64
+	if g.zipf != nil {
+		key = g.zipf.Uint64()
+	} else {
+		key = g.r.Uint64n(g.w.keys)
+	}
+	if g.w.shift > 0 {
+		key = (key + uint64(elapsed/g.w.shift)*g.w.shiftStep) % g.w.keys
+	}
+	return key
+}
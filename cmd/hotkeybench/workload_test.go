@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkloadValidate(t *testing.T) {
+	w := workload{dist: "zipf", keys: 100, zipfS: 1.2, zipfV: 1}
+	assert.NoError(t, w.validate())
+	for _, invalid := range []workload{
+		{dist: "normal", keys: 100},
+		{dist: "uniform"},
+		{dist: "zipf", keys: 100, zipfS: 1, zipfV: 1},
+		{dist: "uniform", keys: 100, burst: time.Second, burstLen: 2 * time.Second},
+		{dist: "uniform", keys: 100, burstRatio: 2},
+	} {
+		assert.Error(t, invalid.validate(), invalid)
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	start := time.Now()
+	w := &workload{dist: "zipf", keys: 100, zipfS: 2, zipfV: 1}
+	g := newGenerator(w, 1, start)
+	counts := make(map[uint64]int)
+	for i := 0; i < 10000; i++ {
+		key := g.next(start)
+		assert.Less(t, key, w.keys)
+		counts[key]++
+	}
+	assert.Greater(t, counts[0], counts[1])
+	assert.Greater(t, counts[1], counts[10])
+
+	// the hot spot moves by shiftStep keys every shift
+	w.shift, w.shiftStep = time.Second, 10
+	g = newGenerator(w, 1, start)
+	counts = make(map[uint64]int)
+	for i := 0; i < 1000; i++ {
+		counts[g.next(start.Add(2*time.Second))]++
+	}
+	assert.Greater(t, counts[20], counts[0])
+
+	// the burst key takes burstRatio of the requests during a burst only
+	w = &workload{dist: "uniform", keys: 100, burst: time.Second, burstLen: 100 * time.Millisecond, burstRatio: 0.5}
+	g = newGenerator(w, 1, start)
+	var burst, idle int
+	for i := 0; i < 1000; i++ {
+		if g.next(start.Add(50*time.Millisecond)) == w.keys-1 {
+			burst++
+		}
+		if g.next(start.Add(500*time.Millisecond)) == w.keys-1 {
+			idle++
+		}
+	}
+	assert.InDelta(t, 500, burst, 60)
+	assert.Less(t, idle, 40)
+}
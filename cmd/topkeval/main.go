@@ -0,0 +1,92 @@
+// Command topkeval runs a key trace through HeavyKeeper, Space-Saving and
+// count-min sketch with min-heap, and prints the precision, recall, rank
+// error, count error, memory and throughput of each against an exact counter.
+//
+// The trace is read from a file of one key per line, or generated by a zipf
+// distribution if no file is given.
+//
+//	topkeval -trace keys.txt -k 100 -width 4096 -depth 4
+//	topkeval -n 1000000 -keys 100000 -zipf-s 1.1 -k 50
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zychimne/aegis/topk/eval"
+)
+
+func main() {
+	var (
+		tracePath string
+		n         int
+		keys      uint64
+		zipfS     float64
+		seed      uint64
+		k         uint
+		width     uint
+		depth     uint
+		counters  uint
+	)
+	flag.StringVar(&tracePath, "trace", "", "file of one key per line, - for stdin, empty generates a zipf trace")
+	flag.IntVar(&n, "n", 1000000, "length of the generated trace")
+	flag.Uint64Var(&keys, "keys", 100000, "key cardinality of the generated trace")
+	flag.Float64Var(&zipfS, "zipf-s", 1.2, "zipf s parameter of the generated trace, > 1")
+	flag.Uint64Var(&seed, "seed", uint64(time.Now().UnixNano()), "random seed of the generated trace")
+	flag.UintVar(&k, "k", 100, "number of top keys evaluated")
+	flag.UintVar(&width, "width", 4096, "width of the sketches")
+	flag.UintVar(&depth, "depth", 4, "depth of the sketches")
+	flag.UintVar(&counters, "counters", 0, "counters of Space-Saving, default 10*k")
+	flag.Parse()
+
+	if k == 0 || width == 0 || depth == 0 {
+		fatal(fmt.Errorf("k, width and depth must be positive"))
+	}
+	if counters == 0 {
+		counters = 10 * k
+	}
+	trace, err := loadTrace(tracePath, n, keys, zipfS, seed)
+	if err != nil {
+		fatal(err)
+	}
+	results := eval.Evaluate(trace, int(k), eval.Candidates(uint32(k), uint32(width), uint32(depth), uint32(counters))...)
+	report(os.Stdout, len(trace), results)
+}
+
+func loadTrace(path string, n int, keys uint64, zipfS float64, seed uint64) ([]string, error) {
+	switch path {
+	case "":
+		if n <= 0 || keys == 0 || zipfS <= 1 {
+			return nil, fmt.Errorf("n and keys must be positive, zipf-s must be > 1")
+		}
+		return eval.ZipfTrace(n, keys, zipfS, seed), nil
+	case "-":
+		return eval.ReadTrace(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return eval.ReadTrace(f)
+}
+
+func report(out io.Writer, n int, results []eval.Result) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "keys\t%d\n\n", n)
+	fmt.Fprintln(tw, "algorithm\tprecision\trecall\trank error\tcount error\tmemory\tthroughput")
+	for _, res := range results {
+		fmt.Fprintf(tw, "%s\t%.4f\t%.4f\t%.2f\t%.4f\t%d B\t%.0f keys/s\n",
+			res.Name, res.Precision, res.Recall, res.RankError, res.CountError, res.SizeBytes, res.Throughput)
+	}
+	tw.Flush()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "topkeval:", err)
+	os.Exit(2)
+}
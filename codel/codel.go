@@ -0,0 +1,214 @@
+// Package codel implements a controlled-delay request queue in front of a
+// limited number of workers. Once the min queueing delay of an interval exceeds
+// the target, the queue is overloaded: requests waiting longer than the target
+// are dropped and the queue is served in LIFO order, so a standing queue can
+// never build up. Otherwise requests are served in FIFO order and are dropped
+// only after waiting a full interval.
+package codel
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+var (
+	// ErrDropped is returned when the request is dropped by the queue.
+	ErrDropped = errors.New("codel: request dropped")
+	// ErrQueueFull is returned when the queue is full.
+	ErrQueueFull = errors.New("codel: queue full")
+
+	_ ratelimit.Limiter = (*Queue)(nil)
+)
+
+// Option is codel queue option function.
+type Option func(*options)
+
+// options of codel queue.
+type options struct {
+	target   time.Duration
+	interval time.Duration
+	size     int
+}
+
+// WithTarget with the acceptable queueing delay, default 5ms.
+func WithTarget(d time.Duration) Option {
+	return func(o *options) {
+		o.target = d
+	}
+}
+
+// WithInterval with the interval of checking the min queueing delay, default 100ms.
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithSize with the max waiting requests, default 1024.
+func WithSize(size int) Option {
+	return func(o *options) {
+		o.size = size
+	}
+}
+
+// Stat contains the metrics snapshot of codel queue.
+type Stat struct {
+	InFlight   int
+	Queued     int
+	Dropped    uint64
+	Overloaded bool
+}
+
+type packet struct {
+	ready   chan error
+	enqueue time.Time
+}
+
+// Queue is a CoDel queue with adaptive LIFO.
+type Queue struct {
+	mu       sync.Mutex
+	workers  int
+	inFlight int
+	packets  list.List
+	dropped  uint64
+	// min queueing delay of the current interval
+	minDelay   time.Duration
+	intervalAt time.Time
+	overloaded bool
+	opts       options
+}
+
+// NewQueue returns a queue serving at most workers requests at once.
+func NewQueue(workers int, opts ...Option) *Queue {
+	opt := options{
+		target:   5 * time.Millisecond,
+		interval: 100 * time.Millisecond,
+		size:     1024,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Queue{
+		workers:    workers,
+		minDelay:   -1,
+		intervalAt: time.Now().Add(opt.interval),
+		opts:       opt,
+	}
+}
+
+// Allow waits for a worker, see Acquire.
+func (q *Queue) Allow() (ratelimit.DoneFunc, error) {
+	return q.Acquire(context.Background())
+}
+
+// Acquire waits for a worker until it is dropped or ctx is done.
+// The returned DoneFunc must be called to release the worker.
+func (q *Queue) Acquire(ctx context.Context) (ratelimit.DoneFunc, error) {
+	q.mu.Lock()
+	if q.inFlight < q.workers && q.packets.Len() == 0 {
+		q.inFlight++
+		q.observe(0, time.Now())
+		q.mu.Unlock()
+		return q.done(), nil
+	}
+	if q.packets.Len() >= q.opts.size {
+		q.dropped++
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	p := &packet{ready: make(chan error, 1), enqueue: time.Now()}
+	elem := q.packets.PushBack(p)
+	q.mu.Unlock()
+
+	select {
+	case err := <-p.ready:
+		if err != nil {
+			return nil, err
+		}
+		return q.done(), nil
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case err := <-p.ready:
+		// dequeued while canceling
+		if err != nil {
+			return nil, err
+		}
+		return q.done(), nil
+	default:
+	}
+	q.packets.Remove(elem)
+	return nil, ctx.Err()
+}
+
+// done returns the DoneFunc releasing one worker.
+func (q *Queue) done() ratelimit.DoneFunc {
+	var once sync.Once
+	return func(ratelimit.DoneInfo) {
+		once.Do(q.release)
+	}
+}
+
+func (q *Queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inFlight--
+	now := time.Now()
+	for q.inFlight < q.workers && q.packets.Len() > 0 {
+		elem := q.packets.Front()
+		if q.overloaded {
+			elem = q.packets.Back()
+		}
+		q.packets.Remove(elem)
+		p := elem.Value.(*packet)
+		delay := now.Sub(p.enqueue)
+		q.observe(delay, now)
+		if q.shouldDrop(delay) {
+			q.dropped++
+			p.ready <- ErrDropped
+			continue
+		}
+		q.inFlight++
+		p.ready <- nil
+	}
+}
+
+// observe records the queueing delay, it must be called with mu held.
+func (q *Queue) observe(delay time.Duration, now time.Time) {
+	if q.minDelay < 0 || delay < q.minDelay {
+		q.minDelay = delay
+	}
+	if now.Before(q.intervalAt) {
+		return
+	}
+	q.overloaded = q.minDelay > q.opts.target
+	q.minDelay = -1
+	q.intervalAt = now.Add(q.opts.interval)
+}
+
+// shouldDrop reports whether the request waited too long, it must be called with mu held.
+func (q *Queue) shouldDrop(delay time.Duration) bool {
+	if q.overloaded {
+		return delay > q.opts.target
+	}
+	return delay > q.opts.interval
+}
+
+// Stat returns the metrics snapshot of the queue.
+func (q *Queue) Stat() Stat {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return Stat{
+		InFlight:   q.inFlight,
+		Queued:     q.packets.Len(),
+		Dropped:    q.dropped,
+		Overloaded: q.overloaded,
+	}
+}
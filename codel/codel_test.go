@@ -0,0 +1,106 @@
+package codel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+type result struct {
+	id   int
+	done ratelimit.DoneFunc
+	err  error
+}
+
+// enqueue starts waiters and waits until they are queued in order.
+func enqueue(q *Queue, ch chan result, ids ...int) {
+	for _, id := range ids {
+		go func(id int) {
+			done, err := q.Allow()
+			ch <- result{id: id, done: done, err: err}
+		}(id)
+		for q.Stat().Queued != id {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestCodelFIFO(t *testing.T) {
+	q := NewQueue(1, WithInterval(time.Hour))
+	done, err := q.Allow()
+	assert.NoError(t, err)
+	ch := make(chan result)
+	enqueue(q, ch, 1, 2)
+	done(ratelimit.DoneInfo{})
+	r := <-ch
+	assert.Equal(t, 1, r.id)
+	assert.NoError(t, r.err)
+	r.done(ratelimit.DoneInfo{})
+	r = <-ch
+	assert.Equal(t, 2, r.id)
+	r.done(ratelimit.DoneInfo{})
+	assert.Equal(t, Stat{}, q.Stat())
+}
+
+func TestCodelOverloaded(t *testing.T) {
+	q := NewQueue(1, WithTarget(10*time.Millisecond), WithInterval(time.Hour))
+	done, err := q.Allow()
+	assert.NoError(t, err)
+	ch := make(chan result)
+	enqueue(q, ch, 1, 2, 3)
+	q.mu.Lock()
+	q.overloaded = true
+	// the first request has waited longer than the target
+	q.packets.Front().Value.(*packet).enqueue = time.Now().Add(-time.Second)
+	q.mu.Unlock()
+
+	// served in LIFO order
+	done(ratelimit.DoneInfo{})
+	r := <-ch
+	assert.Equal(t, 3, r.id)
+	r.done(ratelimit.DoneInfo{})
+	r = <-ch
+	assert.Equal(t, 2, r.id)
+	r.done(ratelimit.DoneInfo{})
+	r = <-ch
+	assert.Equal(t, 1, r.id)
+	assert.Equal(t, ErrDropped, r.err)
+	assert.Equal(t, Stat{Dropped: 1, Overloaded: true}, q.Stat())
+}
+
+func TestCodelObserve(t *testing.T) {
+	q := NewQueue(1, WithTarget(10*time.Millisecond), WithInterval(100*time.Millisecond))
+	now := time.Now()
+	q.observe(20*time.Millisecond, now)
+	q.observe(15*time.Millisecond, now.Add(time.Second))
+	assert.True(t, q.overloaded)
+	assert.True(t, q.shouldDrop(11*time.Millisecond))
+	q.observe(time.Millisecond, now.Add(2*time.Second))
+	assert.False(t, q.overloaded)
+	assert.False(t, q.shouldDrop(11*time.Millisecond))
+	assert.True(t, q.shouldDrop(101*time.Millisecond))
+}
+
+func TestCodelFullAndCancel(t *testing.T) {
+	q := NewQueue(1, WithSize(1))
+	done, err := q.Allow()
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan error)
+	go func() {
+		_, err := q.Acquire(ctx)
+		ch <- err
+	}()
+	for q.Stat().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	_, err = q.Allow()
+	assert.Equal(t, ErrQueueFull, err)
+	cancel()
+	assert.Equal(t, context.Canceled, <-ch)
+	done(ratelimit.DoneInfo{})
+	assert.Equal(t, Stat{Dropped: 1}, q.Stat())
+}
@@ -0,0 +1,309 @@
+// Package aegispb encodes the topk sketches and hot lists in the protobuf wire
+// format of aegis.proto, so instances of different versions and languages can
+// exchange them. The messages are encoded by protowire, a generated package is
+// wire-compatible with them.
+package aegispb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zychimne/aegis/topk"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Version is the version of the wire format written by the encoders, the
+// decoders accept messages up to it.
+const Version = 1
+
+var (
+	// ErrInvalidData is returned when decoding malformed data.
+	ErrInvalidData = errors.New("aegispb: invalid data")
+	// ErrUnsupportedVersion is returned when decoding a message of a newer version.
+	ErrUnsupportedVersion = errors.New("aegispb: unsupported version")
+)
+
+// HotList is the hot keys of an instance, Source identifies the instance.
+type HotList struct {
+	Source string
+	topk.Snapshot
+}
+
+// field numbers of aegis.proto.
+const (
+	bucketFingerprint protowire.Number = 1
+	bucketCount       protowire.Number = 2
+
+	itemKey       protowire.Number = 1
+	itemCount     protowire.Number = 2
+	itemFirstSeen protowire.Number = 3
+	itemLastSeen  protowire.Number = 4
+
+	sketchVersion protowire.Number = 1
+	sketchWidth   protowire.Number = 2
+	sketchDepth   protowire.Number = 3
+	sketchSeed    protowire.Number = 4
+	sketchTotal   protowire.Number = 5
+	sketchBuckets protowire.Number = 6
+	sketchItems   protowire.Number = 7
+
+	hotListVersion     protowire.Number = 1
+	hotListSource      protowire.Number = 2
+	hotListTime        protowire.Number = 3
+	hotListTotal       protowire.Number = 4
+	hotListCardinality protowire.Number = 5
+	hotListItems       protowire.Number = 6
+
+	hotListRequestLimit protowire.Number = 1
+)
+
+// MarshalSketch encode the sketch as an aegis.v1.Sketch.
+func MarshalSketch(s topk.Sketch) []byte {
+	b := appendVarint(nil, sketchVersion, Version)
+	b = appendVarint(b, sketchWidth, uint64(s.Width))
+	b = appendVarint(b, sketchDepth, uint64(s.Depth))
+	b = appendVarint(b, sketchSeed, s.Seed)
+	b = appendVarint(b, sketchTotal, s.Total)
+	var buf []byte
+	for _, bucket := range s.Buckets {
+		buf = appendVarint(buf[:0], bucketFingerprint, uint64(bucket.Fingerprint))
+		buf = appendVarint(buf, bucketCount, uint64(bucket.Count))
+		b = appendMessage(b, sketchBuckets, buf)
+	}
+	for _, item := range s.Items {
+		buf = appendItem(buf[:0], item)
+		b = appendMessage(b, sketchItems, buf)
+	}
+	return b
+}
+
+// UnmarshalSketch decode an aegis.v1.Sketch, the ranks of the items follow
+// their order.
+func UnmarshalSketch(b []byte) (topk.Sketch, error) {
+	var s topk.Sketch
+	var version uint64
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v uint64, msg []byte) error {
+		switch {
+		case num == sketchVersion && typ == protowire.VarintType:
+			version = v
+		case num == sketchWidth && typ == protowire.VarintType:
+			s.Width = uint32(v)
+		case num == sketchDepth && typ == protowire.VarintType:
+			s.Depth = uint32(v)
+		case num == sketchSeed && typ == protowire.VarintType:
+			s.Seed = v
+		case num == sketchTotal && typ == protowire.VarintType:
+			s.Total = v
+		case num == sketchBuckets && typ == protowire.BytesType:
+			bucket, err := consumeBucket(msg)
+			if err != nil {
+				return err
+			}
+			s.Buckets = append(s.Buckets, bucket)
+		case num == sketchItems && typ == protowire.BytesType:
+			item, err := consumeItem(msg)
+			if err != nil {
+				return err
+			}
+			item.Rank = len(s.Items) + 1
+			s.Items = append(s.Items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return topk.Sketch{}, err
+	}
+	if err := checkVersion(version); err != nil {
+		return topk.Sketch{}, err
+	}
+	if uint64(len(s.Buckets)) != uint64(s.Width)*uint64(s.Depth) {
+		return topk.Sketch{}, fmt.Errorf("%w: %d buckets of %dx%d sketch", ErrInvalidData, len(s.Buckets), s.Width, s.Depth)
+	}
+	return s, nil
+}
+
+// MarshalHotList encode the hot list as an aegis.v1.HotList.
+func MarshalHotList(l HotList) []byte {
+	b := appendVarint(nil, hotListVersion, Version)
+	if l.Source != "" {
+		b = protowire.AppendTag(b, hotListSource, protowire.BytesType)
+		b = protowire.AppendString(b, l.Source)
+	}
+	b = appendVarint(b, hotListTime, uint64(unixNano(l.Time)))
+	b = appendVarint(b, hotListTotal, l.Total)
+	b = appendVarint(b, hotListCardinality, l.Cardinality)
+	var buf []byte
+	for _, item := range l.Items {
+		buf = appendItem(buf[:0], item)
+		b = appendMessage(b, hotListItems, buf)
+	}
+	return b
+}
+
+// UnmarshalHotList decode an aegis.v1.HotList, the ranks of the items follow
+// their order.
+func UnmarshalHotList(b []byte) (HotList, error) {
+	var l HotList
+	var version uint64
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v uint64, msg []byte) error {
+		switch {
+		case num == hotListVersion && typ == protowire.VarintType:
+			version = v
+		case num == hotListSource && typ == protowire.BytesType:
+			l.Source = string(msg)
+		case num == hotListTime && typ == protowire.VarintType:
+			l.Time = fromUnixNano(int64(v))
+		case num == hotListTotal && typ == protowire.VarintType:
+			l.Total = v
+		case num == hotListCardinality && typ == protowire.VarintType:
+			l.Cardinality = v
+		case num == hotListItems && typ == protowire.BytesType:
+			item, err := consumeItem(msg)
+			if err != nil {
+				return err
+			}
+			item.Rank = len(l.Items) + 1
+			l.Items = append(l.Items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return HotList{}, err
+	}
+	if err := checkVersion(version); err != nil {
+		return HotList{}, err
+	}
+	return l, nil
+}
+
+// MarshalHotListRequest encode an aegis.v1.HotListRequest of the first limit
+// hot keys.
+func MarshalHotListRequest(limit uint32) []byte {
+	return appendVarint(nil, hotListRequestLimit, uint64(limit))
+}
+
+// UnmarshalHotListRequest decode an aegis.v1.HotListRequest and return the limit.
+func UnmarshalHotListRequest(b []byte) (uint32, error) {
+	var limit uint32
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v uint64, _ []byte) error {
+		if num == hotListRequestLimit && typ == protowire.VarintType {
+			limit = uint32(v)
+		}
+		return nil
+	})
+	return limit, err
+}
+
+func checkVersion(version uint64) error {
+	if version == 0 {
+		return fmt.Errorf("%w: missing version", ErrInvalidData)
+	}
+	if version > Version {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	return nil
+}
+
+// appendVarint append a varint field, zero values are omitted as in proto3.
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendItem(b []byte, item topk.Item) []byte {
+	if item.Key != "" {
+		b = protowire.AppendTag(b, itemKey, protowire.BytesType)
+		b = protowire.AppendString(b, item.Key)
+	}
+	b = appendVarint(b, itemCount, uint64(item.Count))
+	b = appendVarint(b, itemFirstSeen, uint64(unixNano(item.FirstSeen)))
+	return appendVarint(b, itemLastSeen, uint64(unixNano(item.LastSeen)))
+}
+
+func consumeBucket(b []byte) (topk.Bucket, error) {
+	var bucket topk.Bucket
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v uint64, _ []byte) error {
+		switch {
+		case num == bucketFingerprint && typ == protowire.VarintType:
+			bucket.Fingerprint = uint32(v)
+		case num == bucketCount && typ == protowire.VarintType:
+			bucket.Count = uint32(v)
+		}
+		return nil
+	})
+	return bucket, err
+}
+
+func consumeItem(b []byte) (topk.Item, error) {
+	var item topk.Item
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v uint64, msg []byte) error {
+		switch {
+		case num == itemKey && typ == protowire.BytesType:
+			item.Key = string(msg)
+		case num == itemCount && typ == protowire.VarintType:
+			item.Count = uint32(v)
+		case num == itemFirstSeen && typ == protowire.VarintType:
+			item.FirstSeen = fromUnixNano(int64(v))
+		case num == itemLastSeen && typ == protowire.VarintType:
+			item.LastSeen = fromUnixNano(int64(v))
+		}
+		return nil
+	})
+	return item, err
+}
+
+// consumeFields call fn with the varint value or the bytes of each field,
+// fields of other wire types are skipped like unknown fields.
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, msg []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrInvalidData, protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v uint64
+		var msg []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			msg, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: field %d: %v", ErrInvalidData, num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ != protowire.VarintType && typ != protowire.BytesType {
+			continue
+		}
+		if err := fn(num, typ, v, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec)
+}
@@ -0,0 +1,119 @@
+package aegispb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/topk"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestSketch(t *testing.T) {
+	hk := topk.NewHeavyKeeper(3, 100, 4, 0.925, 0, topk.WithSeed(7), topk.WithTimestamps()).(*topk.HeavyKeeper)
+	for i := 0; i < 5; i++ {
+		for j := 0; j <= i; j++ {
+			hk.Add(strconv.Itoa(i), 1)
+		}
+	}
+	sketch := hk.Sketch()
+	decoded, err := UnmarshalSketch(MarshalSketch(sketch))
+	assert.NoError(t, err)
+	assert.Equal(t, sketch.Buckets, decoded.Buckets)
+	assert.Equal(t, sketch.Total, decoded.Total)
+	assert.Len(t, decoded.Items, 3)
+	for i, item := range sketch.Items {
+		assert.Equal(t, item.Key, decoded.Items[i].Key)
+		assert.Equal(t, item.Rank, decoded.Items[i].Rank)
+		assert.True(t, item.FirstSeen.Equal(decoded.Items[i].FirstSeen))
+	}
+
+	restored := topk.NewHeavyKeeper(3, 100, 4, 0.925, 0, topk.WithSeed(7)).(*topk.HeavyKeeper)
+	assert.NoError(t, restored.Restore(decoded))
+	assert.Equal(t, []string{"4", "3", "2"}, keys(restored.List()))
+
+	_, err = UnmarshalSketch(MarshalSketch(topk.Sketch{Width: 2, Depth: 2, Buckets: make([]topk.Bucket, 3)}))
+	assert.ErrorIs(t, err, ErrInvalidData)
+}
+
+func TestHotList(t *testing.T) {
+	now := time.Now()
+	list := HotList{
+		Source: "10.0.0.1:8080",
+		Snapshot: topk.Snapshot{
+			Time:        now,
+			Total:       100,
+			Cardinality: 20,
+			Items:       []topk.Item{{Key: "a", Count: 50, Rank: 1}, {Key: "b", Count: 0, Rank: 2}},
+		},
+	}
+	decoded, err := UnmarshalHotList(MarshalHotList(list))
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(decoded.Time))
+	decoded.Time = list.Time
+	assert.Equal(t, list, decoded)
+}
+
+func TestCompatibility(t *testing.T) {
+	b := MarshalHotList(HotList{Snapshot: topk.Snapshot{Total: 1}})
+	// fields added by newer versions are skipped
+	b = protowire.AppendTag(b, 100, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, 1)
+	b = protowire.AppendTag(b, 101, protowire.BytesType)
+	b = protowire.AppendString(b, "unknown")
+	list, err := UnmarshalHotList(b)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), list.Total)
+
+	b = protowire.AppendTag(nil, hotListVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, Version+1)
+	_, err = UnmarshalHotList(b)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	limit, err := UnmarshalHotListRequest(MarshalHotListRequest(10))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(10), limit)
+	_, err = UnmarshalSketch(nil)
+	assert.ErrorIs(t, err, ErrInvalidData)
+	full := MarshalSketch(topk.Sketch{Width: 1, Depth: 1, Buckets: []topk.Bucket{{Fingerprint: 1, Count: 1}}})
+	_, err = UnmarshalSketch(full[:len(full)-1])
+	assert.ErrorIs(t, err, ErrInvalidData)
+}
+
+func keys(items []topk.Item) []string {
+	res := make([]string, 0, len(items))
+	for _, item := range items {
+		res = append(res, item.Key)
+	}
+	return res
+}
+
+func TestDelimited(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteDelimited(&buf, []byte("a")))
+	assert.NoError(t, WriteDelimited(&buf, nil))
+	assert.NoError(t, WriteDelimited(&buf, MarshalHotList(HotList{Source: "b"})))
+	r := bufio.NewReader(&buf)
+	msg, err := ReadDelimited(r)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), msg)
+	msg, err = ReadDelimited(r)
+	assert.NoError(t, err)
+	assert.Empty(t, msg)
+	msg, err = ReadDelimited(r)
+	assert.NoError(t, err)
+	list, err := UnmarshalHotList(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", list.Source)
+	_, err = ReadDelimited(r)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = ReadDelimited(bufio.NewReader(bytes.NewReader([]byte{3, 'a'})))
+	assert.ErrorIs(t, err, ErrInvalidData)
+	_, err = ReadDelimited(bufio.NewReader(bytes.NewReader(binary.AppendUvarint(nil, MaxMessageSize+1))))
+	assert.ErrorIs(t, err, ErrInvalidData)
+}
@@ -0,0 +1,39 @@
+package aegispb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxMessageSize is the maximum size of a delimited message.
+const MaxMessageSize = 4 << 20
+
+// WriteDelimited write the message prefixed by its size in varint, the
+// delimited format of protobuf, so messages can be streamed over a connection.
+func WriteDelimited(w io.Writer, msg []byte) error {
+	b := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(msg)), uint64(len(msg)))
+	_, err := w.Write(append(b, msg...))
+	return err
+}
+
+// ReadDelimited read a message written by WriteDelimited, it returns io.EOF
+// if r is at the end before the message.
+func ReadDelimited(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	if size > MaxMessageSize {
+		return nil, fmt.Errorf("%w: message of %d bytes", ErrInvalidData, size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidData, err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,19 @@
+module github.com/zychimne/aegis/contrib/aegispb
+
+go 1.21
+
+require (
+	github.com/stretchr/testify v1.8.2
+	github.com/zychimne/aegis v0.0.0
+	google.golang.org/protobuf v1.30.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/twmb/murmur3 v1.1.6 // indirect
+	golang.org/x/exp v0.0.0-20230522175609-2e198f4a06a1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/zychimne/aegis => ../../
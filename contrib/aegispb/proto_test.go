@@ -0,0 +1,148 @@
+package aegispb
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/topk"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	messageRe = regexp.MustCompile(`^message (\w+) \{$`)
+	fieldRe   = regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+)
+
+// parseProto parses the messages of aegis.proto, which are plain fields of
+// scalars and messages, into the descriptor of the file.
+func parseProto(t *testing.T) protoreflect.FileDescriptor {
+	b, err := os.ReadFile("aegis.proto")
+	assert.NoError(t, err)
+	scalars := map[string]descriptorpb.FieldDescriptorProto_Type{
+		"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("aegis.proto"),
+		Package: proto.String("aegis.v1"),
+		Syntax:  proto.String("proto3"),
+	}
+	var msg *descriptorpb.DescriptorProto
+	for _, line := range strings.Split(string(b), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if m := messageRe.FindStringSubmatch(line); m != nil {
+			msg = &descriptorpb.DescriptorProto{Name: proto.String(m[1])}
+			file.MessageType = append(file.MessageType, msg)
+			continue
+		}
+		if line == "}" {
+			msg = nil
+			continue
+		}
+		m := fieldRe.FindStringSubmatch(line)
+		if msg == nil || m == nil {
+			continue
+		}
+		number, err := strconv.Atoi(m[4])
+		assert.NoError(t, err)
+		field := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(m[3]),
+			JsonName: proto.String(m[3]),
+			Number:   proto.Int32(int32(number)),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if m[1] != "" {
+			field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		}
+		if typ, ok := scalars[m[2]]; ok {
+			field.Type = typ.Enum()
+		} else {
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			field.TypeName = proto.String(".aegis.v1." + m[2])
+		}
+		msg.Field = append(msg.Field, field)
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, fd.Messages().Len())
+	return fd
+}
+
+// roundTrip decodes b into a dynamic message of name and encodes it again.
+func roundTrip(t *testing.T, fd protoreflect.FileDescriptor, name protoreflect.Name, b []byte) (*dynamicpb.Message, []byte) {
+	msg := dynamicpb.NewMessage(fd.Messages().ByName(name))
+	assert.NoError(t, proto.Unmarshal(b, msg))
+	assert.Empty(t, msg.GetUnknown())
+	b, err := proto.Marshal(msg)
+	assert.NoError(t, err)
+	return msg, b
+}
+
+func TestProtoSketch(t *testing.T) {
+	fd := parseProto(t)
+	hk := topk.NewHeavyKeeper(3, 100, 4, 0.925, 0, topk.WithSeed(7), topk.WithTimestamps()).(*topk.HeavyKeeper)
+	for i := 0; i < 5; i++ {
+		for j := 0; j <= i; j++ {
+			hk.Add(strconv.Itoa(i), 1)
+		}
+	}
+	sketch := hk.Sketch()
+	// the sketch is read and written by the generic implementation of protobuf
+	msg, b := roundTrip(t, fd, "Sketch", MarshalSketch(sketch))
+	fields := msg.Descriptor().Fields()
+	assert.Equal(t, uint64(Version), msg.Get(fields.ByName("version")).Uint())
+	assert.Equal(t, uint64(7), msg.Get(fields.ByName("seed")).Uint())
+	assert.Equal(t, sketch.Total, msg.Get(fields.ByName("total")).Uint())
+	assert.Equal(t, len(sketch.Buckets), msg.Get(fields.ByName("buckets")).List().Len())
+	items := msg.Get(fields.ByName("items")).List()
+	assert.Equal(t, 3, items.Len())
+	assert.Equal(t, "4", items.Get(0).Message().Get(fields.ByName("items").Message().Fields().ByName("key")).String())
+
+	decoded, err := UnmarshalSketch(b)
+	assert.NoError(t, err)
+	assert.Equal(t, sketch.Buckets, decoded.Buckets)
+	assert.Equal(t, keys(sketch.Items), keys(decoded.Items))
+}
+
+func TestProtoHotList(t *testing.T) {
+	fd := parseProto(t)
+	now := time.Now()
+	list := HotList{
+		Source: "10.0.0.1:8080",
+		Snapshot: topk.Snapshot{
+			Time:        now,
+			Total:       100,
+			Cardinality: 20,
+			Items:       []topk.Item{{Key: "a", Count: 50, Rank: 1}, {Key: "b", Count: 10, Rank: 2}},
+		},
+	}
+	msg, b := roundTrip(t, fd, "HotList", MarshalHotList(list))
+	fields := msg.Descriptor().Fields()
+	assert.Equal(t, list.Source, msg.Get(fields.ByName("source")).String())
+	assert.Equal(t, now.UnixNano(), msg.Get(fields.ByName("time_unix_nano")).Int())
+	assert.Equal(t, uint64(20), msg.Get(fields.ByName("cardinality")).Uint())
+	assert.Equal(t, 2, msg.Get(fields.ByName("items")).List().Len())
+
+	decoded, err := UnmarshalHotList(b)
+	assert.NoError(t, err)
+	assert.True(t, now.Equal(decoded.Time))
+	decoded.Time = list.Time
+	assert.Equal(t, list, decoded)
+
+	msg, _ = roundTrip(t, fd, "HotListRequest", MarshalHotListRequest(10))
+	assert.Equal(t, uint64(10), msg.Get(msg.Descriptor().Fields().ByName("limit")).Uint())
+}
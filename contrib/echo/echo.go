@@ -0,0 +1,114 @@
+// Package echo provides the Echo middleware applying a middleware.Guard, with
+// the same knobs as the net/http middleware. Rejected requests get an
+// echo.HTTPError of 429 if the limit is exceeded and 503 if the breaker is
+// open, and 5xx responses or errors are counted as failures.
+package echo
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/zychimne/aegis/middleware"
+)
+
+// errServer is the failure reported for 5xx responses.
+var errServer = errors.New("echo: server error")
+
+// Option is middleware option function.
+type Option func(*options)
+
+// options of middleware.
+type options struct {
+	key      func(c echo.Context) string
+	resource func(c echo.Context) string
+	guard    func(c echo.Context) *middleware.Guard
+	reject   func(c echo.Context, err error) error
+}
+
+// WithKeyFunc with the hot key extractor, keys are counted as "resource:key".
+// Default no key is counted.
+func WithKeyFunc(fn func(c echo.Context) string) Option {
+	return func(o *options) {
+		o.key = fn
+	}
+}
+
+// WithResourceFunc with the resource extractor, default the method and the route path.
+func WithResourceFunc(fn func(c echo.Context) string) Option {
+	return func(o *options) {
+		o.resource = fn
+	}
+}
+
+// WithGuardFunc with the selector of the guard of a request, such as a guard
+// per route, the request passes through if it returns nil.
+func WithGuardFunc(fn func(c echo.Context) *middleware.Guard) Option {
+	return func(o *options) {
+		o.guard = fn
+	}
+}
+
+// WithRejectHandler with the handler of rejected requests, default returns an
+// echo.HTTPError of the status code of middleware.StatusCode.
+func WithRejectHandler(fn func(c echo.Context, err error) error) Option {
+	return func(o *options) {
+		o.reject = fn
+	}
+}
+
+func reject(_ echo.Context, err error) error {
+	return echo.NewHTTPError(middleware.StatusCode(err)).SetInternal(err)
+}
+
+// failed reports whether the request failed with err or a 5xx response.
+func failed(c echo.Context, err error) bool {
+	if err != nil {
+		var he *echo.HTTPError
+		if errors.As(err, &he) {
+			return he.Code >= http.StatusInternalServerError
+		}
+		return true
+	}
+	return c.Response().Status >= http.StatusInternalServerError
+}
+
+// Middleware returns the Echo middleware applying g.
+func Middleware(g *middleware.Guard, opts ...Option) echo.MiddlewareFunc {
+	opt := options{
+		key: func(echo.Context) string { return "" },
+		resource: func(c echo.Context) string {
+			return c.Request().Method + " " + c.Path()
+		},
+		guard:  func(echo.Context) *middleware.Guard { return g },
+		reject: reject,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			guard := opt.guard(c)
+			if guard == nil {
+				return next(c)
+			}
+			done, err := guard.Allow(opt.resource(c), opt.key(c))
+			if err != nil {
+				return opt.reject(c, err)
+			}
+			defer func() {
+				if p := recover(); p != nil {
+					done(errServer)
+					panic(p)
+				}
+			}()
+			err = next(c)
+			if failed(c, err) {
+				done(errServer)
+			} else {
+				done(nil)
+			}
+			return err
+		}
+	}
+}
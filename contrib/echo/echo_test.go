@@ -0,0 +1,80 @@
+package echo
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/middleware"
+)
+
+type testBreaker struct {
+	open    bool
+	failure int
+}
+
+func (b *testBreaker) Allow() error {
+	if b.open {
+		return circuitbreaker.ErrNotAllowed
+	}
+	return nil
+}
+
+func (b *testBreaker) MarkSuccess() {}
+
+func (b *testBreaker) MarkFailed() { b.failure++ }
+
+func call(m echo.MiddlewareFunc, handler echo.HandlerFunc, target string) error {
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, target, nil), httptest.NewRecorder())
+	c.SetPath("/user/:id")
+	return m(handler)(c)
+}
+
+func TestMiddleware(t *testing.T) {
+	breaker := &testBreaker{}
+	h, err := hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: 10, MinCount: 1})
+	assert.NoError(t, err)
+	g := middleware.New(middleware.WithBreaker(breaker), middleware.WithHotkey(h))
+	m := Middleware(g, WithKeyFunc(func(c echo.Context) string { return c.QueryParam("user") }))
+
+	assert.NoError(t, call(m, func(c echo.Context) error { return c.NoContent(http.StatusOK) }, "/user/1?user=42"))
+	assert.Equal(t, "GET /user/:id:42", h.List()[0].Key)
+	err = call(m, func(echo.Context) error { return echo.NewHTTPError(http.StatusNotFound) }, "/user/1")
+	assert.Error(t, err)
+	assert.Equal(t, 0, breaker.failure)
+	err = call(m, func(echo.Context) error { return errors.New("failed") }, "/user/1")
+	assert.Error(t, err)
+	assert.Equal(t, 1, breaker.failure)
+	assert.NoError(t, call(m, func(c echo.Context) error { return c.NoContent(http.StatusBadGateway) }, "/user/1"))
+	assert.Equal(t, 2, breaker.failure)
+
+	breaker.open = true
+	err = call(m, nil, "/user/1")
+	var he *echo.HTTPError
+	assert.True(t, errors.As(err, &he))
+	assert.Equal(t, http.StatusServiceUnavailable, he.Code)
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	g := middleware.New(middleware.WithBreaker(&testBreaker{open: true}))
+	m := Middleware(g,
+		WithGuardFunc(func(c echo.Context) *middleware.Guard {
+			if c.QueryParam("skip") != "" {
+				return nil
+			}
+			return g
+		}),
+		WithRejectHandler(func(c echo.Context, err error) error {
+			return c.NoContent(http.StatusTeapot)
+		}),
+	)
+	ok := func(echo.Context) error { return nil }
+	assert.NoError(t, call(m, ok, "/user/1?skip=1"))
+	assert.NoError(t, call(m, ok, "/user/1"))
+}
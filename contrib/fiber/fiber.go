@@ -0,0 +1,122 @@
+// Package fiber provides the Fiber middleware applying a middleware.Guard,
+// with the same knobs as the net/http middleware. Rejected requests get a
+// fiber.Error of 429 if the limit is exceeded and 503 if the breaker is open,
+// and 5xx responses or errors are counted as failures.
+package fiber
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/zychimne/aegis/middleware"
+)
+
+// errServer is the failure reported for 5xx responses.
+var errServer = errors.New("fiber: server error")
+
+// Option is middleware option function.
+type Option func(*options)
+
+// options of middleware.
+type options struct {
+	key      func(c *fiber.Ctx) string
+	resource func(c *fiber.Ctx) string
+	guard    func(c *fiber.Ctx) *middleware.Guard
+	reject   func(c *fiber.Ctx, err error) error
+}
+
+// WithKeyFunc with the hot key extractor, keys are counted as "resource:key".
+// Default no key is counted.
+func WithKeyFunc(fn func(c *fiber.Ctx) string) Option {
+	return func(o *options) {
+		o.key = fn
+	}
+}
+
+// WithResourceFunc with the resource extractor, default the method and the
+// route path, or the path if the middleware is registered by app.Use, whose
+// route is not matched yet. Extract the route pattern instead if the path
+// contains parameters and the middleware is registered by app.Use.
+func WithResourceFunc(fn func(c *fiber.Ctx) string) Option {
+	return func(o *options) {
+		o.resource = fn
+	}
+}
+
+// WithGuardFunc with the selector of the guard of a request, such as a guard
+// per route, the request passes through if it returns nil.
+func WithGuardFunc(fn func(c *fiber.Ctx) *middleware.Guard) Option {
+	return func(o *options) {
+		o.guard = fn
+	}
+}
+
+// WithRejectHandler with the handler of rejected requests, default returns a
+// fiber.Error of the status code of middleware.StatusCode.
+func WithRejectHandler(fn func(c *fiber.Ctx, err error) error) Option {
+	return func(o *options) {
+		o.reject = fn
+	}
+}
+
+func reject(_ *fiber.Ctx, err error) error {
+	return fiber.NewError(middleware.StatusCode(err), err.Error())
+}
+
+// resource returns the method and the route path of c if it has parameters,
+// or the path, which is the route path of the routes without parameters and
+// not the prefix of app.Use.
+func resource(c *fiber.Ctx) string {
+	if route := c.Route(); len(route.Params) > 0 {
+		return c.Method() + " " + route.Path
+	}
+	return c.Method() + " " + c.Path()
+}
+
+// failed reports whether the request failed with err or a 5xx response.
+func failed(c *fiber.Ctx, err error) bool {
+	if err != nil {
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			return fe.Code >= fiber.StatusInternalServerError
+		}
+		return true
+	}
+	return c.Response().StatusCode() >= fiber.StatusInternalServerError
+}
+
+// Middleware returns the Fiber middleware applying g.
+func Middleware(g *middleware.Guard, opts ...Option) fiber.Handler {
+	opt := options{
+		key:      func(*fiber.Ctx) string { return "" },
+		resource: resource,
+		guard:    func(*fiber.Ctx) *middleware.Guard { return g },
+		reject:   reject,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return func(c *fiber.Ctx) error {
+		guard := opt.guard(c)
+		if guard == nil {
+			return c.Next()
+		}
+		done, err := guard.Allow(opt.resource(c), opt.key(c))
+		if err != nil {
+			return opt.reject(c, err)
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				done(errServer)
+				panic(p)
+			}
+		}()
+		err = c.Next()
+		if failed(c, err) {
+			done(errServer)
+		} else {
+			done(nil)
+		}
+		return err
+	}
+}
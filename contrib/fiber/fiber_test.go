@@ -0,0 +1,93 @@
+package fiber
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/middleware"
+)
+
+type testBreaker struct {
+	open    bool
+	failure int
+}
+
+func (b *testBreaker) Allow() error {
+	if b.open {
+		return circuitbreaker.ErrNotAllowed
+	}
+	return nil
+}
+
+func (b *testBreaker) MarkSuccess() {}
+
+func (b *testBreaker) MarkFailed() { b.failure++ }
+
+func get(t *testing.T, app *fiber.App, target string) int {
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, target, nil))
+	assert.NoError(t, err)
+	return resp.StatusCode
+}
+
+func TestMiddleware(t *testing.T) {
+	breaker := &testBreaker{}
+	h, err := hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: 10, MinCount: 1})
+	assert.NoError(t, err)
+	g := middleware.New(middleware.WithBreaker(breaker), middleware.WithHotkey(h))
+	app := fiber.New()
+	app.Use(Middleware(g, WithKeyFunc(func(c *fiber.Ctx) string { return c.Query("user") })))
+	app.Get("/ok", func(c *fiber.Ctx) error { return nil })
+	app.Get("/missing", func(c *fiber.Ctx) error { return fiber.NewError(http.StatusNotFound) })
+	app.Get("/error", func(c *fiber.Ctx) error { return errors.New("failed") })
+	app.Get("/gateway", func(c *fiber.Ctx) error { return c.SendStatus(http.StatusBadGateway) })
+
+	assert.Equal(t, http.StatusOK, get(t, app, "/ok?user=42"))
+	assert.Equal(t, "GET /ok:42", h.List()[0].Key)
+	assert.Equal(t, http.StatusNotFound, get(t, app, "/missing"))
+	assert.Equal(t, 0, breaker.failure)
+	assert.Equal(t, http.StatusInternalServerError, get(t, app, "/error"))
+	assert.Equal(t, http.StatusBadGateway, get(t, app, "/gateway"))
+	assert.Equal(t, 2, breaker.failure)
+
+	breaker.open = true
+	assert.Equal(t, http.StatusServiceUnavailable, get(t, app, "/ok"))
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	g := middleware.New(middleware.WithBreaker(&testBreaker{open: true}))
+	app := fiber.New()
+	app.Use(Middleware(g,
+		WithGuardFunc(func(c *fiber.Ctx) *middleware.Guard {
+			if c.Path() == "/health" {
+				return nil
+			}
+			return g
+		}),
+		WithRejectHandler(func(c *fiber.Ctx, err error) error {
+			return c.SendStatus(http.StatusTeapot)
+		}),
+	))
+	app.Get("/health", func(c *fiber.Ctx) error { return nil })
+	app.Get("/ok", func(c *fiber.Ctx) error { return nil })
+	assert.Equal(t, http.StatusOK, get(t, app, "/health"))
+	assert.Equal(t, http.StatusTeapot, get(t, app, "/ok"))
+}
+
+func TestMiddlewareRoute(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: 10, MinCount: 1})
+	assert.NoError(t, err)
+	m := Middleware(middleware.New(middleware.WithHotkey(h)), WithKeyFunc(func(*fiber.Ctx) string { return "k" }))
+	app := fiber.New()
+	app.Get("/user/:id", m, func(c *fiber.Ctx) error { return nil })
+	// the requests of a route share the resource of its path
+	assert.Equal(t, http.StatusOK, get(t, app, "/user/1"))
+	assert.Equal(t, http.StatusOK, get(t, app, "/user/2"))
+	assert.Len(t, h.List(), 1)
+	assert.Equal(t, "GET /user/:id:k", h.List()[0].Key)
+}
@@ -0,0 +1,110 @@
+// Package gin provides the Gin middleware applying a middleware.Guard per
+// route. Rejected requests are aborted with 429 if the limit is exceeded and
+// 503 if the breaker is open, and responses with 5xx status are counted as
+// failures.
+package gin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zychimne/aegis/middleware"
+)
+
+// errServer is the failure reported for 5xx responses.
+var errServer = errors.New("gin: server error")
+
+// Option is middleware option function.
+type Option func(*options)
+
+// options of middleware.
+type options struct {
+	key    func(c *gin.Context) string
+	guard  func(c *gin.Context) *middleware.Guard
+	reject func(c *gin.Context, err error)
+}
+
+// WithKeyFunc with the hot key extractor, keys are counted as "route:key".
+// Default no key is counted.
+func WithKeyFunc(fn func(c *gin.Context) string) Option {
+	return func(o *options) {
+		o.key = fn
+	}
+}
+
+// WithRoutes with the guards of routes, the keys are the full paths of routes
+// such as "/user/:id", other routes use the default guard.
+func WithRoutes(routes map[string]*middleware.Guard) Option {
+	return func(o *options) {
+		def := o.guard
+		o.guard = func(c *gin.Context) *middleware.Guard {
+			if g, ok := routes[c.FullPath()]; ok {
+				return g
+			}
+			return def(c)
+		}
+	}
+}
+
+// WithGuardFunc with the selector of the guard of a request, the request
+// passes through if it returns nil.
+func WithGuardFunc(fn func(c *gin.Context) *middleware.Guard) Option {
+	return func(o *options) {
+		o.guard = fn
+	}
+}
+
+// WithRejectHandler with the handler of rejected requests, default aborts
+// with the status code of middleware.StatusCode.
+func WithRejectHandler(fn func(c *gin.Context, err error)) Option {
+	return func(o *options) {
+		o.reject = fn
+	}
+}
+
+func reject(c *gin.Context, err error) {
+	c.AbortWithStatus(middleware.StatusCode(err))
+}
+
+// Middleware returns the Gin middleware applying g, use it on the engine, a
+// group or a route to configure them separately.
+func Middleware(g *middleware.Guard, opts ...Option) gin.HandlerFunc {
+	opt := options{
+		key:    func(*gin.Context) string { return "" },
+		guard:  func(*gin.Context) *middleware.Guard { return g },
+		reject: reject,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return func(c *gin.Context) {
+		guard := opt.guard(c)
+		if guard == nil {
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		if route == "" {
+			// unmatched routes share a breaker
+			route = "404"
+		}
+		done, err := guard.Allow(c.Request.Method+" "+route, opt.key(c))
+		if err != nil {
+			opt.reject(c, err)
+			return
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				done(errServer)
+				panic(p)
+			}
+			if c.Writer.Status() >= http.StatusInternalServerError {
+				done(errServer)
+				return
+			}
+			done(nil)
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,72 @@
+package gin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/middleware"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+)
+
+type testBreaker struct {
+	open    bool
+	failure int
+}
+
+func (b *testBreaker) Allow() error {
+	if b.open {
+		return circuitbreaker.ErrNotAllowed
+	}
+	return nil
+}
+
+func (b *testBreaker) MarkSuccess() {}
+
+func (b *testBreaker) MarkFailed() { b.failure++ }
+
+func serve(h http.Handler, path string) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code
+}
+
+func TestMiddleware(t *testing.T) {
+	breaker := &testBreaker{}
+	h, err := hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: 10, MinCount: 1})
+	assert.NoError(t, err)
+	g := middleware.New(
+		middleware.WithLimiter(concurrency.NewLimiter(1)),
+		middleware.WithBreaker(breaker),
+		middleware.WithHotkey(h),
+	)
+	r := gin.New()
+	r.Use(Middleware(g, WithKeyFunc(func(c *gin.Context) string { return c.Query("user") })))
+	r.GET("/nested", func(c *gin.Context) {
+		assert.Equal(t, http.StatusTooManyRequests, serve(r, "/ok"))
+	})
+	r.GET("/ok", func(c *gin.Context) {})
+	r.GET("/error", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+
+	assert.Equal(t, http.StatusOK, serve(r, "/nested?user=42"))
+	assert.Equal(t, http.StatusInternalServerError, serve(r, "/error"))
+	assert.Equal(t, 1, breaker.failure)
+	assert.Equal(t, "GET /nested:42", h.List()[0].Key)
+
+	breaker.open = true
+	assert.Equal(t, http.StatusServiceUnavailable, serve(r, "/ok"))
+}
+
+func TestMiddlewareRoutes(t *testing.T) {
+	open := middleware.New(middleware.WithBreaker(&testBreaker{open: true}))
+	r := gin.New()
+	r.Use(Middleware(middleware.New(), WithRoutes(map[string]*middleware.Guard{"/closed": open})))
+	r.GET("/ok", func(c *gin.Context) {})
+	r.GET("/closed", func(c *gin.Context) {})
+	assert.Equal(t, http.StatusOK, serve(r, "/ok"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(r, "/closed"))
+}
@@ -0,0 +1,23 @@
+module github.com/zychimne/aegis/contrib/gorm
+
+go 1.21
+
+require (
+	github.com/stretchr/testify v1.8.4
+	github.com/zychimne/aegis v0.0.0
+	gorm.io/gorm v1.25.5
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/jellydator/ttlcache/v3 v3.1.0 // indirect
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/twmb/murmur3 v1.1.6 // indirect
+	golang.org/x/exp v0.0.0-20230522175609-2e198f4a06a1 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/zychimne/aegis => ../../
@@ -0,0 +1,367 @@
+// Package gorm is a GORM plugin caching hot rows in the local cache of a
+// hotkey. Lookups by primary key are counted into the hotkey, the rows of hot
+// keys are served from the local cache, and the updates and deletes through
+// GORM invalidate the cached rows.
+package gorm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/hotkey"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNoQueryCallback is returned by Initialize if the query callback of GORM
+// is not registered.
+var ErrNoQueryCallback = errors.New("gorm: query callback not registered")
+
+// Option is plugin option function.
+type Option func(*options)
+
+// options of plugin.
+type options struct {
+	ttl    time.Duration
+	tables map[string]bool
+}
+
+// WithTTL with the ttl of the cached rows, it's bounded by the ttl of the
+// hotkey, default 0 uses the ttl of the hotkey only.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithTables with the tables whose rows are cached, default all tables.
+func WithTables(tables ...string) Option {
+	return func(o *options) {
+		o.tables = make(map[string]bool, len(tables))
+		for _, table := range tables {
+			o.tables[table] = true
+		}
+	}
+}
+
+// entry is a cached row.
+type entry struct {
+	row      interface{}
+	expireAt time.Time
+}
+
+// Plugin caches the hot rows looked up by primary key.
+type Plugin struct {
+	hotkey *hotkey.HotKeyWithCache
+	mu     sync.Mutex
+	keys   map[string]map[string]struct{}
+	// indexed is the number of keys, the keys no longer cached are pruned
+	// when it reaches pruneAt.
+	indexed int
+	pruneAt int
+	// gens is the invalidation generation of the tables, a lookup skips
+	// caching its row if the table is invalidated while it runs.
+	gens map[string]uint64
+	opts options
+}
+
+var _ gorm.Plugin = (*Plugin)(nil)
+
+// New returns a plugin caching the rows in the local cache of h, which must
+// have a local cache by AutoCache or whitelist rules matching the keys. The
+// keys are "gorm:<table>:<primary key>".
+func New(h *hotkey.HotKeyWithCache, opts ...Option) *Plugin {
+	var opt options
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Plugin{
+		hotkey:  h,
+		keys:    make(map[string]map[string]struct{}),
+		pruneAt: minPruneAt,
+		gens:    make(map[string]uint64),
+		opts:    opt,
+	}
+}
+
+// Name implements gorm.Plugin.
+func (p *Plugin) Name() string {
+	return "aegis:hotkey"
+}
+
+// Initialize implements gorm.Plugin, it wraps the query callback and registers
+// the invalidation after the update and delete callbacks.
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	query := db.Callback().Query()
+	next := query.Get("gorm:query")
+	if next == nil {
+		return ErrNoQueryCallback
+	}
+	if err := query.Replace("gorm:query", p.query(next)); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("aegis:hotkey_update", p.invalidate); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("aegis:hotkey_delete", p.invalidate)
+}
+
+// query serves the lookup of a row by primary key from the cache, or runs next
+// and adds the row to the hotkey.
+func (p *Plugin) query(next func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		key, dest, ok := p.lookup(db)
+		if !ok {
+			next(db)
+			return
+		}
+		if e, ok := p.hotkey.Get(key).(*entry); ok {
+			if row := reflect.ValueOf(e.row); row.Type() == dest.Type() && (e.expireAt.IsZero() || time.Now().Before(e.expireAt)) {
+				p.hotkey.Add(key, 1)
+				dest.Set(row)
+				db.RowsAffected = 1
+				return
+			}
+			p.hotkey.Del(key)
+		}
+		name := table(db.Statement)
+		gen := p.generation(name)
+		next(db)
+		if db.Error != nil || db.RowsAffected != 1 {
+			return
+		}
+		p.add(name, key, gen, dest.Interface())
+	}
+}
+
+// lookup returns the key and the destination struct of a query of a single row
+// by primary key, without selected columns, joins or preloads which would make
+// the row partial. Locking reads and queries in a transaction go to the
+// database, as they must see the rows of the transaction and its locks.
+func (p *Plugin) lookup(db *gorm.DB) (string, reflect.Value, bool) {
+	stmt := db.Statement
+	if stmt.Schema == nil || stmt.Unscoped || len(stmt.Selects) > 0 || len(stmt.Omits) > 0 ||
+		len(stmt.Joins) > 0 || len(stmt.Preloads) > 0 {
+		return "", reflect.Value{}, false
+	}
+	if _, ok := stmt.Clauses["FOR"]; ok {
+		return "", reflect.Value{}, false
+	}
+	if _, ok := stmt.ConnPool.(gorm.TxCommitter); ok {
+		return "", reflect.Value{}, false
+	}
+	name := table(stmt)
+	if len(p.opts.tables) > 0 && !p.opts.tables[name] {
+		return "", reflect.Value{}, false
+	}
+	dest := reflect.ValueOf(stmt.Dest)
+	if dest.Kind() != reflect.Ptr || dest.Elem().Kind() != reflect.Struct || dest.Elem().Type() != stmt.Schema.ModelType {
+		return "", reflect.Value{}, false
+	}
+	values := primaryKeys(stmt)
+	if len(values) != 1 {
+		return "", reflect.Value{}, false
+	}
+	return key(name, values[0]), dest.Elem(), true
+}
+
+// invalidate removes the cached rows written by an update or delete, or all
+// the rows of the table if the rows are unknown.
+func (p *Plugin) invalidate(db *gorm.DB) {
+	stmt := db.Statement
+	if stmt.Schema == nil {
+		return
+	}
+	name := table(stmt)
+	values := primaryKeys(stmt)
+	if len(values) == 0 {
+		values = modelKeys(db)
+	}
+	if len(values) == 0 {
+		p.InvalidateTable(name)
+		return
+	}
+	for _, v := range values {
+		p.Invalidate(name, v)
+	}
+}
+
+// Invalidate removes the cached row of a primary key, for writes made out of
+// GORM.
+func (p *Plugin) Invalidate(table string, primaryKey interface{}) {
+	k := key(table, primaryKey)
+	p.mu.Lock()
+	p.gens[table]++
+	if _, ok := p.keys[table][k]; ok {
+		delete(p.keys[table], k)
+		p.indexed--
+	}
+	p.mu.Unlock()
+	p.hotkey.Del(k)
+}
+
+// InvalidateTable removes all the cached rows of a table.
+func (p *Plugin) InvalidateTable(table string) {
+	p.mu.Lock()
+	p.gens[table]++
+	keys := p.keys[table]
+	p.indexed -= len(keys)
+	delete(p.keys, table)
+	p.mu.Unlock()
+	for k := range keys {
+		p.hotkey.Del(k)
+	}
+}
+
+// minPruneAt is the minimum number of keys before the index is pruned.
+const minPruneAt = 1024
+
+// generation returns the invalidation generation of the table.
+func (p *Plugin) generation(table string) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gens[table]
+}
+
+// add adds the row to the hotkey and indexes its key by the table, unless the
+// table is invalidated since gen, in which case the row may be stale and is
+// only counted.
+func (p *Plugin) add(table, key string, gen uint64, row interface{}) {
+	e := &entry{row: row}
+	if p.opts.ttl > 0 {
+		e.expireAt = time.Now().Add(p.opts.ttl)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.gens[table] != gen {
+		p.hotkey.Add(key, 1)
+		return
+	}
+	p.hotkey.AddWithValue(key, e, 1)
+	if p.hotkey.Get(key) == nil {
+		return
+	}
+	keys, ok := p.keys[table]
+	if !ok {
+		keys = make(map[string]struct{})
+		p.keys[table] = keys
+	}
+	if _, ok := keys[key]; !ok {
+		keys[key] = struct{}{}
+		p.indexed++
+	}
+	if p.indexed >= p.pruneAt {
+		p.prune()
+	}
+}
+
+// prune drops the keys evicted from the local cache from the index, p.mu must
+// be held.
+func (p *Plugin) prune() {
+	for table, keys := range p.keys {
+		for key := range keys {
+			if p.hotkey.Get(key) == nil {
+				delete(keys, key)
+				p.indexed--
+			}
+		}
+		if len(keys) == 0 {
+			delete(p.keys, table)
+		}
+	}
+	p.pruneAt = 2 * p.indexed
+	if p.pruneAt < minPruneAt {
+		p.pruneAt = minPruneAt
+	}
+}
+
+func key(table string, primaryKey interface{}) string {
+	return fmt.Sprintf("gorm:%s:%v", table, primaryKey)
+}
+
+func table(stmt *gorm.Statement) string {
+	if stmt.Table != "" {
+		return stmt.Table
+	}
+	return stmt.Schema.Table
+}
+
+var eqRegexp = regexp.MustCompile("^\\s*(?:[`\"]?\\w+[`\"]?\\.)?[`\"]?(\\w+)[`\"]?\\s*=\\s*\\?\\s*$")
+
+// primaryKeys returns the primary keys of the where clause, if it consists of
+// a single condition on the primary key such as "id = ?", id IN (?) or the
+// primary key argument of First.
+func primaryKeys(stmt *gorm.Statement) []interface{} {
+	field := stmt.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return nil
+	}
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok {
+		return nil
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok || len(where.Exprs) != 1 {
+		return nil
+	}
+	isPrimary := func(column interface{}) bool {
+		switch column := column.(type) {
+		case string:
+			return column == field.DBName
+		case clause.Column:
+			return column.Name == clause.PrimaryKey || column.Name == field.DBName
+		}
+		return false
+	}
+	switch expr := where.Exprs[0].(type) {
+	case clause.Eq:
+		if isPrimary(expr.Column) {
+			return []interface{}{expr.Value}
+		}
+	case clause.IN:
+		if isPrimary(expr.Column) {
+			return expr.Values
+		}
+	case clause.Expr:
+		if m := eqRegexp.FindStringSubmatch(expr.SQL); m != nil && strings.EqualFold(m[1], field.DBName) && len(expr.Vars) == 1 {
+			return expr.Vars
+		}
+	}
+	return nil
+}
+
+// modelKeys returns the non-zero primary keys of the model, such as the struct
+// passed to Save or Model(&row).Updates.
+func modelKeys(db *gorm.DB) []interface{} {
+	stmt := db.Statement
+	field := stmt.Schema.PrioritizedPrimaryField
+	if field == nil || !stmt.ReflectValue.IsValid() {
+		return nil
+	}
+	var values []interface{}
+	add := func(rv reflect.Value) {
+		if rv.Kind() == reflect.Ptr {
+			rv = rv.Elem()
+		}
+		if rv.Kind() != reflect.Struct {
+			return
+		}
+		if v, zero := field.ValueOf(stmt.Context, rv); !zero {
+			values = append(values, v)
+		}
+	}
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			add(rv.Index(i))
+		}
+	case reflect.Struct:
+		add(rv)
+	}
+	return values
+}
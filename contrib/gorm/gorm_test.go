@@ -0,0 +1,316 @@
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/hotkey"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+type User struct {
+	ID   uint
+	Name string
+}
+
+// testDB is an in-memory users table behind database/sql, it understands the
+// statements GORM generates for the tests and counts the selects.
+type testDB struct {
+	mu      sync.Mutex
+	users   map[int64]string
+	selects int
+	// hook is called by the selects out of the lock if it's set.
+	hook func()
+}
+
+func (d *testDB) Connect(context.Context) (driver.Conn, error) { return &testConn{d: d}, nil }
+func (d *testDB) Driver() driver.Driver                        { return nil }
+
+func (d *testDB) queries() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.selects
+}
+
+type testConn struct{ d *testDB }
+
+func (c *testConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *testConn) Close() error                        { return nil }
+func (c *testConn) Begin() (driver.Tx, error)           { return c, nil }
+func (c *testConn) Commit() error                       { return nil }
+func (c *testConn) Rollback() error                     { return nil }
+
+// match reports whether the row matches the last argument, an id or a name.
+func match(args []driver.NamedValue, id int64, name string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	switch v := args[len(args)-1].Value.(type) {
+	case int64:
+		return v == id
+	case string:
+		return v == name
+	}
+	return false
+}
+
+func (c *testConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.d.mu.Lock()
+	hook := c.d.hook
+	c.d.hook = nil
+	c.d.mu.Unlock()
+	if hook != nil {
+		hook()
+	}
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.selects++
+	rows := &testRows{}
+	for id, name := range c.d.users {
+		if match(args, id, name) {
+			rows.rows = append(rows.rows, []driver.Value{id, name})
+		}
+	}
+	return rows, nil
+}
+
+func (c *testConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	var n int64
+	for id, name := range c.d.users {
+		if !match(args, id, name) {
+			continue
+		}
+		n++
+		switch {
+		case strings.HasPrefix(query, "UPDATE"):
+			c.d.users[id] = args[0].Value.(string)
+		case strings.HasPrefix(query, "DELETE"):
+			delete(c.d.users, id)
+		}
+	}
+	return driver.RowsAffected(n), nil
+}
+
+type testRows struct{ rows [][]driver.Value }
+
+func (r *testRows) Columns() []string { return []string{"id", "name"} }
+func (r *testRows) Close() error      { return nil }
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// openTestDB opens a gorm.DB of alice and bob using the plugin.
+func openTestDB(t *testing.T, p *Plugin) (*gorm.DB, *testDB) {
+	d := &testDB{users: map[int64]string{1: "alice", 2: "bob"}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{
+		ConnPool: sql.OpenDB(d),
+		Logger:   logger.Discard,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Use(p))
+	return db, d
+}
+
+func TestQuery(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		HotKeyCnt:     10,
+		LocalCacheCap: 10,
+		AutoCache:     true,
+		TTL:           time.Minute,
+		MinCount:      3,
+	})
+	assert.NoError(t, err)
+	db, d := openTestDB(t, New(h))
+
+	for _, lookup := range []func(u *User) *gorm.DB{
+		func(u *User) *gorm.DB { return db.First(u, 1) },
+		func(u *User) *gorm.DB { return db.Where("id = ?", 1).First(u) },
+		func(u *User) *gorm.DB { return db.Where(&User{ID: 1}).Take(u) },
+		func(u *User) *gorm.DB { return db.First(u, 1) },
+	} {
+		var u User
+		assert.NoError(t, lookup(&u).Error)
+		assert.Equal(t, User{ID: 1, Name: "alice"}, u)
+	}
+	// the lookups by primary key count the same key, which is served from the
+	// cache once its count reaches MinCount
+	assert.Equal(t, 3, d.queries())
+
+	// partial rows and other conditions are not served from the cache
+	var u User
+	assert.NoError(t, db.Select("name").First(&u, 1).Error)
+	assert.NoError(t, db.Where("name = ?", "alice").First(&u).Error)
+	var users []User
+	assert.NoError(t, db.Find(&users, 1).Error)
+	assert.Equal(t, 6, d.queries())
+
+	// a missing row is not cached
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, db.First(&User{}, 3).Error, gorm.ErrRecordNotFound)
+	}
+	assert.Equal(t, 9, d.queries())
+}
+
+func TestInvalidate(t *testing.T) {
+	// the users are whitelisted, so their rows are cached from the first lookup
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		LocalCacheCap: 10,
+		TTL:           time.Minute,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "pattern", Value: "^gorm:users:"}},
+	})
+	assert.NoError(t, err)
+	p := New(h, WithTables("users"))
+	db, d := openTestDB(t, p)
+	lookup := func(id uint) string {
+		var u User
+		assert.NoError(t, db.First(&u, id).Error)
+		return u.Name
+	}
+	lookup(1)
+	lookup(2)
+	assert.Equal(t, 2, d.queries())
+
+	// an update of the model invalidates its row only
+	assert.NoError(t, db.Model(&User{ID: 1}).Update("name", "carol").Error)
+	assert.Equal(t, "carol", lookup(1))
+	assert.Equal(t, "bob", lookup(2))
+	assert.Equal(t, 3, d.queries())
+
+	// a delete by primary key invalidates its row
+	assert.NoError(t, db.Delete(&User{}, 2).Error)
+	var u User
+	assert.ErrorIs(t, db.First(&u, 2).Error, gorm.ErrRecordNotFound)
+	assert.Equal(t, 4, d.queries())
+
+	// a bulk update invalidates the table
+	assert.NoError(t, db.Model(&User{}).Where("name = ?", "carol").Update("name", "dave").Error)
+	assert.Equal(t, "dave", lookup(1))
+	assert.Equal(t, 5, d.queries())
+
+	// writes out of GORM are invalidated explicitly
+	d.users[1] = "erin"
+	p.Invalidate("users", 1)
+	assert.Equal(t, "erin", lookup(1))
+	assert.Equal(t, 6, d.queries())
+}
+
+func TestTTL(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		LocalCacheCap: 10,
+		TTL:           time.Minute,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "key", Value: "gorm:users:1"}},
+	})
+	assert.NoError(t, err)
+	db, d := openTestDB(t, New(h, WithTTL(50*time.Millisecond)))
+	var u User
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, db.First(&u, 1).Error)
+	}
+	assert.Equal(t, 1, d.queries())
+	// the row expires by the ttl of the plugin before the ttl of the hotkey
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, db.First(&u, 1).Error)
+	assert.Equal(t, 2, d.queries())
+}
+
+func TestLockingAndTransaction(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		LocalCacheCap: 10,
+		TTL:           time.Minute,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "key", Value: "gorm:users:1"}},
+	})
+	assert.NoError(t, err)
+	db, d := openTestDB(t, New(h))
+	var u User
+	assert.NoError(t, db.First(&u, 1).Error)
+	assert.Equal(t, 1, d.queries())
+
+	// a locking read goes to the database although the row is cached
+	assert.NoError(t, db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&User{}, 1).Error)
+	assert.Equal(t, 2, d.queries())
+
+	// so does a read in a transaction, which may see its own writes
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var u User
+		if err := tx.First(&u, 1).Error; err != nil {
+			return err
+		}
+		assert.Equal(t, "alice", u.Name)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, d.queries())
+
+	assert.NoError(t, db.First(&u, 1).Error)
+	assert.Equal(t, 3, d.queries())
+}
+
+func TestInvalidateWhileLoading(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		LocalCacheCap: 10,
+		TTL:           time.Minute,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "pattern", Value: "^gorm:users:"}},
+	})
+	assert.NoError(t, err)
+	db, d := openTestDB(t, New(h))
+	started, release := make(chan struct{}), make(chan struct{})
+	d.hook = func() {
+		close(started)
+		<-release
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var u User
+		assert.NoError(t, db.First(&u, 1).Error)
+	}()
+	// the row read before the update is not cached after it
+	<-started
+	assert.NoError(t, db.Model(&User{ID: 1}).Update("name", "carol").Error)
+	close(release)
+	<-done
+	for i := 0; i < 2; i++ {
+		var u User
+		assert.NoError(t, db.First(&u, 1).Error)
+		assert.Equal(t, "carol", u.Name)
+	}
+	assert.Equal(t, 2, d.queries())
+}
+
+func TestPrune(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		LocalCacheCap: 2,
+		TTL:           time.Minute,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "pattern", Value: "^gorm:users:"}},
+	})
+	assert.NoError(t, err)
+	p := New(h)
+	db, d := openTestDB(t, p)
+	for i := int64(3); i <= minPruneAt+10; i++ {
+		d.users[i] = "user"
+	}
+	// the keys evicted from the local cache are pruned from the index
+	for i := 1; i <= minPruneAt+10; i++ {
+		assert.NoError(t, db.First(&User{}, i).Error)
+	}
+	assert.Less(t, p.indexed, 16)
+	assert.Equal(t, p.indexed, len(p.keys["users"]))
+}
@@ -0,0 +1,111 @@
+package gozero
+
+import (
+	"time"
+
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/circuitbreaker/sre"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/middleware"
+	"github.com/zychimne/aegis/ratelimit/bbr"
+)
+
+// Conf is the config of a guard, it can be embedded in the config of a rest
+// or zrpc service and loaded by conf.MustLoad. Components not configured are
+// skipped, and zero fields are defaults.
+type Conf struct {
+	RateLimit      *RateLimitConf      `json:",optional"`
+	CircuitBreaker *CircuitBreakerConf `json:",optional"`
+	Hotkey         *HotkeyConf         `json:",optional"`
+}
+
+// RateLimitConf is the config of the bbr limiter.
+type RateLimitConf struct {
+	Window       time.Duration `json:",optional"`
+	Bucket       int           `json:",optional"`
+	CPUThreshold int64         `json:",optional"`
+	CPUQuota     float64       `json:",optional"`
+}
+
+// CircuitBreakerConf is the config of the sre breakers, one per route or method.
+type CircuitBreakerConf struct {
+	Success float64       `json:",optional"`
+	Request int64         `json:",optional"`
+	Window  time.Duration `json:",optional"`
+	Bucket  int           `json:",optional"`
+}
+
+// HotkeyConf is the config of the hot-key counter.
+type HotkeyConf struct {
+	HotKeyCnt int `json:",optional"`
+	MinCount  int `json:",optional"`
+}
+
+// NewGuard returns the guard of the config, and the hot-key counter if configured.
+func NewGuard(c Conf) (*middleware.Guard, *hotkey.HotKeyWithCache, error) {
+	var (
+		opts []middleware.Option
+		h    *hotkey.HotKeyWithCache
+	)
+	if c.RateLimit != nil {
+		opts = append(opts, middleware.WithLimiter(bbr.NewLimiter(c.RateLimit.options()...)))
+	}
+	if c.CircuitBreaker != nil {
+		breakerOpts := c.CircuitBreaker.options()
+		opts = append(opts, middleware.WithBreakerFactory(func() circuitbreaker.CircuitBreaker {
+			return sre.NewBreaker(breakerOpts...)
+		}))
+	}
+	if c.Hotkey != nil {
+		var err error
+		h, err = hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: c.Hotkey.HotKeyCnt, MinCount: c.Hotkey.MinCount})
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, middleware.WithHotkey(h))
+	}
+	return middleware.New(opts...), h, nil
+}
+
+// MustNewGuard is like NewGuard but panics on error, as the constructors of go-zero.
+func MustNewGuard(c Conf) (*middleware.Guard, *hotkey.HotKeyWithCache) {
+	g, h, err := NewGuard(c)
+	if err != nil {
+		panic(err)
+	}
+	return g, h
+}
+
+func (c *RateLimitConf) options() []bbr.Option {
+	var opts []bbr.Option
+	if c.Window > 0 {
+		opts = append(opts, bbr.WithWindow(c.Window))
+	}
+	if c.Bucket > 0 {
+		opts = append(opts, bbr.WithBucket(c.Bucket))
+	}
+	if c.CPUThreshold > 0 {
+		opts = append(opts, bbr.WithCPUThreshold(c.CPUThreshold))
+	}
+	if c.CPUQuota > 0 {
+		opts = append(opts, bbr.WithCPUQuota(c.CPUQuota))
+	}
+	return opts
+}
+
+func (c *CircuitBreakerConf) options() []sre.Option {
+	var opts []sre.Option
+	if c.Success > 0 {
+		opts = append(opts, sre.WithSuccess(c.Success))
+	}
+	if c.Request > 0 {
+		opts = append(opts, sre.WithRequest(c.Request))
+	}
+	if c.Window > 0 {
+		opts = append(opts, sre.WithWindow(c.Window))
+	}
+	if c.Bucket > 0 {
+		opts = append(opts, sre.WithBucket(c.Bucket))
+	}
+	return opts
+}
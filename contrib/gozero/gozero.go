@@ -0,0 +1,70 @@
+// Package gozero wires a middleware.Guard into the rest and zrpc servers and
+// clients of go-zero. The rest middleware is the net/http middleware of aegis,
+// and the zrpc interceptors are the gRPC interceptors of aegis.
+package gozero
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/zeromicro/go-zero/rest"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+	"github.com/zeromicro/go-zero/zrpc"
+	aegisgrpc "github.com/zychimne/aegis/contrib/grpc"
+	"github.com/zychimne/aegis/middleware"
+	aegishttp "github.com/zychimne/aegis/middleware/http"
+)
+
+// Middleware returns a rest middleware applying g, with the options of the
+// net/http middleware. Use it with rest.WithMiddleware to guard some routes,
+// or with Server.Use to guard all. The resource defaults to the method and the
+// route, such as "GET /user/:id".
+func Middleware(g *middleware.Guard, opts ...aegishttp.Option) rest.Middleware {
+	opts = append([]aegishttp.Option{aegishttp.WithResourceFunc(resource)}, opts...)
+	m := aegishttp.Middleware(g, opts...)
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return m(next).ServeHTTP
+	}
+}
+
+// resource returns the method and the route of r, whose segments matching the
+// path variables are replaced by their names. The variables of the same value
+// are matched in the order of their names, go-zero keeps no route pattern.
+func resource(r *http.Request) string {
+	vars := pathvar.Vars(r)
+	if len(vars) == 0 {
+		return r.Method + " " + r.URL.Path
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	used := make(map[string]bool, len(vars))
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		for _, name := range names {
+			if !used[name] && vars[name] == segment {
+				used[name] = true
+				segments[i] = ":" + name
+				break
+			}
+		}
+	}
+	return r.Method + " " + strings.Join(segments, "/")
+}
+
+// RegisterServer adds the interceptors guarding the methods to the zrpc server.
+func RegisterServer(s *zrpc.RpcServer, g *middleware.Guard, opts ...aegisgrpc.Option) {
+	s.AddUnaryInterceptors(aegisgrpc.UnaryServerInterceptor(g, opts...))
+	s.AddStreamInterceptors(aegisgrpc.StreamServerInterceptor(g, opts...))
+}
+
+// ClientOptions returns the zrpc client options guarding the calls.
+func ClientOptions(g *middleware.Guard, opts ...aegisgrpc.Option) []zrpc.ClientOption {
+	return []zrpc.ClientOption{
+		zrpc.WithUnaryClientInterceptor(aegisgrpc.UnaryClientInterceptor(g, opts...)),
+		zrpc.WithStreamClientInterceptor(aegisgrpc.StreamClientInterceptor(g, opts...)),
+	}
+}
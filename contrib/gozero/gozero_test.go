@@ -0,0 +1,103 @@
+package gozero
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zeromicro/go-zero/core/conf"
+	"github.com/zeromicro/go-zero/rest/router"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/middleware"
+	aegishttp "github.com/zychimne/aegis/middleware/http"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+)
+
+type testBreaker struct {
+	open    bool
+	failure int
+}
+
+func (b *testBreaker) Allow() error {
+	if b.open {
+		return circuitbreaker.ErrNotAllowed
+	}
+	return nil
+}
+
+func (b *testBreaker) MarkSuccess() {}
+
+func (b *testBreaker) MarkFailed() { b.failure++ }
+
+func serve(h http.HandlerFunc, path string) int {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code
+}
+
+func TestMiddleware(t *testing.T) {
+	breaker := &testBreaker{}
+	g := middleware.New(middleware.WithLimiter(concurrency.NewLimiter(1)), middleware.WithBreaker(breaker))
+	m := Middleware(g)
+	var handler http.HandlerFunc
+	handler = m(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nested":
+			assert.Equal(t, http.StatusTooManyRequests, serve(handler, "/ok"))
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	assert.Equal(t, http.StatusOK, serve(handler, "/nested"))
+	assert.Equal(t, http.StatusInternalServerError, serve(handler, "/error"))
+	assert.Equal(t, 1, breaker.failure)
+
+	breaker.open = true
+	assert.Equal(t, http.StatusServiceUnavailable, serve(handler, "/ok"))
+}
+
+func TestMiddlewareRoute(t *testing.T) {
+	g, h := MustNewGuard(Conf{Hotkey: &HotkeyConf{HotKeyCnt: 10, MinCount: 1}})
+	m := Middleware(g, aegishttp.WithKeyFunc(func(*http.Request) string { return "k" }))
+	r := router.NewRouter()
+	assert.NoError(t, r.Handle(http.MethodGet, "/user/:id/order/:oid", m(func(http.ResponseWriter, *http.Request) {})))
+	// the requests of a route share the resource of the route
+	for _, path := range []string{"/user/1/order/1", "/user/2/order/3"} {
+		assert.Equal(t, http.StatusOK, serve(r.ServeHTTP, path))
+	}
+	assert.Len(t, h.List(), 1)
+	assert.Equal(t, "GET /user/:id/order/:oid:k", h.List()[0].Key)
+}
+
+func TestNewGuard(t *testing.T) {
+	var c struct {
+		Name  string
+		Aegis Conf
+	}
+	err := conf.LoadFromYamlBytes([]byte(`
+Name: user-api
+Aegis:
+  CircuitBreaker:
+    Request: 10
+    Window: 1s
+  Hotkey:
+    HotKeyCnt: 10
+    MinCount: 1
+`), &c)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, c.Aegis.CircuitBreaker.Window)
+	assert.Nil(t, c.Aegis.RateLimit)
+
+	g, h := MustNewGuard(c.Aegis)
+	handler := Middleware(g, aegishttp.WithKeyFunc(func(r *http.Request) string {
+		return r.URL.Query().Get("user")
+	}))(func(http.ResponseWriter, *http.Request) {})
+	assert.Equal(t, http.StatusOK, serve(handler, "/user?user=42"))
+	assert.Equal(t, "GET /user:42", h.List()[0].Key)
+	assert.Len(t, ClientOptions(g), 2)
+
+	_, _, err = NewGuard(Conf{Hotkey: &HotkeyConf{HotKeyCnt: -1}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,161 @@
+// Package cache provides a gRPC client interceptor caching the replies of hot
+// idempotent calls in the local cache of a hotkey. Calls of the allowed
+// methods are keyed by the method and the hash of the request, and the replies
+// of hot calls are served locally, which shields the backends from the fan-out
+// of hot requests.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/zychimne/aegis/hotkey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// KeyFunc returns the cache key of a call, the call is not cached if it
+// returns an empty key.
+type KeyFunc func(ctx context.Context, method string, req interface{}) string
+
+// Option is interceptor option function.
+type Option func(*options)
+
+// options of interceptor.
+type options struct {
+	methods  map[string]bool
+	ttl      time.Duration
+	key      KeyFunc
+	metadata []string
+}
+
+// WithMethods with the full methods safe to cache, such as
+// "/user.UserService/GetUser", default none. The replies are shared by the
+// calls of the same request whatever their outgoing metadata, except the keys
+// of WithMetadata, so a method whose reply depends on other metadata, such as
+// the credentials of the caller, is not safe to cache.
+func WithMethods(methods ...string) Option {
+	return func(o *options) {
+		for _, m := range methods {
+			o.methods[m] = true
+		}
+	}
+}
+
+// WithTTL with the ttl of the cached replies, it's bounded by the ttl of the
+// hotkey, default 0 uses the ttl of the hotkey only.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithMetadata with the outgoing metadata keys the replies depend on, such as
+// "x-tenant-id", their values are appended to the cache key so the calls of
+// different values don't share the replies. Default none.
+func WithMetadata(keys ...string) Option {
+	return func(o *options) {
+		for _, k := range keys {
+			o.metadata = append(o.metadata, strings.ToLower(k))
+		}
+	}
+}
+
+// WithKeyFunc with the cache key of a call, default Key.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(o *options) {
+		o.key = fn
+	}
+}
+
+// Key returns the method and the sha256 of the deterministic encoding of the
+// request, or empty if the request is not a proto message. The metadata of the
+// call is ignored, see WithMetadata.
+func Key(_ context.Context, method string, req interface{}) string {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return "grpc:" + method + ":" + hex.EncodeToString(sum[:])
+}
+
+// metadataKey returns the sha256 of the values of the outgoing metadata keys.
+func metadataKey(ctx context.Context, keys []string) string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		for _, v := range md.Get(k) {
+			h.Write([]byte{0})
+			h.Write([]byte(v))
+		}
+		h.Write([]byte{0, 0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// entry is a cached reply.
+type entry struct {
+	reply    []byte
+	expireAt time.Time
+}
+
+// UnaryClientInterceptor returns a unary client interceptor caching the
+// replies of hot calls of the allowed methods in h, which must have a local
+// cache by AutoCache or whitelist rules matching the keys. The cached replies
+// are encoded, so each caller gets its own copy.
+func UnaryClientInterceptor(h *hotkey.HotKeyWithCache, opts ...Option) grpc.UnaryClientInterceptor {
+	opt := options{
+		methods: make(map[string]bool),
+		key:     Key,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		msg, ok := reply.(proto.Message)
+		if !ok || !opt.methods[method] {
+			return invoker(ctx, method, req, reply, cc, callOpts...)
+		}
+		key := opt.key(ctx, method, req)
+		if key == "" {
+			return invoker(ctx, method, req, reply, cc, callOpts...)
+		}
+		if len(opt.metadata) > 0 {
+			key += ":" + metadataKey(ctx, opt.metadata)
+		}
+		if e, ok := h.Get(key).(*entry); ok {
+			if e.expireAt.IsZero() || time.Now().Before(e.expireAt) {
+				if err := proto.Unmarshal(e.reply, msg); err == nil {
+					h.Add(key, 1)
+					return nil
+				}
+			}
+			h.Del(key)
+		}
+		if err := invoker(ctx, method, req, reply, cc, callOpts...); err != nil {
+			h.Add(key, 1)
+			return err
+		}
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			h.Add(key, 1)
+			return nil
+		}
+		e := &entry{reply: b}
+		if opt.ttl > 0 {
+			e.expireAt = time.Now().Add(opt.ttl)
+		}
+		h.AddWithValue(key, e, 1)
+		return nil
+	}
+}
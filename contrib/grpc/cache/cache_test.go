@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/hotkey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testMethod = "/test.Service/Get"
+
+// newWhitelist returns a hotkey caching the keys of testMethod from the first
+// reply, without waiting for them to become hot.
+func newWhitelist(t *testing.T) *hotkey.HotKeyWithCache {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		LocalCacheCap: 10,
+		TTL:           time.Minute,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "pattern", Value: "^grpc:" + testMethod + ":"}},
+	})
+	assert.NoError(t, err)
+	return h
+}
+
+// testInvoker replies the request with "!" appended, or fails with err.
+type testInvoker struct {
+	calls int
+	err   error
+}
+
+func (i *testInvoker) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+	i.calls++
+	if i.err != nil {
+		return i.err
+	}
+	reply.(*wrapperspb.StringValue).Value = req.(*wrapperspb.StringValue).Value + "!"
+	return nil
+}
+
+func (i *testInvoker) call(ctx context.Context, interceptor grpc.UnaryClientInterceptor, method, req string) (*wrapperspb.StringValue, error) {
+	reply := &wrapperspb.StringValue{}
+	err := interceptor(ctx, method, wrapperspb.String(req), reply, nil, i.invoke)
+	return reply, err
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		HotKeyCnt:     10,
+		LocalCacheCap: 10,
+		AutoCache:     true,
+		TTL:           time.Minute,
+		MinCount:      3,
+	})
+	assert.NoError(t, err)
+	interceptor := UnaryClientInterceptor(h, WithMethods(testMethod))
+	invoker := &testInvoker{}
+	ctx := context.Background()
+	// the reply is cached once the count of the call reaches MinCount
+	for i := 0; i < 5; i++ {
+		reply, err := invoker.call(ctx, interceptor, testMethod, "a")
+		assert.NoError(t, err)
+		assert.Equal(t, "a!", reply.GetValue())
+	}
+	assert.Equal(t, 3, invoker.calls)
+	// each caller decodes its own copy of the reply
+	reply, _ := invoker.call(ctx, interceptor, testMethod, "a")
+	reply.Value = "b"
+	reply, _ = invoker.call(ctx, interceptor, testMethod, "a")
+	assert.Equal(t, "a!", reply.GetValue())
+	assert.Equal(t, 3, invoker.calls)
+
+	// methods out of the allowlist are never cached however hot
+	for i := 0; i < 5; i++ {
+		invoker.call(ctx, interceptor, "/test.Service/Update", "a")
+	}
+	assert.Equal(t, 8, invoker.calls)
+}
+
+func TestUnaryClientInterceptorError(t *testing.T) {
+	interceptor := UnaryClientInterceptor(newWhitelist(t), WithMethods(testMethod))
+	invoker := &testInvoker{err: errors.New("unavailable")}
+	for i := 0; i < 3; i++ {
+		_, err := invoker.call(context.Background(), interceptor, testMethod, "a")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 3, invoker.calls)
+	// the first successful reply is cached
+	invoker.err = nil
+	for i := 0; i < 3; i++ {
+		_, err := invoker.call(context.Background(), interceptor, testMethod, "a")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 4, invoker.calls)
+}
+
+func TestUnaryClientInterceptorTTL(t *testing.T) {
+	interceptor := UnaryClientInterceptor(newWhitelist(t), WithMethods(testMethod), WithTTL(50*time.Millisecond))
+	invoker := &testInvoker{}
+	for i := 0; i < 3; i++ {
+		invoker.call(context.Background(), interceptor, testMethod, "a")
+	}
+	assert.Equal(t, 1, invoker.calls)
+	time.Sleep(60 * time.Millisecond)
+	invoker.call(context.Background(), interceptor, testMethod, "a")
+	assert.Equal(t, 2, invoker.calls)
+}
+
+func TestUnaryClientInterceptorMetadata(t *testing.T) {
+	interceptor := UnaryClientInterceptor(newWhitelist(t), WithMethods(testMethod), WithMetadata("X-Tenant-Id"))
+	invoker := &testInvoker{}
+	call := func(md ...string) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), md...)
+		_, err := invoker.call(ctx, interceptor, testMethod, "a")
+		assert.NoError(t, err)
+	}
+	// the metadata out of WithMetadata doesn't split the cache
+	for i := 0; i < 3; i++ {
+		call("x-tenant-id", "1", "x-request-id", strconv.Itoa(i))
+	}
+	assert.Equal(t, 1, invoker.calls)
+	call("x-tenant-id", "2")
+	call()
+	assert.Equal(t, 3, invoker.calls)
+}
+
+func TestUnaryClientInterceptorKeyFunc(t *testing.T) {
+	// the calls are shared by the first letter of the request
+	keyFunc := func(_ context.Context, method string, req interface{}) string {
+		if v := req.(*wrapperspb.StringValue).GetValue(); v != "" {
+			return "grpc:" + method + ":" + v[:1]
+		}
+		return ""
+	}
+	interceptor := UnaryClientInterceptor(newWhitelist(t), WithMethods(testMethod), WithKeyFunc(keyFunc))
+	invoker := &testInvoker{}
+	invoker.call(context.Background(), interceptor, testMethod, "ab")
+	reply, _ := invoker.call(context.Background(), interceptor, testMethod, "ac")
+	assert.Equal(t, "ab!", reply.GetValue())
+	// an empty key skips the cache
+	invoker.call(context.Background(), interceptor, testMethod, "")
+	invoker.call(context.Background(), interceptor, testMethod, "")
+	assert.Equal(t, 3, invoker.calls)
+}
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, Key(context.Background(), testMethod, wrapperspb.String("a")), Key(context.Background(), testMethod, wrapperspb.String("a")))
+	assert.NotEqual(t, Key(context.Background(), testMethod, wrapperspb.String("a")), Key(context.Background(), testMethod, wrapperspb.String("b")))
+	assert.Empty(t, Key(context.Background(), testMethod, "a"))
+}
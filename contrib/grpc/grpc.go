@@ -0,0 +1,158 @@
+// Package grpc provides the gRPC interceptors applying the hot-key counting,
+// rate limiting and circuit breaking of a middleware.Guard. Rejections are
+// returned as RESOURCE_EXHAUSTED if the limit is exceeded, and UNAVAILABLE if
+// the breaker is open.
+package grpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zychimne/aegis/middleware"
+	"github.com/zychimne/aegis/ratelimit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// KeyFunc extracts the hot key of a request, req is nil for streams.
+type KeyFunc func(ctx context.Context, method string, req interface{}) string
+
+// Option is interceptor option function.
+type Option func(*options)
+
+// options of interceptors.
+type options struct {
+	key     KeyFunc
+	failure func(err error) bool
+}
+
+// WithKeyFunc with the hot key extractor, keys are counted as "method:key".
+// Default no key is counted.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(o *options) {
+		o.key = fn
+	}
+}
+
+// WithFailure with the function reporting whether an error is a failure
+// counted by the breaker, default the server errors.
+func WithFailure(fn func(err error) bool) Option {
+	return func(o *options) {
+		o.failure = fn
+	}
+}
+
+// isServerError reports whether err is a server error.
+func isServerError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+func newOptions(opts []Option) options {
+	opt := options{
+		key:     func(context.Context, string, interface{}) string { return "" },
+		failure: isServerError,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
+// errPanic is the failure reported when the call panics.
+var errPanic = errors.New("grpc: call panicked")
+
+// call calls fn and then done with its error if it is a failure, a panic is
+// reported as a failure and re-panicked.
+func (o *options) call(done middleware.DoneFunc, fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			done(errPanic)
+			panic(p)
+		}
+		if err != nil && o.failure(err) {
+			done(err)
+			return
+		}
+		done(nil)
+	}()
+	return fn()
+}
+
+// toStatus translates the rejections to status errors, the open breaker and
+// the other rejections are unavailable.
+func toStatus(err error) error {
+	if errors.Is(err, ratelimit.ErrLimitExceed) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// UnaryServerInterceptor returns a unary server interceptor guarding the methods.
+func UnaryServerInterceptor(g *middleware.Guard, opts ...Option) grpc.UnaryServerInterceptor {
+	opt := newOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done, err := g.Allow(info.FullMethod, opt.key(ctx, info.FullMethod, req))
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		var resp interface{}
+		err = opt.call(done, func() (err error) {
+			resp, err = handler(ctx, req)
+			return err
+		})
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor returns a stream server interceptor guarding the methods,
+// the stream is done when the handler returns.
+func StreamServerInterceptor(g *middleware.Guard, opts ...Option) grpc.StreamServerInterceptor {
+	opt := newOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done, err := g.Allow(info.FullMethod, opt.key(ss.Context(), info.FullMethod, nil))
+		if err != nil {
+			return toStatus(err)
+		}
+		return opt.call(done, func() error {
+			return handler(srv, ss)
+		})
+	}
+}
+
+// UnaryClientInterceptor returns a unary client interceptor guarding the calls.
+func UnaryClientInterceptor(g *middleware.Guard, opts ...Option) grpc.UnaryClientInterceptor {
+	opt := newOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		done, err := g.Allow(method, opt.key(ctx, method, req))
+		if err != nil {
+			return toStatus(err)
+		}
+		return opt.call(done, func() error {
+			return invoker(ctx, method, req, reply, cc, callOpts...)
+		})
+	}
+}
+
+// StreamClientInterceptor returns a stream client interceptor guarding the calls,
+// the stream is done when it is established.
+func StreamClientInterceptor(g *middleware.Guard, opts ...Option) grpc.StreamClientInterceptor {
+	opt := newOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done, err := g.Allow(method, opt.key(ctx, method, nil))
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		var cs grpc.ClientStream
+		err = opt.call(done, func() (err error) {
+			cs, err = streamer(ctx, desc, cc, method, callOpts...)
+			return err
+		})
+		return cs, err
+	}
+}
@@ -0,0 +1,80 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/middleware"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testBreaker struct {
+	open    bool
+	failure int
+}
+
+func (b *testBreaker) Allow() error {
+	if b.open {
+		return circuitbreaker.ErrNotAllowed
+	}
+	return nil
+}
+
+func (b *testBreaker) MarkSuccess() {}
+
+func (b *testBreaker) MarkFailed() { b.failure++ }
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	breaker := &testBreaker{}
+	limiter := concurrency.NewLimiter(1)
+	g := middleware.New(middleware.WithLimiter(limiter), middleware.WithBreaker(breaker))
+	interceptor := UnaryServerInterceptor(g)
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.User/Get"}
+
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		// the limit is exceeded by a nested request
+		_, err := interceptor(ctx, req, info, nil)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	// client errors are not failures
+	assert.Equal(t, 0, breaker.failure)
+
+	_, err = interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Internal, "internal")
+	})
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, 1, breaker.failure)
+
+	// a panic is a failure and releases the limit
+	assert.Panics(t, func() {
+		interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			panic("boom")
+		})
+	})
+	assert.Equal(t, 2, breaker.failure)
+	_, err = interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	assert.NoError(t, err)
+
+	breaker.open = true
+	_, err = interceptor(context.Background(), nil, info, nil)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	breaker := &testBreaker{open: true}
+	g := middleware.New(middleware.WithBreaker(breaker))
+	interceptor := UnaryClientInterceptor(g, WithKeyFunc(func(context.Context, string, interface{}) string { return "k" }))
+	err := interceptor(context.Background(), "/user.User/Get", nil, nil, nil, func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		return nil
+	})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
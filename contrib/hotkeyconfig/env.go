@@ -0,0 +1,92 @@
+package hotkeyconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zychimne/aegis/hotkey"
+)
+
+// DefaultEnvPrefix is the prefix of the environment variables applied by Load.
+const DefaultEnvPrefix = "AEGIS_HOTKEY_"
+
+// Option is the option of Load.
+type Option func(*options)
+
+type options struct {
+	envPrefix string
+}
+
+// WithEnvPrefix with the prefix of the environment variables overriding the
+// file, empty disables the overrides, default AEGIS_HOTKEY_.
+func WithEnvPrefix(prefix string) Option {
+	return func(o *options) {
+		o.envPrefix = prefix
+	}
+}
+
+// ApplyEnv override the fields of option by the environment variables, which
+// take precedence over the file:
+//
+//	<prefix>TTL              duration, e.g. 100ms
+//	<prefix>HOT_KEY_CNT      integer
+//	<prefix>LOCAL_CACHE_CAP  integer
+//	<prefix>AUTO_CACHE       boolean
+//
+// Unset or empty variables are skipped, and option is left unchanged if any
+// variable is invalid.
+func ApplyEnv(option *hotkey.Option, prefix string) error {
+	o := *option
+	var errs Errors
+	if v, ok := lookupEnv(prefix + "TTL"); ok {
+		d, err := time.ParseDuration(v)
+		if err == nil && d < 0 {
+			err = errors.New("must not be negative")
+		} else if err != nil {
+			err = fmt.Errorf("invalid duration %q", v)
+		}
+		if err != nil {
+			errs = append(errs, &Error{Field: prefix + "TTL", Err: err})
+		}
+		o.TTL = d
+	}
+	if v, ok := lookupEnv(prefix + "HOT_KEY_CNT"); ok {
+		n, err := strconv.Atoi(v)
+		if err == nil && n < 0 {
+			err = errors.New("must not be negative")
+		} else if err != nil {
+			err = fmt.Errorf("invalid integer %q", v)
+		}
+		if err != nil {
+			errs = append(errs, &Error{Field: prefix + "HOT_KEY_CNT", Err: err})
+		}
+		o.HotKeyCnt = n
+	}
+	if v, ok := lookupEnv(prefix + "LOCAL_CACHE_CAP"); ok {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			errs = append(errs, &Error{Field: prefix + "LOCAL_CACHE_CAP", Err: fmt.Errorf("invalid integer %q", v)})
+		}
+		o.LocalCacheCap = n
+	}
+	if v, ok := lookupEnv(prefix + "AUTO_CACHE"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, &Error{Field: prefix + "AUTO_CACHE", Err: fmt.Errorf("invalid boolean %q", v)})
+		}
+		o.AutoCache = b
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	*option = o
+	return nil
+}
+
+func lookupEnv(key string) (string, bool) {
+	v, ok := os.LookupEnv(key)
+	return v, ok && v != ""
+}
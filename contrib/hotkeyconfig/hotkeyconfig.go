@@ -0,0 +1,339 @@
+// Package hotkeyconfig loads the hotkey.Option and its cache rules from TOML,
+// YAML or JSON files, with human-readable durations such as "100ms" or "5m".
+package hotkeyconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/zychimne/aegis/hotkey"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the format of a config file.
+type Format string
+
+const (
+	FormatTOML Format = "toml"
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+)
+
+var ErrUnknownFormat = errors.New("hotkeyconfig: unknown format")
+
+// Error is an error of the config, Line is 0 if the position is unknown.
+type Error struct {
+	Line  int
+	Field string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString("hotkeyconfig: ")
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+	if e.Field != "" {
+		b.WriteString(e.Field + ": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors is the list of errors found by the validation, ordered by line.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+type rule struct {
+	Mode  string `json:"match_mode" yaml:"match_mode" toml:"match_mode"`
+	Value string `json:"match_value" yaml:"match_value" toml:"match_value"`
+	TTL   string `json:"ttl" yaml:"ttl" toml:"ttl"`
+}
+
+type file struct {
+	HotKeyCnt     int    `json:"hot_key_cnt" yaml:"hot_key_cnt" toml:"hot_key_cnt"`
+	LocalCacheCap uint64 `json:"local_cache_cap" yaml:"local_cache_cap" toml:"local_cache_cap"`
+	AutoCache     bool   `json:"auto_cache" yaml:"auto_cache" toml:"auto_cache"`
+	TTL           string `json:"ttl" yaml:"ttl" toml:"ttl"`
+	MinCount      int    `json:"min_count" yaml:"min_count" toml:"min_count"`
+	Whitelist     []rule `json:"whitelist" yaml:"whitelist" toml:"whitelist"`
+	Blacklist     []rule `json:"blacklist" yaml:"blacklist" toml:"blacklist"`
+}
+
+var (
+	topLevelFields = map[string]bool{
+		"hot_key_cnt": true, "local_cache_cap": true, "auto_cache": true, "ttl": true,
+		"min_count": true, "whitelist": true, "blacklist": true,
+	}
+	ruleFields = map[string]bool{"match_mode": true, "match_value": true, "ttl": true}
+	lineRegexp = regexp.MustCompile(`line (\d+)`)
+)
+
+// Load read the config file, the format is detected by the extension of path:
+// .toml, .yaml, .yml or .json. The environment variables are applied after the
+// file, see ApplyEnv.
+func Load(path string, opts ...Option) (*hotkey.Option, error) {
+	o := &options{envPrefix: DefaultEnvPrefix}
+	for _, opt := range opts {
+		opt(o)
+	}
+	var format Format
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		format = FormatTOML
+	case ".yaml", ".yml":
+		format = FormatYAML
+	case ".json":
+		format = FormatJSON
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	option, err := Parse(data, format)
+	if err != nil {
+		return nil, err
+	}
+	if o.envPrefix != "" {
+		if err := ApplyEnv(option, o.envPrefix); err != nil {
+			return nil, err
+		}
+	}
+	return option, nil
+}
+
+// Parse decode and validate the config, syntax errors are returned as *Error
+// and validation errors as Errors, both with the line of the field.
+func Parse(data []byte, format Format) (*hotkey.Option, error) {
+	var (
+		f     file
+		lines []position
+		err   error
+	)
+	switch format {
+	case FormatTOML:
+		if _, err = toml.Decode(string(data), &f); err != nil {
+			return nil, tomlError(data, err)
+		}
+		lines = tomlPositions(data)
+	case FormatYAML:
+		var node yaml.Node
+		if err = yaml.Unmarshal(data, &node); err == nil {
+			err = node.Decode(&f)
+		}
+		if err != nil {
+			return nil, decodeError(err)
+		}
+		lines = yamlPositions(&node, "", nil)
+	case FormatJSON:
+		if err = json.Unmarshal(data, &f); err != nil {
+			return nil, jsonError(data, err)
+		}
+		lines, err = jsonPositions(data)
+		if err != nil {
+			return nil, jsonError(data, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+	return f.option(lines)
+}
+
+func (f *file) option(lines []position) (*hotkey.Option, error) {
+	v := &validator{lines: lines}
+	for _, p := range lines {
+		if !known(p.path) {
+			v.fail(p.path, errors.New("unknown field"))
+		}
+	}
+	if f.HotKeyCnt < 0 {
+		v.fail("hot_key_cnt", errors.New("must not be negative"))
+	}
+	if f.MinCount < 0 {
+		v.fail("min_count", errors.New("must not be negative"))
+	}
+	option := &hotkey.Option{
+		HotKeyCnt:     f.HotKeyCnt,
+		LocalCacheCap: f.LocalCacheCap,
+		AutoCache:     f.AutoCache,
+		TTL:           v.duration("ttl", f.TTL),
+		MinCount:      f.MinCount,
+		WhileList:     v.rules("whitelist", f.Whitelist),
+		BlackList:     v.rules("blacklist", f.Blacklist),
+	}
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+	return option, nil
+}
+
+type validator struct {
+	lines []position
+	errs  Errors
+}
+
+func (v *validator) fail(field string, err error) {
+	e := &Error{Line: v.line(field), Field: field, Err: err}
+	i := len(v.errs)
+	for i > 0 && v.errs[i-1].Line > e.Line {
+		i--
+	}
+	v.errs = append(v.errs, nil)
+	copy(v.errs[i+1:], v.errs[i:])
+	v.errs[i] = e
+}
+
+// line return the line of field, or the line of the nearest parent if the
+// field is absent.
+func (v *validator) line(field string) int {
+	for field != "" {
+		for _, p := range v.lines {
+			if p.path == field {
+				return p.line
+			}
+		}
+		i := strings.LastIndexAny(field, ".[")
+		if i < 0 {
+			break
+		}
+		field = field[:i]
+	}
+	return 0
+}
+
+func (v *validator) duration(field, s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		v.fail(field, fmt.Errorf("invalid duration %q", s))
+		return 0
+	}
+	if d < 0 {
+		v.fail(field, errors.New("must not be negative"))
+		return 0
+	}
+	return d
+}
+
+func (v *validator) rules(field string, rules []rule) []*hotkey.CacheRuleConfig {
+	if len(rules) == 0 {
+		return nil
+	}
+	configs := make([]*hotkey.CacheRuleConfig, 0, len(rules))
+	for i, r := range rules {
+		prefix := field + "[" + strconv.Itoa(i) + "]."
+		switch r.Mode {
+		case "key":
+		case "pattern":
+			if _, err := regexp.Compile(r.Value); err != nil {
+				v.fail(prefix+"match_value", fmt.Errorf("invalid pattern: %v", err))
+			}
+		case "":
+			v.fail(prefix+"match_mode", errors.New("required"))
+		default:
+			v.fail(prefix+"match_mode", fmt.Errorf("invalid mode %q, must be key or pattern", r.Mode))
+		}
+		if r.Value == "" {
+			v.fail(prefix+"match_value", errors.New("required"))
+		}
+		configs = append(configs, &hotkey.CacheRuleConfig{
+			Mode:  r.Mode,
+			Value: r.Value,
+			TTL:   v.duration(prefix+"ttl", r.TTL),
+		})
+	}
+	return configs
+}
+
+func known(path string) bool {
+	name, rest, nested := strings.Cut(path, "[")
+	if !topLevelFields[name] {
+		return false
+	}
+	if !nested {
+		return true
+	}
+	if name != "whitelist" && name != "blacklist" {
+		return false
+	}
+	_, rest, _ = strings.Cut(rest, "]")
+	rest = strings.TrimPrefix(rest, ".")
+	return rest == "" || ruleFields[rest]
+}
+
+// tomlError map the error to the line of its offset, the line reported by
+// toml is past the newline which ends an incomplete line.
+func tomlError(data []byte, err error) error {
+	var perr toml.ParseError
+	if errors.As(err, &perr) {
+		return &Error{Line: offsetLine(data, int64(perr.Position.Start)), Err: err}
+	}
+	return decodeError(err)
+}
+
+func decodeError(err error) error {
+	var terr *yaml.TypeError
+	if errors.As(err, &terr) && len(terr.Errors) > 0 {
+		errs := make(Errors, 0, len(terr.Errors))
+		for _, msg := range terr.Errors {
+			errs = append(errs, &Error{Line: lineOf(msg), Err: errors.New(msg)})
+		}
+		return errs
+	}
+	return &Error{Line: lineOf(err.Error()), Err: err}
+}
+
+func jsonError(data []byte, err error) error {
+	var (
+		serr *json.SyntaxError
+		terr *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &serr):
+		return &Error{Line: offsetLine(data, serr.Offset), Err: err}
+	case errors.As(err, &terr):
+		return &Error{Line: offsetLine(data, terr.Offset), Field: terr.Field, Err: err}
+	}
+	return &Error{Err: err}
+}
+
+func lineOf(msg string) int {
+	m := lineRegexp.FindStringSubmatch(msg)
+	if m == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(m[1])
+	return line
+}
+
+func offsetLine(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
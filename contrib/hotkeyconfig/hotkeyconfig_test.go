@@ -0,0 +1,183 @@
+package hotkeyconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/hotkey"
+)
+
+const testTOML = `hot_key_cnt = 100
+local_cache_cap = 1000
+auto_cache = true
+ttl = "100ms"
+min_count = 10
+
+[[whitelist]]
+match_mode = "key"
+match_value = "user:1"
+ttl = "5m"
+
+[[blacklist]]
+match_mode = "pattern"
+match_value = "^order:"
+`
+
+const testYAML = `hot_key_cnt: 100
+local_cache_cap: 1000
+auto_cache: true
+ttl: 100ms
+min_count: 10
+whitelist:
+  - match_mode: key
+    match_value: "user:1"
+    ttl: 5m
+blacklist:
+  - match_mode: pattern
+    match_value: "^order:"
+`
+
+const testJSON = `{
+  "hot_key_cnt": 100,
+  "local_cache_cap": 1000,
+  "auto_cache": true,
+  "ttl": "100ms",
+  "min_count": 10,
+  "whitelist": [
+    {"match_mode": "key", "match_value": "user:1", "ttl": "5m"}
+  ],
+  "blacklist": [
+    {"match_mode": "pattern", "match_value": "^order:"}
+  ]
+}`
+
+func TestParse(t *testing.T) {
+	expected := &hotkey.Option{
+		HotKeyCnt:     100,
+		LocalCacheCap: 1000,
+		AutoCache:     true,
+		TTL:           100 * time.Millisecond,
+		MinCount:      10,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "key", Value: "user:1", TTL: 5 * time.Minute}},
+		BlackList:     []*hotkey.CacheRuleConfig{{Mode: "pattern", Value: "^order:"}},
+	}
+	for format, data := range map[Format]string{FormatTOML: testTOML, FormatYAML: testYAML, FormatJSON: testJSON} {
+		option, err := Parse([]byte(data), format)
+		assert.NoError(t, err, format)
+		assert.Equal(t, expected, option, format)
+		_, err = hotkey.NewHotkey(option)
+		assert.NoError(t, err, format)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"hotkey.toml": testTOML, "hotkey.yml": testYAML, "hotkey.json": testJSON} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		option, err := Load(path)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 100*time.Millisecond, option.TTL, name)
+	}
+	_, err := Load(filepath.Join(dir, "hotkey.ini"))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+	_, err = Load(filepath.Join(dir, "missing.toml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		format Format
+		data   string
+	}{
+		{FormatTOML, "ttl = \"1s\"\nmin_count = -1\n\n[[whitelist]]\nmatch_mode = \"prefix\"\nmatch_value = \"a\"\nttl = \"5 minutes\"\n"},
+		{FormatYAML, "ttl: 1s\nmin_count: -1\nwhitelist:\n  - match_mode: prefix\n    match_value: a\n    ttl: 5 minutes\n"},
+		{FormatJSON, "{\n  \"ttl\": \"1s\",\n  \"min_count\": -1,\n  \"whitelist\": [{\n    \"match_mode\": \"prefix\",\n    \"match_value\": \"a\",\n    \"ttl\": \"5 minutes\"\n  }]\n}"},
+	}
+	for _, test := range tests {
+		_, err := Parse([]byte(test.data), test.format)
+		var errs Errors
+		assert.True(t, errors.As(err, &errs), test.format)
+		fields := make([]string, 0, len(errs))
+		for _, e := range errs {
+			fields = append(fields, e.Field)
+			assert.Greater(t, e.Line, 0, test.format)
+		}
+		assert.Equal(t, []string{"min_count", "whitelist[0].match_mode", "whitelist[0].ttl"}, fields, test.format)
+	}
+}
+
+func TestValidateLine(t *testing.T) {
+	_, err := Parse([]byte(testTOML+"\n[[blacklist]]\nmatch_mode = \"key\"\n"), FormatTOML)
+	assert.EqualError(t, err, "hotkeyconfig: line 16: blacklist[1].match_value: required")
+
+	_, err = Parse([]byte("hot_key_cnt: 10\nwhilelist:\n  - match_mode: key\n"), FormatYAML)
+	var errs Errors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 2, errs[0].Line)
+	assert.Equal(t, "whilelist", errs[0].Field)
+
+	_, err = Parse([]byte("{\n  \"blacklist\": [\n    {\"match_mode\": \"pattern\", \"match_value\": \"(\"}\n  ]\n}"), FormatJSON)
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, 3, errs[0].Line)
+	assert.Equal(t, "blacklist[0].match_value", errs[0].Field)
+}
+
+func TestSyntaxError(t *testing.T) {
+	var e *Error
+	_, err := Parse([]byte("{\n  \"ttl\": \"1s\",\n  \"min_count\": \"ten\"\n}"), FormatJSON)
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, 3, e.Line)
+
+	_, err = Parse([]byte("{\n  \"ttl\": \"1s\"\n  \"min_count\": 1\n}"), FormatJSON)
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, 3, e.Line)
+
+	_, err = Parse([]byte("ttl = \"1s\"\nmin_count\n"), FormatTOML)
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, 2, e.Line)
+
+	_, err = Parse([]byte("ttl: 1s\n"), Format("ini"))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("TEST_TTL", "5s")
+	t.Setenv("TEST_HOT_KEY_CNT", "50")
+	t.Setenv("TEST_LOCAL_CACHE_CAP", "")
+	t.Setenv("TEST_AUTO_CACHE", "false")
+	option := &hotkey.Option{HotKeyCnt: 100, LocalCacheCap: 1000, AutoCache: true, TTL: time.Second}
+	assert.NoError(t, ApplyEnv(option, "TEST_"))
+	assert.Equal(t, &hotkey.Option{HotKeyCnt: 50, LocalCacheCap: 1000, TTL: 5 * time.Second}, option)
+
+	t.Setenv("TEST_TTL", "-1s")
+	t.Setenv("TEST_LOCAL_CACHE_CAP", "many")
+	err := ApplyEnv(option, "TEST_")
+	var errs Errors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "TEST_TTL", errs[0].Field)
+	assert.Equal(t, "TEST_LOCAL_CACHE_CAP", errs[1].Field)
+	assert.Equal(t, 5*time.Second, option.TTL)
+}
+
+func TestLoadEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hotkey.toml")
+	assert.NoError(t, os.WriteFile(path, []byte(testTOML), 0o644))
+	t.Setenv(DefaultEnvPrefix+"TTL", "1s")
+	t.Setenv(DefaultEnvPrefix+"LOCAL_CACHE_CAP", "10")
+
+	option, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, option.TTL)
+	assert.Equal(t, uint64(10), option.LocalCacheCap)
+	assert.Equal(t, 100, option.HotKeyCnt)
+
+	option, err = Load(path, WithEnvPrefix(""))
+	assert.NoError(t, err)
+	assert.Equal(t, 100*time.Millisecond, option.TTL)
+}
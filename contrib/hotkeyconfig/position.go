@@ -0,0 +1,131 @@
+package hotkeyconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// position is the line of a field, the path looks like "whitelist[0].ttl".
+type position struct {
+	path string
+	line int
+}
+
+func join(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func index(prefix string, i int) string {
+	return prefix + "[" + strconv.Itoa(i) + "]"
+}
+
+func yamlPositions(node *yaml.Node, path string, lines []position) []position {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			lines = yamlPositions(n, path, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := join(path, node.Content[i].Value)
+			lines = append(lines, position{path: key, line: node.Content[i].Line})
+			lines = yamlPositions(node.Content[i+1], key, lines)
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			key := index(path, i)
+			lines = append(lines, position{path: key, line: n.Line})
+			lines = yamlPositions(n, key, lines)
+		}
+	}
+	return lines
+}
+
+// tomlPositions scan the lines of the document, which is enough for the flat
+// tables and the arrays of tables of the config.
+func tomlPositions(data []byte) []position {
+	var (
+		lines  []position
+		prefix string
+		counts = make(map[string]int)
+	)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[["):
+			name := tomlKey(strings.TrimSuffix(strings.TrimPrefix(line, "[["), "]]"))
+			prefix = index(name, counts[name])
+			counts[name]++
+			lines = append(lines, position{path: prefix, line: i + 1})
+		case strings.HasPrefix(line, "["):
+			prefix = tomlKey(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+			lines = append(lines, position{path: prefix, line: i + 1})
+		default:
+			if key, _, ok := strings.Cut(line, "="); ok {
+				lines = append(lines, position{path: join(prefix, tomlKey(key)), line: i + 1})
+			}
+		}
+	}
+	return lines
+}
+
+func tomlKey(key string) string {
+	if i := strings.IndexByte(key, '#'); i >= 0 && !strings.ContainsAny(key, `"'`) {
+		key = key[:i]
+	}
+	return strings.Trim(strings.TrimSpace(key), `"'`)
+}
+
+func jsonPositions(data []byte) ([]position, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var lines []position
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key := join(path, tok.(string))
+				lines = append(lines, position{path: key, line: offsetLine(data, dec.InputOffset())})
+				if err := walk(key); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				key := index(path, i)
+				lines = append(lines, position{path: key, line: offsetLine(data, nextToken(data, dec.InputOffset()))})
+				if err := walk(key); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err
+	}
+	return lines, walk("")
+}
+
+// nextToken skip the whitespaces and the separator before the next token.
+func nextToken(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
@@ -0,0 +1,127 @@
+package kratos
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/circuitbreaker/sre"
+	"github.com/zychimne/aegis/hotkey"
+	aegis "github.com/zychimne/aegis/middleware"
+	"github.com/zychimne/aegis/ratelimit/bbr"
+)
+
+// Duration is a time.Duration decoded from a string such as "1s" or nanoseconds.
+type Duration time.Duration
+
+// UnmarshalJSON decodes the duration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v)
+	case string:
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(dur)
+	default:
+		return fmt.Errorf("kratos: invalid duration %s", b)
+	}
+	return nil
+}
+
+// Config is the config of a guard, it can be scanned from the config of kratos.
+// Components not configured are skipped, and zero fields are defaults.
+type Config struct {
+	RateLimit      *RateLimitConfig      `json:"ratelimit" yaml:"ratelimit"`
+	CircuitBreaker *CircuitBreakerConfig `json:"circuitbreaker" yaml:"circuitbreaker"`
+	Hotkey         *HotkeyConfig         `json:"hotkey" yaml:"hotkey"`
+}
+
+// RateLimitConfig is the config of the bbr limiter.
+type RateLimitConfig struct {
+	Window       Duration `json:"window" yaml:"window"`
+	Bucket       int      `json:"bucket" yaml:"bucket"`
+	CPUThreshold int64    `json:"cpu_threshold" yaml:"cpu_threshold"`
+	CPUQuota     float64  `json:"cpu_quota" yaml:"cpu_quota"`
+}
+
+// CircuitBreakerConfig is the config of the sre breakers, one per operation.
+type CircuitBreakerConfig struct {
+	Success float64  `json:"success" yaml:"success"`
+	Request int64    `json:"request" yaml:"request"`
+	Window  Duration `json:"window" yaml:"window"`
+	Bucket  int      `json:"bucket" yaml:"bucket"`
+}
+
+// HotkeyConfig is the config of the hot-key counter.
+type HotkeyConfig struct {
+	HotKeyCnt int `json:"hot_key_cnt" yaml:"hot_key_cnt"`
+	MinCount  int `json:"min_count" yaml:"min_count"`
+}
+
+// NewGuard returns the guard of the config, and the hot-key counter if configured.
+func NewGuard(c *Config) (*aegis.Guard, *hotkey.HotKeyWithCache, error) {
+	var (
+		opts []aegis.Option
+		h    *hotkey.HotKeyWithCache
+	)
+	if c.RateLimit != nil {
+		opts = append(opts, aegis.WithLimiter(bbr.NewLimiter(c.RateLimit.options()...)))
+	}
+	if c.CircuitBreaker != nil {
+		breakerOpts := c.CircuitBreaker.options()
+		opts = append(opts, aegis.WithBreakerFactory(func() circuitbreaker.CircuitBreaker {
+			return sre.NewBreaker(breakerOpts...)
+		}))
+	}
+	if c.Hotkey != nil {
+		var err error
+		h, err = hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: c.Hotkey.HotKeyCnt, MinCount: c.Hotkey.MinCount})
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, aegis.WithHotkey(h))
+	}
+	return aegis.New(opts...), h, nil
+}
+
+func (c *RateLimitConfig) options() []bbr.Option {
+	var opts []bbr.Option
+	if c.Window > 0 {
+		opts = append(opts, bbr.WithWindow(time.Duration(c.Window)))
+	}
+	if c.Bucket > 0 {
+		opts = append(opts, bbr.WithBucket(c.Bucket))
+	}
+	if c.CPUThreshold > 0 {
+		opts = append(opts, bbr.WithCPUThreshold(c.CPUThreshold))
+	}
+	if c.CPUQuota > 0 {
+		opts = append(opts, bbr.WithCPUQuota(c.CPUQuota))
+	}
+	return opts
+}
+
+func (c *CircuitBreakerConfig) options() []sre.Option {
+	var opts []sre.Option
+	if c.Success > 0 {
+		opts = append(opts, sre.WithSuccess(c.Success))
+	}
+	if c.Request > 0 {
+		opts = append(opts, sre.WithRequest(c.Request))
+	}
+	if c.Window > 0 {
+		opts = append(opts, sre.WithWindow(time.Duration(c.Window)))
+	}
+	if c.Bucket > 0 {
+		opts = append(opts, sre.WithBucket(c.Bucket))
+	}
+	return opts
+}
@@ -0,0 +1,122 @@
+// Package kratos provides the go-kratos middlewares applying a
+// middleware.Guard per operation. Rejections are returned as the errors of the
+// ratelimit and circuitbreaker middlewares of kratos, 429 RATELIMIT and 503
+// CIRCUITBREAKER, so aegis is a drop-in alternative of them.
+package kratos
+
+import (
+	"context"
+	stderrors "errors"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	aegis "github.com/zychimne/aegis/middleware"
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+var (
+	// ErrLimitExceed is returned when the limit is exceeded.
+	ErrLimitExceed = errors.New(429, "RATELIMIT", "service unavailable due to rate limit exceeded")
+	// ErrNotAllowed is returned when the breaker is open.
+	ErrNotAllowed = errors.New(503, "CIRCUITBREAKER", "request failed due to circuit breaker triggered")
+
+	// errPanic is the failure reported when the handler panics.
+	errPanic = stderrors.New("kratos: handler panicked")
+)
+
+// Option is middleware option function.
+type Option func(*options)
+
+// options of middlewares.
+type options struct {
+	key     func(ctx context.Context, operation string, req interface{}) string
+	failure func(err error) bool
+}
+
+// WithKeyFunc with the hot key extractor, keys are counted as "operation:key".
+// Default no key is counted.
+func WithKeyFunc(fn func(ctx context.Context, operation string, req interface{}) string) Option {
+	return func(o *options) {
+		o.key = fn
+	}
+}
+
+// WithFailure with the function reporting whether an error is a failure
+// counted by the breaker, default 500, 503 and 504 errors.
+func WithFailure(fn func(err error) bool) Option {
+	return func(o *options) {
+		o.failure = fn
+	}
+}
+
+func isServerError(err error) bool {
+	return errors.IsInternalServer(err) || errors.IsServiceUnavailable(err) || errors.IsGatewayTimeout(err)
+}
+
+func newOptions(opts []Option) options {
+	opt := options{
+		key:     func(context.Context, string, interface{}) string { return "" },
+		failure: isServerError,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return opt
+}
+
+func toError(err error) error {
+	if stderrors.Is(err, ratelimit.ErrLimitExceed) {
+		return ErrLimitExceed.WithCause(err)
+	}
+	return ErrNotAllowed.WithCause(err)
+}
+
+func guard(g *aegis.Guard, opt options, operation string, handler middleware.Handler) middleware.Handler {
+	return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
+		done, err := g.Allow(operation, opt.key(ctx, operation, req))
+		if err != nil {
+			return nil, toError(err)
+		}
+		defer func() {
+			if p := recover(); p != nil {
+				done(errPanic)
+				panic(p)
+			}
+			if err != nil && opt.failure(err) {
+				done(err)
+				return
+			}
+			done(nil)
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// Server returns a server middleware guarding the operations.
+func Server(g *aegis.Guard, opts ...Option) middleware.Middleware {
+	opt := newOptions(opts)
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			operation := ""
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				operation = tr.Operation()
+			}
+			return guard(g, opt, operation, handler)(ctx, req)
+		}
+	}
+}
+
+// Client returns a client middleware guarding the calls of operations.
+func Client(g *aegis.Guard, opts ...Option) middleware.Middleware {
+	opt := newOptions(opts)
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			operation := ""
+			if tr, ok := transport.FromClientContext(ctx); ok {
+				operation = tr.Operation()
+			}
+			return guard(g, opt, operation, handler)(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package kratos
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	aegis "github.com/zychimne/aegis/middleware"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+)
+
+type testTransport struct {
+	transport.Transporter
+	operation string
+}
+
+func (tr *testTransport) Operation() string { return tr.operation }
+
+type testBreaker struct {
+	open    bool
+	failure int
+}
+
+func (b *testBreaker) Allow() error {
+	if b.open {
+		return circuitbreaker.ErrNotAllowed
+	}
+	return nil
+}
+
+func (b *testBreaker) MarkSuccess() {}
+
+func (b *testBreaker) MarkFailed() { b.failure++ }
+
+func TestServer(t *testing.T) {
+	breaker := &testBreaker{}
+	g := aegis.New(aegis.WithLimiter(concurrency.NewLimiter(1)), aegis.WithBreaker(breaker))
+	m := Server(g)
+	ctx := transport.NewServerContext(context.Background(), &testTransport{operation: "/user.User/Get"})
+
+	var handler func(ctx context.Context, req interface{}) (interface{}, error)
+	handler = m(func(ctx context.Context, req interface{}) (interface{}, error) {
+		if req == "nested" {
+			_, err := handler(ctx, nil)
+			assert.Equal(t, 429, errors.Code(err))
+			assert.Equal(t, "RATELIMIT", errors.Reason(err))
+			return nil, errors.New(404, "NOT_FOUND", "")
+		}
+		return nil, errors.New(500, "INTERNAL", "")
+	})
+	_, err := handler(ctx, "nested")
+	assert.Equal(t, 404, errors.Code(err))
+	assert.Equal(t, 0, breaker.failure)
+	_, err = handler(ctx, nil)
+	assert.Equal(t, 500, errors.Code(err))
+	assert.Equal(t, 1, breaker.failure)
+
+	// a panic is a failure and releases the limit
+	panicking := m(func(context.Context, interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	assert.Panics(t, func() { panicking(ctx, nil) })
+	assert.Equal(t, 2, breaker.failure)
+	_, err = handler(ctx, nil)
+	assert.Equal(t, 500, errors.Code(err))
+	assert.Equal(t, 3, breaker.failure)
+
+	breaker.open = true
+	_, err = handler(ctx, nil)
+	assert.Equal(t, 503, errors.Code(err))
+	assert.Equal(t, "CIRCUITBREAKER", errors.Reason(err))
+}
+
+func TestNewGuard(t *testing.T) {
+	var c Config
+	err := json.Unmarshal([]byte(`{
+		"circuitbreaker": {"request": 10, "window": "1s"},
+		"hotkey": {"hot_key_cnt": 10, "min_count": 1}
+	}`), &c)
+	assert.NoError(t, err)
+	assert.Equal(t, Duration(time.Second), c.CircuitBreaker.Window)
+	assert.Nil(t, c.RateLimit)
+
+	g, h, err := NewGuard(&c)
+	assert.NoError(t, err)
+	m := Client(g, WithKeyFunc(func(context.Context, string, interface{}) string { return "42" }))
+	ctx := transport.NewClientContext(context.Background(), &testTransport{operation: "/user.User/Get"})
+	_, err = m(func(context.Context, interface{}) (interface{}, error) { return nil, nil })(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/user.User/Get:42", h.List()[0].Key)
+}
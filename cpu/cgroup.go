@@ -2,6 +2,7 @@ package cpu
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -12,13 +13,29 @@ import (
 
 const cgroupRootDir = "/sys/fs/cgroup"
 
+// ErrPerCPUUsage is returned when the per-cpu usage is not supported by cgroup v2.
+var ErrPerCPUUsage = errors.New("no per-cpu usage in cgroup v2")
+
 // cgroup Linux cgroup
 type cgroup struct {
 	cgroupSet map[string]string
+	// unified is the directory of cgroup v2, it is empty if the cpu
+	// controllers are mounted by cgroup v1.
+	unified string
 }
 
-// CPUCFSQuotaUs cpu.cfs_quota_us
+// CPUCFSQuotaUs cpu.cfs_quota_us, or the quota of cpu.max in cgroup v2, -1 if unlimited
 func (c *cgroup) CPUCFSQuotaUs() (int64, error) {
+	if c.unified != "" {
+		fields, err := c.cpuMax()
+		if err != nil {
+			return 0, err
+		}
+		if fields[0] == "max" {
+			return -1, nil
+		}
+		return strconv.ParseInt(fields[0], 10, 64)
+	}
 	data, err := readFile(path.Join(c.cgroupSet["cpu"], "cpu.cfs_quota_us"))
 	if err != nil {
 		return 0, err
@@ -26,8 +43,15 @@ func (c *cgroup) CPUCFSQuotaUs() (int64, error) {
 	return strconv.ParseInt(data, 10, 64)
 }
 
-// CPUCFSPeriodUs cpu.cfs_period_us
+// CPUCFSPeriodUs cpu.cfs_period_us, or the period of cpu.max in cgroup v2
 func (c *cgroup) CPUCFSPeriodUs() (uint64, error) {
+	if c.unified != "" {
+		fields, err := c.cpuMax()
+		if err != nil {
+			return 0, err
+		}
+		return parseUint(fields[1])
+	}
 	data, err := readFile(path.Join(c.cgroupSet["cpu"], "cpu.cfs_period_us"))
 	if err != nil {
 		return 0, err
@@ -35,8 +59,28 @@ func (c *cgroup) CPUCFSPeriodUs() (uint64, error) {
 	return parseUint(data)
 }
 
-// CPUAcctUsage cpuacct.usage
+// cpuMax cpu.max of cgroup v2, "$MAX $PERIOD"
+func (c *cgroup) cpuMax() ([]string, error) {
+	data, err := readFile(path.Join(c.unified, "cpu.max"))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(data)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid cpu.max format %s", data)
+	}
+	return fields, nil
+}
+
+// CPUAcctUsage cpuacct.usage, or usage_usec of cpu.stat in cgroup v2, in nanoseconds
 func (c *cgroup) CPUAcctUsage() (uint64, error) {
+	if c.unified != "" {
+		stat, err := readStatFile(path.Join(c.unified, "cpu.stat"))
+		if err != nil {
+			return 0, err
+		}
+		return stat["usage_usec"] * 1e3, nil
+	}
 	data, err := readFile(path.Join(c.cgroupSet["cpuacct"], "cpuacct.usage"))
 	if err != nil {
 		return 0, err
@@ -44,8 +88,24 @@ func (c *cgroup) CPUAcctUsage() (uint64, error) {
 	return parseUint(data)
 }
 
+// CPUThrottled nr_periods and nr_throttled of cpu.stat
+func (c *cgroup) CPUThrottled() (periods uint64, throttled uint64, err error) {
+	dir := c.unified
+	if dir == "" {
+		dir = c.cgroupSet["cpu"]
+	}
+	stat, err := readStatFile(path.Join(dir, "cpu.stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	return stat["nr_periods"], stat["nr_throttled"], nil
+}
+
 // CPUAcctUsagePerCPU cpuacct.usage_percpu
 func (c *cgroup) CPUAcctUsagePerCPU() ([]uint64, error) {
+	if c.unified != "" {
+		return nil, ErrPerCPUUsage
+	}
 	data, err := readFile(path.Join(c.cgroupSet["cpuacct"], "cpuacct.usage_percpu"))
 	if err != nil {
 		return nil, err
@@ -64,9 +124,13 @@ func (c *cgroup) CPUAcctUsagePerCPU() ([]uint64, error) {
 	return usage, nil
 }
 
-// CPUSetCPUs cpuset.cpus
+// CPUSetCPUs cpuset.cpus, or cpuset.cpus.effective in cgroup v2
 func (c *cgroup) CPUSetCPUs() ([]uint64, error) {
-	data, err := readFile(path.Join(c.cgroupSet["cpuset"], "cpuset.cpus"))
+	file := path.Join(c.cgroupSet["cpuset"], "cpuset.cpus")
+	if c.unified != "" {
+		file = path.Join(c.unified, "cpuset.cpus.effective")
+	}
+	data, err := readFile(file)
 	if err != nil {
 		return nil, err
 	}
@@ -86,6 +150,7 @@ func currentcGroup() (*cgroup, error) {
 	pid := os.Getpid()
 	cgroupFile := fmt.Sprintf("/proc/%d/cgroup", pid)
 	cgroupSet := make(map[string]string)
+	unified := ""
 	fp, err := os.Open(cgroupFile)
 	if err != nil {
 		return nil, err
@@ -105,6 +170,14 @@ func currentcGroup() (*cgroup, error) {
 			return nil, fmt.Errorf("invalid cgroup format %s", line)
 		}
 		dir := col[2]
+		if col[0] == "0" && col[1] == "" {
+			// cgroup v2, the directory is missing if the cgroup namespace is not private
+			unified = path.Join(cgroupRootDir, dir)
+			if _, err := os.Stat(unified); err != nil {
+				unified = cgroupRootDir
+			}
+			continue
+		}
 		// When dir is not equal to /, it must be in docker
 		if dir != "/" {
 			cgroupSet[col[1]] = path.Join(cgroupRootDir, col[1])
@@ -122,5 +195,9 @@ func currentcGroup() (*cgroup, error) {
 			}
 		}
 	}
-	return &cgroup{cgroupSet: cgroupSet}, nil
+	// prefer the cpu controllers of cgroup v1 in hybrid mode
+	if _, ok := cgroupSet["cpuacct"]; ok {
+		unified = ""
+	}
+	return &cgroup{cgroupSet: cgroupSet, unified: unified}, nil
 }
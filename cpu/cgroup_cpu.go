@@ -10,7 +10,10 @@ import (
 	pscpu "github.com/shirou/gopsutil/v3/cpu"
 )
 
-var _ CPU = (*cgroupCPU)(nil)
+var (
+	_ CPU       = (*cgroupCPU)(nil)
+	_ throttler = (*cgroupCPU)(nil)
+)
 
 type cgroupCPU struct {
 	frequency uint64
@@ -19,6 +22,9 @@ type cgroupCPU struct {
 
 	preSystem uint64
 	preTotal  uint64
+
+	prePeriods   uint64
+	preThrottled uint64
 }
 
 func newCgroupCPU() (cpu *cgroupCPU, err error) {
@@ -89,6 +95,24 @@ func (cpu *cgroupCPU) Usage() (u uint64, err error) {
 	return
 }
 
+// Throttling returns the permillage of the CFS periods throttled since last call.
+func (cpu *cgroupCPU) Throttling() (t uint64, err error) {
+	var (
+		periods   uint64
+		throttled uint64
+	)
+	periods, throttled, err = cpuThrottled()
+	if err != nil {
+		return
+	}
+	if periods > cpu.prePeriods {
+		t = (throttled - cpu.preThrottled) * 1e3 / (periods - cpu.prePeriods)
+	}
+	cpu.prePeriods = periods
+	cpu.preThrottled = throttled
+	return
+}
+
 func (cpu *cgroupCPU) Info() Info {
 	return Info{
 		Frequency: cpu.frequency,
@@ -168,6 +192,14 @@ func perCPUUsage() (usage []uint64, err error) {
 	return cg.CPUAcctUsagePerCPU()
 }
 
+func cpuThrottled() (periods uint64, throttled uint64, err error) {
+	var cg *cgroup
+	if cg, err = currentcGroup(); err != nil {
+		return
+	}
+	return cg.CPUThrottled()
+}
+
 func cpuSets() (sets []uint64, err error) {
 	var cg *cgroup
 	if cg, err = currentcGroup(); err != nil {
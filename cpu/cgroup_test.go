@@ -0,0 +1,67 @@
+package cpu
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		assert.NoError(t, os.WriteFile(path.Join(dir, name), []byte(content), 0o644))
+	}
+}
+
+func TestCgroupV2(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"cpu.max":               "150000 100000\n",
+		"cpu.stat":              "usage_usec 2000\nuser_usec 1500\nsystem_usec 500\nnr_periods 40\nnr_throttled 10\nthrottled_usec 300\n",
+		"cpuset.cpus.effective": "0-3\n",
+	})
+	cg := &cgroup{unified: dir}
+	quota, err := cg.CPUCFSQuotaUs()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(150000), quota)
+	period, err := cg.CPUCFSPeriodUs()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100000), period)
+	usage, err := cg.CPUAcctUsage()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2000000), usage)
+	periods, throttled, err := cg.CPUThrottled()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(40), periods)
+	assert.Equal(t, uint64(10), throttled)
+	sets, err := cg.CPUSetCPUs()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []uint64{0, 1, 2, 3}, sets)
+	_, err = cg.CPUAcctUsagePerCPU()
+	assert.Equal(t, ErrPerCPUUsage, err)
+
+	writeFiles(t, dir, map[string]string{"cpu.max": "max 100000\n"})
+	quota, err = cg.CPUCFSQuotaUs()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-1), quota)
+}
+
+func TestCgroupV1Throttled(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"cpu.stat": "nr_periods 100\nnr_throttled 25\nthrottled_time 123456\n",
+	})
+	cg := &cgroup{cgroupSet: map[string]string{"cpu": dir}}
+	periods, throttled, err := cg.CPUThrottled()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), periods)
+	assert.Equal(t, uint64(25), throttled)
+}
+
+func TestEMA(t *testing.T) {
+	assert.Equal(t, uint64(50), ema(0, 1000))
+	// the usage is capped to 100%
+	assert.Equal(t, uint64(50), ema(0, 4000))
+	assert.Equal(t, uint64(500), ema(500, 500))
+}
@@ -0,0 +1,97 @@
+// Package cpu collects the CPU usage of the process, it is container-accurate
+// with cgroup v1 and v2 quotas and falls back to psutil on other platforms.
+// The usage is sampled every 500ms and smoothed by EMA.
+package cpu
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	interval time.Duration = time.Millisecond * 500
+)
+
+var (
+	stats      CPU
+	usage      uint64
+	smoothed   uint64
+	throttling uint64
+	decay      = 0.95
+)
+
+// CPU is cpu stat usage.
+type CPU interface {
+	Usage() (u uint64, e error)
+	Info() Info
+}
+
+// throttler reports the CFS throttling of the cgroup.
+type throttler interface {
+	Throttling() (t uint64, e error)
+}
+
+func init() {
+	var (
+		err error
+	)
+	stats, err = newCgroupCPU()
+	if err != nil {
+		// fmt.Printf("cgroup cpu init failed(%v),switch to psutil cpu\n", err)
+		stats, err = newPsutilCPU(interval)
+		if err != nil {
+			panic(fmt.Sprintf("cgroup cpu init failed!err:=%v", err))
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			<-ticker.C
+			u, err := stats.Usage()
+			if err == nil && u != 0 {
+				atomic.StoreUint64(&usage, u)
+				atomic.StoreUint64(&smoothed, ema(atomic.LoadUint64(&smoothed), u))
+			}
+			if t, ok := stats.(throttler); ok {
+				if v, err := t.Throttling(); err == nil {
+					atomic.StoreUint64(&throttling, v)
+				}
+			}
+		}
+	}()
+}
+
+// ema returns prev * decay + cur * (1 - decay), cur is capped to 100%.
+func ema(prev, cur uint64) uint64 {
+	if cur > 1000 {
+		cur = 1000
+	}
+	return uint64(float64(prev)*decay + float64(cur)*(1.0-decay))
+}
+
+// Stat cpu stat.
+type Stat struct {
+	Usage      uint64 // cpu use ratio.
+	Smoothed   uint64 // cpu use ratio smoothed by EMA, capped to 1000.
+	Throttling uint64 // ratio of the CFS periods throttled, 0 without cgroup.
+}
+
+// Info cpu info.
+type Info struct {
+	Frequency uint64
+	Quota     float64
+}
+
+// ReadStat read cpu stat.
+func ReadStat(stat *Stat) {
+	stat.Usage = atomic.LoadUint64(&usage)
+	stat.Smoothed = atomic.LoadUint64(&smoothed)
+	stat.Throttling = atomic.LoadUint64(&throttling)
+}
+
+// GetInfo get cpu info.
+func GetInfo() Info {
+	return stats.Info()
+}
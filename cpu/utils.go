@@ -119,3 +119,24 @@ func readLinesOffsetN(filename string, offset uint, n int) ([]string, error) {
 
 	return ret, nil
 }
+
+// readStatFile reads the flat keyed file such as cpu.stat, "$KEY $VALUE" per line.
+func readStatFile(filename string) (map[string]uint64, error) {
+	lines, err := readLines(filename)
+	if err != nil {
+		return nil, err
+	}
+	stat := make(map[string]uint64, len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := parseUint(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		stat[fields[0]] = v
+	}
+	return stat, nil
+}
@@ -0,0 +1,206 @@
+package cuckoo
+
+// Native Go implement of Cuckoo filter, Based on paper
+// Cuckoo Filter: Practically Better Than Bloom (https://www.cs.cmu.edu/~dga/papers/cuckoo-conext2014.pdf)
+
+import (
+	"errors"
+	"math/bits"
+	"unsafe"
+
+	"github.com/twmb/murmur3"
+	"golang.org/x/exp/rand"
+)
+
+const (
+	// bucketSize is the number of fingerprints of a bucket.
+	bucketSize = 4
+	// maxKicks is the max number of relocations of an insertion.
+	maxKicks = 500
+)
+
+// ErrFull is returned when a key can't be added because the filter is full.
+var ErrFull = errors.New("cuckoo: filter is full")
+
+type bucket [bucketSize]uint16
+
+// victim is the fingerprint evicted by the last failed insertion, it is kept
+// aside so the filter has no false negatives.
+type victim struct {
+	index       uint64
+	fingerprint uint16
+	used        bool
+}
+
+// Filter tests whether a key is in a set like a Bloom filter, and also
+// supports deleting keys. Fingerprints are 16 bits, the false positive rate is
+// about 8/2^16 at full load.
+type Filter struct {
+	buckets []bucket
+	mask    uint64
+	count   uint64
+	victim  victim
+	r       *rand.Rand
+}
+
+// New return a Cuckoo filter with the capacity of at least capacity keys.
+func New(capacity uint64) *Filter {
+	n := (capacity + bucketSize - 1) / bucketSize
+	if n < 1 {
+		n = 1
+	}
+	n = 1 << bits.Len64(n-1)
+	return &Filter{
+		buckets: make([]bucket, n),
+		mask:    n - 1,
+		r:       rand.New(rand.NewSource(uint64(n))),
+	}
+}
+
+func (f *Filter) hash(key []byte) (uint64, uint16) {
+	h := murmur3.Sum64(key)
+	fingerprint := uint16(h >> 48)
+	if fingerprint == 0 {
+		// zero marks an empty slot
+		fingerprint = 1
+	}
+	return h & f.mask, fingerprint
+}
+
+func (f *Filter) altIndex(index uint64, fingerprint uint16) uint64 {
+	var b [2]byte
+	b[0], b[1] = byte(fingerprint), byte(fingerprint>>8)
+	return (index ^ murmur3.Sum64(b[:])) & f.mask
+}
+
+// Add add key into the filter, ErrFull is returned if there is no room for it.
+// Adding a key twice stores it twice, it must then be deleted twice.
+func (f *Filter) Add(key []byte) error {
+	if f.victim.used {
+		return ErrFull
+	}
+	i1, fingerprint := f.hash(key)
+	i2 := f.altIndex(i1, fingerprint)
+	if f.insert(i1, fingerprint) || f.insert(i2, fingerprint) {
+		f.count++
+		return nil
+	}
+	index := i1
+	if f.r.Intn(2) == 1 {
+		index = i2
+	}
+	for i := 0; i < maxKicks; i++ {
+		slot := f.r.Intn(bucketSize)
+		fingerprint, f.buckets[index][slot] = f.buckets[index][slot], fingerprint
+		index = f.altIndex(index, fingerprint)
+		if f.insert(index, fingerprint) {
+			f.count++
+			return nil
+		}
+	}
+	f.victim = victim{index: index, fingerprint: fingerprint, used: true}
+	f.count++
+	return nil
+}
+
+// AddString add key into the filter without copying it.
+func (f *Filter) AddString(key string) error {
+	return f.Add(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+func (f *Filter) insert(index uint64, fingerprint uint16) bool {
+	b := &f.buckets[index]
+	for i := range b {
+		if b[i] == 0 {
+			b[i] = fingerprint
+			return true
+		}
+	}
+	return false
+}
+
+// Test return false if key is definitely not in the filter.
+func (f *Filter) Test(key []byte) bool {
+	i1, fingerprint := f.hash(key)
+	i2 := f.altIndex(i1, fingerprint)
+	if f.victim.used && f.victim.fingerprint == fingerprint && (f.victim.index == i1 || f.victim.index == i2) {
+		return true
+	}
+	return f.find(i1, fingerprint) >= 0 || f.find(i2, fingerprint) >= 0
+}
+
+// TestString return false if key is definitely not in the filter.
+func (f *Filter) TestString(key string) bool {
+	return f.Test(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+func (f *Filter) find(index uint64, fingerprint uint16) int {
+	for i, fp := range f.buckets[index] {
+		if fp == fingerprint {
+			return i
+		}
+	}
+	return -1
+}
+
+// Delete delete key from the filter and return if it was found. Only keys
+// which were added can be deleted, or another key sharing the fingerprint may
+// be deleted.
+func (f *Filter) Delete(key []byte) bool {
+	i1, fingerprint := f.hash(key)
+	i2 := f.altIndex(i1, fingerprint)
+	if f.victim.used && f.victim.fingerprint == fingerprint && (f.victim.index == i1 || f.victim.index == i2) {
+		f.victim.used = false
+		f.count--
+		return true
+	}
+	for _, index := range [2]uint64{i1, i2} {
+		if slot := f.find(index, fingerprint); slot >= 0 {
+			f.buckets[index][slot] = 0
+			f.count--
+			f.reinsertVictim()
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteString delete key from the filter and return if it was found.
+func (f *Filter) DeleteString(key string) bool {
+	return f.Delete(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// reinsertVictim move the victim back into the buckets once there is room.
+func (f *Filter) reinsertVictim() {
+	if !f.victim.used {
+		return
+	}
+	v := f.victim
+	if f.insert(v.index, v.fingerprint) || f.insert(f.altIndex(v.index, v.fingerprint), v.fingerprint) {
+		f.victim.used = false
+	}
+}
+
+// Count return the number of keys in the filter.
+func (f *Filter) Count() uint64 {
+	return f.count
+}
+
+// LoadFactor return the ratio of occupied slots to all slots.
+func (f *Filter) LoadFactor() float64 {
+	return float64(f.count) / float64(len(f.buckets)*bucketSize)
+}
+
+// Reset clear all keys in place.
+func (f *Filter) Reset() {
+	for i := range f.buckets {
+		f.buckets[i] = bucket{}
+	}
+	f.count = 0
+	f.victim = victim{}
+}
+
+// SizeBytes return the memory consumed by the filter in bytes.
+func (f *Filter) SizeBytes() uint64 {
+	return uint64(unsafe.Sizeof(*f)) + uint64(cap(f.buckets))*uint64(unsafe.Sizeof(bucket{}))
+}
@@ -0,0 +1,75 @@
+package cuckoo
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCuckooAddDelete(t *testing.T) {
+	f := New(10000)
+	for i := 0; i < 9000; i++ {
+		assert.Nil(t, f.AddString(strconv.Itoa(i)))
+	}
+	assert.Equal(t, uint64(9000), f.Count())
+	for i := 0; i < 9000; i++ {
+		assert.True(t, f.TestString(strconv.Itoa(i)))
+	}
+	var fp int
+	for i := 9000; i < 109000; i++ {
+		if f.TestString(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	assert.Less(t, float64(fp)/100000, 0.001)
+
+	for i := 0; i < 9000; i += 2 {
+		assert.True(t, f.DeleteString(strconv.Itoa(i)))
+	}
+	assert.Equal(t, uint64(4500), f.Count())
+	for i := 1; i < 9000; i += 2 {
+		assert.True(t, f.TestString(strconv.Itoa(i)))
+	}
+	assert.False(t, f.DeleteString("missing"))
+
+	f.Reset()
+	assert.Equal(t, uint64(0), f.Count())
+	assert.False(t, f.TestString("1"))
+}
+
+func TestCuckooFull(t *testing.T) {
+	f := New(64)
+	var added []string
+	for i := 0; ; i++ {
+		key := strconv.Itoa(i)
+		if err := f.AddString(key); err != nil {
+			assert.Equal(t, ErrFull, err)
+			break
+		}
+		added = append(added, key)
+	}
+	assert.Greater(t, f.LoadFactor(), 0.8)
+	// no false negatives, the victim of the last insertion is kept
+	for _, key := range added {
+		assert.True(t, f.TestString(key))
+	}
+	count := f.Count()
+	assert.True(t, f.DeleteString(added[0]))
+	assert.Equal(t, count-1, f.Count())
+}
+
+func BenchmarkCuckooAdd(b *testing.B) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	f := New(uint64(len(keys)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := keys[i%len(keys)]
+		f.AddString(key)
+		f.DeleteString(key)
+	}
+}
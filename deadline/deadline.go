@@ -0,0 +1,140 @@
+// Package deadline derives the per-hop timeouts of calls from the remaining
+// budget of the request, with a floor and per-dependency caps, so calls stop
+// wasting time on work the caller has already abandoned.
+package deadline
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Header is the HTTP header carrying the remaining budget in milliseconds.
+const Header = "X-Request-Timeout"
+
+// ErrBudgetExhausted is returned when the remaining budget is under the floor.
+var ErrBudgetExhausted = errors.New("deadline: budget exhausted")
+
+// Option is budget option function.
+type Option func(*options)
+
+// options of budget.
+type options struct {
+	floor   time.Duration
+	reserve float64
+	def     time.Duration
+	caps    map[string]time.Duration
+}
+
+// WithFloor with the min timeout of a hop, calls are not made if the remaining
+// budget is under it, default 5ms.
+func WithFloor(d time.Duration) Option {
+	return func(o *options) {
+		o.floor = d
+	}
+}
+
+// WithReserve with the ratio of the remaining budget reserved for the caller
+// to handle the results, default 0.
+func WithReserve(ratio float64) Option {
+	return func(o *options) {
+		o.reserve = ratio
+	}
+}
+
+// WithDefaultCap with the timeout of dependencies without cap, default 1s.
+func WithDefaultCap(d time.Duration) Option {
+	return func(o *options) {
+		o.def = d
+	}
+}
+
+// WithCap with the max timeout of a dependency.
+func WithCap(dependency string, d time.Duration) Option {
+	return func(o *options) {
+		o.caps[dependency] = d
+	}
+}
+
+// Budget derives the timeouts of hops.
+type Budget struct {
+	opts options
+}
+
+// New returns a budget.
+func New(opts ...Option) *Budget {
+	opt := options{
+		floor: 5 * time.Millisecond,
+		def:   time.Second,
+		caps:  make(map[string]time.Duration),
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Budget{opts: opt}
+}
+
+// Timeout returns the timeout of a call of dependency, it is the remaining
+// budget of ctx minus the reserve, capped by the dependency. It returns
+// ErrBudgetExhausted if the timeout is under the floor.
+func (b *Budget) Timeout(ctx context.Context, dependency string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	timeout, ok := b.opts.caps[dependency]
+	if !ok {
+		timeout = b.opts.def
+	}
+	if remaining, ok := Remaining(ctx); ok {
+		remaining -= time.Duration(float64(remaining) * b.opts.reserve)
+		if remaining < timeout {
+			timeout = remaining
+		}
+	}
+	if timeout < b.opts.floor {
+		return 0, ErrBudgetExhausted
+	}
+	return timeout, nil
+}
+
+// WithTimeout returns the context of a call of dependency with the timeout.
+func (b *Budget) WithTimeout(ctx context.Context, dependency string) (context.Context, context.CancelFunc, error) {
+	timeout, err := b.Timeout(ctx, dependency)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, nil
+}
+
+// Remaining returns the remaining budget of ctx, false if ctx has no deadline.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// Inject sets the remaining budget of ctx to the header of an outgoing request.
+func Inject(ctx context.Context, header http.Header) {
+	if remaining, ok := Remaining(ctx); ok {
+		ms := remaining.Milliseconds()
+		if ms < 0 {
+			ms = 0
+		}
+		header.Set(Header, strconv.FormatInt(ms, 10))
+	}
+}
+
+// Extract returns the context with the deadline of the budget in the header
+// of an incoming request, ctx is returned as is if the header is absent or invalid.
+func Extract(ctx context.Context, header http.Header) (context.Context, context.CancelFunc) {
+	ms, err := strconv.ParseInt(header.Get(Header), 10, 64)
+	if err != nil || ms < 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+}
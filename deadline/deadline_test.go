@@ -0,0 +1,78 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	b := New(WithFloor(10*time.Millisecond), WithCap("db", 50*time.Millisecond), WithDefaultCap(time.Second))
+	// without deadline, the caps are the timeouts
+	timeout, err := b.Timeout(context.Background(), "db")
+	assert.NoError(t, err)
+	assert.Equal(t, 50*time.Millisecond, timeout)
+	timeout, err = b.Timeout(context.Background(), "cache")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Second, timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	timeout, err = b.Timeout(ctx, "db")
+	assert.NoError(t, err)
+	assert.Equal(t, 50*time.Millisecond, timeout)
+	timeout, err = b.Timeout(ctx, "cache")
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, timeout, 200*time.Millisecond)
+	assert.Greater(t, timeout, 150*time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err = b.Timeout(ctx, "db")
+	assert.Equal(t, ErrBudgetExhausted, err)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = b.Timeout(ctx, "db")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestReserve(t *testing.T) {
+	b := New(WithReserve(0.5))
+	parent, cancelParent := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelParent()
+	ctx, cancel, err := b.WithTimeout(parent, "db")
+	assert.NoError(t, err)
+	defer cancel()
+	remaining, ok := Remaining(ctx)
+	assert.True(t, ok)
+	assert.LessOrEqual(t, remaining, 50*time.Millisecond)
+}
+
+func TestPropagation(t *testing.T) {
+	header := http.Header{}
+	Inject(context.Background(), header)
+	assert.Empty(t, header.Get(Header))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	Inject(ctx, header)
+	ms, err := strconv.Atoi(header.Get(Header))
+	assert.NoError(t, err)
+	assert.Greater(t, ms, 900)
+
+	ctx, cancel = Extract(context.Background(), header)
+	defer cancel()
+	remaining, ok := Remaining(ctx)
+	assert.True(t, ok)
+	assert.Greater(t, remaining, 900*time.Millisecond)
+
+	ctx, cancel = Extract(context.Background(), http.Header{})
+	defer cancel()
+	_, ok = Remaining(ctx)
+	assert.False(t, ok)
+}
@@ -0,0 +1,221 @@
+// Package degrade manages feature degradation switches. A switch is named
+// after the feature it degrades, such as "disable_recommendations", and has a
+// level from 0, not degraded, to its max level. Switches are controlled at
+// runtime by config sources and the admin handler, and read on the hot path
+// with a single atomic load.
+package degrade
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrUnknownSwitch is returned when setting a switch which is not registered.
+	ErrUnknownSwitch = errors.New("degrade: unknown switch")
+	// ErrInvalidLevel is returned when setting a level out of [0, max level].
+	ErrInvalidLevel = errors.New("degrade: invalid level")
+)
+
+// SwitchOption is switch option function.
+type SwitchOption func(*switchOptions)
+
+// switchOptions of switch.
+type switchOptions struct {
+	maxLevel    int32
+	description string
+}
+
+// WithMaxLevel with the max level of the switch, default 1.
+func WithMaxLevel(level int) SwitchOption {
+	return func(o *switchOptions) {
+		o.maxLevel = int32(level)
+	}
+}
+
+// WithDescription with the description of the switch shown by the admin handler.
+func WithDescription(description string) SwitchOption {
+	return func(o *switchOptions) {
+		o.description = description
+	}
+}
+
+// Switch is a degradation switch.
+type Switch struct {
+	name  string
+	level int32
+	opts  switchOptions
+}
+
+// Name returns the name of the switch.
+func (s *Switch) Name() string {
+	return s.name
+}
+
+// Level returns the level of the switch.
+func (s *Switch) Level() int {
+	return int(atomic.LoadInt32(&s.level))
+}
+
+// Enabled reports whether the feature is degraded at any level, it can be
+// used as a loadshed.Signal.
+func (s *Switch) Enabled() bool {
+	return atomic.LoadInt32(&s.level) > 0
+}
+
+// AtLeast reports whether the feature is degraded at level or above.
+func (s *Switch) AtLeast(level int) bool {
+	return atomic.LoadInt32(&s.level) >= int32(level)
+}
+
+// Stat is the state of a switch.
+type Stat struct {
+	Name        string `json:"name"`
+	Level       int    `json:"level"`
+	MaxLevel    int    `json:"max_level"`
+	Description string `json:"description,omitempty"`
+}
+
+// ChangeFunc is called after the level of a switch changes.
+type ChangeFunc func(name string, from, to int)
+
+// Manager is a registry of switches.
+type Manager struct {
+	mu        sync.Mutex
+	switches  map[string]*Switch
+	listeners []ChangeFunc
+}
+
+// New returns a switch manager.
+func New() *Manager {
+	return &Manager{switches: make(map[string]*Switch)}
+}
+
+// Register registers the switch of name at level 0, or returns the switch
+// already registered. The switch should be kept to read it on the hot path.
+func (m *Manager) Register(name string, opts ...SwitchOption) *Switch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.switches[name]; ok {
+		return s
+	}
+	opt := switchOptions{maxLevel: 1}
+	for _, o := range opts {
+		o(&opt)
+	}
+	s := &Switch{name: name, opts: opt}
+	m.switches[name] = s
+	return s
+}
+
+// Switch returns the switch of name, nil if it is not registered.
+func (m *Manager) Switch(name string) *Switch {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.switches[name]
+}
+
+// Level returns the level of the switch of name, 0 if it is not registered.
+func (m *Manager) Level(name string) int {
+	if s := m.Switch(name); s != nil {
+		return s.Level()
+	}
+	return 0
+}
+
+// OnChange adds a listener called after the level of a switch changes.
+func (m *Manager) OnChange(fn ChangeFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// Set sets the level of the switch of name.
+func (m *Manager) Set(name string, level int) error {
+	return m.Apply(map[string]int{name: level})
+}
+
+// Apply sets the levels of switches by name, such as the levels of a config
+// source. No level is set if any switch is unknown or any level is invalid.
+func (m *Manager) Apply(levels map[string]int) error {
+	m.mu.Lock()
+	for name, level := range levels {
+		s, ok := m.switches[name]
+		if !ok {
+			m.mu.Unlock()
+			return fmt.Errorf("%w: %s", ErrUnknownSwitch, name)
+		}
+		if level < 0 || level > int(s.opts.maxLevel) {
+			m.mu.Unlock()
+			return fmt.Errorf("%w: %s level %d out of [0, %d]", ErrInvalidLevel, name, level, s.opts.maxLevel)
+		}
+	}
+	type change struct {
+		name     string
+		from, to int
+	}
+	var changes []change
+	for name, level := range levels {
+		s := m.switches[name]
+		if from := atomic.SwapInt32(&s.level, int32(level)); int(from) != level {
+			changes = append(changes, change{name: name, from: int(from), to: level})
+		}
+	}
+	listeners := m.listeners
+	m.mu.Unlock()
+	for _, c := range changes {
+		for _, fn := range listeners {
+			fn(c.name, c.from, c.to)
+		}
+	}
+	return nil
+}
+
+// Stats returns the state of the switches sorted by name.
+func (m *Manager) Stats() []Stat {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]Stat, 0, len(m.switches))
+	for _, s := range m.switches {
+		stats = append(stats, Stat{
+			Name:        s.name,
+			Level:       s.Level(),
+			MaxLevel:    int(s.opts.maxLevel),
+			Description: s.opts.description,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Handler returns the admin handler of the switches. GET returns the state of
+// the switches as JSON, and POST or PUT applies the levels of the JSON object
+// in the body, such as {"disable_recommendations": 1}.
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodPut:
+			var levels map[string]int
+			if err := json.NewDecoder(r.Body).Decode(&levels); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := m.Apply(levels); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.Stats())
+	})
+}
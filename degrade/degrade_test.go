@@ -0,0 +1,66 @@
+package degrade
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager(t *testing.T) {
+	m := New()
+	recommend := m.Register("disable_recommendations", WithDescription("hide recommendations"))
+	search := m.Register("degrade_search", WithMaxLevel(3))
+	assert.Same(t, recommend, m.Register("disable_recommendations"))
+	assert.False(t, recommend.Enabled())
+
+	var changes []string
+	m.OnChange(func(name string, from, to int) {
+		changes = append(changes, name)
+	})
+	assert.NoError(t, m.Set("disable_recommendations", 1))
+	assert.True(t, recommend.Enabled())
+	assert.NoError(t, m.Set("degrade_search", 2))
+	assert.True(t, search.AtLeast(2))
+	assert.False(t, search.AtLeast(3))
+	assert.Equal(t, 2, m.Level("degrade_search"))
+	assert.Equal(t, 0, m.Level("unknown"))
+	assert.Nil(t, m.Switch("unknown"))
+
+	// nothing is applied if any level is invalid
+	err := m.Apply(map[string]int{"disable_recommendations": 0, "degrade_search": 4})
+	assert.True(t, errors.Is(err, ErrInvalidLevel))
+	assert.True(t, recommend.Enabled())
+	assert.True(t, errors.Is(m.Set("unknown", 1), ErrUnknownSwitch))
+	// unchanged levels are not notified
+	assert.NoError(t, m.Set("degrade_search", 2))
+	assert.Equal(t, []string{"disable_recommendations", "degrade_search"}, changes)
+
+	assert.Equal(t, []Stat{
+		{Name: "degrade_search", Level: 2, MaxLevel: 3},
+		{Name: "disable_recommendations", Level: 1, MaxLevel: 1, Description: "hide recommendations"},
+	}, m.Stats())
+}
+
+func TestManagerHandler(t *testing.T) {
+	m := New()
+	s := m.Register("disable_recommendations")
+	handler := m.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"disable_recommendations": 1}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"name": "disable_recommendations", "level": 1, "max_level": 1}]`, w.Body.String())
+	assert.True(t, s.Enabled())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"disable_recommendations": 2}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,244 @@
+// Package fallback serves degraded responses, such as stale cached values,
+// when the protection kicks in: the limiter rejects, the breaker is open, or
+// the primary call fails. The fallbacks are counted by reason, and the
+// fallback rate over a rolling window tells how degraded the service is.
+package fallback
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/window"
+)
+
+// Func is the primary call.
+type Func[T any] func(ctx context.Context) (T, error)
+
+// FallbackFunc is the fallback, called with the error of the limiter, the
+// breaker or the primary call.
+type FallbackFunc[T any] func(ctx context.Context, err error) (T, error)
+
+// Option is fallback option function.
+type Option func(*options)
+
+// options of fallback.
+type options struct {
+	limiter    ratelimit.Limiter
+	breaker    circuitbreaker.CircuitBreaker
+	fallbackOn func(err error) bool
+	window     time.Duration
+	bucket     int
+}
+
+// WithLimiter with the limiter checked before the primary call.
+func WithLimiter(limiter ratelimit.Limiter) Option {
+	return func(o *options) {
+		o.limiter = limiter
+	}
+}
+
+// WithBreaker with the breaker checked before the primary call and marked with
+// its result and elapsed time, a primary call failing with context.Canceled
+// is not marked.
+func WithBreaker(breaker circuitbreaker.CircuitBreaker) Option {
+	return func(o *options) {
+		o.breaker = breaker
+	}
+}
+
+// WithFallbackOn with the errors of the primary call which are served by the
+// fallback, default all errors except the errors of the context.
+func WithFallbackOn(fn func(err error) bool) Option {
+	return func(o *options) {
+		o.fallbackOn = fn
+	}
+}
+
+// WithWindow with the duration of the rolling window of the fallback rate, default 10s.
+func WithWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.window = d
+	}
+}
+
+// WithBucket with the buckets of the rolling window, default 10.
+func WithBucket(b int) Option {
+	return func(o *options) {
+		o.bucket = b
+	}
+}
+
+// Stat contains the metrics snapshot of fallback.
+type Stat struct {
+	Requests uint64
+	// Limited, Rejected and Failed are the fallbacks for the limiter, the
+	// breaker and the primary call.
+	Limited  uint64
+	Rejected uint64
+	Failed   uint64
+	// Errors is the fallbacks which failed.
+	Errors uint64
+	// Rate is the ratio of fallbacks to requests within the rolling window.
+	Rate float64
+}
+
+// Fallback calls the primary call, and the fallback when the protection kicks in.
+type Fallback[T any] struct {
+	requests  window.RollingCounter
+	fallbacks window.RollingCounter
+	total     uint64
+	limited   uint64
+	rejected  uint64
+	failed    uint64
+	errors    uint64
+	opts      options
+}
+
+// New returns a fallback.
+func New[T any](opts ...Option) *Fallback[T] {
+	opt := options{
+		fallbackOn: func(err error) bool {
+			return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+		},
+		window: 10 * time.Second,
+		bucket: 10,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	counterOpts := window.RollingCounterOpts{
+		Size:           opt.bucket,
+		BucketDuration: time.Duration(int64(opt.window) / int64(opt.bucket)),
+	}
+	return &Fallback[T]{
+		requests:  window.NewRollingCounter(counterOpts),
+		fallbacks: window.NewRollingCounter(counterOpts),
+		opts:      opt,
+	}
+}
+
+// Do calls primary if the limiter and the breaker allow it, and fallback with
+// the error if they reject or primary fails. The error is returned as is if
+// fallback is nil.
+func (f *Fallback[T]) Do(ctx context.Context, primary Func[T], fallback FallbackFunc[T]) (T, error) {
+	atomic.AddUint64(&f.total, 1)
+	f.requests.Add(1)
+	var limiterDone ratelimit.DoneFunc
+	if f.opts.limiter != nil {
+		done, err := f.opts.limiter.Allow()
+		if err != nil {
+			return f.fallback(ctx, fallback, err, &f.limited)
+		}
+		limiterDone = done
+	}
+	var p circuitbreaker.Permit
+	if f.opts.breaker != nil {
+		var err error
+		if p, err = allowBreaker(f.opts.breaker); err != nil {
+			if limiterDone != nil {
+				limiterDone(ratelimit.DoneInfo{Err: err})
+			}
+			return f.fallback(ctx, fallback, err, &f.rejected)
+		}
+	}
+	start := time.Now()
+	v, err := primary(ctx)
+	if limiterDone != nil {
+		limiterDone(ratelimit.DoneInfo{Err: err})
+	}
+	if p != nil {
+		// a caller giving up tells nothing of the health of the backend
+		if errors.Is(err, context.Canceled) {
+			p.Release()
+		} else {
+			p.Mark(err == nil, time.Since(start))
+		}
+	}
+	if err != nil && f.opts.fallbackOn(err) {
+		return f.fallback(ctx, fallback, err, &f.failed)
+	}
+	return v, err
+}
+
+// acquirer is a breaker returning the permits of the allowed requests, such as
+// the classic breaker.
+type acquirer interface {
+	Acquire() (circuitbreaker.Permit, error)
+}
+
+// marker is a breaker marking the elapsed time of requests.
+type marker interface {
+	Mark(success bool, elapsed time.Duration)
+}
+
+// releaser is a breaker returning the permit of a request without a result.
+type releaser interface {
+	Release()
+}
+
+// allowBreaker allows the request on breaker, and returns the permit marking
+// the result of the request as middleware.Guard does.
+func allowBreaker(breaker circuitbreaker.CircuitBreaker) (circuitbreaker.Permit, error) {
+	if a, ok := breaker.(acquirer); ok {
+		return a.Acquire()
+	}
+	if err := breaker.Allow(); err != nil {
+		return nil, err
+	}
+	return permit{breaker}, nil
+}
+
+// permit is the permit of a breaker without permits.
+type permit struct {
+	breaker circuitbreaker.CircuitBreaker
+}
+
+func (p permit) Mark(success bool, elapsed time.Duration) {
+	// breakers tracking slow calls are marked with the elapsed time
+	if m, ok := p.breaker.(marker); ok {
+		m.Mark(success, elapsed)
+	} else if success {
+		p.breaker.MarkSuccess()
+	} else {
+		p.breaker.MarkFailed()
+	}
+}
+
+func (p permit) Release() {
+	if r, ok := p.breaker.(releaser); ok {
+		r.Release()
+	}
+}
+
+func (f *Fallback[T]) fallback(ctx context.Context, fallback FallbackFunc[T], err error, reason *uint64) (T, error) {
+	atomic.AddUint64(reason, 1)
+	f.fallbacks.Add(1)
+	if fallback == nil {
+		var zero T
+		return zero, err
+	}
+	v, err := fallback(ctx, err)
+	if err != nil {
+		atomic.AddUint64(&f.errors, 1)
+	}
+	return v, err
+}
+
+// Stat returns the metrics snapshot of the fallback.
+func (f *Fallback[T]) Stat() Stat {
+	stat := Stat{
+		Requests: atomic.LoadUint64(&f.total),
+		Limited:  atomic.LoadUint64(&f.limited),
+		Rejected: atomic.LoadUint64(&f.rejected),
+		Failed:   atomic.LoadUint64(&f.failed),
+		Errors:   atomic.LoadUint64(&f.errors),
+	}
+	if requests := f.requests.Sum(); requests > 0 {
+		stat.Rate = f.fallbacks.Sum() / requests
+	}
+	return stat
+}
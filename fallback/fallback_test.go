@@ -0,0 +1,86 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/circuitbreaker/classic"
+	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+)
+
+func TestFallbackDo(t *testing.T) {
+	breaker := classic.NewBreaker(classic.WithRequest(2))
+	f := New[string](WithBreaker(breaker))
+	failed := errors.New("failed")
+	primary := func(ctx context.Context) (string, error) { return "fresh", nil }
+	failing := func(ctx context.Context) (string, error) { return "", failed }
+	stale := func(ctx context.Context, err error) (string, error) { return "stale", nil }
+
+	v, err := f.Do(context.Background(), primary, stale)
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", v)
+	v, err = f.Do(context.Background(), failing, stale)
+	assert.NoError(t, err)
+	assert.Equal(t, "stale", v)
+
+	// the breaker opens, primary is not called
+	v, err = f.Do(context.Background(), primary, func(ctx context.Context, err error) (string, error) {
+		assert.Equal(t, circuitbreaker.ErrNotAllowed, err)
+		return "stale", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "stale", v)
+	// without fallback the error is returned
+	_, err = f.Do(context.Background(), primary, nil)
+	assert.Equal(t, circuitbreaker.ErrNotAllowed, err)
+
+	stat := f.Stat()
+	assert.Equal(t, Stat{Requests: 4, Rejected: 2, Failed: 1, Rate: 0.75}, stat)
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	limiter := concurrency.NewLimiter(1)
+	f := New[int](WithLimiter(limiter))
+	fallback := func(ctx context.Context, err error) (int, error) {
+		return 0, err
+	}
+	_, err := f.Do(context.Background(), func(ctx context.Context) (int, error) {
+		_, err := f.Do(ctx, func(ctx context.Context) (int, error) { return 1, nil }, fallback)
+		assert.Equal(t, ratelimit.ErrLimitExceed, err)
+		return 1, nil
+	}, fallback)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, limiter.Stat().InFlight)
+
+	// errors of the context are not served by the fallback
+	_, err = f.Do(context.Background(), func(ctx context.Context) (int, error) { return 0, context.Canceled }, fallback)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, Stat{Requests: 3, Limited: 1, Errors: 1, Rate: 1.0 / 3}, f.Stat())
+}
+
+func TestFallbackBreaker(t *testing.T) {
+	breaker := classic.NewBreaker(classic.WithRequest(2), classic.WithSlowCallRate(0.5), classic.WithSlowCallDuration(time.Millisecond))
+	f := New[string](WithBreaker(breaker))
+	// callers giving up don't trip the breaker
+	canceled := func(ctx context.Context) (string, error) { return "", context.Canceled }
+	for i := 0; i < 3; i++ {
+		f.Do(context.Background(), canceled, nil)
+	}
+	assert.Equal(t, classic.StateClosed, breaker.State())
+
+	// slow calls do, by the elapsed time of the primary call
+	slow := func(ctx context.Context) (string, error) {
+		time.Sleep(2 * time.Millisecond)
+		return "fresh", nil
+	}
+	for i := 0; i < 2; i++ {
+		_, err := f.Do(context.Background(), slow, nil)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, classic.StateOpen, breaker.State())
+}
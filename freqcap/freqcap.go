@@ -0,0 +1,140 @@
+// Package freqcap caps the frequency of operations per key, such as the
+// notifications sent to a user or the writes of a key, to at most limit
+// operations within a rolling window.
+package freqcap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/window"
+)
+
+// Option is frequency cap option function.
+type Option func(*options)
+
+// options of frequency cap.
+type options struct {
+	bucket int
+	sweep  time.Duration
+}
+
+// WithBucket with the buckets of the rolling window, default 10.
+func WithBucket(b int) Option {
+	return func(o *options) {
+		o.bucket = b
+	}
+}
+
+// WithSweepInterval with the interval of evicting idle keys, default 1m.
+func WithSweepInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.sweep = d
+	}
+}
+
+type counter struct {
+	window.RollingCounter
+	lastSeen time.Time
+}
+
+// Cap allows at most limit operations per key within the rolling window.
+type Cap struct {
+	mu       sync.Mutex
+	limit    int64
+	window   time.Duration
+	counters map[string]*counter
+	sweepAt  time.Time
+	opts     options
+}
+
+// New returns a frequency cap allowing limit operations per key per window,
+// there is at least 1 bucket and the buckets last at least 1ns.
+func New(limit int64, window time.Duration, opts ...Option) *Cap {
+	opt := options{
+		bucket: 10,
+		sweep:  time.Minute,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.bucket < 1 {
+		opt.bucket = 1
+	}
+	if window < time.Duration(opt.bucket) {
+		window = time.Duration(opt.bucket)
+	}
+	return &Cap{
+		limit:    limit,
+		window:   window,
+		counters: make(map[string]*counter),
+		opts:     opt,
+	}
+}
+
+// Allow counts one operation of key and reports whether it is allowed.
+func (c *Cap) Allow(key string) bool {
+	return c.AllowN(key, 1)
+}
+
+// AllowN counts n operations of key and reports whether they are allowed,
+// the operations are not counted if they are not allowed.
+func (c *Cap) AllowN(key string, n int64) bool {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweepIdle(now)
+	ctr, ok := c.counters[key]
+	if !ok {
+		ctr = &counter{RollingCounter: window.NewRollingCounter(window.RollingCounterOpts{
+			Size:           c.opts.bucket,
+			BucketDuration: c.window / time.Duration(c.opts.bucket),
+		})}
+		c.counters[key] = ctr
+	}
+	ctr.lastSeen = now
+	if ctr.Value()+n > c.limit {
+		return false
+	}
+	ctr.Add(n)
+	return true
+}
+
+// Count returns the operations of key within the window.
+func (c *Cap) Count(key string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ctr, ok := c.counters[key]; ok {
+		return ctr.Value()
+	}
+	return 0
+}
+
+// Remaining returns the operations of key still allowed within the window.
+func (c *Cap) Remaining(key string) int64 {
+	remaining := c.limit - c.Count(key)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Reset forgets the operations of key.
+func (c *Cap) Reset(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.counters, key)
+}
+
+// sweepIdle evicts the keys without operations within the window.
+func (c *Cap) sweepIdle(now time.Time) {
+	if now.Before(c.sweepAt) {
+		return
+	}
+	c.sweepAt = now.Add(c.opts.sweep)
+	for key, ctr := range c.counters {
+		if now.Sub(ctr.lastSeen) > c.window {
+			delete(c.counters, key)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package freqcap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapAllow(t *testing.T) {
+	c := New(3, 100*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		assert.True(t, c.Allow("user1"))
+	}
+	assert.False(t, c.Allow("user1"))
+	assert.True(t, c.Allow("user2"))
+	assert.Equal(t, int64(3), c.Count("user1"))
+	assert.Equal(t, int64(0), c.Remaining("user1"))
+	assert.Equal(t, int64(2), c.Remaining("user2"))
+	// rejected operations are not counted
+	assert.False(t, c.AllowN("user2", 3))
+	assert.True(t, c.AllowN("user2", 2))
+
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, c.Allow("user1"))
+
+	c.Reset("user2")
+	assert.Equal(t, int64(0), c.Count("user2"))
+}
+
+func TestCapSweep(t *testing.T) {
+	c := New(1, 10*time.Millisecond, WithSweepInterval(time.Millisecond))
+	c.Allow("a")
+	time.Sleep(20 * time.Millisecond)
+	c.Allow("b")
+	assert.Len(t, c.counters, 1)
+}
+
+func TestCapInvalidWindow(t *testing.T) {
+	c := New(1, time.Second, WithBucket(0))
+	assert.True(t, c.Allow("user1"))
+	assert.False(t, c.Allow("user1"))
+	// a window shorter than its buckets is clamped to 1ns per bucket
+	for _, c := range []*Cap{New(1, 5), New(1, 0)} {
+		assert.True(t, c.Allow("user1"))
+		assert.LessOrEqual(t, c.Count("user1"), int64(1))
+	}
+}
@@ -0,0 +1,58 @@
+package hotkey
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Chaos injects failures into a hotkey, so services can be verified to
+// survive the degradation of the local cache in game days. A zero Chaos
+// injects nothing.
+type Chaos struct {
+	// MissRate is the probability of Get returning nil as a cache miss.
+	MissRate float64
+	// DelayRate is the probability of Get being delayed by Delay.
+	DelayRate float64
+	Delay     time.Duration
+	// DropRate is the probability of Del dropping the invalidation, so the
+	// stale value is kept until it expires.
+	DropRate float64
+	// Rand returns a number in [0, 1), default rand.Float64.
+	Rand func() float64
+}
+
+func (c *Chaos) hit(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	if c.Rand != nil {
+		return c.Rand() < rate
+	}
+	return rand.Float64() < rate
+}
+
+// SetChaos set the failures injected into the hotkey, a nil chaos stops the
+// injection.
+func (h *HotKeyWithCache) SetChaos(chaos *Chaos) error {
+	if err := validateChaos(chaos); err != nil {
+		return err
+	}
+	h.chaos.Store(chaos)
+	return nil
+}
+
+func validateChaos(chaos *Chaos) error {
+	if chaos == nil {
+		return nil
+	}
+	for _, rate := range []float64{chaos.MissRate, chaos.DelayRate, chaos.DropRate} {
+		if !(rate >= 0 && rate <= 1) {
+			return fmt.Errorf("%w: rate %v is out of [0, 1]", ErrInvalidChaos, rate)
+		}
+	}
+	if chaos.Delay < 0 {
+		return fmt.Errorf("%w: delay %v is negative", ErrInvalidChaos, chaos.Delay)
+	}
+	return nil
+}
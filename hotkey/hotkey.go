@@ -1,13 +1,16 @@
 package hotkey
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jellydator/ttlcache/v3"
+	"github.com/zychimne/aegis/singleflight"
 	"github.com/zychimne/aegis/topk"
 )
 
@@ -25,6 +28,16 @@ type Option struct {
 	MinCount      int
 	WhileList     []*CacheRuleConfig
 	BlackList     []*CacheRuleConfig
+	// TTLFunc is consulted when a value is cached, such as for an expiry
+	// embedded in the value. A positive ttl overrides the ttl of the option or
+	// the whitelist rule, zero keeps it, and a negative ttl means the value has
+	// expired and is not cached.
+	TTLFunc func(key string, value interface{}) time.Duration
+	// Chaos injects failures into the hotkey, it can be changed by SetChaos.
+	Chaos *Chaos
+	// SnapshotInterval is the maximum age of the snapshot returned by
+	// SnapshotView after a write, zero rebuilds it on the first view after a write.
+	SnapshotInterval time.Duration
 }
 
 var (
@@ -45,11 +58,23 @@ type HotKeyWithCache struct {
 	localCache *ttlcache.Cache[string, interface{}]
 	whilelist  []*cacheRule
 	blacklist  []*cacheRule
+	loader     *singleflight.Group[string, interface{}]
+	chaos      atomic.Pointer[Chaos]
+
+	// snapshot is published for SnapshotView, version counts the writes.
+	snapshot   atomic.Pointer[Snapshot]
+	version    atomic.Uint64
+	refreshing atomic.Bool
 }
 
+// NewHotkey create a hotkey with cache, the option is validated and the errors
+// wrap the ErrX sentinels, or are *ErrBadRule for the rules.
 func NewHotkey(option *Option) (*HotKeyWithCache, error) {
-	var err error
-	h := &HotKeyWithCache{option: option}
+	if err := validate(option); err != nil {
+		return nil, err
+	}
+	h := &HotKeyWithCache{option: option, loader: singleflight.New[string, interface{}]()}
+	h.chaos.Store(option.Chaos)
 	if option.HotKeyCnt > 0 {
 		factor := uint32(math.Log(float64(option.HotKeyCnt)))
 		if factor < 1 {
@@ -58,16 +83,10 @@ func NewHotkey(option *Option) (*HotKeyWithCache, error) {
 		h.topk = topk.NewHeavyKeeper(uint32(option.HotKeyCnt), 1024*factor, 4, 0.925, uint32(option.MinCount))
 	}
 	if len(h.option.WhileList) > 0 {
-		h.whilelist, err = h.initCacheRules(h.option.WhileList)
-		if err != nil {
-			return nil, err
-		}
+		h.whilelist = h.initCacheRules(h.option.WhileList)
 	}
 	if len(h.option.BlackList) > 0 {
-		h.blacklist, err = h.initCacheRules(h.option.BlackList)
-		if err != nil {
-			return nil, err
-		}
+		h.blacklist = h.initCacheRules(h.option.BlackList)
 	}
 	if h.option.AutoCache || len(h.whilelist) > 0 {
 		h.localCache = ttlcache.New[string, interface{}](
@@ -77,7 +96,7 @@ func NewHotkey(option *Option) (*HotKeyWithCache, error) {
 	return h, nil
 }
 
-func (h *HotKeyWithCache) initCacheRules(rules []*CacheRuleConfig) ([]*cacheRule, error) {
+func (h *HotKeyWithCache) initCacheRules(rules []*CacheRuleConfig) []*cacheRule {
 	list := make([]*cacheRule, 0, len(rules))
 	for _, rule := range rules {
 		ttl := rule.TTL
@@ -87,18 +106,12 @@ func (h *HotKeyWithCache) initCacheRules(rules []*CacheRuleConfig) ([]*cacheRule
 		cacheRule := &cacheRule{ttl: ttl}
 		if rule.Mode == ruleTypeKey {
 			cacheRule.value = rule.Value
-		} else if rule.Mode == ruleTypePattern {
-			regexp, err := regexp.Compile(rule.Value)
-			if err != nil {
-				return nil, fmt.Errorf("localcache: add rule pattern failed, err:%v", err)
-			}
-			cacheRule.regexp = regexp
 		} else {
-			return nil, fmt.Errorf("invalid local cache rule mode")
+			cacheRule.regexp = regexp.MustCompile(rule.Value)
 		}
 		list = append(list, cacheRule)
 	}
-	return list, nil
+	return list
 }
 
 func (h *HotKeyWithCache) inBlacklist(key string) bool {
@@ -131,6 +144,19 @@ func (h *HotKeyWithCache) inWhitelist(key string) (time.Duration, bool) {
 	return 0, false
 }
 
+// Cacheable return true if the value of key can be cached locally, by the
+// auto cache unless it's blacklisted, or by the whitelist.
+func (h *HotKeyWithCache) Cacheable(key string) bool {
+	if h.localCache == nil {
+		return false
+	}
+	if h.option.AutoCache && !h.inBlacklist(key) {
+		return true
+	}
+	_, ok := h.inWhitelist(key)
+	return ok
+}
+
 // Add add item to topk, and return true if it's hotkey.
 func (h *HotKeyWithCache) Add(key string, incr uint32) bool {
 	if h.topk == nil {
@@ -138,6 +164,7 @@ func (h *HotKeyWithCache) Add(key string, incr uint32) bool {
 	}
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
+	h.version.Add(1)
 	_, hotkey := h.topk.Add(key, incr)
 	return hotkey
 }
@@ -149,6 +176,7 @@ func (h *HotKeyWithCache) AddWithValue(key string, value interface{}, incr uint3
 	}
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
+	h.version.Add(1)
 	var added bool
 	if h.topk != nil {
 		var expelled string
@@ -158,23 +186,42 @@ func (h *HotKeyWithCache) AddWithValue(key string, value interface{}, incr uint3
 		}
 		if h.option.AutoCache && added {
 			if !h.inBlacklist(key) {
-				h.localCache.Set(key, value, h.option.TTL)
+				h.set(key, value, h.option.TTL)
 			}
 			return added
 		}
 	}
 	if ttl, ok := h.inWhitelist(key); ok {
-		h.localCache.Set(key, value, ttl)
+		h.set(key, value, ttl)
 	}
 	return added
 }
 
+// set caches the value with the ttl of TTLFunc if it's positive, or the static
+// ttl if it's zero. A negative ttl of TTLFunc drops the cached value instead.
+func (h *HotKeyWithCache) set(key string, value interface{}, static time.Duration) {
+	ttl := static
+	if h.option.TTLFunc != nil {
+		if t := h.option.TTLFunc(key, value); t > 0 {
+			ttl = t
+		} else if t < 0 {
+			h.localCache.Delete(key)
+			return
+		}
+	}
+	h.localCache.Set(key, value, ttl)
+}
+
 func (h *HotKeyWithCache) Del(key string) {
 	if h.localCache == nil {
 		return
 	}
+	if chaos := h.chaos.Load(); chaos != nil && chaos.hit(chaos.DropRate) {
+		return
+	}
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
+	h.version.Add(1)
 	h.localCache.Delete(key)
 }
 
@@ -182,6 +229,14 @@ func (h *HotKeyWithCache) Get(key string) interface{} {
 	if h.localCache == nil {
 		return nil
 	}
+	if chaos := h.chaos.Load(); chaos != nil {
+		if chaos.hit(chaos.DelayRate) {
+			time.Sleep(chaos.Delay)
+		}
+		if chaos.hit(chaos.MissRate) {
+			return nil
+		}
+	}
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 	h.localCache.DeleteExpired()
@@ -191,15 +246,50 @@ func (h *HotKeyWithCache) Get(key string) interface{} {
 	return nil
 }
 
+// GetOrLoad get the value of key from the local cache, or load it on a miss.
+// Concurrent loads of the same key are merged into one, and the loaded value
+// is added like AddWithValue, so it is cached if the key is hot.
+func (h *HotKeyWithCache) GetOrLoad(ctx context.Context, key string, load func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if value := h.Get(key); value != nil {
+		h.Add(key, 1)
+		return value, nil
+	}
+	return h.loader.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		value, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+		h.AddWithValue(key, value, 1)
+		return value, nil
+	})
+}
+
 func (h *HotKeyWithCache) Fading() {
 	if h.topk == nil {
 		return
 	}
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
+	h.version.Add(1)
 	h.topk.Fading()
 }
 
+// SetMinCount set the minimal count for a key to become hotkey, without losing the history.
+// It returns ErrInvalidMinCount if minCount is out of range, as NewHotkey does.
+func (h *HotKeyWithCache) SetMinCount(minCount int) error {
+	if minCount < 0 || uint64(minCount) > math.MaxUint32 {
+		return fmt.Errorf("%w: %d is out of range", ErrInvalidMinCount, minCount)
+	}
+	if h.topk == nil {
+		return nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.version.Add(1)
+	h.topk.SetMinCount(uint32(minCount))
+	return nil
+}
+
 func (h *HotKeyWithCache) List() []topk.Item {
 	if h.topk == nil {
 		return nil
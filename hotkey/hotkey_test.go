@@ -1,8 +1,12 @@
 package hotkey
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -238,3 +242,237 @@ func testHotkeyHit(t *testing.T) {
 		}
 	}
 }
+
+func TestHotkeySetMinCount(t *testing.T) {
+	option := &Option{
+		HotKeyCnt:     100,
+		LocalCacheCap: 100,
+		AutoCache:     true,
+		TTL:           1000 * time.Millisecond,
+		MinCount:      10,
+	}
+
+	h, err := NewHotkey(option)
+	if err != nil {
+		t.Fatalf("new hot key failed,err:=%v", err)
+	}
+	assert.False(t, h.Add("1", 5))
+	assert.NoError(t, h.SetMinCount(5))
+	assert.True(t, h.Add("1", 1))
+	// a negative min count is rejected instead of wrapping around
+	assert.ErrorIs(t, h.SetMinCount(-1), ErrInvalidMinCount)
+	assert.True(t, h.Add("1", 1))
+}
+
+func TestHotkeyGetOrLoad(t *testing.T) {
+	option := &Option{
+		HotKeyCnt:     100,
+		LocalCacheCap: 100,
+		AutoCache:     true,
+		TTL:           1000 * time.Millisecond,
+		MinCount:      2,
+	}
+
+	h, err := NewHotkey(option)
+	if err != nil {
+		t.Fatalf("new hot key failed,err:=%v", err)
+	}
+	var loads int32
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return "value", nil
+	}
+	for i := 0; i < 3; i++ {
+		value, err := h.GetOrLoad(context.Background(), "1", load)
+		assert.NoError(t, err)
+		assert.Equal(t, "value", value)
+	}
+	// the key becomes hot on the second load, and is then cached
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+	assert.Equal(t, "value", h.Get("1"))
+}
+
+func TestNewHotkeyValidate(t *testing.T) {
+	key := func(value string) *CacheRuleConfig { return &CacheRuleConfig{Mode: "key", Value: value} }
+	tests := []struct {
+		option *Option
+		err    error
+		rule   *ErrBadRule
+	}{
+		{nil, ErrNilOption, nil},
+		{&Option{HotKeyCnt: 10, TTL: -time.Second}, ErrInvalidTTL, nil},
+		{&Option{HotKeyCnt: -1}, ErrInvalidHotKeyCnt, nil},
+		{&Option{AutoCache: true, LocalCacheCap: 10}, ErrInvalidHotKeyCnt, nil},
+		{&Option{HotKeyCnt: 10, MinCount: -1}, ErrInvalidMinCount, nil},
+		{&Option{HotKeyCnt: 10, AutoCache: true}, ErrInvalidCapacity, nil},
+		{&Option{LocalCacheCap: 10, WhileList: []*CacheRuleConfig{key("1"), {Mode: "prefix", Value: "1"}}},
+			ErrInvalidRule, &ErrBadRule{List: "whitelist", Index: 1}},
+		{&Option{LocalCacheCap: 10, WhileList: []*CacheRuleConfig{{Mode: "key", Value: "1", TTL: -1}}},
+			ErrInvalidTTL, &ErrBadRule{List: "whitelist", Index: 0}},
+		{&Option{BlackList: []*CacheRuleConfig{{Mode: "pattern", Value: "("}}},
+			ErrInvalidRule, &ErrBadRule{List: "blacklist", Index: 0}},
+		{&Option{LocalCacheCap: 10, WhileList: []*CacheRuleConfig{key("1")}, BlackList: []*CacheRuleConfig{key("2"), key("1")}},
+			ErrConflictRule, &ErrBadRule{List: "blacklist", Index: 1}},
+	}
+	for i, test := range tests {
+		_, err := NewHotkey(test.option)
+		assert.ErrorIs(t, err, test.err, i)
+		var rule *ErrBadRule
+		if assert.Equal(t, test.rule != nil, errors.As(err, &rule), i) && test.rule != nil {
+			assert.Equal(t, test.rule.List, rule.List, i)
+			assert.Equal(t, test.rule.Index, rule.Index, i)
+		}
+	}
+	_, err := NewHotkey(&Option{HotKeyCnt: 10, LocalCacheCap: 10, AutoCache: true, BlackList: []*CacheRuleConfig{key("1")}})
+	assert.NoError(t, err)
+}
+
+func TestTTLFunc(t *testing.T) {
+	type versioned struct {
+		value    string
+		expireAt time.Time
+	}
+	option := &Option{
+		HotKeyCnt:     10,
+		LocalCacheCap: 10,
+		AutoCache:     true,
+		TTL:           time.Minute,
+		WhileList:     []*CacheRuleConfig{{Mode: "key", Value: "rule", TTL: time.Minute}},
+		TTLFunc: func(key string, value interface{}) time.Duration {
+			if v, ok := value.(versioned); ok {
+				return time.Until(v.expireAt)
+			}
+			return 0
+		},
+	}
+	h, err := NewHotkey(option)
+	assert.NoError(t, err)
+
+	expireAt := time.Now().Add(50 * time.Millisecond)
+	for _, key := range []string{"auto", "rule"} {
+		h.AddWithValue(key, versioned{value: key, expireAt: expireAt}, 1)
+		assert.NotNil(t, h.Get(key), key)
+	}
+	// values without an embedded expiry keep the static ttl
+	h.AddWithValue("static", "static", 1)
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, h.Get("auto"))
+	assert.Nil(t, h.Get("rule"))
+	assert.Equal(t, "static", h.Get("static"))
+
+	// values which have already expired are not cached, and drop the cached value
+	expired := versioned{value: "expired", expireAt: time.Now().Add(-time.Second)}
+	for _, key := range []string{"auto", "rule", "static"} {
+		h.AddWithValue(key, expired, 1)
+		assert.Nil(t, h.Get(key), key)
+	}
+}
+
+func TestSnapshotView(t *testing.T) {
+	h, err := NewHotkey(&Option{
+		HotKeyCnt:     10,
+		LocalCacheCap: 10,
+		AutoCache:     true,
+		TTL:           time.Minute,
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		h.AddWithValue("a", "a", 2)
+		h.AddWithValue("b", "b", 1)
+	}
+	s := h.SnapshotView()
+	// the snapshot is not affected by the changes of the hotkey
+	h.Del("a")
+	h.AddWithValue("c", "c", 10)
+	assert.Equal(t, "a", s.Get("a"))
+	assert.Nil(t, s.Get("c"))
+	assert.Equal(t, 2, s.Len())
+	count, ok := s.Hot("a")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(6), count)
+	_, ok = s.Hot("c")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"a", "b"}, func() []string {
+		var keys []string
+		for _, item := range s.List() {
+			keys = append(keys, item.Key)
+		}
+		return keys
+	}())
+	keys := make(map[string]interface{})
+	s.Range(func(key string, value interface{}) bool {
+		keys[key] = value
+		return true
+	})
+	assert.Equal(t, map[string]interface{}{"a": "a", "b": "b"}, keys)
+	assert.Nil(t, h.Get("a"))
+	assert.Equal(t, "c", h.SnapshotView().Get("c"))
+}
+
+func TestSnapshotViewPublished(t *testing.T) {
+	h, err := NewHotkey(&Option{
+		HotKeyCnt:        10,
+		LocalCacheCap:    10,
+		AutoCache:        true,
+		TTL:              time.Minute,
+		SnapshotInterval: 50 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	h.AddWithValue("a", "a", 1)
+	s := h.SnapshotView()
+	// the snapshot is reused while the hotkey is unchanged
+	assert.Same(t, s, h.SnapshotView())
+	// and after a write until it's older than the interval
+	h.AddWithValue("b", "b", 1)
+	assert.Same(t, s, h.SnapshotView())
+	assert.Nil(t, h.SnapshotView().Get("b"))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "b", h.SnapshotView().Get("b"))
+
+	// an expired value refreshes the snapshot without a write
+	h, err = NewHotkey(&Option{
+		HotKeyCnt:        10,
+		LocalCacheCap:    10,
+		AutoCache:        true,
+		TTL:              20 * time.Millisecond,
+		SnapshotInterval: time.Hour,
+	})
+	assert.NoError(t, err)
+	h.AddWithValue("a", "a", 1)
+	assert.Equal(t, "a", h.SnapshotView().Get("a"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Nil(t, h.SnapshotView().Get("a"))
+}
+
+func TestChaos(t *testing.T) {
+	h, err := NewHotkey(&Option{
+		HotKeyCnt:     10,
+		LocalCacheCap: 10,
+		AutoCache:     true,
+		TTL:           time.Minute,
+		Chaos:         &Chaos{MissRate: 1},
+	})
+	assert.NoError(t, err)
+	h.AddWithValue("a", "a", 1)
+	assert.Nil(t, h.Get("a"))
+
+	assert.NoError(t, h.SetChaos(&Chaos{DropRate: 1, DelayRate: 1, Delay: 20 * time.Millisecond}))
+	start := time.Now()
+	assert.Equal(t, "a", h.Get("a"))
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	h.Del("a")
+	assert.Equal(t, "a", h.Get("a"))
+
+	// the rates are drawn from Rand
+	assert.NoError(t, h.SetChaos(&Chaos{MissRate: 0.5, Rand: func() float64 { return 0.5 }}))
+	assert.Equal(t, "a", h.Get("a"))
+	assert.NoError(t, h.SetChaos(nil))
+	h.Del("a")
+	assert.Nil(t, h.Get("a"))
+
+	assert.ErrorIs(t, h.SetChaos(&Chaos{MissRate: 2}), ErrInvalidChaos)
+	assert.ErrorIs(t, h.SetChaos(&Chaos{DelayRate: math.NaN()}), ErrInvalidChaos)
+	assert.ErrorIs(t, h.SetChaos(&Chaos{Delay: -1}), ErrInvalidChaos)
+	_, err = NewHotkey(&Option{HotKeyCnt: 10, Chaos: &Chaos{DropRate: -1}})
+	assert.ErrorIs(t, err, ErrInvalidChaos)
+}
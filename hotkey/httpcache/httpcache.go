@@ -0,0 +1,341 @@
+// Package httpcache is a net/http middleware caching the responses of hot URLs
+// in the local cache of a hotkey. The URLs of GET requests are normalized and
+// counted into the hotkey, and the responses of hot URLs are served from the
+// local cache, so a celebrity page can't overload the handlers behind it.
+package httpcache
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/hotkey"
+)
+
+// maxVariants is the maximum number of variants cached per URL.
+const maxVariants = 16
+
+// Option is middleware option function.
+type Option func(*options)
+
+// options of middleware.
+type options struct {
+	ttl     time.Duration
+	maxBody int
+	key     func(r *http.Request) string
+	ignored map[string]bool
+	status  map[int]bool
+	cookies bool
+}
+
+// WithTTL with the ttl of the cached responses, it's bounded by the ttl of the
+// hotkey and by the max-age of the response, default 0 uses the ttl of the
+// hotkey only.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithMaxBodySize with the maximum size of a cached body, default 1MB.
+func WithMaxBodySize(size int) Option {
+	return func(o *options) {
+		o.maxBody = size
+	}
+}
+
+// WithKeyFunc with the cache key of a request, default the normalized URL.
+func WithKeyFunc(fn func(r *http.Request) string) Option {
+	return func(o *options) {
+		o.key = fn
+	}
+}
+
+// WithIgnoredParams with the query parameters dropped from the default key,
+// such as tracking parameters.
+func WithIgnoredParams(params ...string) Option {
+	return func(o *options) {
+		o.ignored = make(map[string]bool, len(params))
+		for _, p := range params {
+			o.ignored[p] = true
+		}
+	}
+}
+
+// WithStatus with the cacheable status codes, default 200.
+func WithStatus(codes ...int) Option {
+	return func(o *options) {
+		o.status = make(map[int]bool, len(codes))
+		for _, code := range codes {
+			o.status[code] = true
+		}
+	}
+}
+
+// WithCookies caches the responses of requests carrying cookies, default these
+// requests pass through as their responses may be personalized. Only use it if
+// the responses don't depend on the cookies, or if they are marked private.
+func WithCookies() Option {
+	return func(o *options) {
+		o.cookies = true
+	}
+}
+
+// response is a cached response.
+type response struct {
+	code     int
+	header   http.Header
+	body     []byte
+	expireAt time.Time
+}
+
+// entry is the cached responses of a URL, one per variant of the request
+// headers named by the Vary header of the responses.
+type entry struct {
+	vary     []string
+	mu       sync.RWMutex
+	variants map[string]*response
+}
+
+func (e *entry) get(variant string, now time.Time) *response {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	resp, ok := e.variants[variant]
+	if !ok || !resp.expireAt.IsZero() && !now.Before(resp.expireAt) {
+		return nil
+	}
+	return resp
+}
+
+func (e *entry) set(variant string, resp *response) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.variants[variant]; !ok && len(e.variants) >= maxVariants {
+		return
+	}
+	e.variants[variant] = resp
+}
+
+// Middleware returns the net/http middleware caching the responses of hot
+// URLs in h, which must have a local cache by AutoCache or whitelist rules
+// matching the keys.
+func Middleware(h *hotkey.HotKeyWithCache, opts ...Option) func(http.Handler) http.Handler {
+	opt := options{
+		maxBody: 1 << 20,
+		status:  map[int]bool{http.StatusOK: true},
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.key == nil {
+		opt.key = func(r *http.Request) string {
+			return Key(r, opt.ignored)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || r.Header.Get("Authorization") != "" || noCache(r.Header) ||
+				!opt.cookies && r.Header.Get("Cookie") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := opt.key(r)
+			e, _ := h.Get(key).(*entry)
+			if e != nil {
+				if resp := e.get(variant(r, e.vary), time.Now()); resp != nil {
+					h.Add(key, 1)
+					serve(w, resp)
+					return
+				}
+			}
+			rw := &recorder{ResponseWriter: w, max: opt.maxBody}
+			next.ServeHTTP(rw, r)
+			resp, vary, ok := rw.response(&opt)
+			if !ok {
+				h.Add(key, 1)
+				return
+			}
+			if e == nil || !equal(e.vary, vary) {
+				e = &entry{vary: vary, variants: make(map[string]*response, 1)}
+			}
+			e.set(variant(r, vary), resp)
+			h.AddWithValue(key, e, 1)
+		})
+	}
+}
+
+func serve(w http.ResponseWriter, resp *response) {
+	header := w.Header()
+	for k, v := range resp.header {
+		header[k] = append([]string(nil), v...)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(resp.body)))
+	w.WriteHeader(resp.code)
+	w.Write(resp.body)
+}
+
+// recorder writes the response through and records it while it's cacheable.
+type recorder struct {
+	http.ResponseWriter
+	code     int
+	body     bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (w *recorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if !w.overflow {
+		if w.body.Len()+len(b) > w.max {
+			w.overflow = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *recorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// response returns the recorded response and the names of its Vary header, ok
+// is false if it's not cacheable: the status is not cacheable, the body is too
+// large, it sets cookies, or is private or no-store.
+func (w *recorder) response(opt *options) (*response, []string, bool) {
+	code := w.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	header := w.Header()
+	if !opt.status[code] || w.overflow || header.Get("Set-Cookie") != "" {
+		return nil, nil, false
+	}
+	ttl := opt.ttl
+	for _, directive := range directives(header) {
+		name, value, _ := strings.Cut(directive, "=")
+		switch name {
+		case "no-store", "no-cache", "private":
+			return nil, nil, false
+		case "max-age", "s-maxage":
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			if seconds <= 0 {
+				return nil, nil, false
+			}
+			if age := time.Duration(seconds) * time.Second; ttl == 0 || age < ttl {
+				ttl = age
+			}
+		}
+	}
+	var vary []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, nil, false
+			}
+			if name != "" {
+				vary = append(vary, name)
+			}
+		}
+	}
+	sort.Strings(vary)
+	resp := &response{code: code, header: header.Clone(), body: w.body.Bytes()}
+	resp.header.Del("Content-Length")
+	if ttl > 0 {
+		resp.expireAt = time.Now().Add(ttl)
+	}
+	return resp, vary, true
+}
+
+func noCache(header http.Header) bool {
+	for _, directive := range directives(header) {
+		if directive == "no-cache" || directive == "no-store" {
+			return true
+		}
+	}
+	return false
+}
+
+func directives(header http.Header) []string {
+	var list []string
+	for _, v := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			if directive = strings.ToLower(strings.TrimSpace(directive)); directive != "" {
+				list = append(list, directive)
+			}
+		}
+	}
+	return list
+}
+
+// variant returns the values of the request headers named by vary.
+func variant(r *http.Request, vary []string) string {
+	if len(vary) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, name := range vary {
+		b.WriteString(name)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Key returns the normalized URL of the request: the lower-case host, the
+// cleaned path and the sorted query without the ignored parameters.
+func Key(r *http.Request, ignored map[string]bool) string {
+	p := r.URL.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	if cleaned := path.Clean(p); cleaned != "/" && strings.HasSuffix(p, "/") {
+		p = cleaned + "/"
+	} else {
+		p = cleaned
+	}
+	query := r.URL.Query()
+	for name := range query {
+		if ignored[name] {
+			delete(query, name)
+		}
+	}
+	key := "http:" + strings.ToLower(r.Host) + p
+	if len(query) > 0 {
+		key += "?" + query.Encode()
+	}
+	return key
+}
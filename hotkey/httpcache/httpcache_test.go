@@ -0,0 +1,186 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/hotkey"
+)
+
+// newWhitelist returns a hotkey caching the keys matching pattern from the
+// first response, without waiting for them to become hot.
+func newWhitelist(t *testing.T, pattern string) *hotkey.HotKeyWithCache {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		LocalCacheCap: 10,
+		TTL:           time.Minute,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "pattern", Value: pattern}},
+	})
+	assert.NoError(t, err)
+	return h
+}
+
+// counter returns a handler calling fn and writing the number of its calls.
+func counter(fn func(w http.ResponseWriter, r *http.Request)) (http.HandlerFunc, *int) {
+	calls := new(int)
+	return func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		fn(w, r)
+		w.Write([]byte(strconv.Itoa(*calls)))
+	}, calls
+}
+
+func get(handler http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		HotKeyCnt:     10,
+		LocalCacheCap: 10,
+		AutoCache:     true,
+		TTL:           time.Minute,
+		MinCount:      3,
+	})
+	assert.NoError(t, err)
+	next, calls := counter(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+	})
+	handler := Middleware(h, WithIgnoredParams("utm_source"))(next)
+	// the spellings of the URL count the same key, whose response is cached
+	// once the count reaches MinCount
+	for i, target := range []string{
+		"http://example.com/page?b=2&a=1",
+		"http://EXAMPLE.com//page?a=1&b=2&utm_source=x",
+		"http://example.com/x/../page?a=1&b=2",
+		"http://example.com/page?b=2&a=1&utm_source=y",
+	} {
+		w := get(handler, target)
+		assert.Equal(t, http.StatusOK, w.Code, target)
+		assert.Equal(t, []string{"1", "2", "3", "3"}[i], w.Body.String(), target)
+		assert.Equal(t, "text/plain", w.Header().Get("Content-Type"), target)
+	}
+	assert.Equal(t, "1", get(handler, "http://example.com/page?a=1&b=2").Header().Get("Content-Length"))
+
+	// requests which must not be served from a shared cache pass through
+	assert.Equal(t, "4", get(handler, "http://example.com/page?a=1&b=2", "Authorization", "token").Body.String())
+	assert.Equal(t, "5", get(handler, "http://example.com/page?a=1&b=2", "Cache-Control", "no-cache").Body.String())
+	assert.Equal(t, "6", get(handler, "http://example.com/page?a=1&b=2", "Cookie", "session=1").Body.String())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "http://example.com/page?a=1&b=2", nil))
+	assert.Equal(t, "7", w.Body.String())
+	assert.Equal(t, 7, *calls)
+}
+
+func TestMiddlewareCookies(t *testing.T) {
+	next, calls := counter(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Middleware(newWhitelist(t, "^http:"))(next)
+	// the responses to requests with cookies may be personalized
+	for i := 0; i < 3; i++ {
+		get(handler, "/page", "Cookie", "session="+strconv.Itoa(i))
+	}
+	assert.Equal(t, 3, *calls)
+
+	next, calls = counter(func(w http.ResponseWriter, r *http.Request) {})
+	handler = Middleware(newWhitelist(t, "^http:"), WithCookies())(next)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "1", get(handler, "/page", "Cookie", "session="+strconv.Itoa(i)).Body.String())
+	}
+	assert.Equal(t, 1, *calls)
+}
+
+func TestMiddlewareUncacheable(t *testing.T) {
+	for name, fn := range map[string]func(w http.ResponseWriter, r *http.Request){
+		"private":  func(w http.ResponseWriter, r *http.Request) { w.Header().Set("Cache-Control", "private") },
+		"no-store": func(w http.ResponseWriter, r *http.Request) { w.Header().Set("Cache-Control", "no-store") },
+		"max-age":  func(w http.ResponseWriter, r *http.Request) { w.Header().Set("Cache-Control", "max-age=0") },
+		"vary":     func(w http.ResponseWriter, r *http.Request) { w.Header().Set("Vary", "*") },
+		"cookie": func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+		},
+		"error": func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+	} {
+		next, calls := counter(fn)
+		handler := Middleware(newWhitelist(t, "^http:"))(next)
+		for i := 0; i < 3; i++ {
+			get(handler, "/page")
+		}
+		assert.Equal(t, 3, *calls, name)
+	}
+
+	// the body is not cached beyond the max size, but is written in full
+	next, calls := counter(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Middleware(newWhitelist(t, "^http:"), WithMaxBodySize(0))(next)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, strconv.Itoa(i+1), get(handler, "/page").Body.String())
+	}
+	assert.Equal(t, 3, *calls)
+}
+
+func TestMiddlewareStatus(t *testing.T) {
+	next, calls := counter(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler := Middleware(newWhitelist(t, "^http:"), WithStatus(http.StatusNotFound))(next)
+	for i := 0; i < 3; i++ {
+		w := get(handler, "/missing")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, "1", w.Body.String())
+	}
+	assert.Equal(t, 1, *calls)
+}
+
+func TestMiddlewareVary(t *testing.T) {
+	calls := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Write([]byte(r.Header.Get("Accept-Encoding")))
+	})
+	handler := Middleware(newWhitelist(t, "^http:"))(next)
+	// each variant of the URL is cached on its first response
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "gzip", get(handler, "/vary", "Accept-Encoding", "gzip").Body.String())
+		assert.Equal(t, "br", get(handler, "/vary", "Accept-Encoding", "br").Body.String())
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestMiddlewareTTL(t *testing.T) {
+	next, calls := counter(func(w http.ResponseWriter, r *http.Request) {})
+	handler := Middleware(newWhitelist(t, "^http:"), WithTTL(50*time.Millisecond))(next)
+	for i := 0; i < 3; i++ {
+		get(handler, "/page")
+	}
+	assert.Equal(t, 1, *calls)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, "2", get(handler, "/page").Body.String())
+
+	// the max-age of the response bounds the ttl
+	next, calls = counter(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=1")
+	})
+	handler = Middleware(newWhitelist(t, "^http:"), WithTTL(time.Minute))(next)
+	get(handler, "/short")
+	get(handler, "/short")
+	assert.Equal(t, 1, *calls)
+	time.Sleep(time.Second)
+	get(handler, "/short")
+	assert.Equal(t, 2, *calls)
+}
+
+func TestKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://Example.com/a/../b/?z=1&a=2&utm=3", nil)
+	assert.Equal(t, "http:example.com/b/?a=2&z=1", Key(r, map[string]bool{"utm": true}))
+	r = httptest.NewRequest(http.MethodGet, "http://example.com", nil)
+	assert.Equal(t, "http:example.com/", Key(r, nil))
+}
@@ -0,0 +1,229 @@
+// Package shed closes the loop from hot-key detection to mitigation. Keys
+// which are hot beyond an extreme QPS and can't be served from the local cache,
+// because the requests are writes or the keys are blacklisted, are rate limited
+// per key or rejected, so a single key can't overload the backend.
+package shed
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/ratelimit/gcra"
+	"github.com/zychimne/aegis/window"
+)
+
+// ErrHotKey is returned, wrapped by HotKeyError, when the request of a hot key
+// is rejected.
+var ErrHotKey = errors.New("shed: hot key rejected")
+
+// Action is the mitigation applied to hot keys.
+type Action int
+
+const (
+	// ActionLimit rate limits the requests of a hot key.
+	ActionLimit Action = iota
+	// ActionReject rejects all requests of a hot key.
+	ActionReject
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionLimit:
+		return "limit"
+	case ActionReject:
+		return "reject"
+	}
+	return "unknown"
+}
+
+// HotKeyError is the error of a rejected request of a hot key, it matches
+// ErrHotKey, and ratelimit.ErrLimitExceed if the key is rate limited.
+type HotKeyError struct {
+	Key    string
+	QPS    float64
+	Action Action
+}
+
+func (e *HotKeyError) Error() string {
+	return fmt.Sprintf("shed: hot key %s rejected by %s at %.0f qps", e.Key, e.Action, e.QPS)
+}
+
+// Is reports whether target is ErrHotKey, or ratelimit.ErrLimitExceed if limited.
+func (e *HotKeyError) Is(target error) bool {
+	return target == ErrHotKey || e.Action == ActionLimit && target == ratelimit.ErrLimitExceed
+}
+
+// Option is shedder option function.
+type Option func(*options)
+
+// options of shedder.
+type options struct {
+	threshold float64
+	action    Action
+	limit     int
+	window    time.Duration
+	bucket    int
+}
+
+// WithThreshold with the QPS of a hot key above which it is mitigated, default 1000.
+func WithThreshold(qps float64) Option {
+	return func(o *options) {
+		o.threshold = qps
+	}
+}
+
+// WithAction with the mitigation of hot keys, default ActionLimit.
+func WithAction(a Action) Option {
+	return func(o *options) {
+		o.action = a
+	}
+}
+
+// WithLimit with the QPS a mitigated key is limited to, default 100.
+func WithLimit(qps int) Option {
+	return func(o *options) {
+		o.limit = qps
+	}
+}
+
+// WithWindow with the rolling window measuring the QPS of hot keys, default 1s.
+func WithWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.window = d
+	}
+}
+
+// WithBucket with the buckets of the rolling window, default 10.
+func WithBucket(b int) Option {
+	return func(o *options) {
+		o.bucket = b
+	}
+}
+
+// Stat is the state of a hot key whose QPS is measured.
+type Stat struct {
+	Key       string
+	QPS       float64
+	Mitigated bool
+}
+
+type counter struct {
+	window.RollingCounter
+	mitigated bool
+}
+
+// Shedder mitigates the hot keys detected by a hot-key counter.
+type Shedder struct {
+	mu       sync.RWMutex
+	hotkey   *hotkey.HotKeyWithCache
+	limiter  *gcra.Limiter
+	counters map[string]*counter
+	sweepAt  time.Time
+	opts     options
+}
+
+// New returns a shedder mitigating the hot keys of h. The requests are counted
+// into h by Allow, they must not be counted again by the caller. There is at
+// least 1 bucket and the buckets last at least 1ns.
+func New(h *hotkey.HotKeyWithCache, opts ...Option) *Shedder {
+	opt := options{
+		threshold: 1000,
+		action:    ActionLimit,
+		limit:     100,
+		window:    time.Second,
+		bucket:    10,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.bucket < 1 {
+		opt.bucket = 1
+	}
+	if opt.window < time.Duration(opt.bucket) {
+		opt.window = time.Duration(opt.bucket)
+	}
+	return &Shedder{
+		hotkey:   h,
+		limiter:  gcra.NewLimiter(opt.limit, time.Second, gcra.WithBurst(opt.limit)),
+		counters: make(map[string]*counter),
+		opts:     opt,
+	}
+}
+
+// Allow counts a request of key, write reports whether it is a write which
+// can't be served from the cache. It returns a HotKeyError if the key is
+// mitigated and the request is rejected.
+func (s *Shedder) Allow(key string, write bool) error {
+	if !s.hotkey.Add(key, 1) {
+		s.forget(key)
+		return nil
+	}
+	s.mu.Lock()
+	s.sweepIdle(time.Now())
+	c, ok := s.counters[key]
+	if !ok {
+		c = &counter{RollingCounter: window.NewRollingCounter(window.RollingCounterOpts{
+			Size:           s.opts.bucket,
+			BucketDuration: s.opts.window / time.Duration(s.opts.bucket),
+		})}
+		s.counters[key] = c
+	}
+	c.Add(1)
+	qps := c.Sum() / s.opts.window.Seconds()
+	c.mitigated = qps > s.opts.threshold && (write || !s.hotkey.Cacheable(key))
+	mitigated := c.mitigated
+	s.mu.Unlock()
+	if !mitigated {
+		return nil
+	}
+	if s.opts.action == ActionLimit && s.limiter.AllowKey(key).Allowed {
+		return nil
+	}
+	return &HotKeyError{Key: key, QPS: qps, Action: s.opts.action}
+}
+
+// forget stops measuring the QPS of a key which is no longer hot, it only
+// takes the write lock if the key is measured.
+func (s *Shedder) forget(key string) {
+	s.mu.RLock()
+	_, ok := s.counters[key]
+	s.mu.RUnlock()
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+}
+
+// sweepIdle evicts the keys without requests within the window, every 10 windows.
+func (s *Shedder) sweepIdle(now time.Time) {
+	if now.Before(s.sweepAt) {
+		return
+	}
+	s.sweepAt = now.Add(10 * s.opts.window)
+	for key, c := range s.counters {
+		if c.Sum() == 0 {
+			delete(s.counters, key)
+		}
+	}
+}
+
+// Stats returns the state of the hot keys whose QPS is measured.
+func (s *Shedder) Stats() []Stat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]Stat, 0, len(s.counters))
+	for key, c := range s.counters {
+		stats = append(stats, Stat{
+			Key:       key,
+			QPS:       c.Sum() / s.opts.window.Seconds(),
+			Mitigated: c.mitigated,
+		})
+	}
+	return stats
+}
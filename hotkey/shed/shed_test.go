@@ -0,0 +1,75 @@
+package shed
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+func newHotkey(t *testing.T) *hotkey.HotKeyWithCache {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		HotKeyCnt:     10,
+		LocalCacheCap: 10,
+		AutoCache:     true,
+		MinCount:      5,
+		BlackList:     []*hotkey.CacheRuleConfig{{Mode: "key", Value: "black"}},
+	})
+	assert.NoError(t, err)
+	return h
+}
+
+func TestShedLimit(t *testing.T) {
+	s := New(newHotkey(t), WithThreshold(10), WithLimit(5))
+	var rejected int
+	for i := 0; i < 100; i++ {
+		// cacheable reads are never mitigated
+		assert.NoError(t, s.Allow("read", false))
+		if err := s.Allow("write", true); err != nil {
+			rejected++
+			assert.True(t, errors.Is(err, ErrHotKey))
+			assert.True(t, errors.Is(err, ratelimit.ErrLimitExceed))
+			var hotErr *HotKeyError
+			assert.True(t, errors.As(err, &hotErr))
+			assert.Equal(t, "write", hotErr.Key)
+		}
+	}
+	// 4 requests before the key is hot, 10 below the threshold and 5 of the limit are allowed
+	assert.Equal(t, 81, rejected)
+	for _, stat := range s.Stats() {
+		assert.Equal(t, stat.Key == "write", stat.Mitigated)
+	}
+}
+
+func TestShedReject(t *testing.T) {
+	s := New(newHotkey(t), WithThreshold(10), WithAction(ActionReject))
+	var rejected int
+	for i := 0; i < 100; i++ {
+		if err := s.Allow("black", false); err != nil {
+			rejected++
+			assert.False(t, errors.Is(err, ratelimit.ErrLimitExceed))
+		}
+	}
+	assert.Equal(t, 86, rejected)
+	// keys not hot pass through
+	assert.NoError(t, s.Allow("cold", true))
+}
+
+func TestShedInvalidWindow(t *testing.T) {
+	s := New(newHotkey(t), WithThreshold(10), WithAction(ActionReject), WithBucket(0))
+	var rejected int
+	for i := 0; i < 100; i++ {
+		if s.Allow("black", false) != nil {
+			rejected++
+		}
+	}
+	assert.Equal(t, 86, rejected)
+	// a window shorter than its buckets is clamped to 1ns per bucket
+	s = New(newHotkey(t), WithWindow(5))
+	for i := 0; i < 10; i++ {
+		s.Allow("black", false)
+	}
+	assert.Len(t, s.Stats(), 1)
+}
@@ -0,0 +1,117 @@
+package hotkey
+
+import (
+	"time"
+
+	"github.com/zychimne/aegis/topk"
+)
+
+// Snapshot is an immutable view of the cached values and the hot keys of a
+// hotkey at a point in time. It's safe for concurrent use without locking, so
+// it gives a request a consistent view for its duration, and read-heavy
+// callers a view free of the locks of the live hotkey. The values are shared
+// with the cache, they must not be modified.
+type Snapshot struct {
+	takenAt time.Time
+	version uint64
+	// expireAt is the earliest expiration of the values, zero if none expire.
+	expireAt time.Time
+	values   map[string]interface{}
+	hot      []topk.Item
+	counts   map[string]uint32
+}
+
+// SnapshotView returns the published snapshot of the unexpired cached values
+// and the hot keys. Loading it is lock-free, it's rebuilt by copying the cache
+// and the topk when a value expires or, after a write, once it's older than
+// SnapshotInterval. Views during a rebuild return the previous snapshot.
+func (h *HotKeyWithCache) SnapshotView() *Snapshot {
+	s := h.snapshot.Load()
+	if s != nil && !h.stale(s) {
+		return s
+	}
+	if !h.refreshing.CompareAndSwap(false, true) {
+		if s != nil {
+			return s
+		}
+		// the first snapshot is being taken, wait for it.
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		return h.takeSnapshot()
+	}
+	defer h.refreshing.Store(false)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s = h.takeSnapshot()
+	h.snapshot.Store(s)
+	return s
+}
+
+func (h *HotKeyWithCache) stale(s *Snapshot) bool {
+	now := time.Now()
+	if !s.expireAt.IsZero() && !now.Before(s.expireAt) {
+		return true
+	}
+	return s.version != h.version.Load() && now.Sub(s.takenAt) >= h.option.SnapshotInterval
+}
+
+// takeSnapshot copies the cache and the topk, h.mutex must be held.
+func (h *HotKeyWithCache) takeSnapshot() *Snapshot {
+	s := &Snapshot{takenAt: time.Now(), version: h.version.Load()}
+	if h.localCache != nil {
+		items := h.localCache.Items()
+		s.values = make(map[string]interface{}, len(items))
+		for key, item := range items {
+			if item.IsExpired() {
+				continue
+			}
+			s.values[key] = item.Value()
+			if expireAt := item.ExpiresAt(); !expireAt.IsZero() && (s.expireAt.IsZero() || expireAt.Before(s.expireAt)) {
+				s.expireAt = expireAt
+			}
+		}
+	}
+	if h.topk != nil {
+		s.hot = h.topk.List()
+		s.counts = make(map[string]uint32, len(s.hot))
+		for _, item := range s.hot {
+			s.counts[item.Key] = item.Count
+		}
+	}
+	return s
+}
+
+// TakenAt returns the time the snapshot is taken.
+func (s *Snapshot) TakenAt() time.Time {
+	return s.takenAt
+}
+
+// Get returns the cached value of key, or nil if it's not cached.
+func (s *Snapshot) Get(key string) interface{} {
+	return s.values[key]
+}
+
+// Len returns the number of cached values.
+func (s *Snapshot) Len() int {
+	return len(s.values)
+}
+
+// Range calls fn for each cached value until it returns false.
+func (s *Snapshot) Range(fn func(key string, value interface{}) bool) {
+	for key, value := range s.values {
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// List returns the hot keys ordered by count, the slice is a copy.
+func (s *Snapshot) List() []topk.Item {
+	return append([]topk.Item(nil), s.hot...)
+}
+
+// Hot returns the count of key and true if it's a hot key.
+func (s *Snapshot) Hot(key string) (uint32, bool) {
+	count, ok := s.counts[key]
+	return count, ok
+}
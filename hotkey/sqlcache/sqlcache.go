@@ -0,0 +1,412 @@
+// Package sqlcache caches the scanned results of hot database/sql queries in
+// the local cache of a hotkey. Queries are keyed by the normalized query and
+// its arguments, so the result of a query becomes cached once the key is hot,
+// and is invalidated by the writes to its tables through Exec, or explicitly.
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/singleflight"
+)
+
+// Queryer is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// Execer is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Preparer is implemented by *sql.DB, *sql.Tx and *sql.Conn.
+type Preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// Option is cache option function.
+type Option func(*options)
+
+// options of cache.
+type options struct {
+	ttl    time.Duration
+	key    func(query string, args []interface{}) string
+	tables func(query string) []string
+}
+
+// WithTTL with the ttl of the cached results, it's bounded by the ttl of the
+// hotkey, default 0 uses the ttl of the hotkey only.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithKeyFunc with the cache key of a query, default the normalized query and
+// the arguments prefixed by "sql:".
+func WithKeyFunc(fn func(query string, args []interface{}) string) Option {
+	return func(o *options) {
+		o.key = fn
+	}
+}
+
+// WithTablesFunc with the tables a query reads or a statement writes, which
+// link the cached results to the writes invalidating them, default the tables
+// following FROM, JOIN, INTO and UPDATE.
+func WithTablesFunc(fn func(query string) []string) Option {
+	return func(o *options) {
+		o.tables = fn
+	}
+}
+
+// entry is a cached result.
+type entry struct {
+	value    interface{}
+	expireAt time.Time
+}
+
+// Cache caches the scanned results of hot queries.
+type Cache struct {
+	hotkey *hotkey.HotKeyWithCache
+	group  *singleflight.Group[string, interface{}]
+	mu     sync.Mutex
+	keys   map[string]map[string]struct{}
+	// indexed is the number of keys, the keys no longer cached are pruned
+	// when it reaches pruneAt.
+	indexed int
+	pruneAt int
+	// gens is the invalidation generation of the tables, a load skips caching
+	// its result if the tables are invalidated while it runs.
+	gens map[string]uint64
+	// written is the tables written by Exec in the transactions, which are
+	// invalidated again by Commit.
+	written map[*sql.Tx][]string
+	opts    options
+}
+
+// New returns a cache of the query results in the local cache of h, which must
+// have a local cache by AutoCache or whitelist rules matching the keys.
+func New(h *hotkey.HotKeyWithCache, opts ...Option) *Cache {
+	opt := options{
+		key:    Key,
+		tables: Tables,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Cache{
+		hotkey:  h,
+		group:   singleflight.New[string, interface{}](),
+		keys:    make(map[string]map[string]struct{}),
+		pruneAt: minPruneAt,
+		gens:    make(map[string]uint64),
+		written: make(map[*sql.Tx][]string),
+		opts:    opt,
+	}
+}
+
+// Stmt is a prepared statement remembering its query for the cache key.
+type Stmt struct {
+	*sql.Stmt
+	query  string
+	cached bool
+}
+
+// Prepare prepares the query on p, the statement is queried by QueryStmt. Only
+// the statements prepared on a *sql.DB are cached.
+func (c *Cache) Prepare(ctx context.Context, p Preparer, query string) (*Stmt, error) {
+	stmt, err := p.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	_, cached := p.(*sql.DB)
+	return &Stmt{Stmt: stmt, query: query, cached: cached}, nil
+}
+
+// Query runs the query on q and scans the rows by scan, unless the result of
+// the query is cached. Concurrent misses of the same query are merged. The
+// cached result is shared by the callers, it must not be modified.
+//
+// Only the queries on a *sql.DB are cached, the queries on a *sql.Tx or a
+// *sql.Conn always run, as they may see uncommitted writes or session state.
+func Query[T any](ctx context.Context, c *Cache, q Queryer, scan func(*sql.Rows) (T, error), query string, args ...interface{}) (T, error) {
+	_, cached := q.(*sql.DB)
+	return load(ctx, c, cached, query, args, scan, func(ctx context.Context) (*sql.Rows, error) {
+		return q.QueryContext(ctx, query, args...)
+	})
+}
+
+// QueryStmt is Query of a prepared statement.
+func QueryStmt[T any](ctx context.Context, c *Cache, stmt *Stmt, scan func(*sql.Rows) (T, error), args ...interface{}) (T, error) {
+	return load(ctx, c, stmt.cached, stmt.query, args, scan, func(ctx context.Context) (*sql.Rows, error) {
+		return stmt.QueryContext(ctx, args...)
+	})
+}
+
+// load returns the cached result of the query, or runs and scans it. A cached
+// result of another type, such as the same query scanned by another function,
+// is a miss.
+func load[T any](ctx context.Context, c *Cache, cached bool, query string, args []interface{}, scan func(*sql.Rows) (T, error), run func(ctx context.Context) (*sql.Rows, error)) (T, error) {
+	fetch := func(ctx context.Context) (T, error) {
+		var zero T
+		rows, err := run(ctx)
+		if err != nil {
+			return zero, err
+		}
+		defer rows.Close()
+		value, err := scan(rows)
+		if err != nil {
+			return zero, err
+		}
+		if err := rows.Err(); err != nil {
+			return zero, err
+		}
+		return value, nil
+	}
+	if !cached {
+		return fetch(ctx)
+	}
+	key := c.opts.key(query, args)
+	if e, ok := c.hotkey.Get(key).(*entry); ok {
+		if value, ok := e.value.(T); ok && (e.expireAt.IsZero() || time.Now().Before(e.expireAt)) {
+			c.hotkey.Add(key, 1)
+			return value, nil
+		}
+		c.hotkey.Del(key)
+	}
+	v, err := c.group.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		tables := c.opts.tables(query)
+		gen := c.generation(tables)
+		value, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.add(key, tables, gen, value)
+		return value, nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	if value, ok := v.(T); ok {
+		return value, nil
+	}
+	// merged with a load of another type
+	return fetch(ctx)
+}
+
+// minPruneAt is the minimum number of keys before the index is pruned.
+const minPruneAt = 1024
+
+// generation returns the sum of the invalidation generations of the tables.
+func (c *Cache) generation(tables []string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var gen uint64
+	for _, table := range tables {
+		gen += c.gens[strings.ToLower(table)]
+	}
+	return gen
+}
+
+// add adds the result loaded at the generation gen of the tables, it's counted
+// but not cached if the tables are invalidated since, as the result may be
+// read before the write.
+func (c *Cache) add(key string, tables []string, gen uint64, value interface{}) {
+	e := &entry{value: value}
+	if c.opts.ttl > 0 {
+		e.expireAt = time.Now().Add(c.opts.ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var cur uint64
+	for _, table := range tables {
+		cur += c.gens[strings.ToLower(table)]
+	}
+	if cur != gen {
+		c.hotkey.Add(key, 1)
+		return
+	}
+	c.hotkey.AddWithValue(key, e, 1)
+	if c.hotkey.Get(key) == nil {
+		return
+	}
+	for _, table := range tables {
+		table = strings.ToLower(table)
+		keys, ok := c.keys[table]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.keys[table] = keys
+		}
+		if _, ok := keys[key]; !ok {
+			keys[key] = struct{}{}
+			c.indexed++
+		}
+	}
+	if c.indexed >= c.pruneAt {
+		c.prune()
+	}
+}
+
+// prune drops the keys evicted from the hotkey from the index, and doubles
+// the size it's pruned at again, c.mu must be held.
+func (c *Cache) prune() {
+	for table, keys := range c.keys {
+		for key := range keys {
+			if c.hotkey.Get(key) == nil {
+				delete(keys, key)
+				c.indexed--
+			}
+		}
+		if len(keys) == 0 {
+			delete(c.keys, table)
+		}
+	}
+	c.pruneAt = 2 * c.indexed
+	if c.pruneAt < minPruneAt {
+		c.pruneAt = minPruneAt
+	}
+}
+
+// Exec runs the statement on e and invalidates the cached results of the
+// tables it writes, whether it succeeds or not. The tables written in a
+// *sql.Tx are invalidated again when it's committed by Commit, as the results
+// cached meanwhile are read before the write is visible.
+func (c *Cache) Exec(ctx context.Context, e Execer, query string, args ...interface{}) (sql.Result, error) {
+	tables := c.opts.tables(query)
+	if tx, ok := e.(*sql.Tx); ok {
+		c.mu.Lock()
+		c.written[tx] = append(c.written[tx], tables...)
+		c.mu.Unlock()
+	}
+	defer c.InvalidateTables(tables...)
+	return e.ExecContext(ctx, query, args...)
+}
+
+// Commit commits tx and invalidates the cached results of the tables written
+// by Exec in it.
+func (c *Cache) Commit(tx *sql.Tx) error {
+	err := tx.Commit()
+	c.InvalidateTables(c.forget(tx)...)
+	return err
+}
+
+// Rollback rolls back tx, the results cached meanwhile are not affected by it.
+func (c *Cache) Rollback(tx *sql.Tx) error {
+	c.forget(tx)
+	return tx.Rollback()
+}
+
+func (c *Cache) forget(tx *sql.Tx) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tables := c.written[tx]
+	delete(c.written, tx)
+	return tables
+}
+
+// Invalidate removes the cached result of a query, the loads of the query
+// running meanwhile are not cached.
+func (c *Cache) Invalidate(query string, args ...interface{}) {
+	key := c.opts.key(query, args)
+	c.mu.Lock()
+	for _, table := range c.opts.tables(query) {
+		c.gens[strings.ToLower(table)]++
+	}
+	c.mu.Unlock()
+	c.hotkey.Del(key)
+	c.group.Forget(key)
+}
+
+// InvalidateTables removes the cached results of the queries of the tables,
+// the names are case-insensitive. The loads of the tables running meanwhile
+// are not cached.
+func (c *Cache) InvalidateTables(tables ...string) {
+	c.mu.Lock()
+	var keys []string
+	for _, table := range tables {
+		table = strings.ToLower(table)
+		c.gens[table]++
+		for key := range c.keys[table] {
+			keys = append(keys, key)
+		}
+		c.indexed -= len(c.keys[table])
+		delete(c.keys, table)
+	}
+	c.mu.Unlock()
+	for _, key := range keys {
+		c.hotkey.Del(key)
+		c.group.Forget(key)
+	}
+}
+
+// Key returns the default cache key of a query, the whitespaces out of quotes
+// are collapsed so formatting doesn't split the counts of a query.
+func Key(query string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString("sql:")
+	b.WriteString(Normalize(query))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "\x00%T:%v", arg, arg)
+	}
+	return b.String()
+}
+
+// Normalize collapses the whitespaces out of quotes of the query and trims the
+// trailing semicolon.
+func Normalize(query string) string {
+	var (
+		b     strings.Builder
+		quote rune
+		space bool
+	)
+	b.Grow(len(query))
+	for _, r := range strings.TrimRight(strings.TrimSpace(query), "; \t\r\n") {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == ' ' || r == '\t' || r == '\r' || r == '\n':
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var tableRegexp = regexp.MustCompile("(?i)\\b(?:from|join|into|update)\\s+[`\"]?([\\w.]+)")
+
+// Tables returns the lower-case tables following FROM, JOIN, INTO and UPDATE.
+func Tables(query string) []string {
+	var tables []string
+	for _, m := range tableRegexp.FindAllStringSubmatch(query, -1) {
+		table := strings.ToLower(m[1])
+		found := false
+		for _, t := range tables {
+			if t == table {
+				found = true
+				break
+			}
+		}
+		if !found {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
@@ -0,0 +1,280 @@
+package sqlcache
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/hotkey"
+)
+
+// testDriver returns a single row of the query and counts the queries, the
+// queries call hook if it's set.
+type testDriver struct {
+	queries int32
+	execs   int32
+	hook    func()
+}
+
+func (d *testDriver) Open(string) (driver.Conn, error) { return &testConn{d: d}, nil }
+
+type testConn struct{ d *testDriver }
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) { return &testStmt{d: c.d}, nil }
+func (c *testConn) Close() error                              { return nil }
+func (c *testConn) Begin() (driver.Tx, error)                 { return c, nil }
+func (c *testConn) Commit() error                             { return nil }
+func (c *testConn) Rollback() error                           { return nil }
+
+type testStmt struct{ d *testDriver }
+
+func (s *testStmt) Close() error  { return nil }
+func (s *testStmt) NumInput() int { return -1 }
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	atomic.AddInt32(&s.d.execs, 1)
+	return driver.RowsAffected(1), nil
+}
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	n := atomic.AddInt32(&s.d.queries, 1)
+	if s.d.hook != nil {
+		s.d.hook()
+	}
+	return &testRows{value: int64(n)}, nil
+}
+
+type testRows struct {
+	value int64
+	done  bool
+}
+
+func (r *testRows) Columns() []string { return []string{"n"} }
+func (r *testRows) Close() error      { return nil }
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
+
+var testDrivers int32
+
+func newTestDB(t *testing.T) (*sql.DB, *testDriver) {
+	d := &testDriver{}
+	name := "sqlcache" + string(rune('a'+atomic.AddInt32(&testDrivers, 1)))
+	sql.Register(name, d)
+	db, err := sql.Open(name, "")
+	assert.NoError(t, err)
+	return db, d
+}
+
+// newWhitelistCache returns a cache whose queries matching pattern are cached
+// from the first query, without waiting for them to become hot.
+func newWhitelistCache(t *testing.T, pattern string, opts ...Option) *Cache {
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		LocalCacheCap: 10,
+		TTL:           time.Minute,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "pattern", Value: pattern}},
+	})
+	assert.NoError(t, err)
+	return New(h, opts...)
+}
+
+func scanInt(rows *sql.Rows) (int64, error) {
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(&n); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func TestQuery(t *testing.T) {
+	db, d := newTestDB(t)
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		HotKeyCnt:     10,
+		LocalCacheCap: 10,
+		AutoCache:     true,
+		TTL:           time.Minute,
+		MinCount:      3,
+	})
+	assert.NoError(t, err)
+	c := New(h)
+	ctx := context.Background()
+	// the formats of a query count the same key, its result is cached once
+	// the count reaches MinCount
+	for i, query := range []string{
+		"SELECT n FROM users WHERE id = ?",
+		"SELECT n\n  FROM users\tWHERE id = ?",
+		"  SELECT n FROM users WHERE id = ?;",
+		"SELECT n FROM users WHERE id = ?",
+	} {
+		n, err := Query(ctx, c, db, scanInt, query, 1)
+		assert.NoError(t, err, query)
+		assert.Equal(t, []int64{1, 2, 3, 3}[i], n, query)
+	}
+	assert.Equal(t, Key("SELECT n FROM users WHERE id = ?", []interface{}{1}), h.List()[0].Key)
+	// the arguments and their types are part of the key
+	assert.NotEqual(t, Key("SELECT ?", []interface{}{1}), Key("SELECT ?", []interface{}{"1"}))
+
+	// writes of the table invalidate the cached results, whatever the case
+	_, err = c.Exec(ctx, db, "UPDATE Users SET name = ? WHERE id = ?", "a", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&d.execs))
+	n, err := Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+
+	c.Invalidate("SELECT n FROM users WHERE id = ?", 1)
+	n, err = Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+}
+
+func TestQueryStmt(t *testing.T) {
+	db, d := newTestDB(t)
+	c := newWhitelistCache(t, "^sql:SELECT n FROM orders ", WithTTL(50*time.Millisecond))
+	ctx := context.Background()
+	stmt, err := c.Prepare(ctx, db, "SELECT n FROM orders WHERE id = ?")
+	assert.NoError(t, err)
+	defer stmt.Close()
+	for i := 0; i < 3; i++ {
+		_, err := QueryStmt(ctx, c, stmt, scanInt, 1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&d.queries))
+	// the statement shares the cache with the same query
+	n, err := Query(ctx, c, db, scanInt, "SELECT n FROM orders WHERE id = ?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	// the ttl of the cache expires the result before the ttl of the hotkey
+	time.Sleep(60 * time.Millisecond)
+	n, err = QueryStmt(ctx, c, stmt, scanInt, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	// a statement prepared in a transaction is not cached
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	txStmt, err := c.Prepare(ctx, tx, "SELECT n FROM orders WHERE id = ?")
+	assert.NoError(t, err)
+	n, err = QueryStmt(ctx, c, txStmt, scanInt, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, c.Rollback(tx))
+}
+
+func TestTransaction(t *testing.T) {
+	db, d := newTestDB(t)
+	c := newWhitelistCache(t, "^sql:")
+	ctx := context.Background()
+	_, err := Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+
+	// queries in a transaction are not served from the cache nor cached
+	tx, err := db.Begin()
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := Query(ctx, c, tx, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&d.queries))
+
+	// a write in the transaction is invalidated again by the commit, as the
+	// result cached before it is stale
+	_, err = c.Exec(ctx, tx, "UPDATE users SET n = ? WHERE id = ?", 0, 1)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err := Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(4), atomic.LoadInt32(&d.queries))
+	assert.NoError(t, c.Commit(tx))
+	n, err := Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+}
+
+func TestQueryType(t *testing.T) {
+	db, _ := newTestDB(t)
+	c := newWhitelistCache(t, "^sql:")
+	ctx := context.Background()
+	_, err := Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+	// the same query scanned into another type misses the cached result
+	scanString := func(rows *sql.Rows) (string, error) {
+		n, err := scanInt(rows)
+		return fmt.Sprint(n), err
+	}
+	s, err := Query(ctx, c, db, scanString, "SELECT n FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", s)
+	n, err := Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+}
+
+func TestQueryInvalidatedWhileLoading(t *testing.T) {
+	db, d := newTestDB(t)
+	c := newWhitelistCache(t, "^sql:")
+	ctx := context.Background()
+	started, release := make(chan struct{}), make(chan struct{})
+	d.hook = func() {
+		d.hook = nil
+		close(started)
+		<-release
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+	}()
+	// the result read before the write is not cached after it
+	<-started
+	_, err := c.Exec(ctx, db, "UPDATE users SET n = ? WHERE id = ?", 0, 1)
+	assert.NoError(t, err)
+	close(release)
+	<-done
+	n, err := Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	n, err = Query(ctx, c, db, scanInt, "SELECT n FROM users WHERE id = ?", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+}
+
+func TestPrune(t *testing.T) {
+	db, _ := newTestDB(t)
+	h, err := hotkey.NewHotkey(&hotkey.Option{
+		LocalCacheCap: 2,
+		TTL:           time.Minute,
+		WhileList:     []*hotkey.CacheRuleConfig{{Mode: "pattern", Value: "^sql:"}},
+	})
+	assert.NoError(t, err)
+	c := New(h)
+	// the keys of a table never written are pruned once evicted
+	for i := 0; i < minPruneAt+10; i++ {
+		_, err := Query(context.Background(), c, db, scanInt, "SELECT n FROM users WHERE id = ?", i)
+		assert.NoError(t, err)
+	}
+	assert.Less(t, c.indexed, 16)
+	assert.Equal(t, c.indexed, len(c.keys["users"]))
+}
+
+func TestTables(t *testing.T) {
+	assert.Equal(t, []string{"users", "orders"}, Tables("select * from Users u join `orders` o on u.id = o.uid join users"))
+	assert.Equal(t, []string{"users"}, Tables("INSERT INTO users (id) VALUES (?)"))
+	assert.Equal(t, []string{"db.users"}, Tables("DELETE FROM db.users WHERE id = ?"))
+	assert.Equal(t, "select 'a  b' from t where x = ?", Normalize("  select 'a  b'\n\tfrom t  where x = ? ; "))
+}
@@ -0,0 +1,101 @@
+package hotkey
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+)
+
+var (
+	ErrNilOption        = errors.New("hotkey: nil option")
+	ErrInvalidTTL       = errors.New("hotkey: invalid ttl")
+	ErrInvalidCapacity  = errors.New("hotkey: invalid local cache capacity")
+	ErrInvalidHotKeyCnt = errors.New("hotkey: invalid hot key count")
+	ErrInvalidMinCount  = errors.New("hotkey: invalid min count")
+	ErrInvalidRule      = errors.New("hotkey: invalid rule")
+	ErrConflictRule     = errors.New("hotkey: rule conflicts with the whitelist")
+	ErrInvalidChaos     = errors.New("hotkey: invalid chaos")
+)
+
+// ErrBadRule is the error of the rule at Index of the whitelist or blacklist,
+// it wraps ErrInvalidRule, ErrInvalidTTL or ErrConflictRule.
+type ErrBadRule struct {
+	List  string
+	Index int
+	Err   error
+}
+
+func (e *ErrBadRule) Error() string {
+	return fmt.Sprintf("hotkey: bad %s rule %d: %v", e.List, e.Index, e.Err)
+}
+
+func (e *ErrBadRule) Unwrap() error {
+	return e.Err
+}
+
+// validate check the option, the counters of topk are uint32 so the counts
+// are bounded by math.MaxUint32.
+func validate(option *Option) error {
+	if option == nil {
+		return ErrNilOption
+	}
+	if option.TTL < 0 {
+		return fmt.Errorf("%w: %v is negative", ErrInvalidTTL, option.TTL)
+	}
+	if option.HotKeyCnt < 0 || uint64(option.HotKeyCnt) > math.MaxUint32 {
+		return fmt.Errorf("%w: %d is out of range", ErrInvalidHotKeyCnt, option.HotKeyCnt)
+	}
+	if option.AutoCache && option.HotKeyCnt == 0 {
+		return fmt.Errorf("%w: auto cache requires hot keys", ErrInvalidHotKeyCnt)
+	}
+	if option.MinCount < 0 || uint64(option.MinCount) > math.MaxUint32 {
+		return fmt.Errorf("%w: %d is out of range", ErrInvalidMinCount, option.MinCount)
+	}
+	if (option.AutoCache || len(option.WhileList) > 0) && option.LocalCacheCap == 0 {
+		return fmt.Errorf("%w: local cache requires a positive capacity", ErrInvalidCapacity)
+	}
+	if err := validateChaos(option.Chaos); err != nil {
+		return err
+	}
+	keys := make(map[string]bool, len(option.WhileList))
+	for i, rule := range option.WhileList {
+		if err := validateRule(rule); err != nil {
+			return &ErrBadRule{List: "whitelist", Index: i, Err: err}
+		}
+		if rule.Mode == ruleTypeKey {
+			keys[rule.Value] = true
+		}
+	}
+	for i, rule := range option.BlackList {
+		if err := validateRule(rule); err != nil {
+			return &ErrBadRule{List: "blacklist", Index: i, Err: err}
+		}
+		if rule.Mode == ruleTypeKey && keys[rule.Value] {
+			return &ErrBadRule{List: "blacklist", Index: i, Err: fmt.Errorf("%w: key %q", ErrConflictRule, rule.Value)}
+		}
+	}
+	return nil
+}
+
+func validateRule(rule *CacheRuleConfig) error {
+	if rule == nil {
+		return fmt.Errorf("%w: nil rule", ErrInvalidRule)
+	}
+	if rule.TTL < 0 {
+		return fmt.Errorf("%w: %v is negative", ErrInvalidTTL, rule.TTL)
+	}
+	if rule.Value == "" {
+		return fmt.Errorf("%w: empty value", ErrInvalidRule)
+	}
+	switch rule.Mode {
+	case ruleTypeKey:
+	case ruleTypePattern:
+		if _, err := regexp.Compile(rule.Value); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+	default:
+		return fmt.Errorf("%w: mode %q", ErrInvalidRule, rule.Mode)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Package hotring is an experimental hot-aware index inspired by paper
+// HotRing: A Hotspot-Aware In-Memory Key-Value Store (https://www.usenix.org/conference/fast20/presentation/chen-jiqiang).
+//
+// Every bucket of the hash table is an ordered ring instead of a list, so a
+// lookup can start anywhere in the ring and still stop early on a miss. The
+// head of the ring migrates toward the hot items, so the hot items of extremely
+// skewed workloads are found in one hop.
+package hotring
+
+import (
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/twmb/murmur3"
+)
+
+// Option is hotring option.
+type Option func(*options)
+
+type options struct {
+	buckets  uint64
+	sampling uint32
+}
+
+// WithBuckets with the number of buckets rounded up to a power of two, default 1024.
+func WithBuckets(buckets uint64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// WithSampling with the interval of accesses at which the head of a ring moves
+// to the accessed item, default 5.
+func WithSampling(sampling uint32) Option {
+	return func(o *options) {
+		o.sampling = sampling
+	}
+}
+
+type item[V any] struct {
+	key    string
+	tag    uint64
+	value  V
+	expiry int64
+	prev   *item[V]
+	next   *item[V]
+}
+
+// less order items by tag and key.
+func (i *item[V]) less(tag uint64, key string) bool {
+	return i.tag < tag || i.tag == tag && i.key < key
+}
+
+// greater is the reverse order of less.
+func (i *item[V]) greater(tag uint64, key string) bool {
+	return i.tag > tag || i.tag == tag && i.key > key
+}
+
+type ring[V any] struct {
+	mu       sync.Mutex
+	head     *item[V]
+	size     int
+	accesses uint32
+}
+
+// HotRing is a hash index whose lookups are optimized for hot keys. Items do
+// not count toward a capacity, the expired items are deleted when looked up.
+type HotRing[V any] struct {
+	rings []ring[V]
+	mask  uint64
+	opts  options
+}
+
+// New return a HotRing.
+func New[V any](opts ...Option) *HotRing[V] {
+	opt := options{buckets: 1024, sampling: 5}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.sampling < 1 {
+		opt.sampling = 1
+	}
+	n := uint64(1)
+	for n < opt.buckets {
+		n <<= 1
+	}
+	return &HotRing[V]{rings: make([]ring[V], n), mask: n - 1, opts: opt}
+}
+
+func (h *HotRing[V]) ring(key string) (*ring[V], uint64) {
+	tag := murmur3.StringSum64(key)
+	return &h.rings[tag&h.mask], tag
+}
+
+// find return the item of key, or the item after which key would be inserted
+// if it is not in the ring.
+func (r *ring[V]) find(tag uint64, key string) (*item[V], bool) {
+	cur := r.head
+	for i := 0; i < r.size; i++ {
+		if cur.tag == tag && cur.key == key {
+			return cur, true
+		}
+		next := cur.next
+		if cur.less(tag, key) {
+			// key falls between cur and next, or after the max item
+			if next.greater(tag, key) || !cur.less(next.tag, next.key) {
+				return cur, false
+			}
+		} else if !cur.less(next.tag, next.key) && next.greater(tag, key) {
+			// key is before the min item
+			return cur, false
+		}
+		cur = next
+	}
+	return cur, false
+}
+
+// Get return the value of key and if it is found.
+func (h *HotRing[V]) Get(key string) (V, bool) {
+	r, tag := h.ring(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var zero V
+	if r.head == nil {
+		return zero, false
+	}
+	it, ok := r.find(tag, key)
+	if !ok {
+		return zero, false
+	}
+	if it.expiry > 0 && time.Now().UnixNano() >= it.expiry {
+		r.unlink(it)
+		return zero, false
+	}
+	r.accesses++
+	if r.accesses%uint32(h.opts.sampling) == 0 {
+		// the head moves to the item of a sampled access
+		r.head = it
+	}
+	return it.value, true
+}
+
+// Set set the value of key, ttl 0 means the item never expires.
+func (h *HotRing[V]) Set(key string, value V, ttl time.Duration) {
+	r, tag := h.ring(key)
+	var expiry int64
+	if ttl > 0 {
+		expiry = time.Now().Add(ttl).UnixNano()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.head == nil {
+		it := &item[V]{key: key, tag: tag, value: value, expiry: expiry}
+		it.prev, it.next = it, it
+		r.head = it
+		r.size = 1
+		return
+	}
+	cur, ok := r.find(tag, key)
+	if ok {
+		cur.value, cur.expiry = value, expiry
+		return
+	}
+	it := &item[V]{key: key, tag: tag, value: value, expiry: expiry, prev: cur, next: cur.next}
+	cur.next.prev = it
+	cur.next = it
+	r.size++
+}
+
+// Delete delete key.
+func (h *HotRing[V]) Delete(key string) {
+	r, tag := h.ring(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.head == nil {
+		return
+	}
+	if it, ok := r.find(tag, key); ok {
+		r.unlink(it)
+	}
+}
+
+func (r *ring[V]) unlink(it *item[V]) {
+	r.size--
+	if r.size == 0 {
+		r.head = nil
+		return
+	}
+	it.prev.next = it.next
+	it.next.prev = it.prev
+	if r.head == it {
+		r.head = it.next
+	}
+}
+
+// Len return the number of items, including the expired items not deleted yet.
+func (h *HotRing[V]) Len() int {
+	var n int
+	for i := range h.rings {
+		r := &h.rings[i]
+		r.mu.Lock()
+		n += r.size
+		r.mu.Unlock()
+	}
+	return n
+}
+
+// SizeBytes return the memory consumed by the index in bytes, excluding the
+// keys and the values referenced by the items.
+func (h *HotRing[V]) SizeBytes() uint64 {
+	return uint64(unsafe.Sizeof(*h)) + uint64(cap(h.rings))*uint64(unsafe.Sizeof(ring[V]{})) +
+		uint64(h.Len())*uint64(unsafe.Sizeof(item[V]{}))
+}
@@ -0,0 +1,99 @@
+package hotring
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/rand"
+)
+
+func TestHotRing(t *testing.T) {
+	// few buckets to build long rings
+	h := New[int](WithBuckets(4))
+	for i := 0; i < 1000; i++ {
+		h.Set(strconv.Itoa(i), i, 0)
+	}
+	assert.Equal(t, 1000, h.Len())
+	for i := 0; i < 1000; i++ {
+		v, ok := h.Get(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, i, v)
+	}
+	_, ok := h.Get("missing")
+	assert.False(t, ok)
+
+	h.Set("1", 100, 0)
+	v, _ := h.Get("1")
+	assert.Equal(t, 100, v)
+	for i := 0; i < 1000; i += 2 {
+		h.Delete(strconv.Itoa(i))
+	}
+	assert.Equal(t, 500, h.Len())
+	for i := 0; i < 1000; i++ {
+		_, ok := h.Get(strconv.Itoa(i))
+		assert.Equal(t, i%2 == 1, ok)
+	}
+}
+
+func TestHotRingHead(t *testing.T) {
+	h := New[int](WithBuckets(1), WithSampling(1))
+	for i := 0; i < 100; i++ {
+		h.Set(strconv.Itoa(i), i, 0)
+	}
+	h.Get("42")
+	assert.Equal(t, "42", h.rings[0].head.key)
+	h.Delete("42")
+	assert.NotNil(t, h.rings[0].head)
+	assert.Equal(t, 99, h.Len())
+}
+
+func TestHotRingTTL(t *testing.T) {
+	h := New[int]()
+	h.Set("a", 1, 10*time.Millisecond)
+	_, ok := h.Get("a")
+	assert.True(t, ok)
+	time.Sleep(20 * time.Millisecond)
+	_, ok = h.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, h.Len())
+}
+
+func zipfKeys(n int) []string {
+	zipf := rand.NewZipf(rand.New(rand.NewSource(uint64(time.Now().Unix()))), 2, 2, 100000)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = strconv.FormatUint(zipf.Uint64(), 10)
+	}
+	return keys
+}
+
+func BenchmarkHotRingGet(b *testing.B) {
+	h := New[int](WithBuckets(1024))
+	for i := 0; i <= 100000; i++ {
+		h.Set(strconv.Itoa(i), i, time.Minute)
+	}
+	keys := zipfKeys(10000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			h.Get(keys[i%len(keys)])
+		}
+	})
+}
+
+func BenchmarkTTLCacheGet(b *testing.B) {
+	c := ttlcache.New[string, int](ttlcache.WithCapacity[string, int](200000))
+	for i := 0; i <= 100000; i++ {
+		c.Set(strconv.Itoa(i), i, time.Minute)
+	}
+	keys := zipfKeys(10000)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			c.Get(keys[i%len(keys)])
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package hyperloglog
+
+// Native Go implement of HyperLogLog cardinality estimation, Based on paper
+// HyperLogLog: the analysis of a near-optimal cardinality estimation algorithm (http://algo.inria.fr/flajolet/Publications/FlFuGaMe07.pdf)
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"unsafe"
+
+	"github.com/twmb/murmur3"
+)
+
+const (
+	// MinPrecision is the minimal precision of HyperLogLog.
+	MinPrecision = 4
+	// MaxPrecision is the maximal precision of HyperLogLog.
+	MaxPrecision = 18
+)
+
+// ErrPrecisionMismatch is returned when merging HyperLogLogs of different precision.
+var ErrPrecisionMismatch = errors.New("hyperloglog: precision mismatch")
+
+// HyperLogLog estimates the number of distinct keys with 2^precision registers,
+// the standard error is about 1.04/sqrt(2^precision).
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// New return a HyperLogLog, precision is clamped to [MinPrecision, MaxPrecision].
+func New(precision uint8) *HyperLogLog {
+	if precision < MinPrecision {
+		precision = MinPrecision
+	}
+	if precision > MaxPrecision {
+		precision = MaxPrecision
+	}
+	return &HyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+// Add add key into HyperLogLog.
+func (h *HyperLogLog) Add(key []byte) {
+	h.AddHash(murmur3.Sum64(key))
+}
+
+// AddString add key into HyperLogLog without copying it.
+func (h *HyperLogLog) AddString(key string) {
+	h.Add(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+// AddHash add a 64-bit hash of key into HyperLogLog.
+func (h *HyperLogLog) AddHash(hash uint64) {
+	idx := hash >> (64 - h.precision)
+	// the sentinel bit bounds rank to 64-precision+1
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Count return the estimated number of distinct keys.
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, r := range h.registers {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := alpha(m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// small range correction by linear counting
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// Merge merge other into h, both must have the same precision.
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h.precision != other.precision {
+		return ErrPrecisionMismatch
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Reset clear all registers in place.
+func (h *HyperLogLog) Reset() {
+	for i := range h.registers {
+		h.registers[i] = 0
+	}
+}
+
+// Precision return the precision of HyperLogLog.
+func (h *HyperLogLog) Precision() uint8 {
+	return h.precision
+}
+
+// SizeBytes return the memory consumed by HyperLogLog in bytes.
+func (h *HyperLogLog) SizeBytes() uint64 {
+	return uint64(unsafe.Sizeof(*h)) + uint64(cap(h.registers))
+}
+
+func alpha(m float64) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1 + 1.079/m)
+}
@@ -0,0 +1,54 @@
+package hyperloglog
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLogCount(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		h := New(14)
+		for i := 0; i < n; i++ {
+			key := strconv.Itoa(i)
+			h.AddString(key)
+			h.AddString(key)
+		}
+		// 3 standard errors of 1.04/sqrt(2^14)
+		assert.InDelta(t, float64(n), float64(h.Count()), math.Max(1, 3*0.0081*float64(n)), "n=%d", n)
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a, b := New(12), New(12)
+	for i := 0; i < 1000; i++ {
+		a.AddString(strconv.Itoa(i))
+		b.AddString(strconv.Itoa(i + 500))
+	}
+	assert.Nil(t, a.Merge(b))
+	assert.InEpsilon(t, 1500, float64(a.Count()), 0.05)
+	assert.Equal(t, ErrPrecisionMismatch, a.Merge(New(10)))
+
+	a.Reset()
+	assert.Equal(t, uint64(0), a.Count())
+}
+
+func TestHyperLogLogPrecision(t *testing.T) {
+	assert.Equal(t, uint8(MinPrecision), New(0).Precision())
+	assert.Equal(t, uint8(MaxPrecision), New(32).Precision())
+}
+
+func BenchmarkHyperLogLogAdd(b *testing.B) {
+	h := New(14)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.AddString(keys[i%len(keys)])
+	}
+}
@@ -3,49 +3,122 @@ package minheap
 import (
 	"container/heap"
 	"sort"
+	"unsafe"
 )
 
-type Heap struct {
-	Nodes Nodes
+// Count is the count type of heap nodes.
+type Count interface {
+	~uint32 | ~float64
+}
+
+type Heap[C Count] struct {
+	Nodes Nodes[C]
 	K     uint32
+
+	// free keeps the nodes released by Reset for reuse.
+	free []*Node[C]
 }
 
-func NewHeap(k uint32) *Heap {
-	h := Nodes{}
+func NewHeap[C Count](k uint32) *Heap[C] {
+	h := make(Nodes[C], 0, k)
 	heap.Init(&h)
-	return &Heap{Nodes: h, K: k}
+	return &Heap[C]{Nodes: h, K: k}
 }
 
-func (h *Heap) Add(val *Node) *Node {
+// Add add val into heap, if the heap is full and val count is greater than the
+// min count, the min node is expelled and returned. The node of the expelled
+// key is reused, so Add does not allocate once the heap is full.
+func (h *Heap[C]) Add(val Node[C]) (expelled Node[C], ok bool) {
 	if h.K > uint32(len(h.Nodes)) {
-		heap.Push(&h.Nodes, val)
+		var node *Node[C]
+		if n := len(h.free); n > 0 {
+			node, h.free = h.free[n-1], h.free[:n-1]
+		} else {
+			node = new(Node[C])
+		}
+		*node = val
+		heap.Push(&h.Nodes, node)
 	} else if val.Count > h.Nodes[0].Count {
-		expelled := heap.Pop(&h.Nodes)
-		heap.Push(&h.Nodes, val)
-		node := expelled.(*Node)
-		return node
+		expelled = *h.Nodes[0]
+		*h.Nodes[0] = val
+		heap.Fix(&h.Nodes, 0)
+		return expelled, true
 	}
-	return nil
+	return expelled, false
 }
 
-func (h *Heap) Pop() *Node {
+func (h *Heap[C]) Pop() *Node[C] {
 	expelled := heap.Pop(&h.Nodes)
-	return expelled.(*Node)
+	return expelled.(*Node[C])
+}
+
+// Remove remove the node at idx and return it.
+func (h *Heap[C]) Remove(idx int) Node[C] {
+	node := heap.Remove(&h.Nodes, idx).(*Node[C])
+	removed := *node
+	*node = Node[C]{}
+	h.free = append(h.free, node)
+	return removed
+}
+
+// Prune remove the nodes matched by fn and return them.
+func (h *Heap[C]) Prune(fn func(node *Node[C]) bool) []Node[C] {
+	var pruned []Node[C]
+	nodes := h.Nodes[:0]
+	for _, node := range h.Nodes {
+		if !fn(node) {
+			nodes = append(nodes, node)
+			continue
+		}
+		pruned = append(pruned, *node)
+		*node = Node[C]{}
+		h.free = append(h.free, node)
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+	for i := len(nodes); i < len(h.Nodes); i++ {
+		h.Nodes[i] = nil
+	}
+	h.Nodes = nodes
+	heap.Init(&h.Nodes)
+	return pruned
 }
 
-func (h *Heap) Fix(idx int, count uint32) {
+func (h *Heap[C]) Fix(idx int, count C) {
 	h.Nodes[idx].Count = count
 	heap.Fix(&h.Nodes, idx)
 }
 
-func (h *Heap) Min() uint32 {
+func (h *Heap[C]) Reset() {
+	for i, node := range h.Nodes {
+		*node = Node[C]{}
+		h.free = append(h.free, node)
+		h.Nodes[i] = nil
+	}
+	h.Nodes = h.Nodes[:0]
+}
+
+// SizeBytes return the memory consumed by the nodes and their keys.
+func (h *Heap[C]) SizeBytes() uint64 {
+	ptrSize := uint64(unsafe.Sizeof((*Node[C])(nil)))
+	nodeSize := uint64(unsafe.Sizeof(Node[C]{}))
+	size := uint64(unsafe.Sizeof(*h)) + uint64(cap(h.Nodes)+cap(h.free))*ptrSize
+	size += uint64(len(h.free)) * nodeSize
+	for _, node := range h.Nodes {
+		size += nodeSize + uint64(len(node.Key))
+	}
+	return size
+}
+
+func (h *Heap[C]) Min() C {
 	if len(h.Nodes) == 0 {
 		return 0
 	}
 	return h.Nodes[0].Count
 }
 
-func (h *Heap) Find(key string) (int, bool) {
+func (h *Heap[C]) Find(key string) (int, bool) {
 	for i := range h.Nodes {
 		if h.Nodes[i].Key == key {
 			return i, true
@@ -54,37 +127,40 @@ func (h *Heap) Find(key string) (int, bool) {
 	return 0, false
 }
 
-func (h *Heap) Sorted() Nodes {
-	nodes := append([]*Node(nil), h.Nodes...)
-	sort.Sort(sort.Reverse(Nodes(nodes)))
+func (h *Heap[C]) Sorted() Nodes[C] {
+	nodes := append([]*Node[C](nil), h.Nodes...)
+	sort.Sort(sort.Reverse(Nodes[C](nodes)))
 	return nodes
 }
 
-type Nodes []*Node
+type Nodes[C Count] []*Node[C]
 
-type Node struct {
+type Node[C Count] struct {
 	Key   string
-	Count uint32
+	Count C
+	// FirstSeen and LastSeen are unix nanoseconds, zero if not recorded.
+	FirstSeen int64
+	LastSeen  int64
 }
 
-func (n Nodes) Len() int {
+func (n Nodes[C]) Len() int {
 	return len(n)
 }
 
-func (n Nodes) Less(i, j int) bool {
+func (n Nodes[C]) Less(i, j int) bool {
 	return (n[i].Count < n[j].Count) || (n[i].Count == n[j].Count && n[i].Key > n[j].Key)
 }
 
-func (n Nodes) Swap(i, j int) {
+func (n Nodes[C]) Swap(i, j int) {
 	n[i], n[j] = n[j], n[i]
 }
 
-func (n *Nodes) Push(val interface{}) {
-	*n = append(*n, val.(*Node))
+func (n *Nodes[C]) Push(val interface{}) {
+	*n = append(*n, val.(*Node[C]))
 }
 
-func (n *Nodes) Pop() interface{} {
-	var val *Node
+func (n *Nodes[C]) Pop() interface{} {
+	var val *Node[C]
 	val, *n = (*n)[len((*n))-1], (*n)[:len((*n))-1]
 	return val
 }
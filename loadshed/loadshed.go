@@ -0,0 +1,221 @@
+// Package loadshed implements priority-based load shedding. Requests are
+// classified by caller-supplied criticality, and under overload the shed level
+// rises one priority at a time, so the lowest-priority traffic is shed first
+// and the most critical traffic is never shed.
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zychimne/aegis/cpu"
+)
+
+// ErrShed is returned when the request is shed.
+var ErrShed = errors.New("loadshed: request shed")
+
+// Priority is the criticality of a request, a lower value is more critical.
+type Priority int
+
+const (
+	// CriticalPlus is never shed.
+	CriticalPlus Priority = iota
+	// Critical is the default priority of production traffic.
+	Critical
+	// SheddablePlus is traffic tolerating partial unavailability, such as batch jobs with retries.
+	SheddablePlus
+	// Sheddable is traffic expected to be shed first, such as prefetching.
+	Sheddable
+
+	numPriorities = int(Sheddable) + 1
+)
+
+// String returns the name of the priority.
+func (p Priority) String() string {
+	switch p {
+	case CriticalPlus:
+		return "critical_plus"
+	case Critical:
+		return "critical"
+	case SheddablePlus:
+		return "sheddable_plus"
+	case Sheddable:
+		return "sheddable"
+	}
+	return "unknown"
+}
+
+// ParsePriority parses the priority from the caller-supplied tag, unknown tags are Critical.
+func ParsePriority(tag string) Priority {
+	switch strings.ToLower(tag) {
+	case "critical_plus":
+		return CriticalPlus
+	case "sheddable_plus":
+		return SheddablePlus
+	case "sheddable":
+		return Sheddable
+	}
+	return Critical
+}
+
+type priorityKey struct{}
+
+// NewContext returns a context carrying the priority.
+func NewContext(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// FromContext returns the priority carried by ctx, default Critical.
+func FromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return Critical
+}
+
+// Signal reports whether the process is overloaded.
+type Signal func() bool
+
+// CPUSignal reports overload when the CPU usage smoothed by EMA exceeds threshold, 1000 is 100%.
+func CPUSignal(threshold uint64) Signal {
+	return func() bool {
+		stat := &cpu.Stat{}
+		cpu.ReadStat(stat)
+		return stat.Smoothed > threshold
+	}
+}
+
+// QueueDelaySignal reports overload when the queueing delay returned by delay exceeds target.
+func QueueDelaySignal(delay func() time.Duration, target time.Duration) Signal {
+	return func() bool {
+		return delay() > target
+	}
+}
+
+// Option is load shedder option function.
+type Option func(*options)
+
+// options of load shedder.
+type options struct {
+	signals  []Signal
+	interval time.Duration
+}
+
+// WithSignals with the overload signals, any of them triggers shedding.
+func WithSignals(signals ...Signal) Option {
+	return func(o *options) {
+		o.signals = append(o.signals, signals...)
+	}
+}
+
+// WithInterval with the interval of adjusting the shed level, default 500ms.
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// Stat contains the metrics of a priority.
+type Stat struct {
+	Priority Priority
+	Allowed  uint64
+	Shed     uint64
+}
+
+// Shedder sheds requests by priority under overload.
+type Shedder struct {
+	// level is the number of lowest priorities being shed
+	level    int32
+	feedback int32
+	mu       sync.Mutex
+	evalAt   time.Time
+	allowed  [numPriorities]uint64
+	shed     [numPriorities]uint64
+	opts     options
+}
+
+// New returns a load shedder.
+func New(opts ...Option) *Shedder {
+	opt := options{
+		interval: 500 * time.Millisecond,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Shedder{evalAt: time.Now(), opts: opt}
+}
+
+// Allow checks the request of priority p, it returns ErrShed if it is shed.
+func (s *Shedder) Allow(p Priority) error {
+	if p < CriticalPlus {
+		p = CriticalPlus
+	} else if p > Sheddable {
+		p = Sheddable
+	}
+	s.evaluate(time.Now())
+	if int(p) >= numPriorities-int(atomic.LoadInt32(&s.level)) {
+		atomic.AddUint64(&s.shed[p], 1)
+		return ErrShed
+	}
+	atomic.AddUint64(&s.allowed[p], 1)
+	return nil
+}
+
+// AllowContext checks the request with the priority carried by ctx.
+func (s *Shedder) AllowContext(ctx context.Context) error {
+	return s.Allow(FromContext(ctx))
+}
+
+// Overload reports an overload feedback, such as a rejection of a limiter,
+// it is taken as an overload signal in the next adjustment.
+func (s *Shedder) Overload() {
+	atomic.StoreInt32(&s.feedback, 1)
+}
+
+// Level returns the number of lowest priorities being shed.
+func (s *Shedder) Level() int {
+	return int(atomic.LoadInt32(&s.level))
+}
+
+// Stats returns the metrics of all priorities.
+func (s *Shedder) Stats() []Stat {
+	stats := make([]Stat, numPriorities)
+	for i := range stats {
+		stats[i] = Stat{
+			Priority: Priority(i),
+			Allowed:  atomic.LoadUint64(&s.allowed[i]),
+			Shed:     atomic.LoadUint64(&s.shed[i]),
+		}
+	}
+	return stats
+}
+
+// evaluate raises the shed level by one under overload, and lowers it by one otherwise.
+func (s *Shedder) evaluate(now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.evalAt) < s.opts.interval {
+		s.mu.Unlock()
+		return
+	}
+	s.evalAt = now
+	s.mu.Unlock()
+
+	overloaded := atomic.SwapInt32(&s.feedback, 0) == 1
+	for _, signal := range s.opts.signals {
+		if overloaded {
+			break
+		}
+		overloaded = signal()
+	}
+	level := atomic.LoadInt32(&s.level)
+	if overloaded && level < int32(numPriorities-1) {
+		// CriticalPlus is never shed
+		atomic.StoreInt32(&s.level, level+1)
+	} else if !overloaded && level > 0 {
+		atomic.StoreInt32(&s.level, level-1)
+	}
+}
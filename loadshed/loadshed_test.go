@@ -0,0 +1,73 @@
+package loadshed
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadShed(t *testing.T) {
+	var overloaded int32
+	s := New(WithSignals(func() bool { return atomic.LoadInt32(&overloaded) == 1 }), WithInterval(time.Hour))
+	now := time.Now()
+	for p := CriticalPlus; p <= Sheddable; p++ {
+		assert.NoError(t, s.Allow(p))
+	}
+	atomic.StoreInt32(&overloaded, 1)
+	for i := 1; i <= 5; i++ {
+		s.evaluate(now.Add(time.Duration(i) * time.Hour))
+	}
+	assert.Equal(t, 3, s.Level())
+	assert.NoError(t, s.Allow(CriticalPlus))
+	assert.Equal(t, ErrShed, s.Allow(Critical))
+	assert.Equal(t, ErrShed, s.Allow(Sheddable))
+
+	atomic.StoreInt32(&overloaded, 0)
+	s.evaluate(now.Add(6 * time.Hour))
+	s.evaluate(now.Add(7 * time.Hour))
+	assert.Equal(t, 1, s.Level())
+	assert.NoError(t, s.Allow(Critical))
+	assert.NoError(t, s.Allow(SheddablePlus))
+	assert.Equal(t, ErrShed, s.Allow(Sheddable))
+
+	assert.Equal(t, []Stat{
+		{Priority: CriticalPlus, Allowed: 2},
+		{Priority: Critical, Allowed: 2, Shed: 1},
+		{Priority: SheddablePlus, Allowed: 2},
+		{Priority: Sheddable, Allowed: 1, Shed: 2},
+	}, s.Stats())
+}
+
+func TestLoadShedOverload(t *testing.T) {
+	s := New(WithInterval(time.Hour))
+	now := time.Now()
+	s.Overload()
+	s.evaluate(now.Add(time.Hour))
+	assert.Equal(t, 1, s.Level())
+	// the feedback is consumed by the adjustment
+	s.evaluate(now.Add(2 * time.Hour))
+	assert.Equal(t, 0, s.Level())
+}
+
+func TestLoadShedContext(t *testing.T) {
+	assert.Equal(t, Critical, FromContext(context.Background()))
+	ctx := NewContext(context.Background(), ParsePriority("SHEDDABLE"))
+	assert.Equal(t, Sheddable, FromContext(ctx))
+	assert.Equal(t, "sheddable", FromContext(ctx).String())
+	assert.Equal(t, Critical, ParsePriority("unknown"))
+
+	s := New()
+	s.level = 1
+	assert.Equal(t, ErrShed, s.AllowContext(ctx))
+}
+
+func TestQueueDelaySignal(t *testing.T) {
+	delay := 10 * time.Millisecond
+	signal := QueueDelaySignal(func() time.Duration { return delay }, 5*time.Millisecond)
+	assert.True(t, signal())
+	delay = time.Millisecond
+	assert.False(t, signal())
+}
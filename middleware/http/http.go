@@ -0,0 +1,144 @@
+// Package http provides the net/http middleware applying a middleware.Guard.
+// Rejected requests are answered with 429 if the limit is exceeded and 503 if
+// the breaker is open, and responses with 5xx status are counted as failures.
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/zychimne/aegis/middleware"
+)
+
+// errServer is the failure reported for 5xx responses.
+var errServer = errors.New("http: server error")
+
+// Option is middleware option function.
+type Option func(*options)
+
+// options of middleware.
+type options struct {
+	key      func(r *http.Request) string
+	resource func(r *http.Request) string
+	guard    func(r *http.Request) *middleware.Guard
+	reject   func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// WithKeyFunc with the hot key extractor, keys are counted as "resource:key".
+// Default no key is counted.
+func WithKeyFunc(fn func(r *http.Request) string) Option {
+	return func(o *options) {
+		o.key = fn
+	}
+}
+
+// WithResourceFunc with the resource extractor, default the method and the
+// pattern of the ServeMux route, or the path if the request is not routed by a
+// ServeMux yet. Extract the route pattern of the router if the path contains
+// parameters, especially with a breaker per resource, as every URL would
+// otherwise get its own breaker.
+func WithResourceFunc(fn func(r *http.Request) string) Option {
+	return func(o *options) {
+		o.resource = fn
+	}
+}
+
+// WithGuardFunc with the selector of the guard of a request, such as a guard
+// per route, the request passes through if it returns nil.
+func WithGuardFunc(fn func(r *http.Request) *middleware.Guard) Option {
+	return func(o *options) {
+		o.guard = fn
+	}
+}
+
+// WithRejectHandler with the handler of rejected requests, default replies
+// the status code of middleware.StatusCode.
+func WithRejectHandler(fn func(w http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(o *options) {
+		o.reject = fn
+	}
+}
+
+// resource returns the method and the route pattern of r, or its path.
+func resource(r *http.Request) string {
+	p := pattern(r)
+	if p == "" {
+		return r.Method + " " + r.URL.Path
+	}
+	// patterns with a method are "GET /user/{id}" or "GET example.com/user/{id}"
+	if i := strings.IndexByte(p, ' '); i >= 0 {
+		p = strings.TrimLeft(p[i:], " ")
+	}
+	return r.Method + " " + p
+}
+
+func reject(w http.ResponseWriter, _ *http.Request, err error) {
+	code := middleware.StatusCode(err)
+	http.Error(w, http.StatusText(code), code)
+}
+
+// statusWriter records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Middleware returns the net/http middleware applying g.
+func Middleware(g *middleware.Guard, opts ...Option) func(http.Handler) http.Handler {
+	opt := options{
+		key:      func(*http.Request) string { return "" },
+		resource: resource,
+		guard:    func(*http.Request) *middleware.Guard { return g },
+		reject:   reject,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			guard := opt.guard(r)
+			if guard == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			done, err := guard.Allow(opt.resource(r), opt.key(r))
+			if err != nil {
+				opt.reject(w, r, err)
+				return
+			}
+			sw := &statusWriter{ResponseWriter: w}
+			defer func() {
+				if p := recover(); p != nil {
+					done(errServer)
+					panic(p)
+				}
+				if sw.code >= http.StatusInternalServerError {
+					done(errServer)
+					return
+				}
+				done(nil)
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/middleware"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+)
+
+type testBreaker struct {
+	open    bool
+	failure int
+}
+
+func (b *testBreaker) Allow() error {
+	if b.open {
+		return circuitbreaker.ErrNotAllowed
+	}
+	return nil
+}
+
+func (b *testBreaker) MarkSuccess() {}
+
+func (b *testBreaker) MarkFailed() { b.failure++ }
+
+func serve(h http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	breaker := &testBreaker{}
+	h, err := hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: 10, MinCount: 1})
+	assert.NoError(t, err)
+	g := middleware.New(
+		middleware.WithLimiter(concurrency.NewLimiter(1)),
+		middleware.WithBreaker(breaker),
+		middleware.WithHotkey(h),
+	)
+	var handler http.Handler
+	handler = Middleware(g, WithKeyFunc(func(r *http.Request) string {
+		return r.URL.Query().Get("user")
+	}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/nested":
+			assert.Equal(t, http.StatusTooManyRequests, serve(handler, "/").Code)
+		case "/error":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+
+	assert.Equal(t, http.StatusOK, serve(handler, "/nested?user=42").Code)
+	assert.Equal(t, http.StatusBadGateway, serve(handler, "/error").Code)
+	assert.Equal(t, 1, breaker.failure)
+	assert.Equal(t, "GET /nested:42", h.List()[0].Key)
+
+	breaker.open = true
+	assert.Equal(t, http.StatusServiceUnavailable, serve(handler, "/").Code)
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	g := middleware.New(middleware.WithBreaker(&testBreaker{open: true}))
+	handler := Middleware(g,
+		WithGuardFunc(func(r *http.Request) *middleware.Guard {
+			if r.URL.Path == "/health" {
+				return nil
+			}
+			return g
+		}),
+		WithRejectHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+	)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	assert.Equal(t, http.StatusOK, serve(handler, "/health").Code)
+	assert.Equal(t, http.StatusTeapot, serve(handler, "/").Code)
+}
@@ -0,0 +1,10 @@
+//go:build !go1.23
+
+package http
+
+import "net/http"
+
+// pattern returns empty, http.Request has no pattern before Go 1.23.
+func pattern(*http.Request) string {
+	return ""
+}
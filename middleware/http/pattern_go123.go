@@ -0,0 +1,11 @@
+//go:build go1.23
+
+package http
+
+import "net/http"
+
+// pattern returns the pattern of the ServeMux route matching r, empty if r is
+// not routed by a ServeMux yet.
+func pattern(r *http.Request) string {
+	return r.Pattern
+}
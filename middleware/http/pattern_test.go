@@ -0,0 +1,43 @@
+//go:build go1.23
+
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/middleware"
+)
+
+func TestMiddlewarePattern(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: 10, MinCount: 1})
+	assert.NoError(t, err)
+	m := Middleware(middleware.New(middleware.WithHotkey(h)), WithKeyFunc(func(r *http.Request) string {
+		return "k"
+	}))
+	ok := m(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	// the requests of a route share the resource of its pattern, as set by
+	// the ServeMux
+	for path, pattern := range map[string]string{
+		"/user/1":  "GET /user/{id}",
+		"/user/2":  "GET /user/{id}",
+		"/order/1": "/order/{id}",
+	} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Pattern = pattern
+		w := httptest.NewRecorder()
+		ok.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	var keys []string
+	for _, item := range h.List() {
+		keys = append(keys, item.Key)
+	}
+	assert.ElementsMatch(t, []string{"GET /user/{id}:k", "GET /order/{id}:k"}, keys)
+	// a request not routed yet falls back to the path
+	assert.Equal(t, http.StatusOK, serve(ok, "/user/3").Code)
+	assert.Len(t, h.List(), 3)
+}
@@ -0,0 +1,174 @@
+// Package middleware is the framework-agnostic core of the middlewares, it
+// applies the hot-key counting, rate limiting and circuit breaking to a
+// request. The adapters of net/http and the frameworks extract the resource
+// and key from their requests, and translate the rejections to their codes.
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+// DoneFunc must be called when the request finishes, err is non-nil if the
+// request failed and should be counted by the breaker.
+type DoneFunc func(err error)
+
+// Option is guard option function.
+type Option func(*options)
+
+// options of guard.
+type options struct {
+	limiter ratelimit.Limiter
+	breaker func() circuitbreaker.CircuitBreaker
+	shared  circuitbreaker.CircuitBreaker
+	hotkey  *hotkey.HotKeyWithCache
+}
+
+// WithLimiter with the limiter shared by all resources.
+func WithLimiter(limiter ratelimit.Limiter) Option {
+	return func(o *options) {
+		o.limiter = limiter
+	}
+}
+
+// WithBreaker with the breaker shared by all resources.
+func WithBreaker(breaker circuitbreaker.CircuitBreaker) Option {
+	return func(o *options) {
+		o.shared = breaker
+	}
+}
+
+// WithBreakerFactory with the factory creating a breaker per resource, such
+// as a route or a method, so one failing resource doesn't break the others.
+// The breakers live in a circuitbreaker.BreakerGroup of the default bound.
+func WithBreakerFactory(factory func() circuitbreaker.CircuitBreaker) Option {
+	return func(o *options) {
+		o.breaker = factory
+	}
+}
+
+// WithHotkey with the hot-key counter, keys are counted as "resource:key".
+func WithHotkey(h *hotkey.HotKeyWithCache) Option {
+	return func(o *options) {
+		o.hotkey = h
+	}
+}
+
+// Guard applies the components to requests.
+type Guard struct {
+	breakers *circuitbreaker.BreakerGroup[circuitbreaker.CircuitBreaker]
+	opts     options
+}
+
+// New returns a guard, the components not configured are skipped.
+func New(opts ...Option) *Guard {
+	var opt options
+	for _, o := range opts {
+		o(&opt)
+	}
+	g := &Guard{opts: opt}
+	if opt.breaker != nil {
+		g.breakers = circuitbreaker.NewBreakerGroup(func(string) circuitbreaker.CircuitBreaker {
+			return opt.breaker()
+		})
+	}
+	return g
+}
+
+// Allow checks the request of key on resource. It returns
+// ratelimit.ErrLimitExceed or circuitbreaker.ErrNotAllowed if the request is
+// rejected, otherwise the returned DoneFunc must be called.
+func (g *Guard) Allow(resource, key string) (DoneFunc, error) {
+	if g.opts.hotkey != nil && key != "" {
+		g.opts.hotkey.Add(resource+":"+key, 1)
+	}
+	var limiterDone ratelimit.DoneFunc
+	if g.opts.limiter != nil {
+		done, err := g.opts.limiter.Allow()
+		if err != nil {
+			return nil, err
+		}
+		limiterDone = done
+	}
+	// the breaker is checked last, so an allowed probe is always marked
+	var mark func(success bool, elapsed time.Duration)
+	if breaker := g.breaker(resource); breaker != nil {
+		m, err := allowBreaker(breaker)
+		if err != nil {
+			if limiterDone != nil {
+				limiterDone(ratelimit.DoneInfo{Err: err})
+			}
+			return nil, err
+		}
+		mark = m
+	}
+	start := time.Now()
+	return func(err error) {
+		if limiterDone != nil {
+			limiterDone(ratelimit.DoneInfo{Err: err})
+		}
+		if mark != nil {
+			mark(err == nil, time.Since(start))
+		}
+	}, nil
+}
+
+// allowBreaker allows the request on breaker, and returns the func marking the
+// result of the request.
+func allowBreaker(breaker circuitbreaker.CircuitBreaker) (func(success bool, elapsed time.Duration), error) {
+	// breakers stamping their permits, such as the classic breaker, ignore the
+	// late results of the requests allowed before they changed their state
+	if a, ok := breaker.(acquirer); ok {
+		p, err := a.Acquire()
+		if err != nil {
+			return nil, err
+		}
+		return p.Mark, nil
+	}
+	if err := breaker.Allow(); err != nil {
+		return nil, err
+	}
+	// breakers tracking slow calls are marked with the elapsed time
+	if m, ok := breaker.(marker); ok {
+		return m.Mark, nil
+	}
+	return func(success bool, _ time.Duration) {
+		if success {
+			breaker.MarkSuccess()
+		} else {
+			breaker.MarkFailed()
+		}
+	}, nil
+}
+
+// acquirer is a breaker returning the permits of the allowed requests.
+type acquirer interface {
+	Acquire() (circuitbreaker.Permit, error)
+}
+
+// marker is a breaker marking the elapsed time of requests.
+type marker interface {
+	Mark(success bool, elapsed time.Duration)
+}
+
+// breaker returns the breaker of resource, nil if no breaker is configured.
+func (g *Guard) breaker(resource string) circuitbreaker.CircuitBreaker {
+	if g.breakers == nil {
+		return g.opts.shared
+	}
+	return g.breakers.Get(resource)
+}
+
+// StatusCode returns the HTTP status code of a rejection, 429 if the limit is
+// exceeded and 503 otherwise.
+func StatusCode(err error) int {
+	if errors.Is(err, ratelimit.ErrLimitExceed) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusServiceUnavailable
+}
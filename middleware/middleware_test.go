@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/circuitbreaker/classic"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+)
+
+type testBreaker struct {
+	open             bool
+	success, failure int
+}
+
+func (b *testBreaker) Allow() error {
+	if b.open {
+		return circuitbreaker.ErrNotAllowed
+	}
+	return nil
+}
+
+func (b *testBreaker) MarkSuccess() { b.success++ }
+
+func (b *testBreaker) MarkFailed() { b.failure++ }
+
+func TestGuard(t *testing.T) {
+	h, err := hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: 10, MinCount: 1})
+	assert.NoError(t, err)
+	breaker := &testBreaker{}
+	limiter := concurrency.NewLimiter(1)
+	g := New(WithLimiter(limiter), WithBreaker(breaker), WithHotkey(h))
+
+	done, err := g.Allow("/user", "42")
+	assert.NoError(t, err)
+	_, err = g.Allow("/user", "42")
+	assert.Equal(t, ratelimit.ErrLimitExceed, err)
+	done(errors.New("failed"))
+	assert.Equal(t, 1, breaker.failure)
+
+	done, err = g.Allow("/user", "")
+	assert.NoError(t, err)
+	done(nil)
+	assert.Equal(t, 1, breaker.success)
+
+	// the limiter is released when the breaker rejects
+	breaker.open = true
+	_, err = g.Allow("/user", "42")
+	assert.Equal(t, circuitbreaker.ErrNotAllowed, err)
+	assert.Equal(t, 0, limiter.Stat().InFlight)
+
+	items := h.List()
+	assert.Len(t, items, 1)
+	assert.Equal(t, "/user:42", items[0].Key)
+}
+
+func TestGuardBreakerFactory(t *testing.T) {
+	var breakers []*testBreaker
+	g := New(WithBreakerFactory(func() circuitbreaker.CircuitBreaker {
+		b := &testBreaker{}
+		breakers = append(breakers, b)
+		return b
+	}))
+	done, err := g.Allow("/a", "")
+	assert.NoError(t, err)
+	done(nil)
+	breakers[0].open = true
+	_, err = g.Allow("/a", "")
+	assert.Equal(t, circuitbreaker.ErrNotAllowed, err)
+	done, err = g.Allow("/b", "")
+	assert.NoError(t, err)
+	done(nil)
+	assert.Len(t, breakers, 2)
+
+	// nothing is applied without components
+	done, err = New().Allow("/a", "k")
+	assert.NoError(t, err)
+	done(nil)
+}
+
+func TestGuardSlowCall(t *testing.T) {
+	breaker := classic.NewBreaker(classic.WithRequest(1), classic.WithSlowCallRate(0.5), classic.WithSlowCallDuration(time.Millisecond))
+	g := New(WithBreaker(breaker))
+	done, err := g.Allow("/a", "")
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	done(nil)
+	assert.Equal(t, classic.StateOpen, breaker.State())
+}
+
+func TestStatusCode(t *testing.T) {
+	assert.Equal(t, 429, StatusCode(ratelimit.ErrLimitExceed))
+	assert.Equal(t, 503, StatusCode(circuitbreaker.ErrNotAllowed))
+}
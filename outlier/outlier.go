@@ -0,0 +1,345 @@
+// Package outlier detects the endpoints with high error rates or latencies
+// from the rolling windows of their requests, and ejects them from the pool
+// of the caller temporarily. The ejection time grows with the ejections of an
+// endpoint and shrinks back while it stays healthy, and ejected endpoints are
+// reintroduced gradually.
+package outlier
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/window"
+	"golang.org/x/exp/rand"
+)
+
+// Option is detector option function.
+type Option func(*options)
+
+// options of detector.
+type options struct {
+	failureRate   float64
+	latencyFactor float64
+	request       int64
+	window        time.Duration
+	bucket        int
+	ejection      time.Duration
+	maxEjection   time.Duration
+	maxEjected    float64
+	ramp          time.Duration
+}
+
+// WithFailureRate with the failure rate ejecting an endpoint, default 0.5.
+func WithFailureRate(rate float64) Option {
+	return func(o *options) {
+		o.failureRate = rate
+	}
+}
+
+// WithLatencyFactor with the factor of the average latency of the pool
+// ejecting an endpoint, default 0 disables the latency detection.
+func WithLatencyFactor(factor float64) Option {
+	return func(o *options) {
+		o.latencyFactor = factor
+	}
+}
+
+// WithRequest with the min requests of an endpoint in the window before it is checked, default 20.
+func WithRequest(r int64) Option {
+	return func(o *options) {
+		o.request = r
+	}
+}
+
+// WithWindow with the duration size of the statistical window, default 10s.
+func WithWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.window = d
+	}
+}
+
+// WithBucket set the bucket number in a window duration, default 10.
+func WithBucket(b int) Option {
+	return func(o *options) {
+		o.bucket = b
+	}
+}
+
+// WithEjection with the base ejection time, multiplied by the ejections of
+// an endpoint, default 30s. The ejections of an endpoint are decremented once
+// per base ejection time it stays healthy.
+func WithEjection(d time.Duration) Option {
+	return func(o *options) {
+		o.ejection = d
+	}
+}
+
+// WithMaxEjection with the max ejection time, default 5m.
+func WithMaxEjection(d time.Duration) Option {
+	return func(o *options) {
+		o.maxEjection = d
+	}
+}
+
+// WithMaxEjected with the max ratio of the ejected endpoints of the pool, default 0.5.
+func WithMaxEjected(ratio float64) Option {
+	return func(o *options) {
+		o.maxEjected = ratio
+	}
+}
+
+// WithRamp with the duration of reintroducing an ejected endpoint, its
+// traffic grows linearly during it, default 10s.
+func WithRamp(d time.Duration) Option {
+	return func(o *options) {
+		o.ramp = d
+	}
+}
+
+// Stat contains the metrics snapshot of an endpoint.
+type Stat struct {
+	Endpoint     string
+	Requests     int64
+	Failures     int64
+	Latency      time.Duration
+	Ejected      bool
+	Ejections    int
+	EjectedUntil time.Time
+	Weight       float64
+}
+
+type endpoint struct {
+	requests     window.RollingCounter
+	failures     window.RollingCounter
+	latency      window.RollingCounter
+	ejections    int
+	ejectedUntil time.Time
+	// decayedAt is the time the ejections were last decremented.
+	decayedAt time.Time
+}
+
+// Detector detects and ejects the outlier endpoints.
+type Detector struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpoint
+	// pool is the size of the last pool passed to Filter, the max ejected
+	// ratio is of it.
+	pool int
+	r    *rand.Rand
+	opts options
+}
+
+// New returns an outlier detector.
+func New(opts ...Option) *Detector {
+	opt := options{
+		failureRate: 0.5,
+		request:     20,
+		window:      10 * time.Second,
+		bucket:      10,
+		ejection:    30 * time.Second,
+		maxEjection: 5 * time.Minute,
+		maxEjected:  0.5,
+		ramp:        10 * time.Second,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Detector{
+		endpoints: make(map[string]*endpoint),
+		r:         rand.New(rand.NewSource(uint64(time.Now().UnixNano()))),
+		opts:      opt,
+	}
+}
+
+func (d *Detector) endpoint(name string) *endpoint {
+	e, ok := d.endpoints[name]
+	if !ok {
+		e = &endpoint{}
+		d.reset(e)
+		d.endpoints[name] = e
+	}
+	return e
+}
+
+// reset starts the statistics of the endpoint over.
+func (d *Detector) reset(e *endpoint) {
+	counterOpts := window.RollingCounterOpts{
+		Size:           d.opts.bucket,
+		BucketDuration: time.Duration(int64(d.opts.window) / int64(d.opts.bucket)),
+	}
+	e.requests = window.NewRollingCounter(counterOpts)
+	e.failures = window.NewRollingCounter(counterOpts)
+	e.latency = window.NewRollingCounter(counterOpts)
+}
+
+// Report reports the result of a request to the endpoint, which may eject it.
+func (d *Detector) Report(name string, success bool, latency time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	e := d.endpoint(name)
+	d.decay(e, now)
+	e.requests.Add(1)
+	if !success {
+		e.failures.Add(1)
+	}
+	e.latency.Add(int64(latency))
+	if e.ejectedUntil.After(now) || int64(e.requests.Sum()) < d.opts.request {
+		return
+	}
+	if d.outlier(name, e) && d.canEject(now) {
+		d.eject(e, now)
+	}
+}
+
+// outlier reports whether the endpoint is an outlier, it must be called with mu held.
+func (d *Detector) outlier(name string, e *endpoint) bool {
+	requests := e.requests.Sum()
+	if e.failures.Sum()/requests >= d.opts.failureRate {
+		return true
+	}
+	if d.opts.latencyFactor <= 0 {
+		return false
+	}
+	// compare with the average latency of other endpoints
+	var (
+		sum   float64
+		count float64
+	)
+	for other, oe := range d.endpoints {
+		if other == name {
+			continue
+		}
+		if r := oe.requests.Sum(); r > 0 {
+			sum += oe.latency.Sum() / r
+			count++
+		}
+	}
+	if count == 0 {
+		return false
+	}
+	return e.latency.Sum()/requests > d.opts.latencyFactor*sum/count
+}
+
+// canEject reports whether the ejected endpoints are under the max ratio of
+// the pool, it must be called with mu held.
+func (d *Detector) canEject(now time.Time) bool {
+	ejected := 0
+	for _, e := range d.endpoints {
+		if e.ejectedUntil.After(now) {
+			ejected++
+		}
+	}
+	pool := d.pool
+	if pool == 0 {
+		pool = len(d.endpoints)
+	}
+	return float64(ejected+1) <= d.opts.maxEjected*float64(pool)
+}
+
+func (d *Detector) eject(e *endpoint, now time.Time) {
+	e.ejections++
+	ejection := d.opts.ejection * time.Duration(e.ejections)
+	if ejection > d.opts.maxEjection {
+		ejection = d.opts.maxEjection
+	}
+	e.ejectedUntil = now.Add(ejection)
+	// the endpoint starts over after the ejection
+	d.reset(e)
+}
+
+// decay decrements the ejections of the endpoint once per base ejection time
+// since it came back, as Envoy does, it must be called with mu held.
+func (d *Detector) decay(e *endpoint, now time.Time) {
+	if e.ejections == 0 || e.ejectedUntil.After(now) || d.opts.ejection <= 0 {
+		return
+	}
+	since := e.ejectedUntil
+	if e.decayedAt.After(since) {
+		since = e.decayedAt
+	}
+	n := int(now.Sub(since) / d.opts.ejection)
+	if n == 0 {
+		return
+	}
+	if n > e.ejections {
+		n = e.ejections
+	}
+	e.ejections -= n
+	e.decayedAt = since.Add(d.opts.ejection * time.Duration(n))
+}
+
+// weight returns the share of traffic of the endpoint, it must be called with mu held.
+func (d *Detector) weight(e *endpoint, now time.Time) float64 {
+	if e.ejections == 0 {
+		return 1
+	}
+	if e.ejectedUntil.After(now) {
+		return 0
+	}
+	if d.opts.ramp <= 0 {
+		return 1
+	}
+	if w := float64(now.Sub(e.ejectedUntil)) / float64(d.opts.ramp); w < 1 {
+		return w
+	}
+	return 1
+}
+
+// Filter returns the endpoints of the pool not ejected, the reintroduced
+// endpoints are kept by the probability of their weights. The pool is
+// returned as is if all endpoints would be filtered. The max ejected ratio is
+// of the last pool filtered.
+func (d *Detector) Filter(pool []string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pool = len(pool)
+	now := time.Now()
+	healthy := make([]string, 0, len(pool))
+	for _, name := range pool {
+		e, ok := d.endpoints[name]
+		if !ok {
+			healthy = append(healthy, name)
+			continue
+		}
+		if w := d.weight(e, now); w >= 1 || (w > 0 && d.r.Float64() < w) {
+			healthy = append(healthy, name)
+		}
+	}
+	if len(healthy) == 0 {
+		return pool
+	}
+	return healthy
+}
+
+// Remove forgets the endpoint, such as one which left the pool.
+func (d *Detector) Remove(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.endpoints, name)
+}
+
+// Stats returns the metrics of the endpoints.
+func (d *Detector) Stats() []Stat {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	stats := make([]Stat, 0, len(d.endpoints))
+	for name, e := range d.endpoints {
+		d.decay(e, now)
+		stat := Stat{
+			Endpoint:     name,
+			Requests:     int64(e.requests.Sum()),
+			Failures:     int64(e.failures.Sum()),
+			Ejected:      e.ejectedUntil.After(now),
+			Ejections:    e.ejections,
+			EjectedUntil: e.ejectedUntil,
+			Weight:       d.weight(e, now),
+		}
+		if stat.Requests > 0 {
+			stat.Latency = time.Duration(e.latency.Sum() / float64(stat.Requests))
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
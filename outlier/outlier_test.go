@@ -0,0 +1,102 @@
+package outlier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutlierFailureRate(t *testing.T) {
+	d := New(WithRequest(10), WithEjection(time.Hour))
+	for i := 0; i < 10; i++ {
+		d.Report("a", true, time.Millisecond)
+		d.Report("b", i%2 == 0, time.Millisecond)
+		d.Report("c", true, time.Millisecond)
+	}
+	assert.Equal(t, []string{"a", "c"}, d.Filter([]string{"a", "b", "c"}))
+	// endpoints never reported are kept
+	assert.Equal(t, []string{"a", "c", "d"}, d.Filter([]string{"a", "b", "c", "d"}))
+	// the pool is returned as is if all endpoints would be filtered
+	assert.Equal(t, []string{"b"}, d.Filter([]string{"b"}))
+}
+
+func TestOutlierLatency(t *testing.T) {
+	d := New(WithRequest(10), WithLatencyFactor(3))
+	for i := 0; i < 10; i++ {
+		d.Report("a", true, time.Millisecond)
+		d.Report("b", true, time.Millisecond)
+		d.Report("c", true, 10*time.Millisecond)
+	}
+	assert.Equal(t, []string{"a", "b"}, d.Filter([]string{"a", "b", "c"}))
+}
+
+func TestOutlierMaxEjected(t *testing.T) {
+	d := New(WithRequest(2), WithMaxEjected(0.5))
+	d.Report("a", true, 0)
+	d.Report("b", true, 0)
+	d.Report("a", false, 0)
+	d.Report("b", false, 0)
+	// at most half of the pool is ejected
+	assert.Equal(t, []string{"b"}, d.Filter([]string{"a", "b"}))
+}
+
+func TestOutlierReintroduce(t *testing.T) {
+	d := New(WithRequest(1), WithMaxEjected(1), WithEjection(time.Hour), WithMaxEjection(90*time.Minute), WithRamp(time.Minute))
+	d.Report("a", false, 0)
+	stats := d.Stats()
+	assert.Len(t, stats, 1)
+	assert.True(t, stats[0].Ejected)
+	assert.Equal(t, 0.0, stats[0].Weight)
+	assert.Equal(t, int64(0), stats[0].Requests)
+
+	now := time.Now()
+	e := d.endpoints["a"]
+	e.ejectedUntil = now.Add(-30 * time.Second)
+	assert.InDelta(t, 0.5, d.weight(e, now), 0.01)
+	e.ejectedUntil = now.Add(-time.Minute)
+	assert.Equal(t, 1.0, d.weight(e, now))
+
+	// the ejection time grows with the ejections
+	d.Report("a", false, 0)
+	assert.Equal(t, 2, e.ejections)
+	assert.WithinDuration(t, now.Add(90*time.Minute), e.ejectedUntil, time.Second)
+}
+
+func TestOutlierPool(t *testing.T) {
+	d := New(WithRequest(1), WithMaxEjected(0.5))
+	for _, name := range []string{"a", "b", "c", "d"} {
+		d.Report(name, true, 0)
+	}
+	// the endpoints which left the pool don't raise the max ejected
+	d.Remove("c")
+	d.Remove("d")
+	assert.Len(t, d.Stats(), 2)
+	assert.Equal(t, []string{"a", "b"}, d.Filter([]string{"a", "b"}))
+	d.Report("a", false, 0)
+	d.Report("a", false, 0)
+	d.Report("b", false, 0)
+	d.Report("b", false, 0)
+	assert.Equal(t, []string{"b"}, d.Filter([]string{"a", "b"}))
+}
+
+func TestOutlierDecay(t *testing.T) {
+	d := New(WithRequest(1), WithMaxEjected(1), WithEjection(time.Minute), WithMaxEjection(time.Hour))
+	d.Report("a", false, 0)
+	e := d.endpoints["a"]
+	now := time.Now()
+	e.ejections = 3
+	// the ejections decrement once per base ejection time the endpoint is back
+	e.ejectedUntil = now.Add(-150 * time.Second)
+	d.Report("a", true, 0)
+	assert.Equal(t, 1, e.ejections)
+	d.Report("a", true, 0)
+	assert.Equal(t, 1, e.ejections)
+	e.decayedAt = e.decayedAt.Add(-time.Minute)
+	assert.Equal(t, 0, d.Stats()[0].Ejections)
+	// so a recovered endpoint is ejected for the base ejection time again
+	d.Report("a", false, 0)
+	d.Report("a", false, 0)
+	assert.Equal(t, 1, e.ejections)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), e.ejectedUntil, time.Second)
+}
@@ -1,3 +1,6 @@
+// Package bbr implements a BBR-like adaptive limiter, it sheds load when the
+// CPU usage exceeds the threshold and the in-flight requests exceed the
+// max-pass * min-RT estimation of the rolling windows.
 package bbr
 
 import (
@@ -6,15 +9,12 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/zychimne/aegis/internal/cpu"
-	"github.com/zychimne/aegis/internal/window"
+	"github.com/zychimne/aegis/cpu"
 	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/window"
 )
 
 var (
-	gCPU  int64
-	decay = 0.95
-
 	_ ratelimit.Limiter = (*BBR)(nil)
 )
 
@@ -25,36 +25,11 @@ type (
 	Option func(*options)
 )
 
-func init() {
-	go cpuproc()
-}
-
-// cpu = cpuᵗ⁻¹ * decay + cpuᵗ * (1 - decay)
-func cpuproc() {
-	ticker := time.NewTicker(time.Millisecond * 500) // same to cpu sample rate
-	defer func() {
-		ticker.Stop()
-		if err := recover(); err != nil {
-			go cpuproc()
-		}
-	}()
-
-	// EMA algorithm: https://blog.csdn.net/m0_38106113/article/details/81542863
-	for range ticker.C {
-		stat := &cpu.Stat{}
-		cpu.ReadStat(stat)
-		stat.Usage = min(stat.Usage, 1000)
-		prevCPU := atomic.LoadInt64(&gCPU)
-		curCPU := int64(float64(prevCPU)*decay + float64(stat.Usage)*(1.0-decay))
-		atomic.StoreInt64(&gCPU, curCPU)
-	}
-}
-
-func min(l, r uint64) uint64 {
-	if l < r {
-		return l
-	}
-	return r
+// smoothedCPU returns the CPU usage smoothed by EMA.
+func smoothedCPU() int64 {
+	stat := &cpu.Stat{}
+	cpu.ReadStat(stat)
+	return int64(stat.Smoothed)
 }
 
 // Stat contains the metrics snapshot of bbr.
@@ -93,7 +68,7 @@ func WithWindow(d time.Duration) Option {
 	}
 }
 
-// WithBucket with bucket ize.
+// WithBucket with bucket size.
 func WithBucket(b int) Option {
 	return func(o *options) {
 		o.Bucket = b
@@ -154,13 +129,13 @@ func NewLimiter(opts ...Option) *BBR {
 		rtStat:          rtStat,
 		bucketDuration:  bucketDuration,
 		bucketPerSecond: int64(time.Second / bucketDuration),
-		cpu:             func() int64 { return atomic.LoadInt64(&gCPU) },
+		cpu:             smoothedCPU,
 	}
 
 	if opt.CPUQuota != 0 {
 		// if cpuQuota is set, use new cpuGetter,Calculate the real CPU value based on the number of CPUs and Quota.
 		limiter.cpu = func() int64 {
-			return int64(float64(atomic.LoadInt64(&gCPU)) * float64(runtime.NumCPU()) / opt.CPUQuota)
+			return int64(float64(smoothedCPU()) * float64(runtime.NumCPU()) / opt.CPUQuota)
 		}
 	}
 
@@ -277,7 +252,7 @@ func (l *BBR) shouldDrop() bool {
 	return drop
 }
 
-// Stat tasks a snapshot of the bbr limiter.
+// Stat takes a snapshot of the bbr limiter.
 func (l *BBR) Stat() Stat {
 	return Stat{
 		CPU:         l.cpu(),
@@ -289,7 +264,7 @@ func (l *BBR) Stat() Stat {
 }
 
 // Allow checks all inbound traffic.
-// Once overload is detected, it raises limit.ErrLimitExceed error.
+// Once overload is detected, it raises ratelimit.ErrLimitExceed error.
 func (l *BBR) Allow() (ratelimit.DoneFunc, error) {
 	if l.shouldDrop() {
 		return nil, ratelimit.ErrLimitExceed
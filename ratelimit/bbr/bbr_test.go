@@ -7,8 +7,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
-	"github.com/zychimne/aegis/internal/window"
 	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/window"
 	"golang.org/x/exp/rand"
 )
 
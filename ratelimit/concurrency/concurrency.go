@@ -0,0 +1,202 @@
+// Package concurrency implements a semaphore-style limiter capping the
+// in-flight requests instead of the request rate. Requests exceeding the limit
+// wait in a bounded FIFO or LIFO queue until a slot is released or the
+// waiter times out.
+package concurrency
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+var (
+	// ErrWaitTimeout is returned when the request times out in the queue.
+	ErrWaitTimeout = errors.New("concurrency: wait timeout")
+
+	_ ratelimit.Limiter = (*Limiter)(nil)
+)
+
+// Order is the order of waking up waiters.
+type Order int
+
+const (
+	// FIFO wakes up the longest waiting request, it is fair.
+	FIFO Order = iota
+	// LIFO wakes up the latest request, which is most likely to still be
+	// waited by its client when the queue backs up.
+	LIFO
+)
+
+// Option is concurrency limiter option function.
+type Option func(*options)
+
+// options of concurrency limiter.
+type options struct {
+	queue   int
+	order   Order
+	timeout time.Duration
+}
+
+// WithQueue with the max waiting requests, default 0 rejects requests immediately.
+func WithQueue(size int) Option {
+	return func(o *options) {
+		o.queue = size
+	}
+}
+
+// WithOrder with the order of waking up waiters, default FIFO.
+func WithOrder(order Order) Option {
+	return func(o *options) {
+		o.order = order
+	}
+}
+
+// WithTimeout with the max duration a request waits in the queue, default 1s.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// Stat contains the metrics snapshot of concurrency limiter.
+type Stat struct {
+	Limit    int
+	InFlight int
+	Queued   int
+	// Rejected is the requests rejected because the queue is full.
+	Rejected uint64
+	// Timeouts is the requests timed out or canceled in the queue.
+	Timeouts uint64
+}
+
+type waiter struct {
+	ready chan struct{}
+}
+
+// Limiter is a concurrency limiter with bounded queueing.
+type Limiter struct {
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+	waiters  list.List
+	rejected uint64
+	timeouts uint64
+	opts     options
+}
+
+// NewLimiter returns a limiter allowing limit in-flight requests.
+func NewLimiter(limit int, opts ...Option) *Limiter {
+	opt := options{
+		order:   FIFO,
+		timeout: time.Second,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Limiter{
+		limit: limit,
+		opts:  opt,
+	}
+}
+
+// Allow acquires a slot, waiting at most the configured timeout.
+func (l *Limiter) Allow() (ratelimit.DoneFunc, error) {
+	return l.Acquire(context.Background())
+}
+
+// Acquire acquires a slot, waiting until the configured timeout or ctx is done.
+// The returned DoneFunc must be called to release the slot.
+func (l *Limiter) Acquire(ctx context.Context) (ratelimit.DoneFunc, error) {
+	l.mu.Lock()
+	if l.inFlight < l.limit && l.waiters.Len() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.done(), nil
+	}
+	if l.waiters.Len() >= l.opts.queue {
+		l.rejected++
+		l.mu.Unlock()
+		return nil, ratelimit.ErrLimitExceed
+	}
+	w := &waiter{ready: make(chan struct{})}
+	elem := l.waiters.PushBack(w)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.opts.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		return l.done(), nil
+	case <-timer.C:
+		err = ErrWaitTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-w.ready:
+		// the slot was handed over while timing out
+		return l.done(), nil
+	default:
+	}
+	l.waiters.Remove(elem)
+	l.timeouts++
+	return nil, err
+}
+
+// done returns the DoneFunc releasing one slot.
+func (l *Limiter) done() ratelimit.DoneFunc {
+	var once sync.Once
+	return func(ratelimit.DoneInfo) {
+		once.Do(l.release)
+	}
+}
+
+func (l *Limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.wake()
+}
+
+// wake hands over the free slots to waiters, it must be called with mu held.
+func (l *Limiter) wake() {
+	for l.inFlight < l.limit && l.waiters.Len() > 0 {
+		elem := l.waiters.Front()
+		if l.opts.order == LIFO {
+			elem = l.waiters.Back()
+		}
+		l.waiters.Remove(elem)
+		l.inFlight++
+		close(elem.Value.(*waiter).ready)
+	}
+}
+
+// SetLimit updates the max in-flight requests, waiters are woken up if it grows.
+func (l *Limiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.wake()
+}
+
+// Stat returns the metrics snapshot of the limiter.
+func (l *Limiter) Stat() Stat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stat{
+		Limit:    l.limit,
+		InFlight: l.inFlight,
+		Queued:   l.waiters.Len(),
+		Rejected: l.rejected,
+		Timeouts: l.timeouts,
+	}
+}
@@ -0,0 +1,96 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+func TestConcurrencyReject(t *testing.T) {
+	l := NewLimiter(2)
+	done1, err := l.Allow()
+	assert.NoError(t, err)
+	done2, err := l.Allow()
+	assert.NoError(t, err)
+	_, err = l.Allow()
+	assert.Equal(t, ratelimit.ErrLimitExceed, err)
+	done1(ratelimit.DoneInfo{})
+	// calling done twice releases only one slot
+	done1(ratelimit.DoneInfo{})
+	assert.Equal(t, 1, l.Stat().InFlight)
+	done2(ratelimit.DoneInfo{})
+	assert.Equal(t, Stat{Limit: 2, Rejected: 1}, l.Stat())
+}
+
+func TestConcurrencyTimeout(t *testing.T) {
+	l := NewLimiter(1, WithQueue(1), WithTimeout(10*time.Millisecond))
+	done, err := l.Allow()
+	assert.NoError(t, err)
+	_, err = l.Allow()
+	assert.Equal(t, ErrWaitTimeout, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = l.Acquire(ctx)
+	assert.Equal(t, context.Canceled, err)
+	done(ratelimit.DoneInfo{})
+	assert.Equal(t, Stat{Limit: 1, Timeouts: 2}, l.Stat())
+}
+
+func testOrder(t *testing.T, order Order) []int {
+	l := NewLimiter(1, WithQueue(3), WithOrder(order), WithTimeout(time.Second))
+	done, err := l.Allow()
+	assert.NoError(t, err)
+	var (
+		mu  sync.Mutex
+		got []int
+		wg  sync.WaitGroup
+	)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d, err := l.Allow()
+			assert.NoError(t, err)
+			mu.Lock()
+			got = append(got, i)
+			mu.Unlock()
+			d(ratelimit.DoneInfo{})
+		}(i)
+		// enqueue in order
+		for l.Stat().Queued != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	_, err = l.Allow()
+	assert.Equal(t, ratelimit.ErrLimitExceed, err)
+	done(ratelimit.DoneInfo{})
+	wg.Wait()
+	return got
+}
+
+func TestConcurrencyOrder(t *testing.T) {
+	assert.Equal(t, []int{0, 1, 2}, testOrder(t, FIFO))
+	assert.Equal(t, []int{2, 1, 0}, testOrder(t, LIFO))
+}
+
+func TestConcurrencySetLimit(t *testing.T) {
+	l := NewLimiter(1, WithQueue(1))
+	_, err := l.Allow()
+	assert.NoError(t, err)
+	ch := make(chan error)
+	go func() {
+		_, err := l.Allow()
+		ch <- err
+	}()
+	for l.Stat().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	l.SetLimit(2)
+	assert.NoError(t, <-ch)
+	assert.Equal(t, 2, l.Stat().InFlight)
+}
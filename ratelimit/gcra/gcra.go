@@ -0,0 +1,184 @@
+// Package gcra implements the Generic Cell Rate Algorithm, a leaky bucket used
+// as a meter. Only the theoretical arrival time is stored for every key, and
+// every decision comes with precise remaining, retry-after and reset-after
+// results, which are suitable for the Retry-After and RateLimit-* headers.
+package gcra
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+var (
+	_ ratelimit.Limiter = (*Limiter)(nil)
+)
+
+// Option is gcra limiter option function.
+type Option func(*options)
+
+// options of gcra limiter.
+type options struct {
+	burst int
+	key   string
+	sweep time.Duration
+}
+
+// WithBurst with the max requests allowed at once, default 1.
+func WithBurst(burst int) Option {
+	return func(o *options) {
+		o.burst = burst
+	}
+}
+
+// WithKey with the key checked by Allow, default "default".
+func WithKey(key string) Option {
+	return func(o *options) {
+		o.key = key
+	}
+}
+
+// WithSweepInterval with the interval of evicting idle keys, default 1m.
+func WithSweepInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.sweep = d
+	}
+}
+
+// Result is the result of a rate limit decision.
+type Result struct {
+	// Allowed reports whether the requests are allowed.
+	Allowed bool
+	// Limit is the burst of the limiter.
+	Limit int
+	// Remaining is the requests can be allowed immediately after this decision.
+	Remaining int
+	// RetryAfter is the time until the requests would be allowed,
+	// 0 if allowed and -1 if they exceed the burst and will never be allowed.
+	RetryAfter time.Duration
+	// ResetAfter is the time until the limiter returns to its initial state.
+	ResetAfter time.Duration
+}
+
+// Limiter is a GCRA limiter keeping O(1) state per key.
+type Limiter struct {
+	mu sync.Mutex
+	// emission interval between requests
+	emission time.Duration
+	// delay variation tolerance, emission * burst
+	tolerance time.Duration
+	// theoretical arrival time of keys
+	tats    map[string]time.Time
+	sweepAt time.Time
+	opts    options
+}
+
+// NewLimiter returns a limiter allowing rate requests per period for every key,
+// a rate or a burst below 1 is taken as 1, and the interval between requests is
+// at least 1ns.
+func NewLimiter(rate int, period time.Duration, opts ...Option) *Limiter {
+	opt := options{
+		burst: 1,
+		key:   "default",
+		sweep: time.Minute,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if rate < 1 {
+		rate = 1
+	}
+	if opt.burst < 1 {
+		opt.burst = 1
+	}
+	emission := period / time.Duration(rate)
+	if emission < 1 {
+		emission = 1
+	}
+	return &Limiter{
+		emission:  emission,
+		tolerance: emission * time.Duration(opt.burst),
+		tats:      make(map[string]time.Time),
+		opts:      opt,
+	}
+}
+
+// Allow checks the request against the limit of the default key.
+func (l *Limiter) Allow() (ratelimit.DoneFunc, error) {
+	if res := l.AllowN(l.opts.key, 1); !res.Allowed {
+		return nil, ratelimit.ErrLimitExceed
+	}
+	return func(ratelimit.DoneInfo) {}, nil
+}
+
+// AllowKey checks one request of key.
+func (l *Limiter) AllowKey(key string) Result {
+	return l.AllowN(key, 1)
+}
+
+// AllowN checks n requests of key.
+func (l *Limiter) AllowN(key string, n int) Result {
+	return l.allowN(key, n, time.Now())
+}
+
+// PeekN reports the result AllowN would return for n requests of key, without
+// taking the requests.
+func (l *Limiter) PeekN(key string, n int) Result {
+	return l.limit(key, n, time.Now(), false)
+}
+
+func (l *Limiter) allowN(key string, n int, now time.Time) Result {
+	return l.limit(key, n, now, true)
+}
+
+// limit checks n requests of key at now, and takes them if commit is set.
+func (l *Limiter) limit(key string, n int, now time.Time, commit bool) Result {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepIdle(now)
+	tat, ok := l.tats[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+	res := Result{Limit: l.opts.burst}
+	// n beyond the burst is checked first, as its increment may overflow
+	if time.Duration(n) > l.tolerance/l.emission {
+		res.RetryAfter = -1
+		res.ResetAfter = tat.Sub(now)
+		res.Remaining = l.remaining(tat, now)
+		return res
+	}
+	newTat := tat.Add(l.emission * time.Duration(n))
+	if allowAt := newTat.Add(-l.tolerance); now.Before(allowAt) {
+		res.RetryAfter = allowAt.Sub(now)
+		res.ResetAfter = tat.Sub(now)
+		res.Remaining = l.remaining(tat, now)
+		return res
+	}
+	if commit {
+		l.tats[key] = newTat
+	}
+	res.Allowed = true
+	res.ResetAfter = newTat.Sub(now)
+	res.Remaining = l.remaining(newTat, now)
+	return res
+}
+
+// remaining returns the requests can be allowed at now with the tat.
+func (l *Limiter) remaining(tat, now time.Time) int {
+	return int(now.Sub(tat.Add(-l.tolerance)) / l.emission)
+}
+
+// sweepIdle evicts the keys returned to their initial state.
+func (l *Limiter) sweepIdle(now time.Time) {
+	if now.Before(l.sweepAt) {
+		return
+	}
+	l.sweepAt = now.Add(l.opts.sweep)
+	for key, tat := range l.tats {
+		if !tat.After(now) {
+			delete(l.tats, key)
+		}
+	}
+}
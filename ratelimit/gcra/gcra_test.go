@@ -0,0 +1,102 @@
+package gcra
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+func TestGCRA(t *testing.T) {
+	l := NewLimiter(10, time.Second, WithBurst(5))
+	now := time.Unix(100, 0)
+	for i := 0; i < 5; i++ {
+		res := l.allowN("k", 1, now)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 4-i, res.Remaining)
+		assert.Equal(t, time.Duration(i+1)*100*time.Millisecond, res.ResetAfter)
+	}
+	res := l.allowN("k", 1, now)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, 100*time.Millisecond, res.RetryAfter)
+	assert.Equal(t, 500*time.Millisecond, res.ResetAfter)
+
+	// one request is emitted every 100ms
+	res = l.allowN("k", 1, now.Add(100*time.Millisecond))
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	res = l.allowN("k", 2, now.Add(250*time.Millisecond))
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+	assert.Equal(t, 50*time.Millisecond, res.RetryAfter)
+
+	// other keys have their own limit
+	assert.True(t, l.allowN("other", 5, now).Allowed)
+}
+
+func TestGCRAPeek(t *testing.T) {
+	l := NewLimiter(10, time.Second, WithBurst(2))
+	// peeking doesn't take the requests
+	for i := 0; i < 3; i++ {
+		res := l.PeekN("k", 2)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 0, res.Remaining)
+	}
+	assert.True(t, l.AllowN("k", 2).Allowed)
+	assert.False(t, l.PeekN("k", 1).Allowed)
+	assert.False(t, l.AllowN("k", 1).Allowed)
+}
+
+func TestGCRAExceedBurst(t *testing.T) {
+	l := NewLimiter(10, time.Second, WithBurst(5))
+	res := l.AllowN("k", 6)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Duration(-1), res.RetryAfter)
+	assert.Equal(t, 5, res.Remaining)
+	// n whose increment overflows exceeds the burst too
+	res = l.AllowN("k", math.MaxInt64)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Duration(-1), res.RetryAfter)
+}
+
+func TestGCRASweep(t *testing.T) {
+	l := NewLimiter(10, time.Second, WithBurst(5), WithSweepInterval(time.Second))
+	now := time.Unix(100, 0)
+	l.allowN("a", 5, now)
+	l.allowN("b", 1, now.Add(2*time.Second))
+	assert.Len(t, l.tats, 1)
+	assert.Contains(t, l.tats, "b")
+}
+
+func TestGCRAAllow(t *testing.T) {
+	l := NewLimiter(1, time.Hour, WithKey("k"))
+	done, err := l.Allow()
+	assert.NoError(t, err)
+	done(ratelimit.DoneInfo{})
+	_, err = l.Allow()
+	assert.Equal(t, ratelimit.ErrLimitExceed, err)
+	assert.False(t, l.AllowKey("k").Allowed)
+}
+
+func TestGCRAInvalidRate(t *testing.T) {
+	now := time.Now()
+	for _, l := range []*Limiter{NewLimiter(0, time.Second), NewLimiter(-1, time.Second)} {
+		assert.Equal(t, time.Second, l.emission)
+		assert.True(t, l.allowN("k", 1, now).Allowed)
+		assert.False(t, l.allowN("k", 1, now).Allowed)
+	}
+	l := NewLimiter(10, time.Nanosecond, WithBurst(2))
+	assert.Equal(t, time.Duration(1), l.emission)
+	assert.Equal(t, 1, l.allowN("k", 1, now).Remaining)
+	for _, burst := range []int{0, -1} {
+		l := NewLimiter(10, time.Second, WithBurst(burst))
+		assert.Equal(t, 1, l.opts.burst)
+		assert.True(t, l.allowN("k", 1, now).Allowed)
+		res := l.allowN("k", 1, now)
+		assert.False(t, res.Allowed)
+		assert.Equal(t, 100*time.Millisecond, res.RetryAfter)
+	}
+}
@@ -0,0 +1,201 @@
+// Package gradient implements an adaptive concurrency limiter in the style of
+// Netflix Gradient2. The in-flight limit is scaled by the gradient between the
+// long-term and the short-term RTT, it shrinks once requests queue up in the
+// dependency and grows by a queue allowance while the latency is stable.
+package gradient
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/ratelimit/concurrency"
+)
+
+var (
+	_ ratelimit.Limiter = (*Limiter)(nil)
+)
+
+// Option is gradient limiter option function.
+type Option func(*options)
+
+// options of gradient limiter.
+type options struct {
+	initialLimit int
+	minLimit     int
+	maxLimit     int
+	tolerance    float64
+	smoothing    float64
+	window       time.Duration
+	longWindow   int
+}
+
+// WithInitialLimit with the initial in-flight limit, default 20.
+func WithInitialLimit(limit int) Option {
+	return func(o *options) {
+		o.initialLimit = limit
+	}
+}
+
+// WithMinLimit with the min in-flight limit, default 1.
+func WithMinLimit(limit int) Option {
+	return func(o *options) {
+		o.minLimit = limit
+	}
+}
+
+// WithMaxLimit with the max in-flight limit, default 1000.
+func WithMaxLimit(limit int) Option {
+	return func(o *options) {
+		o.maxLimit = limit
+	}
+}
+
+// WithTolerance with the tolerated ratio of short RTT to long RTT before the
+// limit shrinks, default 1.5.
+func WithTolerance(tolerance float64) Option {
+	return func(o *options) {
+		o.tolerance = tolerance
+	}
+}
+
+// WithSmoothing with the weight of a new limit, default 0.2.
+func WithSmoothing(smoothing float64) Option {
+	return func(o *options) {
+		o.smoothing = smoothing
+	}
+}
+
+// WithWindow with the duration of sampling the short RTT, default 100ms.
+func WithWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.window = d
+	}
+}
+
+// WithLongWindow with the windows averaged by the long RTT, default 600.
+func WithLongWindow(windows int) Option {
+	return func(o *options) {
+		o.longWindow = windows
+	}
+}
+
+// Stat contains the metrics snapshot of gradient limiter.
+type Stat struct {
+	Limit    int
+	InFlight int
+	ShortRTT time.Duration
+	LongRTT  time.Duration
+}
+
+// Limiter is a gradient-based adaptive concurrency limiter.
+type Limiter struct {
+	sem *concurrency.Limiter
+
+	mu          sync.Mutex
+	limit       float64
+	shortRTT    float64
+	longRTT     float64
+	longSamples int
+	// samples of the current window
+	sum         float64
+	count       int
+	maxInFlight int
+	windowStart time.Time
+
+	opts options
+}
+
+// NewLimiter returns a gradient limiter.
+func NewLimiter(opts ...Option) *Limiter {
+	opt := options{
+		initialLimit: 20,
+		minLimit:     1,
+		maxLimit:     1000,
+		tolerance:    1.5,
+		smoothing:    0.2,
+		window:       100 * time.Millisecond,
+		longWindow:   600,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Limiter{
+		sem:         concurrency.NewLimiter(opt.initialLimit),
+		limit:       float64(opt.initialLimit),
+		windowStart: time.Now(),
+		opts:        opt,
+	}
+}
+
+// Allow acquires a slot if the in-flight requests are under the limit, the
+// returned DoneFunc must be called to release it and sample the RTT.
+func (l *Limiter) Allow() (ratelimit.DoneFunc, error) {
+	done, err := l.sem.Allow()
+	if err != nil {
+		return nil, err
+	}
+	inFlight := l.sem.Stat().InFlight
+	start := time.Now()
+	return func(di ratelimit.DoneInfo) {
+		done(di)
+		l.sample(time.Since(start), inFlight)
+	}, nil
+}
+
+func (l *Limiter) sample(rtt time.Duration, inFlight int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sum += float64(rtt)
+	l.count++
+	if inFlight > l.maxInFlight {
+		l.maxInFlight = inFlight
+	}
+	now := time.Now()
+	if now.Sub(l.windowStart) < l.opts.window {
+		return
+	}
+	l.update(l.sum/float64(l.count), l.maxInFlight)
+	l.sum, l.count, l.maxInFlight = 0, 0, 0
+	l.windowStart = now
+}
+
+// update adjusts the limit with the short RTT of a window, it must be called with mu held.
+func (l *Limiter) update(shortRTT float64, inFlight int) {
+	l.shortRTT = shortRTT
+	// the long RTT is averaged during warmup and then smoothed by EMA
+	if l.longSamples < l.opts.longWindow {
+		l.longRTT = (l.longRTT*float64(l.longSamples) + shortRTT) / float64(l.longSamples+1)
+		l.longSamples++
+	} else {
+		factor := 2 / float64(l.opts.longWindow+1)
+		l.longRTT = l.longRTT*(1-factor) + shortRTT*factor
+	}
+	// recover quickly from a long RTT raised by a past overload
+	if l.longRTT/shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+	// the limit is not reached, the samples say nothing about it
+	if float64(inFlight) < l.limit/2 {
+		return
+	}
+	gradient := math.Max(0.5, math.Min(1, l.opts.tolerance*l.longRTT/shortRTT))
+	limit := l.limit*gradient + math.Sqrt(l.limit)
+	limit = l.limit*(1-l.opts.smoothing) + limit*l.opts.smoothing
+	limit = math.Max(float64(l.opts.minLimit), math.Min(float64(l.opts.maxLimit), limit))
+	l.limit = limit
+	l.sem.SetLimit(int(limit))
+}
+
+// Stat returns the metrics snapshot of the limiter.
+func (l *Limiter) Stat() Stat {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stat{
+		Limit:    int(l.limit),
+		InFlight: l.sem.Stat().InFlight,
+		ShortRTT: time.Duration(l.shortRTT),
+		LongRTT:  time.Duration(l.longRTT),
+	}
+}
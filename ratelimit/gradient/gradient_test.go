@@ -0,0 +1,55 @@
+package gradient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+func TestGradientGrow(t *testing.T) {
+	l := NewLimiter(WithInitialLimit(10), WithMaxLimit(50))
+	for i := 0; i < 100; i++ {
+		l.update(float64(10*time.Millisecond), int(l.limit))
+	}
+	assert.Equal(t, 50, l.Stat().Limit)
+}
+
+func TestGradientShrink(t *testing.T) {
+	l := NewLimiter(WithInitialLimit(100), WithMinLimit(5), WithMaxLimit(100))
+	for i := 0; i < 10; i++ {
+		l.update(float64(10*time.Millisecond), 100)
+	}
+	assert.Equal(t, 100, l.Stat().Limit)
+	// the latency grows by 4x as requests queue up
+	for i := 0; i < 10; i++ {
+		l.update(float64(40*time.Millisecond), int(l.limit))
+	}
+	stat := l.Stat()
+	assert.Less(t, stat.Limit, 80)
+	assert.GreaterOrEqual(t, stat.Limit, 5)
+	assert.Equal(t, 40*time.Millisecond, stat.ShortRTT)
+}
+
+func TestGradientAppLimited(t *testing.T) {
+	l := NewLimiter(WithInitialLimit(100))
+	for i := 0; i < 10; i++ {
+		l.update(float64(10*time.Millisecond), 10)
+	}
+	assert.Equal(t, 100, l.Stat().Limit)
+}
+
+func TestGradientAllow(t *testing.T) {
+	l := NewLimiter(WithInitialLimit(1), WithWindow(0), WithSmoothing(1))
+	done, err := l.Allow()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, l.Stat().InFlight)
+	_, err = l.Allow()
+	assert.Equal(t, ratelimit.ErrLimitExceed, err)
+	done(ratelimit.DoneInfo{})
+	stat := l.Stat()
+	assert.Equal(t, 0, stat.InFlight)
+	assert.Equal(t, 2, stat.Limit)
+	assert.Greater(t, stat.LongRTT, time.Duration(0))
+}
@@ -0,0 +1,335 @@
+// Package quota implements a client of a central quota service. The rate of
+// every key is allocated by the service and enforced locally by a token bucket,
+// allocation changes are smoothed so the local rates don't jump, and keys fall
+// back to static limits when the service is unreachable.
+package quota
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+var (
+	_ ratelimit.Limiter = (*Limiter)(nil)
+)
+
+// Allocation is the rate allocated to a key.
+type Allocation struct {
+	// Rate is the requests allowed per second.
+	Rate float64
+	// Burst is the max requests allowed at once.
+	Burst int
+}
+
+// Transport fetches the allocations of keys from the quota service, keys
+// missing from the result keep their current allocations.
+type Transport interface {
+	Fetch(ctx context.Context, keys []string) (map[string]Allocation, error)
+}
+
+// TransportFunc is an adapter to allow the use of ordinary functions as Transport.
+type TransportFunc func(ctx context.Context, keys []string) (map[string]Allocation, error)
+
+// Fetch calls f(ctx, keys).
+func (f TransportFunc) Fetch(ctx context.Context, keys []string) (map[string]Allocation, error) {
+	return f(ctx, keys)
+}
+
+// Option is quota limiter option function.
+type Option func(*options)
+
+// options of quota limiter.
+type options struct {
+	refresh   time.Duration
+	timeout   time.Duration
+	staleness time.Duration
+	smoothing float64
+	key       string
+	fallback  Allocation
+	static    map[string]Allocation
+	sweep     time.Duration
+}
+
+// WithRefresh with the interval of fetching the allocations, default 10s,
+// 0 disables the background refresh and Refresh must be called.
+func WithRefresh(d time.Duration) Option {
+	return func(o *options) {
+		o.refresh = d
+	}
+}
+
+// WithTimeout with the timeout of fetching the allocations, default 1s.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithStaleness with the duration an allocation is used after it was fetched,
+// the static limit is used afterwards, default 30s.
+func WithStaleness(d time.Duration) Option {
+	return func(o *options) {
+		o.staleness = d
+	}
+}
+
+// WithSmoothing with the weight of a new allocated rate, default 0.5.
+func WithSmoothing(s float64) Option {
+	return func(o *options) {
+		o.smoothing = s
+	}
+}
+
+// WithKey with the key checked by Allow, default "default".
+func WithKey(key string) Option {
+	return func(o *options) {
+		o.key = key
+	}
+}
+
+// WithFallback with the static limit of keys without their own static limit,
+// default 100 requests per second with a burst of 100.
+func WithFallback(a Allocation) Option {
+	return func(o *options) {
+		o.fallback = a
+	}
+}
+
+// WithStatic with the static limit of key.
+func WithStatic(key string, a Allocation) Option {
+	return func(o *options) {
+		o.static[key] = a
+	}
+}
+
+// WithSweepInterval with the interval of evicting idle keys, default 1m. A key
+// without requests for an interval is evicted once its bucket is refilled,
+// it's no longer fetched and starts with the static limit if seen again.
+func WithSweepInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.sweep = d
+	}
+}
+
+// bucket is the token bucket of a key.
+type bucket struct {
+	allocation Allocation
+	// expiry is the time the allocation goes stale, zero if never allocated.
+	expiry time.Time
+	tokens float64
+	last   time.Time
+}
+
+// Stat is the state of a key.
+type Stat struct {
+	Key        string
+	Allocation Allocation
+	// Static reports whether the static limit is used.
+	Static bool
+}
+
+// Limiter enforces the allocations of the quota service locally.
+type Limiter struct {
+	mu        sync.Mutex
+	transport Transport
+	buckets   map[string]*bucket
+	lastErr   error
+	sweepAt   time.Time
+	stop      chan struct{}
+	stopOnce  sync.Once
+	opts      options
+}
+
+// NewLimiter returns a quota limiter fetching the allocations by transport.
+func NewLimiter(transport Transport, opts ...Option) *Limiter {
+	opt := options{
+		refresh:   10 * time.Second,
+		timeout:   time.Second,
+		staleness: 30 * time.Second,
+		smoothing: 0.5,
+		key:       "default",
+		fallback:  Allocation{Rate: 100, Burst: 100},
+		static:    make(map[string]Allocation),
+		sweep:     time.Minute,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	l := &Limiter{
+		transport: transport,
+		buckets:   make(map[string]*bucket),
+		stop:      make(chan struct{}),
+		opts:      opt,
+	}
+	if opt.refresh > 0 {
+		go l.loop()
+	}
+	return l
+}
+
+func (l *Limiter) loop() {
+	ticker := time.NewTicker(l.opts.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.Refresh(context.Background())
+		}
+	}
+}
+
+// Close stops the background refresh.
+func (l *Limiter) Close() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+}
+
+// Allow checks the request against the quota of the default key.
+func (l *Limiter) Allow() (ratelimit.DoneFunc, error) {
+	if !l.AllowN(l.opts.key, 1) {
+		return nil, ratelimit.ErrLimitExceed
+	}
+	return func(ratelimit.DoneInfo) {}, nil
+}
+
+// AllowKey checks one request of key.
+func (l *Limiter) AllowKey(key string) bool {
+	return l.AllowN(key, 1)
+}
+
+// AllowN checks n requests of key.
+func (l *Limiter) AllowN(key string, n int) bool {
+	return l.allowN(key, n, time.Now())
+}
+
+func (l *Limiter) allowN(key string, n int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepIdle(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		allocation := l.static(key)
+		b = &bucket{allocation: allocation, tokens: float64(allocation.Burst), last: now}
+		l.buckets[key] = b
+	}
+	allocation := l.allocation(key, b, now)
+	b.tokens += now.Sub(b.last).Seconds() * allocation.Rate
+	if b.tokens > float64(allocation.Burst) {
+		b.tokens = float64(allocation.Burst)
+	}
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// sweepIdle evicts the keys without requests within the sweep interval whose
+// buckets are refilled, so they return to their initial state.
+func (l *Limiter) sweepIdle(now time.Time) {
+	if now.Before(l.sweepAt) {
+		return
+	}
+	l.sweepAt = now.Add(l.opts.sweep)
+	for key, b := range l.buckets {
+		if now.Sub(b.last) < l.opts.sweep {
+			continue
+		}
+		allocation := l.allocation(key, b, now)
+		if b.tokens+now.Sub(b.last).Seconds()*allocation.Rate >= float64(allocation.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// allocation returns the allocation of key in effect at now.
+func (l *Limiter) allocation(key string, b *bucket, now time.Time) Allocation {
+	if now.Before(b.expiry) {
+		return b.allocation
+	}
+	return l.static(key)
+}
+
+func (l *Limiter) static(key string) Allocation {
+	if a, ok := l.opts.static[key]; ok {
+		return a
+	}
+	return l.opts.fallback
+}
+
+// Refresh fetches the allocations of the keys seen so far.
+func (l *Limiter) Refresh(ctx context.Context) error {
+	l.mu.Lock()
+	keys := make([]string, 0, len(l.buckets))
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	l.mu.Unlock()
+	if len(keys) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, l.opts.timeout)
+	defer cancel()
+	allocations, err := l.transport.Fetch(ctx, keys)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastErr = err
+	if err != nil {
+		return err
+	}
+	l.update(allocations, time.Now())
+	return nil
+}
+
+// update applies the fetched allocations, the rates of keys with allocations
+// in effect move toward the fetched rates.
+func (l *Limiter) update(allocations map[string]Allocation, now time.Time) {
+	for key, a := range allocations {
+		b, ok := l.buckets[key]
+		if !ok {
+			continue
+		}
+		if now.Before(b.expiry) {
+			a.Rate = b.allocation.Rate + l.opts.smoothing*(a.Rate-b.allocation.Rate)
+		}
+		b.allocation = a
+		b.expiry = now.Add(l.opts.staleness)
+	}
+}
+
+// Err returns the error of the last refresh.
+func (l *Limiter) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastErr
+}
+
+// Forget forgets key, its allocation is no longer fetched.
+func (l *Limiter) Forget(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, key)
+}
+
+// Stats returns the state of the keys.
+func (l *Limiter) Stats() []Stat {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make([]Stat, 0, len(l.buckets))
+	for key, b := range l.buckets {
+		stats = append(stats, Stat{
+			Key:        key,
+			Allocation: l.allocation(key, b, now),
+			Static:     !now.Before(b.expiry),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,84 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+func TestQuotaStatic(t *testing.T) {
+	l := NewLimiter(nil, WithRefresh(0), WithFallback(Allocation{Rate: 10, Burst: 2}), WithStatic("vip", Allocation{Rate: 10, Burst: 5}))
+	now := time.Now()
+	assert.True(t, l.allowN("a", 2, now))
+	assert.False(t, l.allowN("a", 1, now))
+	assert.True(t, l.allowN("vip", 5, now))
+	// 10 requests per second refills a token every 100ms
+	assert.True(t, l.allowN("a", 1, now.Add(100*time.Millisecond)))
+	assert.False(t, l.allowN("a", 1, now.Add(100*time.Millisecond)))
+}
+
+func TestQuotaRefresh(t *testing.T) {
+	var fail bool
+	transport := TransportFunc(func(ctx context.Context, keys []string) (map[string]Allocation, error) {
+		if fail {
+			return nil, errors.New("unreachable")
+		}
+		allocations := make(map[string]Allocation)
+		for _, key := range keys {
+			allocations[key] = Allocation{Rate: 1000, Burst: 10}
+		}
+		return allocations, nil
+	})
+	l := NewLimiter(transport, WithRefresh(0), WithStaleness(time.Hour), WithFallback(Allocation{Rate: 1, Burst: 1}))
+	assert.True(t, l.AllowKey("a"))
+	assert.False(t, l.AllowKey("a"))
+
+	assert.Nil(t, l.Refresh(context.Background()))
+	stats := l.Stats()
+	assert.Equal(t, []Stat{{Key: "a", Allocation: Allocation{Rate: 1000, Burst: 10}}}, stats)
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, l.AllowN("a", 10))
+
+	// allocations in effect are smoothed
+	l.update(map[string]Allocation{"a": {Rate: 500, Burst: 10}}, time.Now())
+	assert.Equal(t, 750.0, l.Stats()[0].Allocation.Rate)
+
+	// the allocations are kept until they go stale
+	fail = true
+	assert.NotNil(t, l.Refresh(context.Background()))
+	assert.NotNil(t, l.Err())
+	assert.False(t, l.Stats()[0].Static)
+	l.buckets["a"].expiry = time.Now()
+	assert.Equal(t, []Stat{{Key: "a", Allocation: Allocation{Rate: 1, Burst: 1}, Static: true}}, l.Stats())
+}
+
+func TestQuotaSweepIdle(t *testing.T) {
+	l := NewLimiter(nil, WithRefresh(0), WithSweepInterval(time.Minute), WithFallback(Allocation{Rate: 1, Burst: 2}))
+	now := time.Now()
+	assert.True(t, l.allowN("a", 1, now))
+	assert.True(t, l.allowN("b", 2, now))
+	// both are idle for a sweep interval, a is refilled but b is not at its
+	// allocated rate
+	l.buckets["b"].allocation = Allocation{Rate: 0.01, Burst: 2}
+	l.buckets["b"].expiry = now.Add(time.Hour)
+	assert.True(t, l.allowN("c", 1, now.Add(time.Minute)))
+	_, ok := l.buckets["a"]
+	assert.False(t, ok)
+	_, ok = l.buckets["b"]
+	assert.True(t, ok)
+	assert.Len(t, l.Stats(), 2)
+}
+
+func TestQuotaAllow(t *testing.T) {
+	l := NewLimiter(nil, WithRefresh(0), WithKey("tenant"), WithStatic("tenant", Allocation{Rate: 1, Burst: 1}))
+	defer l.Close()
+	done, err := l.Allow()
+	assert.Nil(t, err)
+	done(ratelimit.DoneInfo{})
+	_, err = l.Allow()
+	assert.Equal(t, ratelimit.ErrLimitExceed, err)
+}
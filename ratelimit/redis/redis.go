@@ -0,0 +1,234 @@
+// Package redis implements a distributed sliding-window limiter backed by redis,
+// so a fleet can enforce a global per-key QPS cap. The sliding window is
+// approximated by weighting the previous fixed window, which needs O(1) memory
+// per key, and the check-and-increment runs atomically in a lua script.
+// When redis is unreachable, requests are checked by a local sliding window.
+package redis
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+// script checks and increments the sliding window counter.
+// KEYS[1] current window, KEYS[2] previous window.
+// ARGV[1] limit, ARGV[2] window in ms, ARGV[3] elapsed ms of current window, ARGV[4] n.
+const script = `
+local cur = tonumber(redis.call('GET', KEYS[1]) or '0')
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[4])
+if prev * (window - tonumber(ARGV[3])) / window + cur + n > limit then
+	return 0
+end
+redis.call('INCRBY', KEYS[1], n)
+redis.call('PEXPIRE', KEYS[1], window * 2)
+return 1
+`
+
+// Client evaluates lua scripts on redis, go-redis can be adapted by
+//
+//	func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//		return rdb.Eval(ctx, script, keys, args...).Result()
+//	}
+type Client interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// ClientFunc is an adapter to allow the use of ordinary functions as Client.
+type ClientFunc func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+
+// Eval calls f(ctx, script, keys, args...).
+func (f ClientFunc) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return f(ctx, script, keys, args...)
+}
+
+// Option is redis limiter option function.
+type Option func(*options)
+
+var (
+	_ ratelimit.Limiter = (*Limiter)(nil)
+)
+
+// options of redis limiter.
+type options struct {
+	window   time.Duration
+	prefix   string
+	key      string
+	fallback int64
+	timeout  time.Duration
+}
+
+// WithWindow with the duration of sliding window, default 1s, it's rounded
+// down to milliseconds and at least 1ms.
+func WithWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.window = d
+	}
+}
+
+// WithPrefix with the prefix of redis keys, default "aegis:ratelimit:".
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithKey with the key checked by Allow, default "default".
+func WithKey(key string) Option {
+	return func(o *options) {
+		o.key = key
+	}
+}
+
+// WithFallback with the local limit per window used when redis is unreachable,
+// default is the global limit, divide it by the fleet size for a tighter bound.
+func WithFallback(limit int64) Option {
+	return func(o *options) {
+		o.fallback = limit
+	}
+}
+
+// WithTimeout with the timeout of redis calls made by Allow, default 100ms.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// Limiter is a redis-backed sliding-window limiter.
+type Limiter struct {
+	client Client
+	limit  int64
+	local  *localWindows
+	opts   options
+}
+
+// NewLimiter returns a limiter allowing limit requests per window for every key.
+func NewLimiter(client Client, limit int64, opts ...Option) *Limiter {
+	opt := options{
+		window:   time.Second,
+		prefix:   "aegis:ratelimit:",
+		key:      "default",
+		fallback: limit,
+		timeout:  100 * time.Millisecond,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.window < time.Millisecond {
+		opt.window = time.Millisecond
+	}
+	return &Limiter{
+		client: client,
+		limit:  limit,
+		local:  newLocalWindows(opt.window, opt.fallback),
+		opts:   opt,
+	}
+}
+
+// Allow checks the request against the limit of the default key.
+func (l *Limiter) Allow() (ratelimit.DoneFunc, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.opts.timeout)
+	defer cancel()
+	if err := l.AllowN(ctx, l.opts.key, 1); err != nil {
+		return nil, err
+	}
+	return func(ratelimit.DoneInfo) {}, nil
+}
+
+// AllowKey checks one request of key against the global limit.
+func (l *Limiter) AllowKey(ctx context.Context, key string) error {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN checks n requests of key against the global limit, it returns
+// ratelimit.ErrLimitExceed if they are rejected. If redis is unreachable,
+// the local fallback limit is checked instead.
+func (l *Limiter) AllowN(ctx context.Context, key string, n int64) error {
+	now := time.Now()
+	window := l.opts.window.Milliseconds()
+	ms := now.UnixMilli()
+	idx := ms / window
+	keys := []string{
+		l.opts.prefix + key + ":" + strconv.FormatInt(idx, 10),
+		l.opts.prefix + key + ":" + strconv.FormatInt(idx-1, 10),
+	}
+	res, err := l.client.Eval(ctx, script, keys, l.limit, window, ms-idx*window, n)
+	if err != nil {
+		return l.local.allow(key, n, now)
+	}
+	if allowed, ok := res.(int64); ok && allowed == 1 {
+		return nil
+	}
+	return ratelimit.ErrLimitExceed
+}
+
+// localWindows are the sliding windows of keys used when redis is unreachable.
+type localWindows struct {
+	mu      sync.Mutex
+	window  time.Duration
+	limit   int64
+	windows map[string]*localWindow
+	// sweepIdx is the window the idle keys were last evicted in.
+	sweepIdx int64
+}
+
+type localWindow struct {
+	idx  int64
+	cur  int64
+	prev int64
+}
+
+func newLocalWindows(window time.Duration, limit int64) *localWindows {
+	return &localWindows{
+		window:  window,
+		limit:   limit,
+		windows: make(map[string]*localWindow),
+	}
+}
+
+func (l *localWindows) allow(key string, n int64, now time.Time) error {
+	window := l.window.Milliseconds()
+	ms := now.UnixMilli()
+	idx := ms / window
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.windows[key]
+	if !ok {
+		l.sweepIdle(idx)
+		w = &localWindow{idx: idx}
+		l.windows[key] = w
+	}
+	switch {
+	case w.idx == idx-1:
+		w.idx, w.prev, w.cur = idx, w.cur, 0
+	case w.idx < idx-1:
+		w.idx, w.prev, w.cur = idx, 0, 0
+	}
+	elapsed := ms - idx*window
+	if float64(w.prev)*float64(window-elapsed)/float64(window)+float64(w.cur+n) > float64(l.limit) {
+		return ratelimit.ErrLimitExceed
+	}
+	w.cur += n
+	return nil
+}
+
+// sweepIdle evicts the keys idle for more than one window, at most once per
+// window so the scan is amortized over the requests of the window.
+func (l *localWindows) sweepIdle(idx int64) {
+	if idx <= l.sweepIdx {
+		return
+	}
+	l.sweepIdx = idx
+	for k, w := range l.windows {
+		if w.idx < idx-1 {
+			delete(l.windows, k)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/ratelimit"
+)
+
+// fakeRedis emulates the lua script in memory.
+type fakeRedis struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	err    error
+}
+
+func (f *fakeRedis) Eval(_ context.Context, _ string, keys []string, args ...interface{}) (interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	limit, window, elapsed, n := args[0].(int64), args[1].(int64), args[2].(int64), args[3].(int64)
+	cur, prev := f.counts[keys[0]], f.counts[keys[1]]
+	if float64(prev)*float64(window-elapsed)/float64(window)+float64(cur+n) > float64(limit) {
+		return int64(0), nil
+	}
+	f.counts[keys[0]] += n
+	return int64(1), nil
+}
+
+func TestRedisLimiter(t *testing.T) {
+	client := &fakeRedis{counts: make(map[string]int64)}
+	l := NewLimiter(client, 10, WithWindow(time.Hour))
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, l.AllowKey(context.Background(), "tenant"))
+	}
+	assert.Equal(t, ratelimit.ErrLimitExceed, l.AllowKey(context.Background(), "tenant"))
+	// other keys have their own limit
+	assert.NoError(t, l.AllowKey(context.Background(), "other"))
+	assert.Equal(t, ratelimit.ErrLimitExceed, l.AllowN(context.Background(), "other", 10))
+	for key := range client.counts {
+		assert.Contains(t, key, "aegis:ratelimit:")
+	}
+}
+
+func TestRedisLimiterAllow(t *testing.T) {
+	client := &fakeRedis{counts: make(map[string]int64)}
+	l := NewLimiter(client, 1, WithWindow(time.Hour), WithPrefix("p:"), WithKey("k"))
+	done, err := l.Allow()
+	assert.NoError(t, err)
+	done(ratelimit.DoneInfo{})
+	_, err = l.Allow()
+	assert.Equal(t, ratelimit.ErrLimitExceed, err)
+	idx := time.Now().UnixMilli() / time.Hour.Milliseconds()
+	assert.Equal(t, int64(1), client.counts["p:k:"+strconv.FormatInt(idx, 10)])
+}
+
+func TestRedisLimiterFallback(t *testing.T) {
+	client := &fakeRedis{counts: make(map[string]int64), err: errors.New("connection refused")}
+	l := NewLimiter(client, 10, WithWindow(time.Hour), WithFallback(2))
+	assert.NoError(t, l.AllowKey(context.Background(), "tenant"))
+	assert.NoError(t, l.AllowKey(context.Background(), "tenant"))
+	assert.Equal(t, ratelimit.ErrLimitExceed, l.AllowKey(context.Background(), "tenant"))
+	assert.NoError(t, l.AllowKey(context.Background(), "other"))
+}
+
+func TestLocalWindowsSlide(t *testing.T) {
+	w := newLocalWindows(time.Second, 10)
+	start := time.UnixMilli(10_000)
+	assert.NoError(t, w.allow("k", 10, start))
+	assert.Equal(t, ratelimit.ErrLimitExceed, w.allow("k", 1, start.Add(500*time.Millisecond)))
+	// half of the previous window is weighted in
+	assert.NoError(t, w.allow("k", 5, start.Add(1500*time.Millisecond)))
+	assert.Equal(t, ratelimit.ErrLimitExceed, w.allow("k", 1, start.Add(1500*time.Millisecond)))
+	// idle windows are forgotten
+	assert.NoError(t, w.allow("k", 10, start.Add(5*time.Second)))
+}
+
+func TestLocalWindowsSweepIdle(t *testing.T) {
+	w := newLocalWindows(time.Second, 10)
+	start := time.UnixMilli(10_000)
+	assert.NoError(t, w.allow("a", 1, start))
+	assert.NoError(t, w.allow("b", 1, start.Add(2*time.Second)))
+	assert.Len(t, w.windows, 1)
+	assert.NoError(t, w.allow("c", 1, start.Add(4*time.Second)))
+	assert.Len(t, w.windows, 1)
+	// the idle keys are swept once per window
+	w.windows["c"].idx = 0
+	assert.NoError(t, w.allow("d", 1, start.Add(4*time.Second)))
+	assert.Len(t, w.windows, 2)
+}
+
+func TestRedisLimiterWindow(t *testing.T) {
+	client := &fakeRedis{counts: make(map[string]int64), err: errors.New("connection refused")}
+	l := NewLimiter(client, 1, WithWindow(time.Microsecond))
+	assert.Equal(t, time.Millisecond, l.opts.window)
+	assert.NoError(t, l.AllowKey(context.Background(), "k"))
+}
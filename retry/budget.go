@@ -0,0 +1,120 @@
+// Package retry implements a retry budget, which caps the retries to a ratio
+// of the recent successful requests, so clients don't amplify an outage with
+// retry storms, and hedged requests withdrawing from the budget.
+package retry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/window"
+)
+
+// Option is retry budget option function.
+type Option func(*options)
+
+// options of retry budget.
+type options struct {
+	ratio      float64
+	minRetries float64
+	window     time.Duration
+	bucket     int
+}
+
+// WithRatio with the max ratio of retries to successful requests, default 0.1.
+func WithRatio(ratio float64) Option {
+	return func(o *options) {
+		o.ratio = ratio
+	}
+}
+
+// WithMinRetries with the retries per second always allowed, so low-traffic
+// clients can still retry, default 10.
+func WithMinRetries(perSecond float64) Option {
+	return func(o *options) {
+		o.minRetries = perSecond
+	}
+}
+
+// WithWindow with the duration size of the statistical window, default 10s.
+func WithWindow(d time.Duration) Option {
+	return func(o *options) {
+		o.window = d
+	}
+}
+
+// WithBucket set the bucket number in a window duration, default 10.
+func WithBucket(b int) Option {
+	return func(o *options) {
+		o.bucket = b
+	}
+}
+
+// Stat contains the metrics snapshot of retry budget.
+type Stat struct {
+	Successes int64
+	Retries   int64
+	Rejected  uint64
+}
+
+// Budget is a retry budget.
+type Budget struct {
+	mu        sync.Mutex
+	successes window.RollingCounter
+	retries   window.RollingCounter
+	rejected  uint64
+	opts      options
+}
+
+// NewBudget returns a retry budget.
+func NewBudget(opts ...Option) *Budget {
+	opt := options{
+		ratio:      0.1,
+		minRetries: 10,
+		window:     10 * time.Second,
+		bucket:     10,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+	counterOpts := window.RollingCounterOpts{
+		Size:           opt.bucket,
+		BucketDuration: time.Duration(int64(opt.window) / int64(opt.bucket)),
+	}
+	return &Budget{
+		successes: window.NewRollingCounter(counterOpts),
+		retries:   window.NewRollingCounter(counterOpts),
+		opts:      opt,
+	}
+}
+
+// RecordAttempt records a finished attempt, successful attempts deposit into the budget.
+func (b *Budget) RecordAttempt(success bool) {
+	if success {
+		b.successes.Add(1)
+	}
+}
+
+// CanRetry reports whether a retry is allowed, and withdraws it from the budget if so.
+func (b *Budget) CanRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	allowed := b.opts.minRetries*b.opts.window.Seconds() + b.opts.ratio*b.successes.Sum()
+	if b.retries.Sum() >= allowed {
+		b.rejected++
+		return false
+	}
+	b.retries.Add(1)
+	return true
+}
+
+// Stat returns the metrics snapshot of the budget.
+func (b *Budget) Stat() Stat {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Stat{
+		Successes: int64(b.successes.Sum()),
+		Retries:   int64(b.retries.Sum()),
+		Rejected:  b.rejected,
+	}
+}
@@ -0,0 +1,45 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(WithMinRetries(0), WithWindow(time.Hour))
+	assert.False(t, b.CanRetry())
+	for i := 0; i < 100; i++ {
+		b.RecordAttempt(true)
+		b.RecordAttempt(false)
+	}
+	for i := 0; i < 10; i++ {
+		assert.True(t, b.CanRetry())
+	}
+	assert.False(t, b.CanRetry())
+	assert.Equal(t, Stat{Successes: 100, Retries: 10, Rejected: 2}, b.Stat())
+}
+
+func TestBudgetMinRetries(t *testing.T) {
+	b := NewBudget(WithMinRetries(1), WithRatio(0.5), WithWindow(2*time.Second), WithBucket(2))
+	assert.True(t, b.CanRetry())
+	assert.True(t, b.CanRetry())
+	assert.False(t, b.CanRetry())
+	b.RecordAttempt(true)
+	b.RecordAttempt(true)
+	assert.True(t, b.CanRetry())
+	assert.False(t, b.CanRetry())
+}
+
+func TestBudgetWindow(t *testing.T) {
+	b := NewBudget(WithMinRetries(0), WithRatio(1), WithWindow(100*time.Millisecond), WithBucket(2))
+	b.RecordAttempt(true)
+	assert.True(t, b.CanRetry())
+	assert.False(t, b.CanRetry())
+	time.Sleep(150 * time.Millisecond)
+	// the successes expire with the window
+	assert.False(t, b.CanRetry())
+	b.RecordAttempt(true)
+	assert.True(t, b.CanRetry())
+}
@@ -0,0 +1,149 @@
+package retry
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HedgeOption is hedge option function.
+type HedgeOption func(*hedgeOptions)
+
+// hedgeOptions of hedge.
+type hedgeOptions struct {
+	budget  *Budget
+	latency *Latency
+}
+
+// WithHedgeBudget with the retry budget, every hedged attempt withdraws a retry
+// from it, so hedges can't amplify overload.
+func WithHedgeBudget(b *Budget) HedgeOption {
+	return func(o *hedgeOptions) {
+		o.budget = b
+	}
+}
+
+// WithHedgeLatency with the latency tracker recording the latency of the
+// successful attempt, use its percentile as the delay of hedging.
+func WithHedgeLatency(l *Latency) HedgeOption {
+	return func(o *hedgeOptions) {
+		o.latency = l
+	}
+}
+
+type hedgeResult[T any] struct {
+	val T
+	err error
+}
+
+// Hedge calls fn, and issues another attempt if none of the attempts has
+// succeeded within delay, or once an attempt fails, at most maxAttempts in
+// total. The first successful result is returned and the other attempts are
+// canceled, otherwise the error of the last attempt is returned. fn is called
+// at least once, a maxAttempts below 1 is taken as 1.
+func Hedge[T any](ctx context.Context, delay time.Duration, maxAttempts int, fn func(ctx context.Context) (T, error), opts ...HedgeOption) (T, error) {
+	var opt hedgeOptions
+	for _, o := range opts {
+		o(&opt)
+	}
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult[T], maxAttempts)
+	attempt := func() {
+		start := time.Now()
+		val, err := fn(ctx)
+		if err == nil && opt.latency != nil {
+			opt.latency.Record(time.Since(start))
+		}
+		results <- hedgeResult[T]{val: val, err: err}
+	}
+	go attempt()
+	attempts, inFlight := 1, 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var res hedgeResult[T]
+	for {
+		hedge := false
+		select {
+		case res = <-results:
+			inFlight--
+			if res.err == nil {
+				if opt.budget != nil {
+					opt.budget.RecordAttempt(true)
+				}
+				return res.val, nil
+			}
+			hedge = true
+		case <-timer.C:
+			hedge = true
+		case <-ctx.Done():
+			res.err = ctx.Err()
+			if opt.budget != nil {
+				opt.budget.RecordAttempt(false)
+			}
+			return res.val, res.err
+		}
+		if hedge && attempts < maxAttempts && (opt.budget == nil || opt.budget.CanRetry()) {
+			go attempt()
+			attempts++
+			inFlight++
+			timer.Reset(delay)
+		}
+		if inFlight == 0 {
+			if opt.budget != nil {
+				opt.budget.RecordAttempt(false)
+			}
+			return res.val, res.err
+		}
+	}
+}
+
+// Latency tracks the latencies of the recent requests to derive the delay of hedging.
+type Latency struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+// NewLatency returns a latency tracker of the recent size requests.
+func NewLatency(size int) *Latency {
+	return &Latency{samples: make([]time.Duration, size)}
+}
+
+// Record records the latency of a request.
+func (l *Latency) Record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.next] = d
+	l.next++
+	if l.next == len(l.samples) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Percentile returns the p-th percentile of the recent latencies, p in [0, 1],
+// or def if nothing is recorded.
+func (l *Latency) Percentile(p float64, def time.Duration) time.Duration {
+	l.mu.Lock()
+	n := l.next
+	if l.full {
+		n = len(l.samples)
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, l.samples[:n])
+	l.mu.Unlock()
+	if n == 0 {
+		return def
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(p * float64(n-1))
+	return samples[idx]
+}
@@ -0,0 +1,94 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedge(t *testing.T) {
+	var calls int32
+	val, err := Hedge(context.Background(), 10*time.Millisecond, 3, func(ctx context.Context) (int, error) {
+		n := atomic.AddInt32(&calls, 1)
+		if n == 1 {
+			// the first attempt is slow and canceled by the hedge
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return int(n), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, val)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestHedgeFailure(t *testing.T) {
+	var calls int32
+	errFailed := errors.New("failed")
+	_, err := Hedge(context.Background(), time.Hour, 3, func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, errFailed
+	})
+	// failed attempts are hedged immediately
+	assert.Equal(t, errFailed, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestHedgeMaxAttempts(t *testing.T) {
+	// fn is called once whatever the max attempts below 1
+	for _, maxAttempts := range []int{0, -1} {
+		var calls int32
+		val, err := Hedge(context.Background(), time.Millisecond, maxAttempts, func(ctx context.Context) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			return 1, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, val)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	}
+}
+
+func TestHedgeBudget(t *testing.T) {
+	b := NewBudget(WithMinRetries(0), WithWindow(time.Hour))
+	var calls int32
+	_, err := Hedge(context.Background(), time.Millisecond, 3, func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return 0, nil
+	}, WithHedgeBudget(b))
+	assert.NoError(t, err)
+	// no hedge is allowed by an empty budget
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, Stat{Successes: 1, Rejected: 1}, b.Stat())
+}
+
+func TestHedgeContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := Hedge(ctx, time.Hour, 2, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestLatency(t *testing.T) {
+	l := NewLatency(4)
+	assert.Equal(t, time.Second, l.Percentile(0.9, time.Second))
+	for i := 1; i <= 6; i++ {
+		l.Record(time.Duration(i) * time.Millisecond)
+	}
+	// only the recent 4 latencies are kept
+	assert.Equal(t, 3*time.Millisecond, l.Percentile(0, 0))
+	assert.Equal(t, 6*time.Millisecond, l.Percentile(1, 0))
+
+	_, err := Hedge(context.Background(), time.Hour, 1, func(ctx context.Context) (int, error) {
+		return 0, nil
+	}, WithHedgeLatency(l))
+	assert.NoError(t, err)
+	assert.Less(t, l.Percentile(0, 0), time.Millisecond)
+}
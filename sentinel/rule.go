@@ -0,0 +1,193 @@
+// Package sentinel translates the flow, circuit breaking and hot-spot param
+// rules of Alibaba Sentinel into aegis components, so the rules of
+// sentinel-golang can be reused when migrating to aegis. Rules are read in
+// the JSON format of the sentinel datasources, from a file or from Nacos.
+package sentinel
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Kind is the kind of rules of a datasource.
+type Kind int
+
+const (
+	// KindFlow is the flow rules.
+	KindFlow Kind = iota
+	// KindCircuitBreaker is the circuit breaking rules, known as degrade rules.
+	KindCircuitBreaker
+	// KindHotSpot is the hot-spot param rules.
+	KindHotSpot
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindFlow:
+		return "flow"
+	case KindCircuitBreaker:
+		return "circuitbreaker"
+	case KindHotSpot:
+		return "hotspot"
+	}
+	return "unknown"
+}
+
+// Token calculate strategies of flow rules.
+const (
+	Direct = iota
+	WarmUp
+	MemoryAdaptive
+)
+
+// Control behaviors of flow and hot-spot rules.
+const (
+	Reject = iota
+	Throttling
+)
+
+// Relation strategies of flow rules.
+const (
+	CurrentResource = iota
+	AssociatedResource
+)
+
+// Strategies of circuit breaking rules.
+const (
+	SlowRequestRatio = iota
+	ErrorRatio
+	ErrorCount
+)
+
+// Metric types of hot-spot rules.
+const (
+	Concurrency = iota
+	QPS
+)
+
+// FlowRule is the flow rule of sentinel.
+type FlowRule struct {
+	ID                     string  `json:"id,omitempty"`
+	Resource               string  `json:"resource"`
+	TokenCalculateStrategy int     `json:"tokenCalculateStrategy"`
+	ControlBehavior        int     `json:"controlBehavior"`
+	Threshold              float64 `json:"threshold"`
+	RelationStrategy       int     `json:"relationStrategy"`
+	RefResource            string  `json:"refResource"`
+	MaxQueueingTimeMs      uint32  `json:"maxQueueingTimeMs"`
+	WarmUpPeriodSec        uint32  `json:"warmUpPeriodSec"`
+	WarmUpColdFactor       uint32  `json:"warmUpColdFactor"`
+	StatIntervalInMs       uint32  `json:"statIntervalInMs"`
+}
+
+// CircuitBreakerRule is the circuit breaking rule of sentinel.
+type CircuitBreakerRule struct {
+	ID                           string  `json:"id,omitempty"`
+	Resource                     string  `json:"resource"`
+	Strategy                     int     `json:"strategy"`
+	RetryTimeoutMs               uint32  `json:"retryTimeoutMs"`
+	MinRequestAmount             uint64  `json:"minRequestAmount"`
+	StatIntervalMs               uint32  `json:"statIntervalMs"`
+	StatSlidingWindowBucketCount uint32  `json:"statSlidingWindowBucketCount"`
+	MaxAllowedRtMs               uint64  `json:"maxAllowedRtMs"`
+	Threshold                    float64 `json:"threshold"`
+	ProbeNum                     uint64  `json:"probeNum"`
+}
+
+// HotSpotRule is the hot-spot param rule of sentinel.
+type HotSpotRule struct {
+	ID                string           `json:"id,omitempty"`
+	Resource          string           `json:"resource"`
+	MetricType        int              `json:"metricType"`
+	ControlBehavior   int              `json:"controlBehavior"`
+	ParamIndex        int              `json:"paramIndex"`
+	ParamKey          string           `json:"paramKey"`
+	Threshold         int64            `json:"threshold"`
+	MaxQueueingTimeMs int64            `json:"maxQueueingTimeMs"`
+	BurstCount        int64            `json:"burstCount"`
+	DurationInSec     int64            `json:"durationInSec"`
+	ParamsMaxCapacity int64            `json:"paramsMaxCapacity"`
+	SpecificItems     map[string]int64 `json:"specificItems"`
+}
+
+// Rules is the rules of all kinds.
+type Rules struct {
+	Flow           []*FlowRule
+	CircuitBreaker []*CircuitBreakerRule
+	HotSpot        []*HotSpotRule
+}
+
+// Parse replace the rules of kind with the JSON array of rules in data.
+func (r *Rules) Parse(kind Kind, data []byte) error {
+	var err error
+	switch kind {
+	case KindFlow:
+		var rules []*FlowRule
+		if err = json.Unmarshal(data, &rules); err == nil {
+			r.Flow = rules
+		}
+	case KindCircuitBreaker:
+		var rules []*CircuitBreakerRule
+		if err = json.Unmarshal(data, &rules); err == nil {
+			r.CircuitBreaker = rules
+		}
+	case KindHotSpot:
+		var rules []*HotSpotRule
+		if err = json.Unmarshal(data, &rules); err == nil {
+			r.HotSpot = rules
+		}
+	default:
+		return fmt.Errorf("sentinel: unknown rule kind %d", kind)
+	}
+	if err != nil {
+		return fmt.Errorf("sentinel: parse %s rules: %w", kind, err)
+	}
+	return nil
+}
+
+// LoadFile replace the rules of kind with the rules of the file at path.
+func (r *Rules) LoadFile(kind Kind, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.Parse(kind, data)
+}
+
+// NacosClient reads configs from Nacos, the config client of nacos-sdk-go can
+// be adapted by
+//
+//	type nacos struct{ client config_client.IConfigClient }
+//
+//	func (n nacos) GetConfig(dataID, group string) (string, error) {
+//		return n.client.GetConfig(vo.ConfigParam{DataId: dataID, Group: group})
+//	}
+//
+//	func (n nacos) ListenConfig(dataID, group string, onChange func(data string)) error {
+//		return n.client.ListenConfig(vo.ConfigParam{DataId: dataID, Group: group,
+//			OnChange: func(_, _, _, data string) { onChange(data) }})
+//	}
+type NacosClient interface {
+	GetConfig(dataID, group string) (string, error)
+	ListenConfig(dataID, group string, onChange func(data string)) error
+}
+
+// LoadNacos replace the rules of kind with the rules of the Nacos config.
+func (r *Rules) LoadNacos(client NacosClient, kind Kind, dataID, group string) error {
+	data, err := client.GetConfig(dataID, group)
+	if err != nil {
+		return err
+	}
+	return r.Parse(kind, []byte(data))
+}
+
+// WatchNacos watch the Nacos config, onChange is called with a copy of r whose
+// rules of kind are replaced with the changed config, r is not modified.
+func (r *Rules) WatchNacos(client NacosClient, kind Kind, dataID, group string, onChange func(rules *Rules, err error)) error {
+	return client.ListenConfig(dataID, group, func(data string) {
+		rules := *r
+		err := rules.Parse(kind, []byte(data))
+		onChange(&rules, err)
+	})
+}
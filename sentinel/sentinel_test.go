@@ -0,0 +1,149 @@
+package sentinel
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/circuitbreaker/classic"
+	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/ratelimit/gcra"
+)
+
+const flowRules = `[
+	{"resource": "GET /user", "threshold": 2, "statIntervalInMs": 1000},
+	{"resource": "GET /user", "threshold": 10, "controlBehavior": 1, "maxQueueingTimeMs": 500},
+	{"resource": "GET /order", "threshold": 1, "relationStrategy": 1, "refResource": "GET /user"}
+]`
+
+const circuitBreakerRules = `[
+	{"resource": "GET /user", "strategy": 1, "retryTimeoutMs": 60000, "minRequestAmount": 2, "statIntervalMs": 1000, "threshold": 0.5},
+	{"resource": "GET /item", "strategy": 0, "minRequestAmount": 1, "maxAllowedRtMs": 1, "threshold": 0.5},
+	{"resource": "GET /item", "strategy": 2, "threshold": 10}
+]`
+
+const hotSpotRules = `[
+	{"resource": "GET /item", "metricType": 1, "paramIndex": 0, "threshold": 1, "burstCount": 1, "durationInSec": 60, "specificItems": {"vip": 100}},
+	{"resource": "GET /item", "metricType": 0, "paramIndex": 1, "threshold": 1}
+]`
+
+type testNacos struct {
+	configs  map[string]string
+	onChange func(data string)
+}
+
+func (n *testNacos) GetConfig(dataID, group string) (string, error) {
+	if data, ok := n.configs[group+"/"+dataID]; ok {
+		return data, nil
+	}
+	return "", errors.New("config not found")
+}
+
+func (n *testNacos) ListenConfig(dataID, group string, onChange func(data string)) error {
+	n.onChange = onChange
+	return nil
+}
+
+func TestRulesLoad(t *testing.T) {
+	var r Rules
+	path := filepath.Join(t.TempDir(), "flow.json")
+	assert.NoError(t, os.WriteFile(path, []byte(flowRules), 0o644))
+	assert.NoError(t, r.LoadFile(KindFlow, path))
+	assert.Len(t, r.Flow, 3)
+	assert.Equal(t, uint32(500), r.Flow[1].MaxQueueingTimeMs)
+
+	nacos := &testNacos{configs: map[string]string{"SENTINEL_GROUP/degrade": circuitBreakerRules}}
+	assert.NoError(t, r.LoadNacos(nacos, KindCircuitBreaker, "degrade", "SENTINEL_GROUP"))
+	assert.Len(t, r.CircuitBreaker, 3)
+	assert.Error(t, r.LoadNacos(nacos, KindHotSpot, "hotspot", "SENTINEL_GROUP"))
+	assert.Error(t, r.Parse(KindHotSpot, []byte("{")))
+
+	var changed *Rules
+	assert.NoError(t, r.WatchNacos(nacos, KindHotSpot, "hotspot", "SENTINEL_GROUP", func(rules *Rules, err error) {
+		assert.NoError(t, err)
+		changed = rules
+	}))
+	nacos.onChange(hotSpotRules)
+	assert.Len(t, changed.HotSpot, 2)
+	assert.Len(t, changed.Flow, 3)
+	assert.Empty(t, r.HotSpot)
+}
+
+func TestRulesTranslate(t *testing.T) {
+	var r Rules
+	assert.NoError(t, r.Parse(KindFlow, []byte(flowRules)))
+	assert.NoError(t, r.Parse(KindCircuitBreaker, []byte(circuitBreakerRules)))
+	assert.NoError(t, r.Parse(KindHotSpot, []byte(hotSpotRules)))
+	c, err := r.Translate()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"circuitbreaker rule of GET /item: strategy 2 is not supported",
+		"flow rule of GET /order: relation strategy 1 is not supported",
+		"flow rule of GET /user: queueing is not supported, requests are rejected",
+		"hotspot rule of GET /item: metric type 0 is not supported",
+	}, c.Skipped)
+	assert.Len(t, c.Guards, 2)
+	assert.Nil(t, c.Guard("GET /order"))
+
+	// both flow rules of GET /user apply, the throttling rule allows one request at once
+	g := c.Guard("GET /user")
+	done, err := g.Allow("GET /user", "")
+	assert.NoError(t, err)
+	done(errors.New("failed"))
+	_, err = g.Allow("GET /user", "")
+	assert.Equal(t, ratelimit.ErrLimitExceed, err)
+
+	// the slow request ratio rule opens on slow requests
+	g = c.Guard("GET /item")
+	done, err = g.Allow("GET /item", "")
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	done(nil)
+	_, err = g.Allow("GET /item", "")
+	assert.Equal(t, circuitbreaker.ErrNotAllowed, err)
+
+	// threshold 1 with burst count 1 allows 2 requests per value
+	for i := 0; i < 2; i++ {
+		assert.True(t, c.AllowParam("GET /item", "42"))
+	}
+	assert.False(t, c.AllowParam("GET /item", "42"))
+	assert.True(t, c.AllowParam("GET /item", "43"))
+	for i := 0; i < 10; i++ {
+		assert.True(t, c.AllowParam("GET /item", "vip"))
+	}
+	assert.True(t, c.AllowParam("GET /user", "42"))
+	assert.Equal(t, "GET /item:vip", c.Hotkey.List()[0].Key)
+}
+
+func TestMultiLimiter(t *testing.T) {
+	wide := gcra.NewLimiter(10, time.Second, gcra.WithBurst(2), gcra.WithKey(flowKey))
+	narrow := gcra.NewLimiter(1, time.Second, gcra.WithKey(flowKey))
+	m := &multiLimiter{limiters: []*gcra.Limiter{wide, narrow}}
+	_, err := m.Allow()
+	assert.NoError(t, err)
+	// the requests rejected by the narrow limiter take nothing of the wide one
+	for i := 0; i < 3; i++ {
+		_, err = m.Allow()
+		assert.Equal(t, ratelimit.ErrLimitExceed, err)
+	}
+	assert.True(t, wide.PeekN(flowKey, 1).Allowed)
+}
+
+func TestMultiBreakerRelease(t *testing.T) {
+	probe := classic.NewBreaker(classic.WithRequest(1), classic.WithOpenTimeout(time.Millisecond), classic.WithProbes(1))
+	probe.MarkFailed()
+	time.Sleep(2 * time.Millisecond)
+	open := classic.NewBreaker()
+	open.ForceOpen()
+	m := multiBreaker{probe, open}
+	// the probe permitted by the first breaker is released, not marked as a success
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, m.Allow(), circuitbreaker.ErrNotAllowed)
+		assert.Equal(t, classic.StateHalfOpen, probe.State())
+	}
+	assert.Nil(t, probe.Allow())
+}
@@ -0,0 +1,338 @@
+package sentinel
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zychimne/aegis/circuitbreaker"
+	"github.com/zychimne/aegis/circuitbreaker/classic"
+	"github.com/zychimne/aegis/hotkey"
+	"github.com/zychimne/aegis/middleware"
+	"github.com/zychimne/aegis/ratelimit"
+	"github.com/zychimne/aegis/ratelimit/gcra"
+)
+
+var (
+	_ ratelimit.Limiter             = (*multiLimiter)(nil)
+	_ circuitbreaker.CircuitBreaker = multiBreaker(nil)
+)
+
+// flowKey is the key of the limiters of the flow rules.
+const flowKey = "flow"
+
+// disabledRate is a rate threshold of the classic breaker which is never exceeded.
+const disabledRate = 2
+
+// ParamLimiter limits the requests of a resource per value of a param, it is
+// the translation of a hot-spot rule.
+type ParamLimiter struct {
+	// ParamIndex and ParamKey identify the param as in the rule, the caller
+	// passes the value of the param to Allow.
+	ParamIndex int
+	ParamKey   string
+	limiter    *gcra.Limiter
+	specific   map[string]*gcra.Limiter
+}
+
+// Allow checks one request with the value of the param.
+func (p *ParamLimiter) Allow(value string) bool {
+	if l, ok := p.specific[value]; ok {
+		return l.AllowKey(value).Allowed
+	}
+	return p.limiter.AllowKey(value).Allowed
+}
+
+// Config is the aegis components translated from the rules.
+type Config struct {
+	// Guards is the guard of every resource with flow or circuit breaking rules.
+	Guards map[string]*middleware.Guard
+	// Params is the param limiters of every resource with hot-spot rules.
+	Params map[string][]*ParamLimiter
+	// Hotkey counts the params of the hot-spot rules as "resource:value", nil
+	// without hot-spot rules.
+	Hotkey *hotkey.HotKeyWithCache
+	// Skipped describes the rules and fields which can't be translated.
+	Skipped []string
+}
+
+// Guard returns the guard of resource, nil if the resource has no rules.
+func (c *Config) Guard(resource string) *middleware.Guard {
+	return c.Guards[resource]
+}
+
+// AllowParam checks the hot-spot rules of resource, values are the values of
+// the params by index, and it counts the values into the hot-key counter.
+func (c *Config) AllowParam(resource string, values ...string) bool {
+	for _, p := range c.Params[resource] {
+		if p.ParamIndex < 0 || p.ParamIndex >= len(values) {
+			continue
+		}
+		if c.Hotkey != nil {
+			c.Hotkey.Add(resource+":"+values[p.ParamIndex], 1)
+		}
+		if !p.Allow(values[p.ParamIndex]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Translate translates the rules into aegis components: flow rules into gcra
+// limiters, circuit breaking rules into classic breakers, and hot-spot rules
+// into gcra limiters per param value. Rules which can't be translated are
+// skipped and described in Config.Skipped.
+func (r *Rules) Translate() (*Config, error) {
+	c := &Config{
+		Guards: make(map[string]*middleware.Guard),
+		Params: make(map[string][]*ParamLimiter),
+	}
+	limiters := make(map[string][]*gcra.Limiter)
+	for _, rule := range r.Flow {
+		if l := c.flow(rule); l != nil {
+			limiters[rule.Resource] = append(limiters[rule.Resource], l)
+		}
+	}
+	breakers := make(map[string][]*classic.Breaker)
+	for _, rule := range r.CircuitBreaker {
+		if b := c.circuitBreaker(rule); b != nil {
+			breakers[rule.Resource] = append(breakers[rule.Resource], b)
+		}
+	}
+	var capacity int64
+	for _, rule := range r.HotSpot {
+		if p := c.hotSpot(rule); p != nil {
+			c.Params[rule.Resource] = append(c.Params[rule.Resource], p)
+			if rule.ParamsMaxCapacity > capacity {
+				capacity = rule.ParamsMaxCapacity
+			}
+		}
+	}
+	if len(c.Params) > 0 {
+		if capacity <= 0 || capacity > 1000 {
+			capacity = 100
+		}
+		h, err := hotkey.NewHotkey(&hotkey.Option{HotKeyCnt: int(capacity), MinCount: 1})
+		if err != nil {
+			return nil, err
+		}
+		c.Hotkey = h
+	}
+
+	resources := make(map[string]struct{})
+	for resource := range limiters {
+		resources[resource] = struct{}{}
+	}
+	for resource := range breakers {
+		resources[resource] = struct{}{}
+	}
+	for resource := range resources {
+		var opts []middleware.Option
+		switch l := limiters[resource]; len(l) {
+		case 0:
+		case 1:
+			opts = append(opts, middleware.WithLimiter(l[0]))
+		default:
+			opts = append(opts, middleware.WithLimiter(&multiLimiter{limiters: l}))
+		}
+		switch b := breakers[resource]; len(b) {
+		case 0:
+		case 1:
+			opts = append(opts, middleware.WithBreaker(b[0]))
+		default:
+			opts = append(opts, middleware.WithBreaker(multiBreaker(b)))
+		}
+		c.Guards[resource] = middleware.New(opts...)
+	}
+	sort.Strings(c.Skipped)
+	return c, nil
+}
+
+func (c *Config) skip(kind Kind, resource, format string, args ...interface{}) {
+	c.Skipped = append(c.Skipped, fmt.Sprintf("%s rule of %s: ", kind, resource)+fmt.Sprintf(format, args...))
+}
+
+// flow translates a flow rule into a gcra limiter allowing threshold requests
+// per stat interval.
+func (c *Config) flow(rule *FlowRule) *gcra.Limiter {
+	if rule.RelationStrategy != CurrentResource {
+		c.skip(KindFlow, rule.Resource, "relation strategy %d is not supported", rule.RelationStrategy)
+		return nil
+	}
+	if rule.Threshold <= 0 {
+		c.skip(KindFlow, rule.Resource, "threshold %v is not positive", rule.Threshold)
+		return nil
+	}
+	if rule.TokenCalculateStrategy != Direct {
+		c.skip(KindFlow, rule.Resource, "token calculate strategy %d is translated as direct", rule.TokenCalculateStrategy)
+	}
+	interval := time.Second
+	if rule.StatIntervalInMs > 0 {
+		interval = time.Duration(rule.StatIntervalInMs) * time.Millisecond
+	}
+	rate, period := int(rule.Threshold), interval
+	if rule.Threshold < 1 {
+		rate, period = 1, time.Duration(float64(interval)/rule.Threshold)
+	}
+	burst := rate
+	if rule.ControlBehavior == Throttling {
+		burst = 1
+		if rule.MaxQueueingTimeMs > 0 {
+			c.skip(KindFlow, rule.Resource, "queueing is not supported, requests are rejected")
+		}
+	}
+	return gcra.NewLimiter(rate, period, gcra.WithBurst(burst), gcra.WithKey(flowKey))
+}
+
+// circuitBreaker translates a circuit breaking rule into a classic breaker.
+func (c *Config) circuitBreaker(rule *CircuitBreakerRule) *classic.Breaker {
+	var opts []classic.Option
+	switch rule.Strategy {
+	case ErrorRatio:
+		opts = append(opts, classic.WithFailureRate(rule.Threshold), classic.WithSlowCallRate(disabledRate))
+	case SlowRequestRatio:
+		opts = append(opts, classic.WithFailureRate(disabledRate), classic.WithSlowCallRate(rule.Threshold),
+			classic.WithSlowCallDuration(time.Duration(rule.MaxAllowedRtMs)*time.Millisecond))
+	default:
+		c.skip(KindCircuitBreaker, rule.Resource, "strategy %d is not supported", rule.Strategy)
+		return nil
+	}
+	if rule.MinRequestAmount > 0 {
+		opts = append(opts, classic.WithRequest(int64(rule.MinRequestAmount)))
+	}
+	if rule.StatIntervalMs > 0 {
+		opts = append(opts, classic.WithWindow(time.Duration(rule.StatIntervalMs)*time.Millisecond))
+	}
+	if rule.StatSlidingWindowBucketCount > 0 {
+		opts = append(opts, classic.WithBucket(int(rule.StatSlidingWindowBucketCount)))
+	}
+	if rule.RetryTimeoutMs > 0 {
+		opts = append(opts, classic.WithOpenTimeout(time.Duration(rule.RetryTimeoutMs)*time.Millisecond))
+	}
+	if rule.ProbeNum > 0 {
+		opts = append(opts, classic.WithProbes(int64(rule.ProbeNum)))
+	}
+	return classic.NewBreaker(opts...)
+}
+
+// hotSpot translates a hot-spot rule into gcra limiters allowing threshold
+// plus burst count requests per duration for every param value.
+func (c *Config) hotSpot(rule *HotSpotRule) *ParamLimiter {
+	if rule.MetricType != QPS {
+		c.skip(KindHotSpot, rule.Resource, "metric type %d is not supported", rule.MetricType)
+		return nil
+	}
+	if rule.Threshold <= 0 {
+		c.skip(KindHotSpot, rule.Resource, "threshold %d is not positive", rule.Threshold)
+		return nil
+	}
+	if rule.ControlBehavior == Throttling && rule.MaxQueueingTimeMs > 0 {
+		c.skip(KindHotSpot, rule.Resource, "queueing is not supported, requests are rejected")
+	}
+	duration := time.Second
+	if rule.DurationInSec > 0 {
+		duration = time.Duration(rule.DurationInSec) * time.Second
+	}
+	newLimiter := func(threshold int64) *gcra.Limiter {
+		return gcra.NewLimiter(int(threshold), duration, gcra.WithBurst(int(threshold+rule.BurstCount)))
+	}
+	p := &ParamLimiter{
+		ParamIndex: rule.ParamIndex,
+		ParamKey:   rule.ParamKey,
+		limiter:    newLimiter(rule.Threshold),
+		specific:   make(map[string]*gcra.Limiter),
+	}
+	for value, threshold := range rule.SpecificItems {
+		if threshold <= 0 {
+			c.skip(KindHotSpot, rule.Resource, "threshold %d of %s is not positive", threshold, value)
+			continue
+		}
+		p.specific[value] = newLimiter(threshold)
+	}
+	return p
+}
+
+// multiLimiter allows a request only if all limiters allow it.
+type multiLimiter struct {
+	mu       sync.Mutex
+	limiters []*gcra.Limiter
+}
+
+func (m *multiLimiter) Allow() (ratelimit.DoneFunc, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// a request rejected by a limiter takes no requests of the others, as
+	// Sentinel only records a pass once all rules pass
+	for _, l := range m.limiters {
+		if !l.PeekN(flowKey, 1).Allowed {
+			return nil, ratelimit.ErrLimitExceed
+		}
+	}
+	for _, l := range m.limiters {
+		l.AllowN(flowKey, 1)
+	}
+	return func(ratelimit.DoneInfo) {}, nil
+}
+
+// multiBreaker allows a request only if all breakers allow it.
+type multiBreaker []*classic.Breaker
+
+func (m multiBreaker) Allow() error {
+	for i, b := range m {
+		if err := b.Allow(); err != nil {
+			// the earlier breakers may have permitted it as a probe, which is
+			// released without a result since the request never runs
+			for _, allowed := range m[:i] {
+				allowed.Release()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (m multiBreaker) MarkSuccess() {
+	m.Mark(true, 0)
+}
+
+func (m multiBreaker) MarkFailed() {
+	m.Mark(false, 0)
+}
+
+// Mark marks the result and the elapsed time of a request on all breakers.
+func (m multiBreaker) Mark(success bool, elapsed time.Duration) {
+	for _, b := range m {
+		b.Mark(success, elapsed)
+	}
+}
+
+// Acquire allows the request only if all breakers allow it, and returns the
+// permits of all breakers.
+func (m multiBreaker) Acquire() (circuitbreaker.Permit, error) {
+	permits := make(multiPermit, 0, len(m))
+	for _, b := range m {
+		p, err := b.Acquire()
+		if err != nil {
+			permits.Release()
+			return nil, err
+		}
+		permits = append(permits, p)
+	}
+	return permits, nil
+}
+
+// multiPermit is the permits of a request allowed by a multiBreaker.
+type multiPermit []circuitbreaker.Permit
+
+func (m multiPermit) Mark(success bool, elapsed time.Duration) {
+	for _, p := range m {
+		p.Mark(success, elapsed)
+	}
+}
+
+func (m multiPermit) Release() {
+	for _, p := range m {
+		p.Release()
+	}
+}
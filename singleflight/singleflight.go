@@ -0,0 +1,150 @@
+// Package singleflight provides a duplicate call suppression mechanism with
+// memoization of the results.
+package singleflight
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Option is singleflight group option.
+type Option func(*options)
+
+type options struct {
+	ttl      time.Duration
+	errorTTL time.Duration
+	forget   func(err error) bool
+}
+
+// WithTTL with the duration the results are memoized, default 0, the
+// results are only shared by the in-flight callers.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithErrorTTL with the duration the errors are memoized, default 0, the
+// errors are forgotten and the next call retries.
+func WithErrorTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.errorTTL = ttl
+	}
+}
+
+// WithForgetFunc with the func deciding which errors are never memoized,
+// such as context errors or transient errors, default nil.
+func WithForgetFunc(fn func(err error) bool) Option {
+	return func(o *options) {
+		o.forget = fn
+	}
+}
+
+type call[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+	// expiry is the time the memoized result expires, zero if it is not memoized.
+	expiry time.Time
+}
+
+// Group suppresses the duplicate calls of a key: while a call is in flight,
+// the callers of the same key wait for its result instead of calling again.
+type Group[K comparable, V any] struct {
+	mu    sync.Mutex
+	calls map[K]*call[V]
+	opts  options
+}
+
+// New return a singleflight group.
+func New[K comparable, V any](opts ...Option) *Group[K, V] {
+	var opt options
+	for _, o := range opts {
+		o(&opt)
+	}
+	return &Group[K, V]{calls: make(map[K]*call[V]), opts: opt}
+}
+
+// Do call fn once for the key and share its result with the concurrent
+// callers, and with the later callers while the result is memoized.
+func (g *Group[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (V, error) {
+	return g.DoTTL(ctx, key, g.opts.ttl, fn)
+}
+
+// DoTTL is like Do, but memoize a successful result for ttl instead of the
+// TTL of the group.
+//
+// The shared call runs with a context which is not cancelled with ctx, if ctx
+// is done before the call returns, the caller stops waiting and returns the
+// error of ctx, the call goes on for the other callers.
+func (g *Group[K, V]) DoTTL(ctx context.Context, key K, ttl time.Duration, fn func(ctx context.Context) (V, error)) (V, error) {
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if ok && !c.expiry.IsZero() && !time.Now().Before(c.expiry) {
+		ok = false
+	}
+	if !ok {
+		c = &call[V]{done: make(chan struct{})}
+		g.calls[key] = c
+		go g.call(context.WithoutCancel(ctx), key, c, ttl, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+func (g *Group[K, V]) call(ctx context.Context, key K, c *call[V], ttl time.Duration, fn func(ctx context.Context) (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("singleflight: panic: %v", r)
+		}
+		if c.err != nil {
+			ttl = g.opts.errorTTL
+			if g.opts.forget != nil && g.opts.forget(c.err) {
+				ttl = 0
+			}
+		}
+		g.mu.Lock()
+		if ttl > 0 {
+			c.expiry = time.Now().Add(ttl)
+			time.AfterFunc(ttl, func() { g.forget(key, c) })
+		} else if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn(ctx)
+}
+
+// forget delete the call of the key if it is still c.
+func (g *Group[K, V]) forget(key K, c *call[V]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
+
+// Forget forget the key, the next call of the key calls fn again instead of
+// waiting for the in-flight call or using the memoized result.
+func (g *Group[K, V]) Forget(key K) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.calls, key)
+}
+
+// Len return the number of in-flight and memoized calls.
+func (g *Group[K, V]) Len() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
@@ -0,0 +1,118 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSingleflightDo(t *testing.T) {
+	g := New[string, int]()
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 1, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := g.Do(context.Background(), "a", fn)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, v)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	// the result is not memoized without TTL
+	g.Do(context.Background(), "a", fn)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, 0, g.Len())
+}
+
+func TestSingleflightTTL(t *testing.T) {
+	g := New[string, int](WithTTL(50 * time.Millisecond))
+	var calls int32
+	fn := func(ctx context.Context) (int, error) {
+		return int(atomic.AddInt32(&calls, 1)), nil
+	}
+	v, _ := g.Do(context.Background(), "a", fn)
+	assert.Equal(t, 1, v)
+	v, _ = g.Do(context.Background(), "a", fn)
+	assert.Equal(t, 1, v)
+	// per-call TTL
+	v, _ = g.DoTTL(context.Background(), "b", 0, fn)
+	assert.Equal(t, 2, v)
+	v, _ = g.DoTTL(context.Background(), "b", 0, fn)
+	assert.Equal(t, 3, v)
+
+	g.Forget("a")
+	v, _ = g.Do(context.Background(), "a", fn)
+	assert.Equal(t, 4, v)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, g.Len())
+	v, _ = g.Do(context.Background(), "a", fn)
+	assert.Equal(t, 5, v)
+}
+
+func TestSingleflightError(t *testing.T) {
+	errTransient := errors.New("transient")
+	errNotFound := errors.New("not found")
+	g := New[string, int](WithTTL(time.Minute), WithErrorTTL(time.Minute), WithForgetFunc(func(err error) bool {
+		return errors.Is(err, errTransient)
+	}))
+	var calls int32
+	call := func(err error) func(ctx context.Context) (int, error) {
+		return func(ctx context.Context) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			return 0, err
+		}
+	}
+	_, err := g.Do(context.Background(), "a", call(errTransient))
+	assert.Equal(t, errTransient, err)
+	_, err = g.Do(context.Background(), "a", call(errNotFound))
+	assert.Equal(t, errNotFound, err)
+	// the not found error is memoized
+	_, err = g.Do(context.Background(), "a", call(nil))
+	assert.Equal(t, errNotFound, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	_, err = g.Do(context.Background(), "b", func(ctx context.Context) (int, error) { panic("boom") })
+	assert.EqualError(t, err, "singleflight: panic: boom")
+}
+
+func TestSingleflightCancel(t *testing.T) {
+	g := New[string, int]()
+	release := make(chan struct{})
+	var cancelled int32
+	fn := func(ctx context.Context) (int, error) {
+		<-release
+		if ctx.Err() != nil {
+			atomic.StoreInt32(&cancelled, 1)
+		}
+		return 1, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done := make(chan int)
+	go func() {
+		v, _ := g.Do(context.Background(), "a", fn)
+		done <- v
+	}()
+	_, err := g.Do(ctx, "a", fn)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	// the shared call goes on for the other callers
+	close(release)
+	assert.Equal(t, 1, <-done)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&cancelled))
+}
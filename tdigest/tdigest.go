@@ -0,0 +1,217 @@
+package tdigest
+
+// Native Go implement of merging t-digest, Based on paper
+// Computing Extremely Accurate Quantiles Using t-Digests (https://arxiv.org/abs/1902.04023)
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"unsafe"
+)
+
+// DefaultCompression is the compression of t-digest if it is not positive,
+// the digest keeps at most about 2*compression centroids.
+const DefaultCompression = 100
+
+// ErrCompressionMismatch is returned when merging t-digests of different compression.
+var ErrCompressionMismatch = errors.New("tdigest: compression mismatch")
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// TDigest estimates the quantiles of a distribution, with a higher accuracy
+// at the extreme quantiles such as p99.
+type TDigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	total       float64
+	min         float64
+	max         float64
+}
+
+// New return a t-digest, the larger the compression the more accurate and
+// the larger the digest.
+func New(compression float64) *TDigest {
+	if compression <= 0 {
+		compression = DefaultCompression
+	}
+	// the buffer has room for the centroids to be merged with it in place
+	return &TDigest{
+		compression: compression,
+		centroids:   make([]centroid, 0, int(2*compression)),
+		buffer:      make([]centroid, 0, int(7*compression)),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// Add add value into the digest.
+func (t *TDigest) Add(value float64) {
+	t.AddWeighted(value, 1)
+}
+
+// AddWeighted add value with weight into the digest.
+func (t *TDigest) AddWeighted(value, weight float64) {
+	if math.IsNaN(value) || weight <= 0 {
+		return
+	}
+	if len(t.buffer) >= cap(t.buffer)-len(t.centroids) {
+		t.compress()
+	}
+	t.buffer = append(t.buffer, centroid{mean: value, weight: weight})
+	t.total += weight
+	t.min = math.Min(t.min, value)
+	t.max = math.Max(t.max, value)
+}
+
+// compress merge the buffer into the centroids with the k1 scale function.
+func (t *TDigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := append(t.buffer, t.centroids...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+	merged := t.centroids[:0]
+	current := all[0]
+	var weightSoFar float64
+	limit := t.total * t.kInv(t.k(0)+1)
+	for _, c := range all[1:] {
+		if weightSoFar+current.weight+c.weight <= limit {
+			current.weight += c.weight
+			current.mean += (c.mean - current.mean) * c.weight / current.weight
+			continue
+		}
+		weightSoFar += current.weight
+		merged = append(merged, current)
+		limit = t.total * t.kInv(t.k(weightSoFar/t.total)+1)
+		current = c
+	}
+	t.centroids = append(merged, current)
+	t.buffer = t.buffer[:0]
+}
+
+func (t *TDigest) k(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func (t *TDigest) kInv(k float64) float64 {
+	if k >= t.compression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/t.compression) + 1) / 2
+}
+
+// Quantile return the estimated value at quantile q in [0, 1], NaN if the
+// digest is empty.
+func (t *TDigest) Quantile(q float64) float64 {
+	t.compress()
+	if len(t.centroids) == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	c := t.centroids
+	if len(c) == 1 {
+		return c[0].mean
+	}
+	index := q * t.total
+	// values are interpolated between the centers of the centroids, and
+	// between the extremes and the centers of the first and the last centroid.
+	weightSoFar := c[0].weight / 2
+	if index < weightSoFar {
+		return t.min + index/weightSoFar*(c[0].mean-t.min)
+	}
+	for i := 0; i < len(c)-1; i++ {
+		dw := (c[i].weight + c[i+1].weight) / 2
+		if weightSoFar+dw > index {
+			return c[i].mean + (index-weightSoFar)/dw*(c[i+1].mean-c[i].mean)
+		}
+		weightSoFar += dw
+	}
+	last := c[len(c)-1]
+	return last.mean + math.Min(1, (index-weightSoFar)/(last.weight/2))*(t.max-last.mean)
+}
+
+// CDF return the estimated ratio of values less than or equal to value, NaN
+// if the digest is empty.
+func (t *TDigest) CDF(value float64) float64 {
+	t.compress()
+	if len(t.centroids) == 0 {
+		return math.NaN()
+	}
+	if value < t.min {
+		return 0
+	}
+	if value >= t.max {
+		return 1
+	}
+	c := t.centroids
+	if value < c[0].mean {
+		return (value - t.min) / (c[0].mean - t.min) * c[0].weight / 2 / t.total
+	}
+	weightSoFar := c[0].weight / 2
+	for i := 0; i < len(c)-1; i++ {
+		dw := (c[i].weight + c[i+1].weight) / 2
+		if value < c[i+1].mean {
+			return (weightSoFar + (value-c[i].mean)/(c[i+1].mean-c[i].mean)*dw) / t.total
+		}
+		weightSoFar += dw
+	}
+	last := c[len(c)-1]
+	return (weightSoFar + (value-last.mean)/(t.max-last.mean)*last.weight/2) / t.total
+}
+
+// Count return the total weight added into the digest.
+func (t *TDigest) Count() float64 {
+	return t.total
+}
+
+// Min return the min value added into the digest.
+func (t *TDigest) Min() float64 {
+	return t.min
+}
+
+// Max return the max value added into the digest.
+func (t *TDigest) Max() float64 {
+	return t.max
+}
+
+// Merge merge other into t, both must have the same compression.
+func (t *TDigest) Merge(other *TDigest) error {
+	if t.compression != other.compression {
+		return ErrCompressionMismatch
+	}
+	other.compress()
+	for _, c := range other.centroids {
+		if len(t.buffer) >= cap(t.buffer)-len(t.centroids) {
+			t.compress()
+		}
+		t.buffer = append(t.buffer, c)
+		t.total += c.weight
+	}
+	t.min = math.Min(t.min, other.min)
+	t.max = math.Max(t.max, other.max)
+	return nil
+}
+
+// Reset clear the digest in place.
+func (t *TDigest) Reset() {
+	t.centroids = t.centroids[:0]
+	t.buffer = t.buffer[:0]
+	t.total = 0
+	t.min = math.Inf(1)
+	t.max = math.Inf(-1)
+}
+
+// Centroids return the number of centroids after compression.
+func (t *TDigest) Centroids() int {
+	t.compress()
+	return len(t.centroids)
+}
+
+// SizeBytes return the memory consumed by the digest in bytes.
+func (t *TDigest) SizeBytes() uint64 {
+	return uint64(unsafe.Sizeof(*t)) + uint64(cap(t.centroids)+cap(t.buffer))*uint64(unsafe.Sizeof(centroid{}))
+}
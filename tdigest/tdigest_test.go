@@ -0,0 +1,61 @@
+package tdigest
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/rand"
+)
+
+func TestTDigestQuantile(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	td := New(100)
+	values := make([]float64, 100000)
+	for i := range values {
+		// exponential latency distribution
+		values[i] = r.ExpFloat64() * 10
+		td.Add(values[i])
+	}
+	sort.Float64s(values)
+	for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99, 0.999} {
+		expected := values[int(q*float64(len(values)))]
+		// rank error of the estimated quantile
+		rank := float64(sort.SearchFloat64s(values, td.Quantile(q))) / float64(len(values))
+		assert.InDelta(t, q, rank, 0.002, "q=%v", q)
+		assert.InDelta(t, q, td.CDF(expected), 0.005, "q=%v", q)
+	}
+	assert.Equal(t, values[0], td.Quantile(0))
+	assert.Equal(t, values[len(values)-1], td.Quantile(1))
+	assert.Equal(t, float64(len(values)), td.Count())
+	assert.LessOrEqual(t, td.Centroids(), 200)
+}
+
+func TestTDigestMerge(t *testing.T) {
+	a, b := New(100), New(100)
+	for i := 0; i < 1000; i++ {
+		a.Add(float64(i))
+		b.Add(float64(i + 1000))
+	}
+	assert.Nil(t, a.Merge(b))
+	assert.InDelta(t, 1000, a.Quantile(0.5), 10)
+	assert.Equal(t, 0.0, a.Min())
+	assert.Equal(t, 1999.0, a.Max())
+	assert.Equal(t, ErrCompressionMismatch, a.Merge(New(50)))
+
+	a.Reset()
+	assert.True(t, math.IsNaN(a.Quantile(0.5)))
+	a.Add(1)
+	assert.Equal(t, 1.0, a.Quantile(0.99))
+}
+
+func BenchmarkTDigestAdd(b *testing.B) {
+	td := New(100)
+	r := rand.New(rand.NewSource(1))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		td.Add(r.Float64())
+	}
+}
@@ -0,0 +1,45 @@
+package topk
+
+import "context"
+
+// consumeBatch is the max number of keys aggregated before they are added.
+const consumeBatch = 1024
+
+// consume ingest keys into topk until keys is closed or ctx is done. Keys which
+// are already queued are aggregated into batches, so a key repeated n times in
+// a batch costs a single Add.
+func consume(ctx context.Context, topk Topk, keys <-chan string) error {
+	counts := make(map[string]uint32)
+	flush := func() {
+		for key, count := range counts {
+			topk.Add(key, count)
+			delete(counts, key)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return ctx.Err()
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			counts[key]++
+		batch:
+			for n := 1; n < consumeBatch; n++ {
+				select {
+				case key, ok = <-keys:
+					if !ok {
+						flush()
+						return nil
+					}
+					counts[key]++
+				default:
+					break batch
+				}
+			}
+			flush()
+		}
+	}
+}
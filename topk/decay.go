@@ -0,0 +1,77 @@
+package topk
+
+import "math"
+
+// DecayPolicy decides how likely a bucket held by another key is decayed
+// when a key collides with it.
+type DecayPolicy interface {
+	// Probability return the probability of decaying a bucket which holds count.
+	Probability(count uint32) float64
+}
+
+var (
+	_ DecayPolicy = (*exponentialDecay)(nil)
+	_ DecayPolicy = linearDecay(0)
+	_ DecayPolicy = proportionalDecay{}
+	_ DecayPolicy = noDecay{}
+)
+
+type exponentialDecay struct {
+	lookupTable []float64
+}
+
+// ExponentialDecay decays a bucket with probability base^count, which is the
+// policy of the HeavyKeeper paper.
+func ExponentialDecay(base float64) DecayPolicy {
+	d := &exponentialDecay{lookupTable: make([]float64, LOOKUP_TABLE)}
+	for i := 0; i < LOOKUP_TABLE; i++ {
+		d.lookupTable[i] = math.Pow(base, float64(i))
+	}
+	return d
+}
+
+func (d *exponentialDecay) Probability(count uint32) float64 {
+	if count < LOOKUP_TABLE {
+		return d.lookupTable[count]
+	}
+	// decr pow caculate cost
+	return d.lookupTable[LOOKUP_TABLE-1]
+}
+
+type linearDecay uint32
+
+// LinearDecay decays a bucket with probability 1-count/limit, buckets holding
+// limit or more are never decayed.
+func LinearDecay(limit uint32) DecayPolicy {
+	return linearDecay(limit)
+}
+
+func (d linearDecay) Probability(count uint32) float64 {
+	if count >= uint32(d) {
+		return 0
+	}
+	return 1 - float64(count)/float64(d)
+}
+
+type proportionalDecay struct{}
+
+// ProportionalDecay decays a bucket with probability 1/(count+1), inversely
+// proportional to its count.
+func ProportionalDecay() DecayPolicy {
+	return proportionalDecay{}
+}
+
+func (proportionalDecay) Probability(count uint32) float64 {
+	return 1 / (float64(count) + 1)
+}
+
+type noDecay struct{}
+
+// NoDecay never decays a bucket, so a colliding key is not counted in that row.
+func NoDecay() DecayPolicy {
+	return noDecay{}
+}
+
+func (noDecay) Probability(uint32) float64 {
+	return 0
+}
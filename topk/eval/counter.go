@@ -0,0 +1,206 @@
+package eval
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"unsafe"
+
+	"github.com/twmb/murmur3"
+	"github.com/zychimne/aegis/topk"
+)
+
+// Counter is the part of topk.Topk exercised by the evaluation, so algorithms
+// can be evaluated without implementing the whole interface.
+type Counter interface {
+	Add(item string, incr uint32) (string, bool)
+	List() []topk.Item
+	SizeBytes() uint64
+}
+
+// spaceSaving implement the topk by Space-Saving, based on paper
+// Efficient Computation of Frequent and Top-k Elements in Data Streams (https://www.cs.ucsb.edu/sites/default/files/documents/2005-23.pdf)
+type spaceSaving struct {
+	k    int
+	heap *indexedHeap
+}
+
+// NewSpaceSaving return a Space-Saving counter of k monitored keys. A new key
+// takes the place of the minimal key and inherits its count, so counts are
+// over-estimated by at most the minimal count.
+func NewSpaceSaving(k uint32) Counter {
+	return &spaceSaving{k: int(k), heap: newIndexedHeap(int(k))}
+}
+
+func (s *spaceSaving) Add(key string, incr uint32) (string, bool) {
+	if n, ok := s.heap.index[key]; ok {
+		s.heap.fix(n, n.count+incr)
+		return "", true
+	}
+	if s.heap.Len() < s.k {
+		s.heap.push(key, incr)
+		return "", true
+	}
+	min := s.heap.nodes[0]
+	expelled := min.key
+	s.heap.replace(min, key, min.count+incr)
+	return expelled, true
+}
+
+func (s *spaceSaving) List() []topk.Item {
+	return s.heap.list()
+}
+
+func (s *spaceSaving) SizeBytes() uint64 {
+	return uint64(unsafe.Sizeof(*s)) + s.heap.sizeBytes()
+}
+
+// countMinHeap implement the topk by a count-min sketch estimating the counts
+// and a min-heap of the k keys of the largest estimates.
+type countMinHeap struct {
+	k      int
+	width  uint32
+	counts [][]uint32
+	heap   *indexedHeap
+}
+
+// NewCountMinHeap return a count-min sketch of depth rows of width counters
+// with a min-heap of k keys. Counts are over-estimated by collisions.
+func NewCountMinHeap(k, width, depth uint32) Counter {
+	counts := make([][]uint32, depth)
+	for i := range counts {
+		counts[i] = make([]uint32, width)
+	}
+	return &countMinHeap{k: int(k), width: width, counts: counts, heap: newIndexedHeap(int(k))}
+}
+
+func (c *countMinHeap) Add(key string, incr uint32) (string, bool) {
+	keyBytes := unsafe.Slice(unsafe.StringData(key), len(key))
+	estimate := uint32(math.MaxUint32)
+	for i, row := range c.counts {
+		idx := murmur3.SeedSum32(uint32(i), keyBytes) % c.width
+		row[idx] += incr
+		if row[idx] < estimate {
+			estimate = row[idx]
+		}
+	}
+	if n, ok := c.heap.index[key]; ok {
+		c.heap.fix(n, estimate)
+		return "", true
+	}
+	if c.heap.Len() < c.k {
+		c.heap.push(key, estimate)
+		return "", true
+	}
+	min := c.heap.nodes[0]
+	if estimate <= min.count {
+		return "", false
+	}
+	expelled := min.key
+	c.heap.replace(min, key, estimate)
+	return expelled, true
+}
+
+func (c *countMinHeap) List() []topk.Item {
+	return c.heap.list()
+}
+
+func (c *countMinHeap) SizeBytes() uint64 {
+	size := uint64(unsafe.Sizeof(*c)) + uint64(cap(c.counts))*uint64(unsafe.Sizeof(c.counts[0]))
+	for _, row := range c.counts {
+		size += uint64(cap(row)) * 4
+	}
+	return size + c.heap.sizeBytes()
+}
+
+type heapNode struct {
+	key   string
+	count uint32
+	idx   int
+}
+
+// indexedHeap is a min-heap of counts indexed by key, so the node of a key is
+// found in O(1).
+type indexedHeap struct {
+	nodes []*heapNode
+	index map[string]*heapNode
+}
+
+func newIndexedHeap(k int) *indexedHeap {
+	return &indexedHeap{nodes: make([]*heapNode, 0, k), index: make(map[string]*heapNode, k)}
+}
+
+func (h *indexedHeap) Len() int { return len(h.nodes) }
+
+func (h *indexedHeap) Less(i, j int) bool { return h.nodes[i].count < h.nodes[j].count }
+
+func (h *indexedHeap) Swap(i, j int) {
+	h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i]
+	h.nodes[i].idx = i
+	h.nodes[j].idx = j
+}
+
+func (h *indexedHeap) Push(x interface{}) {
+	n := x.(*heapNode)
+	n.idx = len(h.nodes)
+	h.nodes = append(h.nodes, n)
+}
+
+func (h *indexedHeap) Pop() interface{} {
+	n := h.nodes[len(h.nodes)-1]
+	h.nodes = h.nodes[:len(h.nodes)-1]
+	return n
+}
+
+func (h *indexedHeap) push(key string, count uint32) {
+	n := &heapNode{key: key, count: count}
+	h.index[key] = n
+	heap.Push(h, n)
+}
+
+func (h *indexedHeap) fix(n *heapNode, count uint32) {
+	n.count = count
+	heap.Fix(h, n.idx)
+}
+
+// replace reuse the node n for key.
+func (h *indexedHeap) replace(n *heapNode, key string, count uint32) {
+	delete(h.index, n.key)
+	n.key = key
+	h.index[key] = n
+	h.fix(n, count)
+}
+
+// list return the items sorted by count descending, ties by key.
+func (h *indexedHeap) list() []topk.Item {
+	items := make([]topk.Item, 0, len(h.nodes))
+	for _, n := range h.nodes {
+		items = append(items, topk.Item{Key: n.key, Count: n.count})
+	}
+	sortItems(items)
+	return items
+}
+
+func (h *indexedHeap) sizeBytes() uint64 {
+	nodeSize := uint64(unsafe.Sizeof(heapNode{}))
+	ptrSize := uint64(unsafe.Sizeof((*heapNode)(nil)))
+	size := uint64(unsafe.Sizeof(*h)) + uint64(cap(h.nodes))*ptrSize
+	for _, n := range h.nodes {
+		// the node, its key and an entry of the index
+		size += nodeSize + uint64(len(n.key)) + uint64(unsafe.Sizeof("")) + ptrSize
+	}
+	return size
+}
+
+// sortItems sort the items by count descending, ties by key, and set the ranks.
+func sortItems(items []topk.Item) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Key < items[j].Key
+	})
+	for i := range items {
+		items[i].Rank = i + 1
+	}
+}
@@ -0,0 +1,175 @@
+// Package eval evaluates the accuracy and cost of topk algorithms on a key
+// trace against an exact counter, so parameters and algorithms can be chosen
+// by data. Besides HeavyKeeper, it provides the Space-Saving and count-min
+// sketch with min-heap algorithms for comparison.
+package eval
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/zychimne/aegis/topk"
+	"golang.org/x/exp/rand"
+)
+
+// Exact counts every key exactly, it is the ground truth of the evaluation.
+type Exact struct {
+	counts map[string]uint64
+}
+
+// NewExact return an exact counter.
+func NewExact() *Exact {
+	return &Exact{counts: make(map[string]uint64)}
+}
+
+// Add add incr to the count of key.
+func (e *Exact) Add(key string, incr uint32) {
+	e.counts[key] += uint64(incr)
+}
+
+// Count return the count of key.
+func (e *Exact) Count(key string) uint64 {
+	return e.counts[key]
+}
+
+// TopK return the k keys of the largest counts, sorted by count descending
+// and ties by key. Counts beyond math.MaxUint32 are capped.
+func (e *Exact) TopK(k int) []topk.Item {
+	items := make([]topk.Item, 0, len(e.counts))
+	for key, count := range e.counts {
+		if count > math.MaxUint32 {
+			count = math.MaxUint32
+		}
+		items = append(items, topk.Item{Key: key, Count: uint32(count)})
+	}
+	sortItems(items)
+	if len(items) > k {
+		items = items[:k]
+	}
+	return items
+}
+
+// SizeBytes return the memory consumed by the keys and the counts.
+func (e *Exact) SizeBytes() uint64 {
+	size := uint64(unsafe.Sizeof(*e))
+	for key := range e.counts {
+		size += uint64(len(key)) + uint64(unsafe.Sizeof("")) + 8
+	}
+	return size
+}
+
+// Candidate is an algorithm under evaluation, New returns an empty counter.
+type Candidate struct {
+	Name string
+	New  func() Counter
+}
+
+// Candidates return HeavyKeeper and count-min sketch with min-heap of k keys
+// and width x depth buckets, and Space-Saving of counters keys.
+func Candidates(k, width, depth, counters uint32) []Candidate {
+	return []Candidate{
+		{Name: "heavykeeper", New: func() Counter { return topk.NewHeavyKeeper(k, width, depth, 0.925, 0) }},
+		{Name: "spacesaving", New: func() Counter { return NewSpaceSaving(counters) }},
+		{Name: "cms+heap", New: func() Counter { return NewCountMinHeap(k, width, depth) }},
+	}
+}
+
+// Result is the accuracy and cost of a candidate.
+type Result struct {
+	Name string
+	// Precision is the ratio of the reported keys in the true top-k, and
+	// Recall is the ratio of the true top-k keys reported.
+	Precision float64
+	Recall    float64
+	// RankError is the mean absolute difference between the reported and the
+	// true rank of the true top-k keys reported.
+	RankError float64
+	// CountError is the mean relative error of the counts of the reported keys.
+	CountError float64
+	SizeBytes  uint64
+	Duration   time.Duration
+	// Throughput is the keys added per second.
+	Throughput float64
+}
+
+// Evaluate run the trace through the candidates and compare the first k
+// reported keys of each with the true top-k.
+func Evaluate(trace []string, k int, candidates ...Candidate) []Result {
+	exact := NewExact()
+	for _, key := range trace {
+		exact.Add(key, 1)
+	}
+	truth := exact.TopK(k)
+	trueRank := make(map[string]int, len(truth))
+	for _, item := range truth {
+		trueRank[item.Key] = item.Rank
+	}
+	results := make([]Result, 0, len(candidates))
+	for _, candidate := range candidates {
+		c := candidate.New()
+		start := time.Now()
+		for _, key := range trace {
+			c.Add(key, 1)
+		}
+		elapsed := time.Since(start)
+		reported := c.List()
+		if len(reported) > k {
+			reported = reported[:k]
+		}
+		res := Result{Name: candidate.Name, SizeBytes: c.SizeBytes(), Duration: elapsed}
+		if elapsed > 0 {
+			res.Throughput = float64(len(trace)) / elapsed.Seconds()
+		}
+		var hits int
+		var rankError, countError float64
+		for i, item := range reported {
+			if rank, ok := trueRank[item.Key]; ok {
+				hits++
+				rankError += math.Abs(float64(i + 1 - rank))
+			}
+			if count := exact.Count(item.Key); count > 0 {
+				countError += math.Abs(float64(item.Count)-float64(count)) / float64(count)
+			}
+		}
+		if len(reported) > 0 {
+			res.Precision = float64(hits) / float64(len(reported))
+			res.CountError = countError / float64(len(reported))
+		}
+		if len(truth) > 0 {
+			res.Recall = float64(hits) / float64(len(truth))
+		}
+		if hits > 0 {
+			res.RankError = rankError / float64(hits)
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// ReadTrace read a trace of one key per line, empty lines are skipped.
+func ReadTrace(r io.Reader) ([]string, error) {
+	var trace []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			trace = append(trace, key)
+		}
+	}
+	return trace, scanner.Err()
+}
+
+// ZipfTrace generate a trace of n keys in [0, keys) of the zipf distribution
+// with parameter s > 1, key "0" is the most frequent.
+func ZipfTrace(n int, keys uint64, s float64, seed uint64) []string {
+	zipf := rand.NewZipf(rand.New(rand.NewSource(seed)), s, 1, keys-1)
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = strconv.FormatUint(zipf.Uint64(), 10)
+	}
+	return trace
+}
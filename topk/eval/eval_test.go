@@ -0,0 +1,73 @@
+package eval
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zychimne/aegis/topk"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := NewSpaceSaving(2)
+	for _, key := range []string{"a", "a", "a", "b", "b", "c"} {
+		s.Add(key, 1)
+	}
+	// c takes the place of b and over-estimates by its count
+	assert.Equal(t, []topk.Item{{Key: "a", Count: 3, Rank: 1}, {Key: "c", Count: 3, Rank: 2}}, s.List())
+	expelled, ok := s.Add("d", 1)
+	assert.True(t, ok)
+	assert.Contains(t, []string{"a", "c"}, expelled)
+	assert.Greater(t, s.SizeBytes(), uint64(0))
+}
+
+func TestCountMinHeap(t *testing.T) {
+	c := NewCountMinHeap(2, 1024, 4)
+	for _, key := range []string{"a", "a", "a", "b", "b", "c"} {
+		c.Add(key, 1)
+	}
+	assert.Equal(t, []topk.Item{{Key: "a", Count: 3, Rank: 1}, {Key: "b", Count: 2, Rank: 2}}, c.List())
+	_, ok := c.Add("d", 1)
+	assert.False(t, ok)
+	expelled, ok := c.Add("a", 1)
+	assert.True(t, ok)
+	assert.Empty(t, expelled)
+}
+
+func TestExact(t *testing.T) {
+	e := NewExact()
+	for _, key := range []string{"b", "a", "a", "c"} {
+		e.Add(key, 1)
+	}
+	assert.Equal(t, uint64(2), e.Count("a"))
+	assert.Equal(t, []topk.Item{{Key: "a", Count: 2, Rank: 1}, {Key: "b", Count: 1, Rank: 2}}, e.TopK(2))
+	assert.Len(t, e.TopK(10), 3)
+}
+
+func TestEvaluate(t *testing.T) {
+	trace := ZipfTrace(100000, 10000, 1.2, 1)
+	results := Evaluate(trace, 10, Candidates(10, 1024, 4, 100)...)
+	assert.Len(t, results, 3)
+	for _, res := range results {
+		// the head of a skewed trace is found by all algorithms
+		assert.GreaterOrEqual(t, res.Precision, 0.9, res.Name)
+		assert.GreaterOrEqual(t, res.Recall, 0.9, res.Name)
+		assert.Less(t, res.CountError, 0.1, res.Name)
+		assert.Greater(t, res.SizeBytes, uint64(0), res.Name)
+		assert.Greater(t, res.Throughput, float64(0), res.Name)
+	}
+
+	// a candidate reporting the wrong keys
+	results = Evaluate([]string{"a", "a", "b"}, 1, Candidate{Name: "wrong", New: func() Counter {
+		return NewSpaceSaving(1)
+	}})
+	assert.Equal(t, "wrong", results[0].Name)
+	assert.Equal(t, 0.0, results[0].Recall)
+	assert.Equal(t, 2.0, results[0].CountError)
+}
+
+func TestReadTrace(t *testing.T) {
+	trace, err := ReadTrace(strings.NewReader("a\n\n b \nc"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, trace)
+}
@@ -0,0 +1,52 @@
+package topk
+
+// FailureTopk is a topk which also tracks the failing keys in a dedicated
+// topk, the hottest failing keys are often more actionable than the hottest
+// keys. The embedded Topk counts all keys.
+type FailureTopk struct {
+	Topk
+	failed Topk
+}
+
+// NewFailureTopk return a FailureTopk counting all keys into topk and the
+// failing keys into failed.
+func NewFailureTopk(topk, failed Topk) *FailureTopk {
+	return &FailureTopk{Topk: topk, failed: failed}
+}
+
+// Record add key to the topk, and to the failed topk if err is not nil.
+func (topk *FailureTopk) Record(key string, incr uint32, err error) {
+	topk.Topk.Add(key, incr)
+	if err != nil {
+		topk.failed.Add(key, incr)
+	}
+}
+
+// Do call fn and record key with its result.
+func (topk *FailureTopk) Do(key string, fn func() error) error {
+	err := fn()
+	topk.Record(key, 1, err)
+	return err
+}
+
+// Failed return the topk of the failing keys.
+func (topk *FailureTopk) Failed() Topk {
+	return topk.failed
+}
+
+// Fading fade both topk.
+func (topk *FailureTopk) Fading() {
+	topk.Topk.Fading()
+	topk.failed.Fading()
+}
+
+// Reset clear both topk in place.
+func (topk *FailureTopk) Reset() {
+	topk.Topk.Reset()
+	topk.failed.Reset()
+}
+
+// SizeBytes return the memory consumed by both topk in bytes.
+func (topk *FailureTopk) SizeBytes() uint64 {
+	return topk.Topk.SizeBytes() + topk.failed.SizeBytes()
+}
@@ -4,51 +4,194 @@ package topk
 // HeavyKeeper: An Accurate Algorithm for Finding Top-k Elephant Flow (https://www.usenix.org/system/files/conference/atc18/atc18-gong.pdf)
 
 import (
+	"context"
 	"math"
+	"math/bits"
+	"regexp"
+	"strings"
+	"time"
+	"unsafe"
 
 	"github.com/twmb/murmur3"
+	"github.com/zychimne/aegis/hyperloglog"
 	"github.com/zychimne/aegis/internal/minheap"
 	"golang.org/x/exp/rand"
 )
 
 const LOOKUP_TABLE = 256
 
+// Option is heavykeeper option function.
+type Option func(*options)
+
+// options is heavykeeper options.
+type options struct {
+	expelledFn ExpelledFunc
+	phi        float64
+	decay      DecayPolicy
+	timestamps bool
+
+	ignorePrefixes []string
+	ignorePatterns []*regexp.Regexp
+
+	history      int
+	precision    uint8
+	conservative bool
+	staleTimeout time.Duration
+	seed         uint64
+}
+
+// WithExpelledFunc set the callback invoked whenever the min-heap expels an item.
+// The callback runs synchronously inside Add, so it should return quickly.
+func WithExpelledFunc(fn ExpelledFunc) Option {
+	return func(o *options) {
+		o.expelledFn = fn
+	}
+}
+
+// WithPhi switch the heavykeeper to phi-heavy-hitters mode, which report every key
+// whose count exceeds phi of the total instead of a fixed k keys. At most 1/phi keys
+// can exceed the threshold, so the min-heap holds max(k, ceil(1/phi)) items.
+func WithPhi(phi float64) Option {
+	return func(o *options) {
+		o.phi = phi
+	}
+}
+
+// WithDecayPolicy set the decay policy of colliding buckets, it overrides the
+// exponential decay base passed to NewHeavyKeeper.
+func WithDecayPolicy(policy DecayPolicy) Option {
+	return func(o *options) {
+		o.decay = policy
+	}
+}
+
+// WithTimestamps record the first-seen and last-updated time of topk items,
+// so long-lived hot keys can be told apart from ones that just spiked.
+func WithTimestamps() Option {
+	return func(o *options) {
+		o.timestamps = true
+	}
+}
+
+// WithIgnorePrefixes exclude keys with any of the prefixes from counting,
+// e.g. health checks and internal probes.
+func WithIgnorePrefixes(prefixes ...string) Option {
+	return func(o *options) {
+		o.ignorePrefixes = append(o.ignorePrefixes, prefixes...)
+	}
+}
+
+// WithIgnorePatterns exclude keys matching any of the patterns from counting.
+func WithIgnorePatterns(patterns ...*regexp.Regexp) Option {
+	return func(o *options) {
+		o.ignorePatterns = append(o.ignorePatterns, patterns...)
+	}
+}
+
+// WithHistory keep the snapshots of the last n windows, queryable via History.
+// A window rolls over whenever Fading or Reset is called.
+func WithHistory(n int) Option {
+	return func(o *options) {
+		o.history = n
+	}
+}
+
+// WithCardinality estimate the distinct keys of each window by a HyperLogLog
+// with 2^precision registers alongside the sketch.
+func WithCardinality(precision uint8) Option {
+	return func(o *options) {
+		o.precision = precision
+	}
+}
+
+// WithConservativeUpdate only increment the minimal buckets held by the key,
+// other buckets are raised to at most the new minimum. It reduces over-estimation
+// on adversarial or high-collision workloads at the cost of one more hash pass.
+func WithConservativeUpdate() Option {
+	return func(o *options) {
+		o.conservative = true
+	}
+}
+
+// WithStaleTimeout drop items which have not been updated within d from the topk,
+// so long-dead keys with historically huge counts stop occupying hot slots.
+// Dropped items are reported as expelled with an empty new key.
+func WithStaleTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.staleTimeout = d
+	}
+}
+
+// WithSeed set the seed of the hash functions and the decay random generator,
+// sketches with the same seed and input are identical, which makes tests and
+// replay analyses reproducible. The default seed is 0.
+func WithSeed(seed uint64) Option {
+	return func(o *options) {
+		o.seed = seed
+	}
+}
+
 // Topk implement by heavykeeper algorithm.
 type HeavyKeeper struct {
-	k           uint32
-	width       uint32
-	depth       uint32
-	decay       float64
-	lookupTable []float64
-	minCount    uint32
-
-	r        *rand.Rand
+	k        uint32
+	width    uint32
+	depth    uint32
+	decay    DecayPolicy
+	minCount uint32
+
+	r *rand.Rand
+	// data is the contiguous storage of all buckets, buckets are the row views of it.
+	data     []bucket
 	buckets  [][]bucket
-	minHeap  *minheap.Heap
+	minHeap  *minheap.Heap[uint32]
 	expelled chan Item
 	total    uint64
+	hll      *hyperloglog.HyperLogLog
+
+	collisions uint64
+	decays     uint64
+	expulsions uint64
+
+	// history is a ring of window snapshots, next is the slot to write.
+	history     []Snapshot
+	historyNext int
+	// nextExpire is the unix nanoseconds when the oldest item becomes stale.
+	nextExpire int64
+
+	opts options
 }
 
-func NewHeavyKeeper(k, width, depth uint32, decay float64, min uint32) Topk {
-	arrays := make([][]bucket, depth)
-	for i := range arrays {
-		arrays[i] = make([]bucket, width)
+func NewHeavyKeeper(k, width, depth uint32, decay float64, min uint32, opts ...Option) Topk {
+	opt := options{}
+	for _, o := range opts {
+		o(&opt)
+	}
+	if opt.phi > 0 {
+		k = max(k, uint32(math.Ceil(1/opt.phi)))
+	}
+	if opt.decay == nil {
+		opt.decay = ExponentialDecay(decay)
 	}
+	data, arrays := newBuckets(width, depth)
 
 	topk := &HeavyKeeper{
-		k:           k,
-		width:       width,
-		depth:       depth,
-		decay:       decay,
-		lookupTable: make([]float64, LOOKUP_TABLE),
-		buckets:     arrays,
-		r:           rand.New(rand.NewSource(0)),
-		minHeap:     minheap.NewHeap(k),
-		expelled:    make(chan Item, 32),
-		minCount:    min,
-	}
-	for i := 0; i < LOOKUP_TABLE; i++ {
-		topk.lookupTable[i] = math.Pow(decay, float64(i))
+		k:        k,
+		width:    width,
+		depth:    depth,
+		decay:    opt.decay,
+		data:     data,
+		buckets:  arrays,
+		r:        rand.New(rand.NewSource(opt.seed)),
+		minHeap:  minheap.NewHeap[uint32](k),
+		expelled: make(chan Item, 32),
+		minCount: min,
+		opts:     opt,
+	}
+	if opt.history > 0 {
+		topk.history = make([]Snapshot, 0, opt.history)
+	}
+	if opt.precision > 0 {
+		topk.hll = hyperloglog.New(opt.precision)
 	}
 	return topk
 }
@@ -57,49 +200,113 @@ func (topk *HeavyKeeper) Expelled() <-chan Item {
 	return topk.expelled
 }
 
+// List return all topk items sorted by count descending, ties are broken by key.
 func (topk *HeavyKeeper) List() []Item {
+	return topk.ListN(len(topk.minHeap.Nodes))
+}
+
+// ListN return the first n topk items sorted by count descending.
+func (topk *HeavyKeeper) ListN(n int) []Item {
+	if n <= 0 {
+		return []Item{}
+	}
+	if topk.opts.staleTimeout > 0 {
+		topk.expireStale(time.Now().UnixNano())
+	}
 	items := topk.minHeap.Sorted()
+	if n < len(items) {
+		items = items[:n]
+	}
+	phiCount := topk.phiCount()
 	res := make([]Item, 0, len(items))
-	for _, item := range items {
-		res = append(res, Item{Key: item.Key, Count: item.Count})
+	for i, item := range items {
+		if item.Count < phiCount {
+			break
+		}
+		res = append(res, topk.item(*item, i+1))
 	}
 	return res
 }
 
+// ListMin return the topk items whose count is at least minCount, sorted by count descending.
+func (topk *HeavyKeeper) ListMin(minCount uint32) []Item {
+	items := topk.List()
+	for i, item := range items {
+		if item.Count < minCount {
+			return items[:i]
+		}
+	}
+	return items
+}
+
+// SetMinCount set the minimal count for an item to be added into the topk,
+// items already in the topk are kept until they are expelled.
+func (topk *HeavyKeeper) SetMinCount(minCount uint32) {
+	topk.minCount = minCount
+}
+
+// phiCount return the minimal count of a phi-heavy-hitter, zero if phi mode is off.
+func (topk *HeavyKeeper) phiCount() uint32 {
+	if topk.opts.phi <= 0 {
+		return 0
+	}
+	return uint32(math.Ceil(topk.opts.phi * float64(topk.total)))
+}
+
 // Add add item into heavykeeper and return if item had beend add into minheap.
 // if item had been add into minheap and some item was expelled, return the expelled item.
+// Add does not allocate, the key bytes are hashed in place and heap nodes are reused.
 func (topk *HeavyKeeper) Add(key string, incr uint32) (string, bool) {
-	keyBytes := []byte(key)
-	itemFingerprint := murmur3.Sum32(keyBytes)
+	if topk.ignored(key) {
+		return "", false
+	}
+	keyBytes := unsafe.Slice(unsafe.StringData(key), len(key))
+	itemFingerprint := murmur3.SeedSum32(hashSeed(topk.opts.seed), keyBytes)
 	var maxCount uint32
+	if topk.hll != nil {
+		topk.hll.Add(keyBytes)
+	}
+
+	// the count which buckets held by the key are raised to in conservative update
+	var conservativeCount uint32
+	if topk.opts.conservative {
+		conservativeCount = topk.conservativeCount(keyBytes, itemFingerprint) + incr
+	}
 
 	// compute d hashes
 	for i, row := range topk.buckets {
 
-		bucketNumber := murmur3.SeedSum32(uint32(i), keyBytes) % uint32(topk.width)
+		bucketNumber := topk.bucketNumber(i, keyBytes)
 		fingerprint := row[bucketNumber].fingerprint
 		count := row[bucketNumber].count
 
 		if count == 0 {
+			// in conservative update the rows held by the key agree on its count
+			newCount := incr
+			if topk.opts.conservative {
+				newCount = conservativeCount
+			}
 			row[bucketNumber].fingerprint = itemFingerprint
-			row[bucketNumber].count = incr
-			maxCount = max(maxCount, incr)
+			row[bucketNumber].count = newCount
+			maxCount = max(maxCount, newCount)
 
 		} else if fingerprint == itemFingerprint {
-			row[bucketNumber].count += incr
+			if !topk.opts.conservative {
+				row[bucketNumber].count += incr
+			} else if count < conservativeCount {
+				row[bucketNumber].count = conservativeCount
+			}
 			maxCount = max(maxCount, row[bucketNumber].count)
 
 		} else {
+			topk.collisions++
 			for localIncr := incr; localIncr > 0; localIncr-- {
-				var decay float64
-				curCount := row[bucketNumber].count
-				if row[bucketNumber].count < LOOKUP_TABLE {
-					decay = topk.lookupTable[curCount]
-				} else {
-					// decr pow caculate cost
-					decay = topk.lookupTable[LOOKUP_TABLE-1]
+				decay := topk.decay.Probability(row[bucketNumber].count)
+				if decay <= 0 {
+					break
 				}
 				if topk.r.Float64() < decay {
+					topk.decays++
 					row[bucketNumber].count--
 					if row[bucketNumber].count == 0 {
 						row[bucketNumber].fingerprint = itemFingerprint
@@ -112,41 +319,201 @@ func (topk *HeavyKeeper) Add(key string, incr uint32) (string, bool) {
 		}
 	}
 	topk.total += uint64(incr)
-	if maxCount < topk.minCount {
+	var now int64
+	// the heap index of the key, found once unless the heap is pruned
+	itemHeapIdx, itemHeapExist, found := 0, false, false
+	if topk.opts.timestamps || topk.opts.staleTimeout > 0 {
+		now = time.Now().UnixNano()
+		// a key still accessed is not stale, even if its count decayed below
+		// the thresholds and the heap minimum
+		itemHeapIdx, itemHeapExist = topk.minHeap.Find(key)
+		found = true
+		if itemHeapExist {
+			topk.minHeap.Nodes[itemHeapIdx].LastSeen = now
+		}
+	}
+	if maxCount == 0 || maxCount < topk.minCount || maxCount < topk.phiCount() {
 		return "", false
 	}
+	if topk.expireStale(now) {
+		found = false
+	}
 	minHeap := topk.minHeap.Min()
 	if len(topk.minHeap.Nodes) == int(topk.k) && maxCount < minHeap {
 		return "", false
 	}
 	// update minheap
-	itemHeapIdx, itemHeapExist := topk.minHeap.Find(key)
+	if !found {
+		itemHeapIdx, itemHeapExist = topk.minHeap.Find(key)
+	}
 	if itemHeapExist {
 		topk.minHeap.Fix(itemHeapIdx, maxCount)
 		return "", true
 	}
 	var exp string
-	expelled := topk.minHeap.Add(&minheap.Node{Key: key, Count: maxCount})
-	if expelled != nil {
-		topk.expel(Item{Key: expelled.Key, Count: expelled.Count})
+	if expelled, ok := topk.minHeap.Add(minheap.Node[uint32]{Key: key, Count: maxCount, FirstSeen: now, LastSeen: now}); ok {
+		topk.expel(topk.item(expelled, 0), key)
 		exp = expelled.Key
 	}
 
 	return exp, true
 }
 
-func (topk *HeavyKeeper) expel(item Item) {
+// Consume add keys from the channel in batches until it is closed or ctx is done.
+func (topk *HeavyKeeper) Consume(ctx context.Context, keys <-chan string) error {
+	return consume(ctx, topk, keys)
+}
+
+// Decrement retract decr counts of key from the buckets it holds, the key is removed
+// from the topk once its count drops to zero.
+func (topk *HeavyKeeper) Decrement(key string, decr uint32) {
+	keyBytes := unsafe.Slice(unsafe.StringData(key), len(key))
+	itemFingerprint := murmur3.SeedSum32(hashSeed(topk.opts.seed), keyBytes)
+	var prevCount, maxCount uint32
+	for i, row := range topk.buckets {
+		b := &row[topk.bucketNumber(i, keyBytes)]
+		if b.count == 0 || b.fingerprint != itemFingerprint {
+			continue
+		}
+		prevCount = max(prevCount, b.count)
+		if b.count > decr {
+			b.count -= decr
+		} else {
+			*b = bucket{}
+		}
+		maxCount = max(maxCount, b.count)
+	}
+	// only the retracted counts are taken from the total
+	if retracted := uint64(prevCount - maxCount); topk.total > retracted {
+		topk.total -= retracted
+	} else {
+		topk.total = 0
+	}
+	if idx, ok := topk.minHeap.Find(key); ok {
+		if maxCount == 0 {
+			topk.minHeap.Remove(idx)
+		} else {
+			topk.minHeap.Fix(idx, maxCount)
+		}
+	}
+}
+
+// Remove retract all counts of key and remove it from the topk.
+func (topk *HeavyKeeper) Remove(key string) {
+	topk.Decrement(key, math.MaxUint32)
+}
+
+// expireStale drop the items not updated within the stale timeout, and report
+// whether any was dropped, which moves the others in the heap.
+func (topk *HeavyKeeper) expireStale(now int64) bool {
+	if topk.opts.staleTimeout <= 0 || now < topk.nextExpire {
+		return false
+	}
+	deadline := now - int64(topk.opts.staleTimeout)
+	stale := topk.minHeap.Prune(func(node *minheap.Node[uint32]) bool {
+		return node.LastSeen <= deadline
+	})
+	for _, node := range stale {
+		topk.expel(topk.item(node, 0), "")
+	}
+	oldest := now
+	for _, node := range topk.minHeap.Nodes {
+		if node.LastSeen < oldest {
+			oldest = node.LastSeen
+		}
+	}
+	topk.nextExpire = oldest + int64(topk.opts.staleTimeout)
+	return len(stale) > 0
+}
+
+// hashSeed fold the seed into the 32 bits seed of murmur3, so the seeds which
+// only differ in the upper bits hash differently.
+func hashSeed(seed uint64) uint32 {
+	return uint32(seed) ^ uint32(seed>>32)
+}
+
+// bucketNumber return the bucket of key in the i-th row.
+func (topk *HeavyKeeper) bucketNumber(i int, keyBytes []byte) uint32 {
+	return murmur3.SeedSum32(hashSeed(topk.opts.seed)+uint32(i), keyBytes) % topk.width
+}
+
+// conservativeCount return the minimal count of the buckets held by the fingerprint.
+func (topk *HeavyKeeper) conservativeCount(keyBytes []byte, fingerprint uint32) uint32 {
+	minCount := uint32(math.MaxUint32)
+	for i, row := range topk.buckets {
+		b := row[topk.bucketNumber(i, keyBytes)]
+		if b.count > 0 && b.fingerprint == fingerprint && b.count < minCount {
+			minCount = b.count
+		}
+	}
+	if minCount == math.MaxUint32 {
+		return 0
+	}
+	return minCount
+}
+
+func (topk *HeavyKeeper) ignored(key string) bool {
+	for _, prefix := range topk.opts.ignorePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	for _, pattern := range topk.opts.ignorePatterns {
+		if pattern.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (topk *HeavyKeeper) item(node minheap.Node[uint32], rank int) Item {
+	item := Item{Key: node.Key, Count: node.Count, Rank: rank}
+	if topk.opts.timestamps {
+		item.FirstSeen = time.Unix(0, node.FirstSeen)
+		item.LastSeen = time.Unix(0, node.LastSeen)
+	}
+	return item
+}
+
+func (topk *HeavyKeeper) expel(item Item, newKey string) {
+	topk.expulsions++
+	if topk.opts.expelledFn != nil {
+		topk.opts.expelledFn(item.Key, item.Count, newKey)
+	}
 	select {
 	case topk.expelled <- item:
 	default:
 	}
 }
 
+// cacheLineSize is the size of cpu cache line in bytes.
+const cacheLineSize = 64
+
+// bucket packs fingerprint and count into 8 bytes, so a cache line holds 8 buckets.
 type bucket struct {
 	fingerprint uint32
 	count       uint32
 }
 
+// newBuckets allocate the buckets of all rows in one contiguous array, each row
+// starts at a cache line boundary so walking a row touches the fewest cache lines.
+func newBuckets(width, depth uint32) ([]bucket, [][]bucket) {
+	perLine := uint32(cacheLineSize / unsafe.Sizeof(bucket{}))
+	stride := (width + perLine - 1) / perLine * perLine
+	data := make([]bucket, int(stride*depth+perLine-1))
+	// the garbage collector does not move heap objects, so the alignment holds
+	if offset := uintptr(unsafe.Pointer(unsafe.SliceData(data))) % cacheLineSize; offset != 0 {
+		data = data[(cacheLineSize-offset)/unsafe.Sizeof(bucket{}):]
+	}
+	data = data[:stride*depth]
+	rows := make([][]bucket, depth)
+	for i := range rows {
+		start := uint32(i) * stride
+		rows[i] = data[start : start+width : start+width]
+	}
+	return data, rows
+}
+
 func (b *bucket) Get() (uint32, uint32) {
 	return b.fingerprint, b.count
 }
@@ -169,6 +536,13 @@ func max(x, y uint32) uint32 {
 }
 
 func (topk *HeavyKeeper) Fading() {
+	if topk.opts.staleTimeout > 0 {
+		topk.expireStale(time.Now().UnixNano())
+	}
+	topk.snapshot()
+	if topk.hll != nil {
+		topk.hll.Reset()
+	}
 	for _, row := range topk.buckets {
 		for i := range row {
 			row[i].count = row[i].count >> 1
@@ -180,6 +554,114 @@ func (topk *HeavyKeeper) Fading() {
 	topk.total = topk.total >> 1
 }
 
+// Reset clear all buckets and the min-heap in place, for window rollover.
+func (topk *HeavyKeeper) Reset() {
+	topk.snapshot()
+	if topk.hll != nil {
+		topk.hll.Reset()
+	}
+	clear(topk.data)
+	topk.minHeap.Reset()
+	topk.total = 0
+	topk.collisions = 0
+	topk.decays = 0
+	topk.expulsions = 0
+}
+
+// History return the snapshots of the last windows, oldest first.
+func (topk *HeavyKeeper) History() []Snapshot {
+	res := make([]Snapshot, 0, len(topk.history))
+	res = append(res, topk.history[topk.historyNext:]...)
+	return append(res, topk.history[:topk.historyNext]...)
+}
+
+func (topk *HeavyKeeper) snapshot() {
+	if topk.opts.history <= 0 {
+		return
+	}
+	snapshot := Snapshot{Time: time.Now(), Total: topk.total, Cardinality: topk.Cardinality(), Items: topk.List()}
+	if len(topk.history) < topk.opts.history {
+		topk.history = append(topk.history, snapshot)
+		return
+	}
+	topk.history[topk.historyNext] = snapshot
+	topk.historyNext = (topk.historyNext + 1) % len(topk.history)
+}
+
+// Cardinality return the estimated distinct keys since the window rolled over,
+// zero if cardinality estimation is not enabled.
+func (topk *HeavyKeeper) Cardinality() uint64 {
+	if topk.hll == nil {
+		return 0
+	}
+	return topk.hll.Count()
+}
+
 func (topk *HeavyKeeper) Total() uint64 {
 	return topk.total
 }
+
+// Stats return the statistics of the sketch.
+func (topk *HeavyKeeper) Stats() Stats {
+	var used int
+	for _, row := range topk.buckets {
+		for i := range row {
+			if row[i].count > 0 {
+				used++
+			}
+		}
+	}
+	return Stats{
+		Total:           topk.total,
+		FillRatio:       float64(used) / float64(topk.width*topk.depth),
+		Collisions:      topk.collisions,
+		Decays:          topk.decays,
+		Expulsions:      topk.expulsions,
+		OverCountBound:  float64(topk.total) * float64(topk.depth) / (1 << 32),
+		UnderCountBound: math.E * float64(topk.total) / float64(topk.width),
+	}
+}
+
+// Histogram return the distribution of estimated key counts, the i-th bucket
+// holds counts in [2^i, 2^(i+1)-1]. Every occupied bucket holds the count of one
+// key, so the number of keys is averaged over the rows of the sketch.
+func (topk *HeavyKeeper) Histogram() []HistogramBucket {
+	var keys [32]uint64
+	var buckets int
+	for _, row := range topk.buckets {
+		for i := range row {
+			if row[i].count == 0 {
+				continue
+			}
+			idx := bits.Len32(row[i].count) - 1
+			keys[idx]++
+			if idx >= buckets {
+				buckets = idx + 1
+			}
+		}
+	}
+	res := make([]HistogramBucket, buckets)
+	for i := range res {
+		res[i] = HistogramBucket{
+			Min:  1 << i,
+			Max:  1<<(i+1) - 1,
+			Keys: (keys[i] + uint64(topk.depth)/2) / uint64(topk.depth),
+		}
+	}
+	return res
+}
+
+// SizeBytes return the memory consumed by buckets, min-heap and key storage.
+func (topk *HeavyKeeper) SizeBytes() uint64 {
+	size := uint64(unsafe.Sizeof(*topk))
+	size += uint64(cap(topk.buckets)) * uint64(unsafe.Sizeof(topk.buckets[0]))
+	size += uint64(cap(topk.data)) * uint64(unsafe.Sizeof(bucket{}))
+	size += uint64(cap(topk.expelled)) * uint64(unsafe.Sizeof(Item{}))
+	if d, ok := topk.decay.(*exponentialDecay); ok {
+		size += uint64(cap(d.lookupTable)) * uint64(unsafe.Sizeof(d.lookupTable[0]))
+	}
+	if topk.hll != nil {
+		size += topk.hll.SizeBytes()
+	}
+	return size + topk.minHeap.SizeBytes()
+}
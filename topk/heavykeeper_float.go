@@ -0,0 +1,170 @@
+package topk
+
+import (
+	"math"
+	"unsafe"
+
+	"github.com/twmb/murmur3"
+	"github.com/zychimne/aegis/internal/minheap"
+	"golang.org/x/exp/rand"
+)
+
+// FloatHeavyKeeper is the float64 weighted variant of HeavyKeeper.
+// A colliding bucket is decayed by the whole weight with probability decay^count,
+// so the expected decay matches the unit-by-unit decay of HeavyKeeper.
+type FloatHeavyKeeper struct {
+	k         uint32
+	width     uint32
+	depth     uint32
+	decay     DecayPolicy
+	minWeight float64
+	seed      uint64
+
+	r       *rand.Rand
+	buckets [][]floatBucket
+	minHeap *minheap.Heap[float64]
+	total   float64
+}
+
+// NewFloatHeavyKeeper return a FloatHeavyKeeper, WithSeed is the only option
+// applied to it.
+func NewFloatHeavyKeeper(k, width, depth uint32, decay float64, min float64, opts ...Option) FloatTopk {
+	opt := options{}
+	for _, o := range opts {
+		o(&opt)
+	}
+	arrays := make([][]floatBucket, depth)
+	for i := range arrays {
+		arrays[i] = make([]floatBucket, width)
+	}
+
+	topk := &FloatHeavyKeeper{
+		k:         k,
+		width:     width,
+		depth:     depth,
+		decay:     ExponentialDecay(decay),
+		buckets:   arrays,
+		r:         rand.New(rand.NewSource(opt.seed)),
+		minHeap:   minheap.NewHeap[float64](k),
+		minWeight: min,
+		seed:      opt.seed,
+	}
+	return topk
+}
+
+// List return all topk items sorted by weight descending, ties are broken by key.
+func (topk *FloatHeavyKeeper) List() []FloatItem {
+	return topk.ListN(len(topk.minHeap.Nodes))
+}
+
+// ListN return the first n topk items sorted by weight descending.
+func (topk *FloatHeavyKeeper) ListN(n int) []FloatItem {
+	if n <= 0 {
+		return []FloatItem{}
+	}
+	items := topk.minHeap.Sorted()
+	if n < len(items) {
+		items = items[:n]
+	}
+	res := make([]FloatItem, 0, len(items))
+	for i, item := range items {
+		res = append(res, FloatItem{Key: item.Key, Weight: item.Count, Rank: i + 1})
+	}
+	return res
+}
+
+// Add add item with weight into heavykeeper and return if item had been add into minheap.
+// if item had been add into minheap and some item was expelled, return the expelled item.
+// Weights which are not positive and finite are ignored.
+func (topk *FloatHeavyKeeper) Add(key string, weight float64) (string, bool) {
+	if !(weight > 0) || math.IsInf(weight, 1) {
+		return "", false
+	}
+	keyBytes := unsafe.Slice(unsafe.StringData(key), len(key))
+	itemFingerprint := murmur3.SeedSum32(hashSeed(topk.seed), keyBytes)
+	var maxWeight float64
+
+	for i, row := range topk.buckets {
+		b := &row[topk.bucketNumber(i, keyBytes)]
+		if b.count == 0 {
+			b.fingerprint = itemFingerprint
+			b.count = weight
+		} else if b.fingerprint == itemFingerprint {
+			b.count += weight
+		} else if topk.r.Float64() < topk.decay.Probability(uint32(math.Min(b.count, math.MaxUint32))) {
+			b.count -= weight
+			if b.count > 0 {
+				continue
+			}
+			b.fingerprint = itemFingerprint
+			b.count = -b.count
+		} else {
+			continue
+		}
+		maxWeight = math.Max(maxWeight, b.count)
+	}
+	topk.total += weight
+	if maxWeight == 0 || maxWeight < topk.minWeight {
+		return "", false
+	}
+	if len(topk.minHeap.Nodes) == int(topk.k) && maxWeight < topk.minHeap.Min() {
+		return "", false
+	}
+	if idx, ok := topk.minHeap.Find(key); ok {
+		topk.minHeap.Fix(idx, maxWeight)
+		return "", true
+	}
+	var exp string
+	if expelled, ok := topk.minHeap.Add(minheap.Node[float64]{Key: key, Count: maxWeight}); ok {
+		exp = expelled.Key
+	}
+	return exp, true
+}
+
+// bucketNumber return the bucket of key in the i-th row.
+func (topk *FloatHeavyKeeper) bucketNumber(i int, keyBytes []byte) uint32 {
+	return murmur3.SeedSum32(hashSeed(topk.seed)+uint32(i), keyBytes) % topk.width
+}
+
+func (topk *FloatHeavyKeeper) Fading() {
+	for _, row := range topk.buckets {
+		for i := range row {
+			row[i].count /= 2
+		}
+	}
+	for i := 0; i < len(topk.minHeap.Nodes); i++ {
+		topk.minHeap.Nodes[i].Count /= 2
+	}
+	topk.total /= 2
+}
+
+// Reset clear all buckets and the min-heap in place, for window rollover.
+func (topk *FloatHeavyKeeper) Reset() {
+	for _, row := range topk.buckets {
+		for i := range row {
+			row[i] = floatBucket{}
+		}
+	}
+	topk.minHeap.Reset()
+	topk.total = 0
+}
+
+func (topk *FloatHeavyKeeper) Total() float64 {
+	return topk.total
+}
+
+// SizeBytes return the memory consumed by buckets, min-heap and key storage.
+func (topk *FloatHeavyKeeper) SizeBytes() uint64 {
+	size := uint64(unsafe.Sizeof(*topk))
+	size += uint64(cap(topk.buckets)) * uint64(unsafe.Sizeof(topk.buckets[0]))
+	size += uint64(topk.depth) * uint64(topk.width) * uint64(unsafe.Sizeof(floatBucket{}))
+	if d, ok := topk.decay.(*exponentialDecay); ok {
+		size += uint64(cap(d.lookupTable)) * uint64(unsafe.Sizeof(d.lookupTable[0]))
+	}
+	return size + topk.minHeap.SizeBytes()
+}
+
+type floatBucket struct {
+	fingerprint uint32
+	count       float64
+}
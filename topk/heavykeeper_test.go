@@ -1,10 +1,15 @@
 package topk
 
 import (
+	"context"
+	"errors"
 	"math"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/exp/rand"
@@ -39,8 +44,495 @@ func BenchmarkAdd(b *testing.B) {
 		data[i] = strconv.FormatUint(zipf.Uint64(), 10)
 	}
 	topk := NewHeavyKeeper(10, 1000, 5, 0.9, 0)
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		topk.Add(data[i%1000], 1)
 	}
 }
+
+func TestTopkExpelledFunc(t *testing.T) {
+	var expelled []Item
+	var newKeys []string
+	topk := NewHeavyKeeper(2, 1000, 4, 0.925, 0, WithExpelledFunc(func(expelledKey string, count uint32, newKey string) {
+		expelled = append(expelled, Item{Key: expelledKey, Count: count})
+		newKeys = append(newKeys, newKey)
+	}))
+	topk.Add("a", 10)
+	topk.Add("b", 5)
+	exp, added := topk.Add("c", 20)
+	assert.True(t, added)
+	assert.Equal(t, "b", exp)
+	assert.Equal(t, []Item{{Key: "b", Count: 5}}, expelled)
+	assert.Equal(t, []string{"c"}, newKeys)
+}
+
+func TestTopkListN(t *testing.T) {
+	topk := NewHeavyKeeper(5, 1000, 4, 0.925, 0)
+	topk.Add("a", 10)
+	topk.Add("b", 30)
+	topk.Add("c", 20)
+	topk.Add("d", 20)
+	assert.Equal(t, []Item{
+		{Key: "b", Count: 30, Rank: 1},
+		{Key: "c", Count: 20, Rank: 2},
+		{Key: "d", Count: 20, Rank: 3},
+		{Key: "a", Count: 10, Rank: 4},
+	}, topk.List())
+	assert.Equal(t, []Item{
+		{Key: "b", Count: 30, Rank: 1},
+		{Key: "c", Count: 20, Rank: 2},
+	}, topk.ListN(2))
+	assert.Equal(t, 4, len(topk.ListN(10)))
+	assert.Equal(t, 0, len(topk.ListN(0)))
+}
+
+func TestTopkStats(t *testing.T) {
+	// decay base 1 always decays the colliding bucket
+	topk := NewHeavyKeeper(1, 1, 2, 1, 0)
+	stats := topk.Stats()
+	assert.Equal(t, float64(0), stats.FillRatio)
+
+	topk.Add("a", 10)
+	topk.Add("b", 25)
+	stats = topk.Stats()
+	assert.Equal(t, uint64(35), stats.Total)
+	assert.Equal(t, float64(1), stats.FillRatio)
+	assert.Equal(t, uint64(2), stats.Collisions)
+	assert.Equal(t, uint64(20), stats.Decays)
+	assert.Equal(t, uint64(1), stats.Expulsions)
+	assert.InEpsilon(t, math.E*35, stats.UnderCountBound, 1e-9)
+	assert.Less(t, stats.OverCountBound, 1.0)
+}
+
+func TestTopkReset(t *testing.T) {
+	topk := NewHeavyKeeper(2, 1000, 4, 0.925, 0)
+	topk.Add("a", 10)
+	topk.Add("b", 5)
+	topk.Add("c", 20)
+	topk.Reset()
+	assert.Equal(t, 0, len(topk.List()))
+	assert.Equal(t, Stats{}, topk.Stats())
+
+	topk.Add("b", 5)
+	assert.Equal(t, []Item{{Key: "b", Count: 5, Rank: 1}}, topk.List())
+}
+
+func TestTopkPhi(t *testing.T) {
+	topk := NewHeavyKeeper(0, 1000, 4, 0.925, 0, WithPhi(0.1))
+	topk.Add("a", 50)
+	topk.Add("b", 30)
+	topk.Add("c", 15)
+	_, added := topk.Add("d", 5)
+	assert.False(t, added)
+	assert.Equal(t, []Item{
+		{Key: "a", Count: 50, Rank: 1},
+		{Key: "b", Count: 30, Rank: 2},
+		{Key: "c", Count: 15, Rank: 3},
+	}, topk.List())
+
+	topk.Add("e", 100)
+	assert.Equal(t, []Item{
+		{Key: "e", Count: 100, Rank: 1},
+		{Key: "a", Count: 50, Rank: 2},
+		{Key: "b", Count: 30, Rank: 3},
+	}, topk.List())
+}
+
+func TestFloatTopkList(t *testing.T) {
+	topk := NewFloatHeavyKeeper(2, 1000, 4, 0.925, 0)
+	topk.Add("a", 0.5)
+	topk.Add("b", 1.5)
+	topk.Add("a", 0.25)
+	exp, added := topk.Add("c", 2.25)
+	assert.True(t, added)
+	assert.Equal(t, "a", exp)
+	assert.Equal(t, []FloatItem{
+		{Key: "c", Weight: 2.25, Rank: 1},
+		{Key: "b", Weight: 1.5, Rank: 2},
+	}, topk.List())
+
+	topk.Fading()
+	assert.Equal(t, []FloatItem{{Key: "c", Weight: 1.125, Rank: 1}}, topk.ListN(1))
+	topk.Reset()
+	assert.Equal(t, 0, len(topk.List()))
+}
+
+func TestFloatTopkInvalidWeight(t *testing.T) {
+	topk := NewFloatHeavyKeeper(2, 1000, 4, 0.925, 0)
+	for _, weight := range []float64{0, -1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, added := topk.Add("a", weight)
+		assert.False(t, added, weight)
+	}
+	assert.Equal(t, 0.0, topk.(*FloatHeavyKeeper).Total())
+	topk.Add("a", 1)
+	assert.Equal(t, []FloatItem{{Key: "a", Weight: 1, Rank: 1}}, topk.List())
+}
+
+func TestFloatTopkSeed(t *testing.T) {
+	seeded := NewFloatHeavyKeeper(10, 1<<20, 4, 0.925, 0, WithSeed(7)).(*FloatHeavyKeeper)
+	unseeded := NewFloatHeavyKeeper(10, 1<<20, 4, 0.925, 0).(*FloatHeavyKeeper)
+	assert.NotEqual(t, unseeded.bucketNumber(0, []byte("a")), seeded.bucketNumber(0, []byte("a")))
+	hk := NewHeavyKeeper(10, 1<<20, 4, 0.925, 0, WithSeed(7)).(*HeavyKeeper)
+	assert.Equal(t, hk.bucketNumber(1, []byte("a")), seeded.bucketNumber(1, []byte("a")))
+}
+
+func TestDecayPolicy(t *testing.T) {
+	exp := ExponentialDecay(0.5)
+	assert.Equal(t, 1.0, exp.Probability(0))
+	assert.Equal(t, 0.25, exp.Probability(2))
+	assert.Equal(t, math.Pow(0.5, LOOKUP_TABLE-1), exp.Probability(1000))
+	assert.Equal(t, 0.75, LinearDecay(4).Probability(1))
+	assert.Equal(t, 0.0, LinearDecay(4).Probability(5))
+	assert.Equal(t, 0.25, ProportionalDecay().Probability(3))
+	assert.Equal(t, 0.0, NoDecay().Probability(0))
+}
+
+func TestTopkNoDecay(t *testing.T) {
+	topk := NewHeavyKeeper(2, 1, 1, 0.925, 0, WithDecayPolicy(NoDecay()))
+	topk.Add("a", 10)
+	_, added := topk.Add("b", 100)
+	assert.False(t, added)
+	assert.Equal(t, []Item{{Key: "a", Count: 10, Rank: 1}}, topk.List())
+	assert.Equal(t, uint64(0), topk.Stats().Decays)
+}
+
+func TestTopkSizeBytes(t *testing.T) {
+	topk := NewHeavyKeeper(10, 1000, 4, 0.925, 0)
+	empty := topk.SizeBytes()
+	assert.LessOrEqual(t, uint64(1000*4*8), empty)
+	topk.Add("hello", 10)
+	assert.Less(t, empty+uint64(len("hello")), topk.SizeBytes())
+	topk.Reset()
+	assert.LessOrEqual(t, empty, topk.SizeBytes())
+}
+
+func TestTopkAddAllocs(t *testing.T) {
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = strings.Repeat("k", 64) + strconv.Itoa(i)
+	}
+	topk := NewHeavyKeeper(10, 1000, 4, 0.925, 0)
+	for _, key := range keys {
+		topk.Add(key, 1)
+	}
+	var i int
+	allocs := testing.AllocsPerRun(10000, func() {
+		// skewed keys keep the min-heap expelling and admitting items
+		topk.Add(keys[(i*i)%len(keys)], uint32(i%7+1))
+		i++
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func TestTopkTimestamps(t *testing.T) {
+	topk := NewHeavyKeeper(1, 1000, 4, 0.925, 0, WithTimestamps())
+	start := time.Now()
+	topk.Add("a", 10)
+	time.Sleep(10 * time.Millisecond)
+	mid := time.Now()
+	topk.Add("a", 1)
+	items := topk.List()
+	assert.Equal(t, 1, len(items))
+	assert.False(t, items[0].FirstSeen.Before(start))
+	assert.True(t, items[0].FirstSeen.Before(mid))
+	assert.False(t, items[0].LastSeen.Before(mid))
+
+	topk.Add("b", 20)
+	expelled := <-topk.Expelled()
+	assert.Equal(t, "a", expelled.Key)
+	assert.Equal(t, items[0].LastSeen, expelled.LastSeen)
+
+	noTimestamps := NewHeavyKeeper(1, 1000, 4, 0.925, 0)
+	noTimestamps.Add("a", 10)
+	assert.True(t, noTimestamps.List()[0].FirstSeen.IsZero())
+}
+
+func TestTopkIgnore(t *testing.T) {
+	topk := NewHeavyKeeper(10, 1000, 4, 0.925, 0,
+		WithIgnorePrefixes("/health"),
+		WithIgnorePatterns(regexp.MustCompile(`^probe-\d+$`)))
+	for _, key := range []string{"/healthz", "probe-1", "probe-2", "user-1", "probe-x"} {
+		topk.Add(key, 10)
+	}
+	assert.Equal(t, []Item{
+		{Key: "probe-x", Count: 10, Rank: 1},
+		{Key: "user-1", Count: 10, Rank: 2},
+	}, topk.List())
+	assert.Equal(t, uint64(20), topk.Stats().Total)
+}
+
+func TestTopkHistory(t *testing.T) {
+	topk := NewHeavyKeeper(10, 1000, 4, 0.925, 0, WithHistory(2))
+	assert.Equal(t, 0, len(topk.History()))
+	for i := 0; i < 3; i++ {
+		topk.Add(strconv.Itoa(i), 10)
+		topk.Reset()
+	}
+	history := topk.History()
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, []Item{{Key: "1", Count: 10, Rank: 1}}, history[0].Items)
+	assert.Equal(t, []Item{{Key: "2", Count: 10, Rank: 1}}, history[1].Items)
+	assert.Equal(t, uint64(10), history[1].Total)
+	assert.False(t, history[1].Time.Before(history[0].Time))
+
+	noHistory := NewHeavyKeeper(10, 1000, 4, 0.925, 0)
+	noHistory.Fading()
+	assert.Equal(t, 0, len(noHistory.History()))
+}
+
+func TestTopkCardinality(t *testing.T) {
+	topk := NewHeavyKeeper(10, 1000, 4, 0.925, 0, WithCardinality(12), WithHistory(1))
+	for i := 0; i < 1000; i++ {
+		topk.Add(strconv.Itoa(i%500), 1)
+	}
+	assert.InEpsilon(t, 500, float64(topk.Cardinality()), 0.05)
+	topk.Fading()
+	assert.Equal(t, uint64(0), topk.Cardinality())
+	assert.InEpsilon(t, 500, float64(topk.History()[0].Cardinality), 0.05)
+
+	assert.Equal(t, uint64(0), NewHeavyKeeper(10, 1000, 4, 0.925, 0).Cardinality())
+}
+
+func TestTopkHistogram(t *testing.T) {
+	topk := NewHeavyKeeper(10, 1000, 2, 0.925, 0)
+	assert.Equal(t, 0, len(topk.Histogram()))
+	topk.Add("a", 1)
+	topk.Add("b", 5)
+	topk.Add("c", 6)
+	topk.Add("d", 16)
+	assert.Equal(t, []HistogramBucket{
+		{Min: 1, Max: 1, Keys: 1},
+		{Min: 2, Max: 3, Keys: 0},
+		{Min: 4, Max: 7, Keys: 2},
+		{Min: 8, Max: 15, Keys: 0},
+		{Min: 16, Max: 31, Keys: 1},
+	}, topk.Histogram())
+}
+
+func TestTopkMinCount(t *testing.T) {
+	topk := NewHeavyKeeper(10, 1000, 4, 0.925, 5)
+	topk.Add("a", 4)
+	topk.Add("b", 10)
+	topk.Add("c", 20)
+	assert.Equal(t, []Item{{Key: "c", Count: 20, Rank: 1}}, topk.ListMin(15))
+
+	topk.SetMinCount(30)
+	_, added := topk.Add("a", 21)
+	assert.False(t, added)
+	topk.SetMinCount(1)
+	_, added = topk.Add("a", 1)
+	assert.True(t, added)
+	assert.Equal(t, 3, len(topk.List()))
+}
+
+func TestTopkConservativeUpdate(t *testing.T) {
+	topk := NewHeavyKeeper(10, 1000, 4, 0.925, 0, WithConservativeUpdate()).(*HeavyKeeper)
+	topk.Add("a", 10)
+	// inflate one bucket of a, as if a colliding fingerprint was counted in it
+	keyBytes := []byte("a")
+	row := topk.buckets[0]
+	row[topk.bucketNumber(0, keyBytes)].count = 100
+
+	topk.Add("a", 5)
+	for i, row := range topk.buckets {
+		count := row[topk.bucketNumber(i, keyBytes)].count
+		if i == 0 {
+			assert.Equal(t, uint32(100), count)
+		} else {
+			assert.Equal(t, uint32(15), count)
+		}
+	}
+
+	// an emptied bucket is refilled with the count of the other rows
+	row = topk.buckets[1]
+	row[topk.bucketNumber(1, keyBytes)] = bucket{}
+	topk.Add("a", 1)
+	for i, row := range topk.buckets[1:] {
+		assert.Equal(t, uint32(16), row[topk.bucketNumber(i+1, keyBytes)].count, i+1)
+	}
+}
+
+func TestTopkDecrementRemove(t *testing.T) {
+	topk := NewHeavyKeeper(10, 1000, 4, 0.925, 0)
+	topk.Add("a", 10)
+	topk.Add("b", 20)
+	topk.Add("c", 30)
+
+	topk.Decrement("c", 25)
+	assert.Equal(t, []Item{
+		{Key: "b", Count: 20, Rank: 1},
+		{Key: "a", Count: 10, Rank: 2},
+		{Key: "c", Count: 5, Rank: 3},
+	}, topk.List())
+	topk.Decrement("a", 10)
+	topk.Remove("b")
+	topk.Remove("unknown")
+	assert.Equal(t, []Item{{Key: "c", Count: 5, Rank: 1}}, topk.List())
+	assert.Equal(t, uint64(5), topk.Stats().Total)
+
+	topk.Add("b", 1)
+	assert.Equal(t, []Item{
+		{Key: "c", Count: 5, Rank: 1},
+		{Key: "b", Count: 1, Rank: 2},
+	}, topk.List())
+}
+
+func TestTopkStaleTimeoutAccessed(t *testing.T) {
+	topk := NewHeavyKeeper(2, 1000, 4, 0.925, 0, WithStaleTimeout(50*time.Millisecond))
+	topk.Add("a", 10)
+	// a keeps being accessed below the min count, so it is not stale
+	topk.SetMinCount(100)
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		_, added := topk.Add("a", 1)
+		assert.False(t, added)
+	}
+	assert.Equal(t, []string{"a"}, func() []string {
+		var keys []string
+		for _, item := range topk.List() {
+			keys = append(keys, item.Key)
+		}
+		return keys
+	}())
+}
+
+func TestTopkStaleTimeout(t *testing.T) {
+	var stale []string
+	topk := NewHeavyKeeper(2, 1000, 4, 0.925, 0,
+		WithStaleTimeout(50*time.Millisecond),
+		WithExpelledFunc(func(expelledKey string, count uint32, newKey string) {
+			if newKey == "" {
+				stale = append(stale, expelledKey)
+			}
+		}))
+	topk.Add("a", 100)
+	topk.Add("b", 50)
+	time.Sleep(30 * time.Millisecond)
+	topk.Add("b", 1)
+	_, added := topk.Add("c", 10)
+	assert.False(t, added)
+
+	time.Sleep(30 * time.Millisecond)
+	_, added = topk.Add("c", 1)
+	assert.True(t, added)
+	assert.Equal(t, []string{"a"}, stale)
+	assert.Equal(t, []Item{
+		{Key: "b", Count: 51, Rank: 1},
+		{Key: "c", Count: 11, Rank: 2},
+	}, topk.List())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, 0, len(topk.List()))
+}
+
+func TestTopkStaleTimeoutPruned(t *testing.T) {
+	topk := NewHeavyKeeper(3, 1000, 4, 0.925, 0, WithStaleTimeout(50*time.Millisecond))
+	topk.Add("a", 10)
+	topk.Add("b", 50)
+	time.Sleep(30 * time.Millisecond)
+	topk.Add("b", 1)
+	// a is pruned by the add of b, which moves b in the heap
+	time.Sleep(30 * time.Millisecond)
+	_, added := topk.Add("b", 5)
+	assert.True(t, added)
+	assert.Equal(t, []Item{{Key: "b", Count: 56, Rank: 1}}, topk.List())
+}
+
+func TestTopkSeed(t *testing.T) {
+	newTopk := func(seed uint64) Topk {
+		topk := NewHeavyKeeper(10, 100, 4, 0.925, 0, WithSeed(seed))
+		zipf := rand.NewZipf(rand.New(rand.NewSource(42)), 1.5, 2, 10000)
+		for i := 0; i < 10000; i++ {
+			topk.Add(strconv.FormatUint(zipf.Uint64(), 10), 1)
+		}
+		return topk
+	}
+	a, b := newTopk(7), newTopk(7)
+	assert.Equal(t, a.List(), b.List())
+	assert.Equal(t, a.Stats(), b.Stats())
+
+	seeded := NewHeavyKeeper(10, 1<<20, 4, 0.925, 0, WithSeed(7)).(*HeavyKeeper)
+	unseeded := NewHeavyKeeper(10, 1<<20, 4, 0.925, 0).(*HeavyKeeper)
+	assert.NotEqual(t, unseeded.bucketNumber(0, []byte("a")), seeded.bucketNumber(0, []byte("a")))
+	// the upper 32 bits of the seed change the hashes too
+	upper := NewHeavyKeeper(10, 1<<20, 4, 0.925, 0, WithSeed(1<<32+7)).(*HeavyKeeper)
+	assert.NotEqual(t, seeded.bucketNumber(0, []byte("a")), upper.bucketNumber(0, []byte("a")))
+}
+
+func TestTopkConsume(t *testing.T) {
+	topk := NewHeavyKeeper(10, 1000, 4, 0.925, 0)
+	keys := make(chan string, 100)
+	for i := 0; i < 100; i++ {
+		keys <- strconv.Itoa(i % 3)
+	}
+	close(keys)
+	assert.Nil(t, topk.Consume(context.Background(), keys))
+	assert.Equal(t, []Item{
+		{Key: "0", Count: 34, Rank: 1},
+		{Key: "1", Count: 33, Rank: 2},
+		{Key: "2", Count: 33, Rank: 3},
+	}, topk.List())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, topk.Consume(ctx, make(chan string)))
+}
+
+func TestTopkBucketLayout(t *testing.T) {
+	topk := NewHeavyKeeper(10, 100, 4, 0.925, 0).(*HeavyKeeper)
+	assert.Equal(t, uintptr(8), unsafe.Sizeof(bucket{}))
+	for i, row := range topk.buckets {
+		assert.Equal(t, 100, len(row))
+		start := uintptr(unsafe.Pointer(&row[0]))
+		assert.Equal(t, uintptr(0), start%cacheLineSize)
+		if i > 0 {
+			// rows are contiguous, 100 buckets are padded to 104 for alignment
+			prev := uintptr(unsafe.Pointer(&topk.buckets[i-1][0]))
+			assert.Equal(t, uintptr(104*8), start-prev)
+		}
+	}
+}
+
+func TestFailureTopk(t *testing.T) {
+	topk := NewFailureTopk(NewHeavyKeeper(2, 1000, 4, 0.925, 0), NewHeavyKeeper(2, 1000, 4, 0.925, 0))
+	fail := errors.New("fail")
+	for i := 0; i < 10; i++ {
+		topk.Record("a", 1, nil)
+		topk.Record("b", 1, nil)
+		if i < 3 {
+			assert.Equal(t, fail, topk.Do("c", func() error { return fail }))
+		}
+	}
+	assert.ElementsMatch(t, []string{"a", "b"}, []string{topk.List()[0].Key, topk.List()[1].Key})
+	failed := topk.Failed().List()
+	assert.Len(t, failed, 1)
+	assert.Equal(t, Item{Key: "c", Count: 3, Rank: 1}, failed[0])
+	topk.Reset()
+	assert.Empty(t, topk.List())
+	assert.Empty(t, topk.Failed().List())
+}
+
+func TestTopkSketchRestore(t *testing.T) {
+	src := NewHeavyKeeper(3, 100, 4, 0.925, 0, WithSeed(7)).(*HeavyKeeper)
+	for i := 0; i < 5; i++ {
+		for j := 0; j <= i; j++ {
+			src.Add(strconv.Itoa(i), 1)
+		}
+	}
+	sketch := src.Sketch()
+	assert.Len(t, sketch.Buckets, 400)
+	assert.Equal(t, uint64(15), sketch.Total)
+
+	dst := NewHeavyKeeper(3, 100, 4, 0.925, 0, WithSeed(7)).(*HeavyKeeper)
+	assert.NoError(t, dst.Restore(sketch))
+	assert.Equal(t, src.List(), dst.List())
+	assert.Equal(t, src.Stats().FillRatio, dst.Stats().FillRatio)
+	// the restored buckets keep counting the same keys
+	src.Add("4", 1)
+	dst.Add("4", 1)
+	assert.Equal(t, src.List(), dst.List())
+
+	assert.ErrorIs(t, NewHeavyKeeper(3, 100, 4, 0.925, 0).(*HeavyKeeper).Restore(sketch), ErrShapeMismatch)
+	assert.ErrorIs(t, NewHeavyKeeper(3, 50, 4, 0.925, 0, WithSeed(7)).(*HeavyKeeper).Restore(sketch), ErrShapeMismatch)
+}
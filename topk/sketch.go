@@ -0,0 +1,78 @@
+package topk
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zychimne/aegis/internal/minheap"
+)
+
+// ErrShapeMismatch is returned when restoring a sketch of a different width,
+// depth or seed.
+var ErrShapeMismatch = errors.New("topk: sketch shape mismatch")
+
+// Bucket is a bucket of the sketch, holding the fingerprint of a key and its count.
+type Bucket struct {
+	Fingerprint uint32
+	Count       uint32
+}
+
+// Sketch is the exported state of a heavykeeper, so it can be persisted or
+// exchanged between instances. Buckets are row-major, width buckets per row.
+type Sketch struct {
+	Width   uint32
+	Depth   uint32
+	Seed    uint64
+	Total   uint64
+	Buckets []Bucket
+	Items   []Item
+}
+
+// Sketch return a copy of the buckets and the topk items.
+func (topk *HeavyKeeper) Sketch() Sketch {
+	s := Sketch{
+		Width:   topk.width,
+		Depth:   topk.depth,
+		Seed:    topk.opts.seed,
+		Total:   topk.total,
+		Buckets: make([]Bucket, 0, topk.width*topk.depth),
+		Items:   topk.List(),
+	}
+	for _, row := range topk.buckets {
+		for _, b := range row {
+			s.Buckets = append(s.Buckets, Bucket{Fingerprint: b.fingerprint, Count: b.count})
+		}
+	}
+	return s
+}
+
+// Restore replace the buckets and the topk items with the sketch, which must
+// be of the same width, depth and seed. Items beyond k are dropped.
+func (topk *HeavyKeeper) Restore(s Sketch) error {
+	if s.Width != topk.width || s.Depth != topk.depth || s.Seed != topk.opts.seed ||
+		len(s.Buckets) != int(s.Width*s.Depth) {
+		return ErrShapeMismatch
+	}
+	for i, row := range topk.buckets {
+		for j := range row {
+			b := s.Buckets[i*int(topk.width)+j]
+			row[j] = bucket{fingerprint: b.Fingerprint, count: b.Count}
+		}
+	}
+	topk.minHeap.Reset()
+	for _, item := range s.Items {
+		node := minheap.Node[uint32]{Key: item.Key, Count: item.Count}
+		if !item.FirstSeen.IsZero() {
+			node.FirstSeen = item.FirstSeen.UnixNano()
+		}
+		if !item.LastSeen.IsZero() {
+			node.LastSeen = item.LastSeen.UnixNano()
+		} else if topk.opts.staleTimeout > 0 {
+			node.LastSeen = time.Now().UnixNano()
+		}
+		topk.minHeap.Add(node)
+	}
+	topk.total = s.Total
+	topk.nextExpire = 0
+	return nil
+}
@@ -1,18 +1,121 @@
 package topk
 
+import (
+	"context"
+	"time"
+)
+
 // Item is topk item.
 type Item struct {
 	Key   string
 	Count uint32
+	// Rank is the 1-based position of the item in List, zero for expelled items.
+	Rank int
+	// FirstSeen is the time the item entered the topk and LastSeen is the time
+	// it was last updated, both are zero unless timestamps are enabled.
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// ExpelledFunc is called when an item is expelled from the topk, with the
+// expelled key, its count and the new key which took its place.
+type ExpelledFunc func(expelledKey string, count uint32, newKey string)
+
+// Snapshot is the topk items of a window, taken when the window rolls over.
+type Snapshot struct {
+	// Time is the end time of the window.
+	Time  time.Time
+	Total uint64
+	// Cardinality is the estimated distinct keys of the window, zero unless enabled.
+	Cardinality uint64
+	Items       []Item
+}
+
+// HistogramBucket is the number of keys whose estimated count falls in [Min, Max].
+type HistogramBucket struct {
+	Min  uint32
+	Max  uint32
+	Keys uint64
+}
+
+// Stats is the statistics of a topk sketch, it tells whether the chosen
+// width and depth are adequate for the key cardinality.
+type Stats struct {
+	// Total is the sum of all increments added into the sketch.
+	Total uint64
+	// FillRatio is the ratio of occupied buckets to all buckets.
+	FillRatio float64
+	// Collisions is the number of times a key hit a bucket held by another key.
+	Collisions uint64
+	// Decays is the number of times a colliding bucket was decayed.
+	Decays uint64
+	// Expulsions is the number of items expelled from the topk.
+	Expulsions uint64
+	// OverCountBound is the expected over-count of a key caused by fingerprint collisions.
+	OverCountBound float64
+	// UnderCountBound is the estimated under-count of a key caused by decay,
+	// it is a count-min style bound e*Total/width which holds with probability 1-e^-depth.
+	UnderCountBound float64
 }
 
 // Topk algorithm interface.
 type Topk interface {
 	// Add item and return if item is in the topk.
 	Add(item string, incr uint32) (string, bool)
-	// List all topk items.
+	// Decrement retract decr counts of item.
+	Decrement(item string, decr uint32)
+	// Remove retract all counts of item and remove it from the topk.
+	Remove(item string)
+	// Consume add items from the channel until it is closed or ctx is done,
+	// it must not be called concurrently with other methods.
+	Consume(ctx context.Context, items <-chan string) error
+	// List all topk items, sorted by count descending.
 	List() []Item
+	// ListN list the first n topk items, sorted by count descending. With a
+	// stale timeout it drops the stale items first, so like List and ListMin
+	// it mutates the topk and must not be called concurrently with Add.
+	ListN(n int) []Item
+	// ListMin list the topk items whose count is at least minCount.
+	ListMin(minCount uint32) []Item
+	// SetMinCount set the minimal count for an item to be added into the topk.
+	SetMinCount(minCount uint32)
 	// Expelled watch at the expelled items.
 	Expelled() <-chan Item
 	Fading()
+	// Reset clear all items in place, reusing the allocations.
+	Reset()
+	// History return the snapshots of the last windows, oldest first.
+	History() []Snapshot
+	// Cardinality return the estimated distinct keys of the current window.
+	Cardinality() uint64
+	// Stats return the statistics of the sketch.
+	Stats() Stats
+	// Histogram return the distribution of estimated key counts in power-of-two buckets.
+	Histogram() []HistogramBucket
+	// SizeBytes return the memory consumed by the sketch in bytes.
+	SizeBytes() uint64
+}
+
+// FloatItem is topk item weighted by float64.
+type FloatItem struct {
+	Key    string
+	Weight float64
+	// Rank is the 1-based position of the item in List.
+	Rank int
+}
+
+// FloatTopk is the float64 weighted topk algorithm interface, it ranks keys
+// by continuous costs such as latency seconds instead of counts.
+type FloatTopk interface {
+	// Add item with weight and return if item is in the topk.
+	Add(item string, weight float64) (string, bool)
+	// List all topk items, sorted by weight descending.
+	List() []FloatItem
+	// ListN list the first n topk items, sorted by weight descending.
+	ListN(n int) []FloatItem
+	Fading()
+	// Reset clear all items in place, reusing the allocations.
+	Reset()
+	// SizeBytes return the memory consumed by the sketch in bytes.
+	SizeBytes() uint64
 }
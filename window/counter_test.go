@@ -154,3 +154,10 @@ func BenchmarkRollingCounterReduce(b *testing.B) {
 		})
 	}
 }
+
+func TestRollingCounterEmptyAvg(t *testing.T) {
+	r := NewRollingCounter(RollingCounterOpts{Size: 3, BucketDuration: time.Second})
+	assert.Equal(t, 0.0, r.Avg())
+	r.Add(4)
+	assert.Equal(t, 4.0, r.Avg())
+}
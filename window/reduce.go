@@ -12,7 +12,7 @@ func Sum(iterator Iterator) float64 {
 	return result
 }
 
-// Avg the values within the window.
+// Avg the values within the window, it is 0 if the window is empty.
 func Avg(iterator Iterator) float64 {
 	var result = 0.0
 	var count = 0.0
@@ -23,6 +23,9 @@ func Avg(iterator Iterator) float64 {
 			count = count + 1
 		}
 	}
+	if count == 0 {
+		return 0
+	}
 	return result / count
 }
 
@@ -1,3 +1,7 @@
+// Package window implements rolling windows of time buckets. RollingCounter
+// rolls the buckets with time and reduces the live buckets by Sum, Count, Avg,
+// Min, Max or a custom function, it is the primitive of the statistics of
+// breakers and limiters.
 package window
 
 // Bucket contains multiple float64 points.